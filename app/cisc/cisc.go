@@ -19,7 +19,10 @@ import (
 
 	"bytes"
 
-	"github.com/dedis/cothority/app/lib/config"
+	"sync"
+
+	"time"
+
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/services/identity"
 	"gopkg.in/codegangsta/cli.v1"
@@ -109,6 +112,24 @@ func idConnect(c *cli.Context) error {
 		cfg.Proposed.Device[cfg.DeviceName].Point.String())
 	return cfg.saveConfig(c)
 }
+func idLink(c *cli.Context) error {
+	if c.NArg() != 2 {
+		log.Fatal("Please give the id to link to and the name of this identity")
+	}
+	cfg := loadConfigOrFail(c)
+	idBytes, err := hex.DecodeString(c.Args().First())
+	log.ErrFatal(err)
+	name := c.Args().Get(1)
+	link, err := identity.NewIdentityFromCothority(cfg.Cothority,
+		identity.ID(idBytes))
+	log.ErrFatal(err)
+	link.DeviceName = name
+	log.ErrFatal(link.ProposeUpdate())
+	log.ErrFatal(link.ProposeLink(name, cfg.ID))
+	log.Info("Proposed link as", name, "- the devices of the other identity need to vote")
+	cfg.Link = append(cfg.Link, link)
+	return cfg.saveConfig(c)
+}
 func idDel(c *cli.Context) error {
 	if c.NArg() == 0 {
 		log.Fatal("Please give device to delete")
@@ -121,12 +142,29 @@ func idDel(c *cli.Context) error {
 		log.Fatal("Device not found in config.")
 	}
 	prop := cfg.GetProposed()
-	delete(prop.Device, dev)
+	log.ErrFatal(prop.RemoveDevice(dev))
 	for _, s := range cfg.Config.GetSuffixColumn("ssh", dev) {
 		delete(prop.Data, "ssh:"+dev+":"+s)
 	}
+	_, err := reencryptData(prop, cfg.DeviceName, cfg.Private)
+	log.ErrFatal(err)
+	if prop.Threshold != cfg.Config.Threshold {
+		log.Info("Threshold is now", prop.Threshold)
+	}
 	cfg.proposeSendVoteUpdate(prop)
-	return nil
+	return cfg.saveConfig(c)
+}
+func idRotate(c *cli.Context) error {
+	cfg := loadConfigOrFail(c)
+	log.ErrFatal(cfg.ProposeRotateKey())
+	log.ErrFatal(cfg.ProposeVote(true))
+	log.ErrFatal(cfg.ConfigUpdate())
+	if cfg.NewPrivate != nil {
+		log.Info("New key proposed - it will be used once the vote passed")
+	} else {
+		log.Info("New key is active")
+	}
+	return cfg.saveConfig(c)
 }
 func idCheck(c *cli.Context) error {
 	log.Fatal("Not yet implemented")
@@ -141,6 +179,7 @@ func configUpdate(c *cli.Context) error {
 	log.ErrFatal(cfg.ConfigUpdate())
 	log.ErrFatal(cfg.ProposeUpdate())
 	log.Info("Successfully updated")
+	cfg.updateEncrypted()
 	log.ErrFatal(cfg.saveConfig(c))
 	if cfg.Proposed != nil {
 		cfg.showDifference()
@@ -175,20 +214,28 @@ func configVote(c *cli.Context) error {
 	cfg := loadConfigOrFail(c)
 	log.ErrFatal(cfg.ConfigUpdate())
 	log.ErrFatal(cfg.ProposeUpdate())
-	if cfg.Proposed == nil {
+	if cfg.Proposed != nil {
+		accept := cfg.askVote(c)
+		log.ErrFatal(cfg.ProposeVote(accept))
+		if cfg.Proposed == nil && !accept {
+			log.Info("Proposed config has been rejected")
+		}
+	} else {
 		log.Info("No proposed config")
-		return nil
 	}
-	if c.NArg() == 0 {
-		cfg.showDifference()
-		if !config.InputYN(true, "Do you want to accept the changes") {
-			return nil
+	for _, l := range cfg.Link {
+		log.ErrFatal(l.ConfigUpdate())
+		log.ErrFatal(l.ProposeUpdate())
+		if l.Proposed == nil {
+			continue
 		}
+		log.Infof("Proposed config for linked identity %x as %s",
+			l.ID, l.DeviceName)
+		link := &ciscConfig{Identity: l}
+		log.ErrFatal(cfg.ProposeVoteLinked(l.ID, l.DeviceName,
+			link.askVote(c)))
 	}
-	if strings.ToLower(c.Args().First()) == "n" {
-		return nil
-	}
-	log.ErrFatal(cfg.ProposeVote(true))
+	cfg.updateEncrypted()
 	return cfg.saveConfig(c)
 }
 
@@ -199,12 +246,26 @@ func kvList(c *cli.Context) error {
 	cfg := loadConfigOrFail(c)
 	log.Infof("config for id %x", cfg.ID)
 	for k, v := range cfg.Config.Data {
+		if isEncrypted(v) {
+			v = "<encrypted>"
+		}
 		log.Infof("%s: %s", k, v)
 	}
 	return nil
 }
 func kvValue(c *cli.Context) error {
-	log.Fatal("Not yet implemented")
+	cfg := loadConfigOrFail(c)
+	if c.NArg() != 1 {
+		log.Fatal("Please give a key")
+	}
+	key := c.Args().First()
+	value, ok := cfg.Config.Data[key]
+	if !ok {
+		log.Fatal("Didn't find key", key, "in the config")
+	}
+	value, err := decryptValue(cfg.DeviceName, cfg.Private, value)
+	log.ErrFatal(err)
+	log.Infof("%s: %s", key, value)
 	return nil
 }
 func kvAdd(c *cli.Context) error {
@@ -215,6 +276,11 @@ func kvAdd(c *cli.Context) error {
 	key := c.Args().Get(0)
 	value := c.Args().Get(1)
 	prop := cfg.GetProposed()
+	if c.Bool("e") {
+		var err error
+		value, err = encryptData(prop, value)
+		log.ErrFatal(err)
+	}
 	prop.Data[key] = value
 	log.ErrFatal(cfg.ProposeSend(prop))
 	return cfg.saveConfig(c)
@@ -377,6 +443,52 @@ func followDel(c *cli.Context) error {
 	cfg.writeAuthorizedKeys(c)
 	return cfg.saveConfig(c)
 }
+func followWatch(c *cli.Context) error {
+	cfg := loadConfigOrFail(c)
+	if len(cfg.Follow) == 0 {
+		log.Fatal("No skipchains to follow")
+	}
+	for _, f := range cfg.Follow {
+		log.ErrFatal(f.ConfigUpdate())
+	}
+	cfg.writeAuthorizedKeys(c)
+	log.ErrFatal(cfg.saveConfig(c))
+
+	// All subscriptions write to the same files.
+	var mutex sync.Mutex
+	update := func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		cfg.writeAuthorizedKeys(c)
+		log.ErrFatal(cfg.saveConfig(c))
+	}
+	retry := time.Duration(c.Int("retry")) * time.Second
+	var wg sync.WaitGroup
+	for _, f := range cfg.Follow {
+		wg.Add(1)
+		go func(f *identity.Identity) {
+			defer wg.Done()
+			for {
+				log.Infof("Watching %x", f.ID)
+				err := f.Subscribe(func(*identity.Config) bool {
+					log.Infof("Got new config for %x", f.ID)
+					update()
+					return true
+				})
+				log.Warn("Lost connection:", err, "- retrying in", retry)
+				time.Sleep(retry)
+				// Catch up with the blocks we missed
+				if err := f.ConfigUpdate(); err != nil {
+					log.Error(err)
+					continue
+				}
+				update()
+			}
+		}(f)
+	}
+	wg.Wait()
+	return nil
+}
 func followList(c *cli.Context) error {
 	cfg := loadConfigOrFail(c)
 	for _, id := range cfg.Follow {
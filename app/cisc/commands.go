@@ -35,10 +35,24 @@ func init() {
 				Action:    idConnect,
 			},
 			{
-				Name:    "del",
-				Aliases: []string{"rm"},
-				Usage:   "delete an identity",
-				Action:  idDel,
+				Name:      "link",
+				Aliases:   []string{"li"},
+				Usage:     "propose to link this identity to another identity",
+				ArgsUsage: "id name",
+				Action:    idLink,
+			},
+			{
+				Name:      "del",
+				Aliases:   []string{"rm"},
+				Usage:     "propose to remove a device from the identity",
+				ArgsUsage: "device",
+				Action:    idDel,
+			},
+			{
+				Name:    "rotate",
+				Aliases: []string{"ro"},
+				Usage:   "propose a new key for this device",
+				Action:  idRotate,
 			},
 			{
 				Name:    "check",
@@ -83,7 +97,7 @@ func init() {
 			{
 				Name:      "vote",
 				Aliases:   []string{"v"},
-				Usage:     "vote on existing config",
+				Usage:     "accept (y) or reject (n) the proposed config",
 				ArgsUsage: "[yn]",
 				Action:    configVote,
 			},
@@ -113,6 +127,12 @@ func init() {
 				Usage:     "add a new key/value pair",
 				ArgsUsage: "key value",
 				Action:    kvAdd,
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "e,encrypt",
+						Usage: "encrypt the value to the devices of the identity, not for identities with links",
+					},
+				},
 			},
 			{
 				Name:      "del",
@@ -233,6 +253,19 @@ func init() {
 				},
 				Action: followUpdate,
 			},
+			{
+				Name:    "watch",
+				Aliases: []string{"w"},
+				Usage:   "wait for updates of all skipchains and apply them",
+				Flags: []cli.Flag{
+					cli.IntFlag{
+						Name:  "r,retry",
+						Value: 10,
+						Usage: "seconds to wait before reconnecting",
+					},
+				},
+				Action: followWatch,
+			},
 		},
 	}
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/services/identity"
+	"github.com/dedis/crypto/abstract"
+	"github.com/dedis/crypto/random"
+)
+
+/*
+Encrypted values are stored in the data of the identity as a string starting
+with 'encPrefix', followed by the base64-encoding of an encryptedValue. The
+value is encrypted with a random symmetric key, which is encrypted to the
+public key of every device. Whenever the devices change, the values need to
+be re-encrypted by one of the devices. The devices of linked identities are
+not part of the recipients, so values can't be encrypted for an identity with
+links.
+*/
+
+// encPrefix marks an encrypted value in the data of the identity.
+const encPrefix = "enc:"
+
+func init() {
+	network.RegisterPacketType(encryptedValue{})
+}
+
+// encryptedValue holds a value encrypted with AES-GCM and the symmetric key
+// encrypted to every device.
+type encryptedValue struct {
+	Keys       map[string]*encryptedKey
+	Ciphertext []byte
+}
+
+// encryptedKey is the symmetric key encrypted to one device: the key is
+// xor-ed with the hash of a Diffie-Hellman secret between R and Public.
+type encryptedKey struct {
+	Public abstract.Point
+	R      abstract.Point
+	C      []byte
+}
+
+// isEncrypted returns true if the value has been encrypted by encryptValue.
+func isEncrypted(value string) bool {
+	return strings.HasPrefix(value, encPrefix)
+}
+
+// encryptData encrypts the value for the devices of conf. It refuses
+// identities with links, as their linked devices couldn't decrypt it.
+func encryptData(conf *identity.Config, value string) (string, error) {
+	if len(conf.Link) > 0 {
+		return "", errors.New("Values can't be encrypted for linked identities - " +
+			"remove the links first")
+	}
+	return encryptValue(conf.Device, value)
+}
+
+// encryptValue encrypts the value so that every device of the map can
+// decrypt it.
+func encryptValue(devices map[string]*identity.Device, value string) (string, error) {
+	key := random.Bytes(32, random.Stream)
+	aesgcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := random.Bytes(aesgcm.NonceSize(), random.Stream)
+	ev := &encryptedValue{
+		Keys:       make(map[string]*encryptedKey),
+		Ciphertext: aesgcm.Seal(nonce, nonce, []byte(value), nil),
+	}
+	for name, dev := range devices {
+		r := network.Suite.Scalar().Pick(random.Stream)
+		R := network.Suite.Point().Mul(nil, r)
+		pad, err := keyPad(network.Suite.Point().Mul(dev.Point, r))
+		if err != nil {
+			return "", err
+		}
+		ev.Keys[name] = &encryptedKey{
+			Public: dev.Point,
+			R:      R,
+			C:      xorBytes(key, pad),
+		}
+	}
+	b, err := network.MarshalRegisteredType(ev)
+	if err != nil {
+		return "", err
+	}
+	return encPrefix + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// decryptValue decrypts the value for the given device using its private
+// key. Values that are not encrypted are returned as-is.
+func decryptValue(device string, private abstract.Scalar, value string) (string, error) {
+	if !isEncrypted(value) {
+		return value, nil
+	}
+	ev, err := parseEncrypted(value)
+	if err != nil {
+		return "", err
+	}
+	ek, ok := ev.Keys[device]
+	if !ok {
+		return "", errors.New("Value is not encrypted for " + device)
+	}
+	if len(ek.C) != sha256.Size {
+		return "", errors.New("Wrong size of encrypted key")
+	}
+	pad, err := keyPad(network.Suite.Point().Mul(ek.R, private))
+	if err != nil {
+		return "", err
+	}
+	aesgcm, err := newGCM(xorBytes(ek.C, pad))
+	if err != nil {
+		return "", err
+	}
+	ns := aesgcm.NonceSize()
+	if len(ev.Ciphertext) < ns {
+		return "", errors.New("Ciphertext too short")
+	}
+	plain, err := aesgcm.Open(nil, ev.Ciphertext[:ns], ev.Ciphertext[ns:], nil)
+	if err != nil {
+		return "", errors.New("Couldn't decrypt: " + err.Error())
+	}
+	return string(plain), nil
+}
+
+// needsReencryption returns true if the encrypted value is not encrypted
+// for exactly the given devices with their actual public keys.
+func needsReencryption(devices map[string]*identity.Device, value string) bool {
+	ev, err := parseEncrypted(value)
+	if err != nil {
+		return false
+	}
+	if len(ev.Keys) != len(devices) {
+		return true
+	}
+	for name, dev := range devices {
+		ek, ok := ev.Keys[name]
+		if !ok || !ek.Public.Equal(dev.Point) {
+			return true
+		}
+	}
+	return false
+}
+
+// reencryptData re-encrypts all encrypted values of conf that are not
+// encrypted for the devices of conf. It returns the number of re-encrypted
+// values.
+func reencryptData(conf *identity.Config, device string, private abstract.Scalar) (int, error) {
+	var n int
+	for k, v := range conf.Data {
+		if !isEncrypted(v) || !needsReencryption(conf.Device, v) {
+			continue
+		}
+		plain, err := decryptValue(device, private, v)
+		if err != nil {
+			return n, err
+		}
+		conf.Data[k], err = encryptValue(conf.Device, plain)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// parseEncrypted returns the encryptedValue stored in value.
+func parseEncrypted(value string) (*encryptedValue, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encPrefix))
+	if err != nil {
+		return nil, err
+	}
+	_, msg, err := network.UnmarshalRegistered(b)
+	if err != nil {
+		return nil, err
+	}
+	ev, ok := msg.(*encryptedValue)
+	if !ok {
+		return nil, errors.New("Wrong type of encrypted value")
+	}
+	return ev, nil
+}
+
+// keyPad returns the hash of the shared point, used to encrypt the
+// symmetric key.
+func keyPad(shared abstract.Point) ([]byte, error) {
+	b, err := shared.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(b)
+	return h[:], nil
+}
+
+// newGCM returns an AES-GCM cipher using key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// xorBytes returns a xor b, both need to be of the same length.
+func xorBytes(a, b []byte) []byte {
+	ret := make([]byte, len(a))
+	for i := range a {
+		ret[i] = a[i] ^ b[i]
+	}
+	return ret
+}
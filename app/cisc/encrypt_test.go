@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/services/identity"
+	"github.com/dedis/crypto/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptValue(t *testing.T) {
+	kp1 := config.NewKeyPair(network.Suite)
+	kp2 := config.NewKeyPair(network.Suite)
+	kp3 := config.NewKeyPair(network.Suite)
+	devices := map[string]*identity.Device{
+		"one": {Point: kp1.Public},
+		"two": {Point: kp2.Public},
+	}
+	enc, err := encryptValue(devices, "secret")
+	assert.Nil(t, err)
+	assert.True(t, isEncrypted(enc))
+	assert.NotContains(t, enc, "secret")
+
+	for name, kp := range map[string]*config.KeyPair{"one": kp1, "two": kp2} {
+		plain, err := decryptValue(name, kp.Secret, enc)
+		assert.Nil(t, err)
+		assert.Equal(t, "secret", plain)
+	}
+	_, err = decryptValue("three", kp3.Secret, enc)
+	assert.NotNil(t, err)
+	_, err = decryptValue("one", kp2.Secret, enc)
+	assert.NotNil(t, err)
+
+	plain, err := decryptValue("one", kp1.Secret, "cleartext")
+	assert.Nil(t, err)
+	assert.Equal(t, "cleartext", plain)
+}
+
+func TestEncryptData(t *testing.T) {
+	kp := config.NewKeyPair(network.Suite)
+	conf := identity.NewConfig(1, kp.Public, "one")
+	enc, err := encryptData(conf, "secret")
+	assert.Nil(t, err)
+	dec, err := decryptValue("one", kp.Secret, enc)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", dec)
+
+	conf.Link = map[string]identity.ID{"other": {1, 2, 3}}
+	_, err = encryptData(conf, "secret")
+	assert.NotNil(t, err, "Encrypting for an identity with links")
+}
+
+func TestReencryptData(t *testing.T) {
+	kp1 := config.NewKeyPair(network.Suite)
+	kp2 := config.NewKeyPair(network.Suite)
+	conf := identity.NewConfig(2, kp1.Public, "one")
+	conf.Device["two"] = &identity.Device{Point: kp2.Public}
+	enc, err := encryptValue(conf.Device, "secret")
+	assert.Nil(t, err)
+	conf.Data["pwd"] = enc
+	conf.Data["clear"] = "text"
+
+	n, err := reencryptData(conf, "one", kp1.Secret)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	prop := conf.Copy()
+	assert.Nil(t, prop.RemoveDevice("two"))
+	assert.True(t, needsReencryption(prop.Device, prop.Data["pwd"]))
+	n, err = reencryptData(prop, "one", kp1.Secret)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "text", prop.Data["clear"])
+	_, err = decryptValue("two", kp2.Secret, prop.Data["pwd"])
+	assert.NotNil(t, err)
+	plain, err := decryptValue("one", kp1.Secret, prop.Data["pwd"])
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", plain)
+
+	// A device that can't decrypt can't re-encrypt.
+	kp3 := config.NewKeyPair(network.Suite)
+	conf.Device["three"] = &identity.Device{Point: kp3.Public}
+	_, err = reencryptData(conf, "three", kp3.Secret)
+	assert.NotNil(t, err)
+}
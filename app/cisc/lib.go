@@ -27,6 +27,9 @@ func init() {
 type ciscConfig struct {
 	*identity.Identity
 	Follow []*identity.Identity
+	// Link holds the identities this identity is linked to. The DeviceName
+	// of each is the name of this identity in the linked identity.
+	Link []*identity.Identity
 }
 
 // loadConfig will try to load the configuration and `fatal` if it is there but
@@ -89,6 +92,40 @@ func (cfg *ciscConfig) proposeSendVoteUpdate(p *identity.Config) {
 	log.ErrFatal(cfg.ConfigUpdate())
 }
 
+// updateEncrypted proposes to re-encrypt the encrypted values if the devices
+// changed since they have been encrypted. Nothing is done if a proposition is
+// already waiting for votes.
+func (cfg *ciscConfig) updateEncrypted() {
+	if cfg.Proposed != nil {
+		return
+	}
+	prop := cfg.Config.Copy()
+	n, err := reencryptData(prop, cfg.DeviceName, cfg.Private)
+	if err != nil {
+		log.Warn("Couldn't re-encrypt values:", err)
+		return
+	}
+	if n == 0 {
+		return
+	}
+	log.Info("Re-encrypting", n, "values for the actual devices")
+	cfg.proposeSendVoteUpdate(prop)
+}
+
+// askVote shows the votes on the proposed config and returns whether it is
+// accepted, either from the command-line or by asking the user.
+func (cfg *ciscConfig) askVote(c *cli.Context) bool {
+	log.Infof("Votes: %d accepted %v, %d rejected %v - threshold is %d",
+		len(cfg.ProposedAccepted), cfg.ProposedAccepted,
+		len(cfg.ProposedRejected), cfg.ProposedRejected,
+		cfg.Config.Threshold)
+	if c.NArg() > 0 {
+		return strings.ToLower(c.Args().First()) != "n"
+	}
+	cfg.showDifference()
+	return config.InputYN(true, "Do you want to accept the changes")
+}
+
 // writes the ssh-keys to an 'authorized_keys'-file
 func (cfg *ciscConfig) writeAuthorizedKeys(c *cli.Context) {
 	var keys []string
@@ -144,6 +181,16 @@ func (cfg *ciscConfig) showDifference() {
 			log.Info("Deleted device:", dev)
 		}
 	}
+	for name, id := range cfg.Proposed.Link {
+		if _, exists := cfg.Config.Link[name]; !exists {
+			log.Infof("New link: %s / %x", name, []byte(id))
+		}
+	}
+	for name := range cfg.Config.Link {
+		if _, exists := cfg.Proposed.Link[name]; !exists {
+			log.Info("Deleted link:", name)
+		}
+	}
 }
 
 // shows only the keys, but not the data
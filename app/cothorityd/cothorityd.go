@@ -20,6 +20,7 @@ import (
 
 	c "github.com/dedis/cothority/app/lib/config"
 	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/monitor"
 	"gopkg.in/codegangsta/cli.v1"
 	// Empty imports to have the init-functions called which should
 	// register the protocol
@@ -59,6 +60,11 @@ func main() {
 			Value: 0,
 			Usage: "debug-level: 1 for terse, 5 for maximal",
 		},
+		cli.StringFlag{
+			Name:  "metrics, m",
+			Value: "",
+			Usage: "[address]:port to serve the OpenMetrics endpoint on /metrics",
+		},
 	}
 
 	cliApp.Commands = []cli.Command{
@@ -110,6 +116,12 @@ func runServer(ctx *cli.Context) {
 	if err != nil {
 		log.Fatal("Couldn't parse config:", err)
 	}
+	if addr := ctx.String("metrics"); addr != "" {
+		monitor.EnableMetrics().Register(host)
+		go func() {
+			log.ErrFatal(monitor.ServeMetrics(addr), "Couldn't serve metrics")
+		}()
+	}
 	host.ListenAndBind()
 	host.StartProcessMessages()
 	host.WaitForClose()
@@ -16,13 +16,16 @@ import (
 	"crypto/rand"
 
 	"github.com/dedis/cothority/app/lib/config"
+	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/sda"
 	"github.com/dedis/cothority/services/guard"
 	"github.com/dedis/crypto/abstract"
+	"github.com/dedis/crypto/random"
 
 	"io/ioutil"
 
+	"github.com/BurntSushi/toml"
 	s "github.com/SSSaaS/sssa-golang"
 	"gopkg.in/codegangsta/cli.v1"
 
@@ -38,11 +41,20 @@ type User struct {
 	Name []byte
 	// Salt used for the password-hash
 	Salt []byte
-	// Xored keys with hash
+	// Shares of the key, each encrypted with the response of its guard
+	// multiplied by the factor. Empty for the guards that didn't respond
+	// when the password has been set
 	Keys [][]byte
+	// Factors applied to the responses of the guards. A rotation of a
+	// guard only changes its factor, multiplied by the token of the guard
+	Factors [][]byte
+	// Epochs of the guards used for the Keys
+	Epochs [][]byte
 	// Data AEAD-encrypted with key
 	Data []byte
 	Iv   []byte
+	// Threshold is the number of guards needed to recover the key
+	Threshold int
 }
 
 // Database is a structure that stores Cothority(the list of guard servers), and
@@ -50,11 +62,11 @@ type User struct {
 type Database struct {
 	Cothority *sda.Roster
 	Users     []User
+	// Threshold is the number of guards needed to recover the key of a new
+	// user
+	Threshold int
 }
 
-// EPOCH is a constantg
-const EPOCH = "EPOCH"
-
 var db *Database
 
 func main() {
@@ -88,7 +100,13 @@ func main() {
 			Aliases:   []string{"su"},
 			Usage:     "Saves the cothority group-toml to the configuration",
 			ArgsUsage: "Give group definition",
-			Action:    setup,
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "threshold, t",
+					Usage: "number of guards needed to recover a password, defaults to a majority",
+				},
+			},
+			Action: setup,
 		},
 		{
 			Name:    "recover",
@@ -96,6 +114,20 @@ func main() {
 			Usage:   "Gets the password back from the guards",
 			Action:  get,
 		},
+		{
+			Name:      "rotate",
+			Aliases:   []string{"ro"},
+			Usage:     "Rotates the keys of the guards whose conode configurations are given",
+			ArgsUsage: "PRIVATE.toml...",
+			Action:    rotate,
+		},
+		{
+			Name:      "migrate",
+			Aliases:   []string{"m"},
+			Usage:     "Moves all users to the actual epochs of the guards whose conode configurations are given, lists the users to migrate without arguments",
+			ArgsUsage: "[PRIVATE.toml...]",
+			Action:    migrate,
+		},
 	}
 	app.Before = func(c *cli.Context) error {
 		b, err := ioutil.ReadFile("config.bin")
@@ -134,15 +166,25 @@ func readGroup(tomlFileName string) (*sda.Roster, error) {
 	return el, err
 }
 
-func set(c *cli.Context, uid []byte, epoch []byte, password string, userdata []byte) {
+// threshold returns t if it is a valid threshold for n guards, else a
+// majority of the n guards.
+func threshold(n, t int) int {
+	if t > 0 && t <= n {
+		return t
+	}
+	return n/2 + 1
+}
+
+func set(c *cli.Context, uid []byte, password string, userdata []byte) {
 	mastersalt := make([]byte, 12)
 	_, err := rand.Read(mastersalt)
 	log.ErrFatal(err)
 	k := make([]byte, 32)
 	_, err = rand.Read(k)
 	log.ErrFatal(err)
+	thr := threshold(len(db.Cothority.List), db.Threshold)
 	// secretkeys is the Shamir Secret share of the keys.
-	secretkeys := s.Create(2, len(db.Cothority.List), string(k))
+	secretkeys := s.Create(thr, len(db.Cothority.List), string(k))
 	blind := make([]byte, 12)
 	_, err = rand.Read(blind)
 	log.ErrFatal(err)
@@ -153,49 +195,103 @@ func set(c *cli.Context, uid []byte, epoch []byte, password string, userdata []b
 	// pwhash is the password hash that will be sent to the guard servers
 	// with Gu and bi.
 	pwhash := abstract.Sum(network.Suite, []byte(password), mastersalt)
-	GuHash := abstract.Sum(network.Suite, uid, epoch)
-	// creating stream for Scalar.Pick from the hash.
-	blocky, err := aes.NewCipher(iv)
-	log.ErrFatal(err)
-	GuStream := cipher.NewCTR(blocky, iv)
-	gupoint := network.Suite.Point()
-	Gu, _ := gupoint.Pick(GuHash, GuStream)
-	responses := make([][]byte, len(db.Cothority.List))
 	keys := make([][]byte, len(db.Cothority.List))
+	factors := make([][]byte, len(db.Cothority.List))
+	epochs := make([][]byte, len(db.Cothority.List))
+	responded := 0
 	for i, si := range db.Cothority.List {
 		cl := guard.NewClient()
-		// blankpoints needed to call the functions.
-		blankpoint := network.Suite.Point()
-		blankscalar := network.Suite.Scalar()
-		// Initializing the variables pwbytes and blindbytes, which are
-		// scalars with the values of pwhash and blinds[i].
-		pwbytes := network.Suite.Scalar()
-		pwbytes.SetBytes(pwhash)
-		blindbytes := network.Suite.Scalar()
-		blindbytes.SetBytes(blinds[i])
-		// this next part performs all necessary computations to create
-		// Xi, here called sendy.
-		blankscalar.Add(pwbytes, blindbytes).Bytes()
-		sendy := blankpoint.Mul(Gu, blankscalar.Mul(pwbytes, blindbytes))
-		rep, err := cl.SendToGuard(si, uid, epoch, sendy)
-		log.ErrFatal(err)
-		reply := blankpoint.Mul(rep.Msg, blankscalar.Inv(blindbytes))
-		responses[i], err = reply.MarshalBinary()
-		log.ErrFatal(err)
-		block, err := aes.NewCipher(responses[i])
+		epoch, err := cl.GetEpoch(si)
+		if err != nil {
+			log.Warn("Guard", si, "failed:", err)
+			continue
+		}
+		response, err := harden(cl, si, uid, epoch, iv, pwhash, blinds[i])
+		if err != nil {
+			log.Warn("Guard", si, "failed:", err)
+			continue
+		}
+		factor := network.Suite.Scalar().Pick(random.Stream)
+		msg, err := xorShare(response, factor, iv, []byte(secretkeys[i]))
 		log.ErrFatal(err)
-		stream := cipher.NewCTR(block, iv)
-		msg := make([]byte, 88)
-		stream.XORKeyStream(msg, []byte(secretkeys[i]))
 		keys[i] = msg
-
+		factors[i], err = factor.MarshalBinary()
+		log.ErrFatal(err)
+		epochs[i] = epoch
+		responded++
+	}
+	if responded < thr {
+		log.Fatalf("Only %d out of %d guards responded, need %d",
+			responded, len(db.Cothority.List), thr)
 	}
 	// This is the code that seals the user data using the master key
 	// and saves it to the db.
 	block, _ := aes.NewCipher(k)
 	aesgcm, _ := cipher.NewGCM(block)
 	ciphertext := aesgcm.Seal(nil, mastersalt, userdata, nil)
-	db.Users = append(db.Users, User{uid, mastersalt, keys, ciphertext, iv})
+	db.Users = append(db.Users, User{
+		Name:      uid,
+		Salt:      mastersalt,
+		Keys:      keys,
+		Factors:   factors,
+		Epochs:    epochs,
+		Data:      ciphertext,
+		Iv:        iv,
+		Threshold: thr,
+	})
+}
+
+// harden sends the blinded password hash to the guard for the given epoch
+// and returns the unblinded response, which is used to encrypt the share of
+// the guard. The guard never learns the password hash. The point of the user
+// doesn't depend on the epoch, so that the responses of two epochs only
+// differ by the token of the guard.
+func harden(cl *guard.Client, si *network.ServerIdentity, uid, epoch, iv, pwhash, blind []byte) (abstract.Point, error) {
+	GuHash := abstract.Sum(network.Suite, uid)
+	// creating stream for Scalar.Pick from the hash.
+	blocky, err := aes.NewCipher(iv)
+	if err != nil {
+		return nil, err
+	}
+	GuStream := cipher.NewCTR(blocky, iv)
+	gupoint := network.Suite.Point()
+	Gu, _ := gupoint.Pick(GuHash, GuStream)
+	// blankpoints needed to call the functions.
+	blankpoint := network.Suite.Point()
+	blankscalar := network.Suite.Scalar()
+	// Initializing the variables pwbytes and blindbytes, which are
+	// scalars with the values of pwhash and blind.
+	pwbytes := network.Suite.Scalar()
+	pwbytes.SetBytes(pwhash)
+	blindbytes := network.Suite.Scalar()
+	blindbytes.SetBytes(blind)
+	// this next part performs all necessary computations to create
+	// Xi, here called sendy.
+	sendy := blankpoint.Mul(Gu, blankscalar.Mul(pwbytes, blindbytes))
+	rep, err := cl.SendToGuard(si, uid, epoch, sendy)
+	if err != nil {
+		return nil, err
+	}
+	// This section of the program removes the blinding factor from
+	// the Zi for storage.
+	return blankpoint.Mul(rep.Msg, blankscalar.Inv(blindbytes)), nil
+}
+
+// xorShare encrypts or decrypts a share with the response of its guard
+// multiplied by the factor of the user.
+func xorShare(response abstract.Point, factor abstract.Scalar, iv, share []byte) ([]byte, error) {
+	key, err := network.Suite.Point().Mul(response, factor).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	stream := cipher.NewCTR(block, iv)
+	msg := make([]byte, len(share))
+	stream.XORKeyStream(msg, share)
+	return msg, nil
 }
 
 // saltgen is a function that generates all the keys and salts given a length
@@ -214,79 +310,91 @@ func setup(c *cli.Context) error {
 	groupToml := c.Args().First()
 	var err error
 	t, err := readGroup(groupToml)
+	log.ErrFatal(err)
 	db = &Database{
 		Cothority: t,
+		Threshold: threshold(len(t.List), c.Int("threshold")),
 	}
-	log.ErrFatal(err)
+	saveDatabase()
+	return nil
+}
+
+// saveDatabase writes the database to the configuration file.
+func saveDatabase() {
 	b, err := network.MarshalRegisteredType(db)
 	log.ErrFatal(err)
 	err = ioutil.WriteFile("config.bin", b, 0660)
 	log.ErrFatal(err)
-	return nil
 }
 
 // getuser returns the user that the UID matches.
 func getuser(UID []byte) *User {
-	for _, u := range db.Users {
-		if bytes.Equal(u.Name, UID) {
-			return &u
+	for i := range db.Users {
+		if bytes.Equal(db.Users[i].Name, UID) {
+			return &db.Users[i]
 		}
 	}
 	return nil
 }
 
+// deluser removes the user that the UID matches.
+func deluser(UID []byte) {
+	for i, u := range db.Users {
+		if bytes.Equal(u.Name, UID) {
+			db.Users = append(db.Users[:i], db.Users[i+1:]...)
+			return
+		}
+	}
+}
+
 // getpass contacts the guard servers, then gets the passwords and
-// reconstructs the secret keys.
-func getpass(c *cli.Context, uid []byte, epoch []byte, pass string) {
-	if getuser(uid) == nil {
+// reconstructs the secret keys. Only a threshold of guards needs to respond.
+// Each guard is asked for the epoch its key has been stored with. It returns
+// the decrypted user data.
+func getpass(c *cli.Context, uid []byte, pass string) []byte {
+	user := getuser(uid)
+	if user == nil {
 		log.Fatal("Wrong username")
 	}
+	// Users stored before the threshold was configurable used 2.
+	thr := user.Threshold
+	if thr == 0 {
+		thr = 2
+	}
 
-	keys := make([]string, len(db.Cothority.List))
+	var keys []string
 	blind := make([]byte, 12)
 	_, err := rand.Read(blind)
 	log.ErrFatal(err)
 	blinds := saltgen(blind, len(db.Cothority.List))
-	iv := getuser(uid).Iv
 	// pwhash is the password hash that will be sent to the guard servers
 	// with Gu and bi.
-	pwhash := abstract.Sum(network.Suite, []byte(pass), getuser(uid).Salt)
-	GuHash := abstract.Sum(network.Suite, uid, epoch)
-	// creating stream for Scalar.Pick from the hash
-	blocky, err := aes.NewCipher(iv)
-	log.ErrFatal(err)
-	GuStream := cipher.NewCTR(blocky, iv)
-	gupoint := network.Suite.Point()
-	Gu, _ := gupoint.Pick(GuHash, GuStream)
+	pwhash := abstract.Sum(network.Suite, []byte(pass), user.Salt)
 
 	for i, si := range db.Cothority.List {
+		if len(keys) == thr {
+			break
+		}
+		if i >= len(user.Keys) || len(user.Keys[i]) == 0 ||
+			i >= len(user.Epochs) || i >= len(user.Factors) {
+			log.Lvl2("No key stored for guard", si)
+			continue
+		}
 		cl := guard.NewClient()
-		// blankpoints needed for computations.
-		blankpoint := network.Suite.Point()
-		blankscalar := network.Suite.Scalar()
-		// pwbytes and blindbytes are actually scalars that are
-		// initialized to the values of the bytes.
-		pwbytes := network.Suite.Scalar()
-		pwbytes.SetBytes(pwhash)
-		blindbytes := network.Suite.Scalar()
-		blindbytes.SetBytes(blinds[i])
-		// The following sections of the code perform the computations
-		// to Create Xi, here called sendy.
-		blankscalar.Add(pwbytes, blindbytes).MarshalBinary()
-		_, err := blankpoint.Mul(Gu, blankscalar.Mul(pwbytes, blindbytes)).MarshalBinary()
-		sendy := blankpoint.Mul(Gu, blankscalar.Mul(pwbytes, blindbytes))
-		rep, err := cl.SendToGuard(si, uid, epoch, sendy)
-		log.ErrFatal(err)
-		// This section of the program removes the blinding factor from
-		// the Zi for storage.
-		reply, err := blankpoint.Mul(rep.Msg, blankscalar.Inv(blindbytes)).MarshalBinary()
-		log.ErrFatal(err)
+		reply, err := harden(cl, si, uid, user.Epochs[i], user.Iv, pwhash, blinds[i])
+		if err != nil {
+			log.Warn("Guard", si, "failed:", err)
+			continue
+		}
+		factor := network.Suite.Scalar()
+		log.ErrFatal(factor.UnmarshalBinary(user.Factors[i]))
 		// This section Xors the data with the response.
-		block, err := aes.NewCipher(reply)
-		stream := cipher.NewCTR(block, getuser(uid).Iv)
-		msg := make([]byte, len([]byte(getuser(uid).Keys[i])))
-		stream.XORKeyStream(msg, []byte(getuser(uid).Keys[i]))
-		keys[i] = string(msg)
+		msg, err := xorShare(reply, factor, user.Iv, user.Keys[i])
+		log.ErrFatal(err)
+		keys = append(keys, string(msg))
+	}
+	if len(keys) < thr {
+		log.Fatalf("Only %d guards responded, need %d", len(keys), thr)
 	}
 	k := s.Combine(keys)
 	if len(k) == 0 {
@@ -296,23 +404,155 @@ func getpass(c *cli.Context, uid []byte, epoch []byte, pass string) {
 	log.ErrFatal(err)
 	aesgcm, err := cipher.NewGCM(block)
 	log.ErrFatal(err)
-	plaintext, err := aesgcm.Open(nil, getuser(uid).Salt, getuser(uid).Data, nil)
+	plaintext, err := aesgcm.Open(nil, user.Salt, user.Data, nil)
 	log.ErrFatal(err)
-	log.Print(string(plaintext))
+	return plaintext
 }
+
+// outdated returns true if one of the keys of the user is not stored with
+// the actual epoch of its guard. Guards that don't respond are ignored.
+func outdated(user *User) bool {
+	for i, si := range db.Cothority.List {
+		if i >= len(user.Keys) || len(user.Keys[i]) == 0 {
+			continue
+		}
+		epoch, err := guard.NewClient().GetEpoch(si)
+		if err != nil {
+			log.Warn("Guard", si, "failed:", err)
+			continue
+		}
+		if i >= len(user.Epochs) || !bytes.Equal(epoch, user.Epochs[i]) {
+			return true
+		}
+	}
+	return false
+}
+
 func setpass(c *cli.Context) error {
 	uid := []byte(c.Args().Get(0))
 	Pass := c.Args().Get(1)
 	usrdata := []byte(c.Args().Get(2))
-	set(c, uid, []byte(EPOCH), string(Pass), usrdata)
-	b, err := network.MarshalRegisteredType(db)
-	log.ErrFatal(err)
-	err = ioutil.WriteFile("config.bin", b, 0660)
+	set(c, uid, string(Pass), usrdata)
+	saveDatabase()
 	return nil
 }
 func get(c *cli.Context) error {
 	uid := []byte(c.Args().Get(0))
 	pass := c.Args().Get(1)
-	getpass(c, uid, []byte(EPOCH), string(pass))
+	plaintext := getpass(c, uid, string(pass))
+	log.Print(string(plaintext))
+	if outdated(getuser(uid)) {
+		log.Info("The user is not at the actual epochs of the guards, " +
+			"please migrate the database")
+	}
+	return nil
+}
+
+// readOperator reads the configuration of a guard's conode and returns the
+// index of the guard in the database together with its private key.
+func readOperator(file string) (int, abstract.Scalar) {
+	hc := &config.CothoritydConfig{}
+	_, err := toml.DecodeFile(file, hc)
+	log.ErrFatal(err, "Couldn't read", file)
+	private, err := crypto.ReadScalarHex(network.Suite, hc.Private)
+	log.ErrFatal(err, "Couldn't read private key of", file)
+	public := network.Suite.Point().Mul(nil, private)
+	for i, si := range db.Cothority.List {
+		if si.Public.Equal(public) {
+			return i, private
+		}
+	}
+	log.Fatal("The conode of", file, "is not a guard of the database")
+	return 0, nil
+}
+
+// rotate asks the guards whose operator configurations are given to create a
+// new epoch. The users are then moved to the new epochs with migrate.
+func rotate(c *cli.Context) error {
+	if c.NArg() == 0 {
+		log.Fatal("Please give the configurations of the guards to rotate")
+	}
+	for _, file := range c.Args() {
+		i, private := readOperator(file)
+		si := db.Cothority.List[i]
+		epoch, err := guard.NewClient().Rotate(si, private)
+		if err != nil {
+			log.Warn("Guard", si, "failed:", err)
+			continue
+		}
+		log.Infof("Guard %s is at epoch %s", si, epoch)
+	}
+	return nil
+}
+
+// migrate moves all users to the actual epochs of the guards whose operator
+// configurations are given. The factors of the users are multiplied with
+// their tokens of the guards, so the passwords are not needed. The epochs that no
+// user references anymore are retired. Without arguments, it lists the users
+// that need to be migrated.
+func migrate(c *cli.Context) error {
+	if c.NArg() == 0 {
+		for i := range db.Users {
+			if outdated(&db.Users[i]) {
+				log.Info("Needs migration:", string(db.Users[i].Name))
+			}
+		}
+		return nil
+	}
+	for _, file := range c.Args() {
+		i, private := readOperator(file)
+		si := db.Cothority.List[i]
+		cl := guard.NewClient()
+		// The epochs the users have been moved from
+		moved := map[string][]byte{}
+		for u := range db.Users {
+			user := &db.Users[u]
+			if i >= len(user.Keys) || len(user.Keys[i]) == 0 ||
+				i >= len(user.Factors) || i >= len(user.Epochs) {
+				continue
+			}
+			// The tokens are specific to each user.
+			token, err := cl.Token(si, private, user.Name, user.Epochs[i])
+			if err != nil {
+				log.Warn("Guard", si, "failed:", err)
+				break
+			}
+			if bytes.Equal(token.From, token.To) {
+				continue
+			}
+			moved[string(token.From)] = token.From
+			factor := network.Suite.Scalar()
+			log.ErrFatal(factor.UnmarshalBinary(user.Factors[i]))
+			factor.Mul(factor, token.Token)
+			b, err := factor.MarshalBinary()
+			log.ErrFatal(err)
+			user.Factors[i] = b
+			user.Epochs[i] = token.To
+			log.Lvl2("Migrated", string(user.Name), "to epoch", string(token.To),
+				"of guard", si)
+		}
+		saveDatabase()
+		// The epochs are only retired once no user uses them anymore.
+		for from, epoch := range moved {
+			if referenced(i, epoch) {
+				continue
+			}
+			if err := cl.Retire(si, private, epoch); err != nil {
+				log.Warn("Couldn't retire epoch", from, "of guard", si, ":", err)
+			}
+		}
+		log.Info("Migrated all users of guard", si)
+	}
 	return nil
 }
+
+// referenced returns true if a user still uses the epoch of the guard with
+// index i.
+func referenced(i int, epoch []byte) bool {
+	for _, user := range db.Users {
+		if i < len(user.Epochs) && bytes.Equal(user.Epochs[i], epoch) {
+			return true
+		}
+	}
+	return false
+}
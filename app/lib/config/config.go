@@ -35,6 +35,9 @@ type CothoritydConfig struct {
 	Public    string
 	Private   string
 	Addresses []string
+	// Limits are the sizes of the packets accepted by the host. The
+	// network.DefaultLimits are used for the missing values.
+	Limits *network.Limits `toml:",omitempty"`
 }
 
 // Save will save this CothoritydConfig to the given file name
@@ -69,9 +72,30 @@ func ParseCothorityd(file string) (*CothoritydConfig, *sda.Host, error) {
 		return nil, nil, err
 	}
 	host := sda.NewHost(network.NewServerIdentity(point, hc.Addresses...), secret)
+	if hc.Limits != nil {
+		if err := host.SetLimits(limits(*hc.Limits)); err != nil {
+			return nil, nil, err
+		}
+	}
 	return hc, host, nil
 }
 
+// limits returns l with the values that are not set taken from the
+// network.DefaultLimits.
+func limits(l network.Limits) network.Limits {
+	def := network.DefaultLimits
+	if l.MaxPacketSize == 0 {
+		l.MaxPacketSize = def.MaxPacketSize
+	}
+	if l.StreamChunkSize == 0 {
+		l.StreamChunkSize = def.StreamChunkSize
+	}
+	if l.MaxStreamSize == 0 {
+		l.MaxStreamSize = def.MaxStreamSize
+	}
+	return l
+}
+
 // CreateCothoritydConfig will ask through the command line to create a Private / Public
 // key, what is the listening address
 func CreateCothoritydConfig(defaultFile string) (*CothoritydConfig, string, error) {
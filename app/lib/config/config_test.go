@@ -6,7 +6,9 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/BurntSushi/toml"
 	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -46,6 +48,21 @@ func TestReadGroupDescToml(t *testing.T) {
 	}
 }
 
+func TestCothoritydConfigLimits(t *testing.T) {
+	hc := &CothoritydConfig{}
+	_, err := toml.Decode("[Limits]\nMaxStreamSize = 1024\n", hc)
+	log.ErrFatal(err)
+	l := limits(*hc.Limits)
+	assert.Equal(t, uint64(1024), l.MaxStreamSize)
+	assert.Equal(t, network.DefaultLimits.MaxPacketSize, l.MaxPacketSize)
+	assert.Equal(t, network.DefaultLimits.StreamChunkSize, l.StreamChunkSize)
+
+	hc = &CothoritydConfig{}
+	_, err = toml.Decode("Addresses = [\"127.0.0.1:2000\"]\n", hc)
+	log.ErrFatal(err)
+	assert.Nil(t, hc.Limits)
+}
+
 func TestInput(t *testing.T) {
 	setInput("Y")
 	assert.Equal(t, "Y", Input("def", "Question"))
@@ -0,0 +1,169 @@
+// Timestamp asks a cothority running the timestamp service to collectively
+// sign the hash of a file, and stores the receipt next to the file. The
+// receipt can later be verified offline with the group definition of the
+// cothority, proving that the file existed at the signed time.
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/dedis/cothority/app/lib/config"
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/timestamp"
+	"gopkg.in/codegangsta/cli.v1"
+)
+
+// receiptExt is appended to the name of the file to get its receipt.
+const receiptExt = ".tsr"
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "Timestamp"
+	app.Usage = "Timestamp files and verify their receipts."
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "group, g",
+			Value: "group.toml",
+			Usage: "Cothority group definition in `FILE.toml`",
+		},
+		cli.IntFlag{
+			Name:  "debug, d",
+			Value: 0,
+			Usage: "debug-level: `integer`: 1 for terse, 5 for maximal",
+		},
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:    "setup",
+			Aliases: []string{"su"},
+			Usage:   "Starts the timestamp service on the first server of the group",
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:  "epoch, e",
+					Value: 10 * time.Second,
+					Usage: "duration of an epoch",
+				},
+			},
+			Action: setup,
+		},
+		{
+			Name:      "stamp",
+			Aliases:   []string{"s"},
+			Usage:     "Timestamps a file and stores the receipt in FILE" + receiptExt,
+			ArgsUsage: "FILE",
+			Action:    stamp,
+		},
+		{
+			Name:      "verify",
+			Aliases:   []string{"v"},
+			Usage:     "Verifies the receipt of a file offline",
+			ArgsUsage: "FILE [RECEIPT]",
+			Action:    verify,
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		log.SetUseColors(false)
+		log.SetDebugVisible(c.GlobalInt("debug"))
+		return nil
+	}
+	app.Run(os.Args)
+}
+
+// setup starts the timestamp service with the group as roster.
+func setup(c *cli.Context) error {
+	el, err := readGroup(c.GlobalString("group"))
+	log.ErrFatal(err, "Couldn't read group")
+	_, err = timestamp.NewClient().SetupStamper(el, c.Duration("epoch"), 0)
+	log.ErrFatal(err, "Couldn't setup the timestamp service")
+	log.Info("Timestamp service started")
+	return nil
+}
+
+// stamp sends the hash of the file to the timestamp service and saves the
+// receipt.
+func stamp(c *cli.Context) error {
+	if c.NArg() < 1 {
+		log.Fatal("Please give a file to timestamp")
+	}
+	file := c.Args().First()
+	el, err := readGroup(c.GlobalString("group"))
+	log.ErrFatal(err, "Couldn't read group")
+	hash, err := hashFile(file)
+	log.ErrFatal(err, "Couldn't hash file")
+	resp, err := timestamp.NewClient().SignMsg(el.List[0], hash)
+	log.ErrFatal(err, "Couldn't timestamp file")
+	log.ErrFatal(timestamp.Verify(el, hash, resp), "Invalid receipt")
+	b, err := network.MarshalRegisteredType(resp)
+	log.ErrFatal(err)
+	log.ErrFatal(ioutil.WriteFile(file+receiptExt, b, 0660))
+	log.Info("Timestamped", file, "at", time.Unix(resp.Timestamp, 0))
+	return nil
+}
+
+// verify checks the receipt of the file against the group.
+func verify(c *cli.Context) error {
+	if c.NArg() < 1 {
+		log.Fatal("Please give a file to verify")
+	}
+	file := c.Args().First()
+	receipt := file + receiptExt
+	if c.NArg() > 1 {
+		receipt = c.Args().Get(1)
+	}
+	el, err := readGroup(c.GlobalString("group"))
+	log.ErrFatal(err, "Couldn't read group")
+	hash, err := hashFile(file)
+	log.ErrFatal(err, "Couldn't hash file")
+	b, err := ioutil.ReadFile(receipt)
+	log.ErrFatal(err, "Couldn't read receipt")
+	_, msg, err := network.UnmarshalRegistered(b)
+	log.ErrFatal(err, "Couldn't unmarshal receipt")
+	resp, ok := msg.(*timestamp.SignatureResponse)
+	if !ok {
+		log.Fatal("Not a timestamp receipt:", receipt)
+	}
+	log.ErrFatal(timestamp.Verify(el, hash, resp), "Invalid receipt")
+	log.Info(file, "existed at", time.Unix(resp.Timestamp, 0))
+	return nil
+}
+
+// hashFile returns the sha256 hash of the content of the file.
+func hashFile(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// readGroup takes a toml file name and reads the file, returning the entities within.
+func readGroup(tomlFileName string) (*sda.Roster, error) {
+	log.Lvl2("Reading From File")
+	f, err := os.Open(tomlFileName)
+	if err != nil {
+		return nil, err
+	}
+	el, err := config.ReadGroupToml(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(el.List) <= 0 {
+		return nil, errors.New("Empty or invalid group file:" +
+			tomlFileName)
+	}
+	log.Lvl3(el)
+	return el, err
+}
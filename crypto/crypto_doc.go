@@ -5,6 +5,12 @@
 // `HashStream(sha256.New(),stream)`. It will stream the input into the hash
 // function by chunks and output the final hash.
 //
+// merkle.go provides a Merkle tree with proofs of inclusion that bind the
+// position of the leaf and separate leaves from inner nodes.
+//
+// incremental.go provides a Merkle trie of key/value pairs whose changes come
+// with a proof that can be verified without the rest of the trie.
+//
 // schnorr.go provides some crypto-shortcuts: Schnorr signature and a Hash-function.
 // See https://en.wikipedia.org/wiki/Schnorr_signature
 //
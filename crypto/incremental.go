@@ -0,0 +1,423 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+/*
+IncrementalTree is an authenticated binary trie over key/value pairs that can
+be updated without being rebuilt. The path of a key is given by the bits of
+the hash of the key, and a subtree holding only one leaf is replaced by that
+leaf, so the depth of the tree is about log(n) and its root only depends on
+the pairs it holds.
+
+Changing k keys of a tree of n pairs costs O(k log n) hashes. The proof of
+such an update is a PartialTree holding the paths of the changed keys in the
+old tree, which is enough to compute the new root. The same PartialTree
+proves whether a key is in the tree or not.
+
+Hashes:
+  - empty subtree: all zeros
+  - leaf: H(0x00 || H(key) || value)
+  - inner node: H(0x01 || left || right)
+  - root: H(0x03 || version || top node)
+*/
+
+// IncrementalVersion is the version of the construction of IncrementalTree.
+const IncrementalVersion = 1
+
+const incrementalRootPrefix = 0x03
+
+// IncrementalTree is an authenticated trie of key/value pairs. The nodes are
+// never changed, so a tree can be updated while older versions are in use.
+// As the hashes of the nodes are computed when needed, a tree must not be
+// used concurrently.
+type IncrementalTree struct {
+	newHash HashFunc
+	top     *trieNode
+}
+
+// TreeOp is a change of one key of an IncrementalTree.
+type TreeOp struct {
+	Key []byte
+	// Value is the new hash of the value of the key, ignored if Delete is true
+	Value  HashID
+	Delete bool
+}
+
+// TreeUpdate proves that the tree with root OldRoot is changed by Ops.
+type TreeUpdate struct {
+	OldRoot HashID
+	Ops     []*TreeOp
+	Proof   *PartialTree
+}
+
+// PartialTree holds the nodes of an IncrementalTree on the paths to some keys.
+// The other subtrees are only given by their hash.
+type PartialTree struct {
+	Version int
+	// Nodes of the tree in pre-order
+	Nodes []*PartialNode
+}
+
+// Types of a PartialNode.
+const (
+	partialEmpty = iota
+	partialLeaf
+	partialInner
+	partialPruned
+)
+
+// PartialNode is one node of a PartialTree.
+type PartialNode struct {
+	Type int
+	// KeyHash and Value of a leaf
+	KeyHash HashID
+	Value   HashID
+	// Hash of a pruned subtree
+	Hash HashID
+}
+
+// trieNode is a node of the trie, nil being an empty subtree. A pruned node
+// is only known by its hash and always holds at least two leaves.
+type trieNode struct {
+	leaf        bool
+	keyHash     HashID
+	value       HashID
+	left, right *trieNode
+	pruned      bool
+	hash        HashID
+}
+
+// NewIncrementalTree returns an empty tree.
+func NewIncrementalTree(newHash HashFunc) *IncrementalTree {
+	return &IncrementalTree{newHash: newHash}
+}
+
+// Root returns the root of the tree.
+func (t *IncrementalTree) Root() HashID {
+	h := t.newHash()
+	h.Write([]byte{incrementalRootPrefix})
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, IncrementalVersion)
+	h.Write(b)
+	h.Write(t.nodeHash(t.top))
+	return h.Sum(nil)
+}
+
+// Get returns the hash of the value of the key and whether the key is in the
+// tree. It returns an error if the path to the key is not known, which can
+// only happen in a tree created from a PartialTree.
+func (t *IncrementalTree) Get(key []byte) (HashID, bool, error) {
+	kh := t.keyHash(key)
+	n := t.top
+	for depth := 0; n != nil; depth++ {
+		switch {
+		case n.pruned:
+			return nil, false, errors.New("Path to key is not known")
+		case n.leaf:
+			if bytes.Equal(n.keyHash, kh) {
+				return n.value, true, nil
+			}
+			return nil, false, nil
+		case trieBit(kh, depth) == 0:
+			n = n.left
+		default:
+			n = n.right
+		}
+	}
+	return nil, false, nil
+}
+
+// Apply returns a new tree with the changes of ops, the tree itself is not
+// changed. It also returns the proof of the update, that can be verified with
+// TreeUpdate.Verify against the root of the new tree. Deleting a key that is
+// not in the tree returns an error.
+func (t *IncrementalTree) Apply(ops []*TreeOp) (*IncrementalTree, *TreeUpdate, error) {
+	keys := make([][]byte, len(ops))
+	for i, op := range ops {
+		keys[i] = op.Key
+	}
+	update := &TreeUpdate{
+		OldRoot: t.Root(),
+		Ops:     ops,
+		Proof:   t.Prove(keys...),
+	}
+	nt, err := t.apply(ops)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nt, update, nil
+}
+
+// apply returns a new tree with the changes of ops.
+func (t *IncrementalTree) apply(ops []*TreeOp) (*IncrementalTree, error) {
+	top := t.top
+	for _, op := range ops {
+		var err error
+		kh := t.keyHash(op.Key)
+		if op.Delete {
+			top, err = trieDelete(top, kh, 0)
+		} else {
+			top, err = trieInsert(top, &trieNode{leaf: true, keyHash: kh,
+				value: op.Value}, 0)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Key %x: %s", op.Key, err)
+		}
+	}
+	return &IncrementalTree{newHash: t.newHash, top: top}, nil
+}
+
+// Prove returns the PartialTree holding the paths to the keys. It proves
+// the value of the keys in the tree or that they are not in the tree.
+func (t *IncrementalTree) Prove(keys ...[]byte) *PartialTree {
+	khs := make([]HashID, len(keys))
+	for i, k := range keys {
+		khs[i] = t.keyHash(k)
+	}
+	pt := &PartialTree{Version: IncrementalVersion}
+	t.prune(t.top, khs, 0, pt)
+	return pt
+}
+
+// prune appends the nodes of the subtree to pt, only following the paths of
+// the key hashes.
+func (t *IncrementalTree) prune(n *trieNode, khs []HashID, depth int, pt *PartialTree) {
+	switch {
+	case n == nil:
+		pt.Nodes = append(pt.Nodes, &PartialNode{Type: partialEmpty})
+	case n.leaf:
+		pt.Nodes = append(pt.Nodes, &PartialNode{Type: partialLeaf,
+			KeyHash: n.keyHash, Value: n.value})
+	case len(khs) == 0 || n.pruned:
+		pt.Nodes = append(pt.Nodes, &PartialNode{Type: partialPruned,
+			Hash: t.nodeHash(n)})
+	default:
+		var left, right []HashID
+		for _, kh := range khs {
+			if trieBit(kh, depth) == 0 {
+				left = append(left, kh)
+			} else {
+				right = append(right, kh)
+			}
+		}
+		pt.Nodes = append(pt.Nodes, &PartialNode{Type: partialInner})
+		t.prune(n.left, left, depth+1, pt)
+		t.prune(n.right, right, depth+1, pt)
+	}
+}
+
+// Tree returns the tree described by the PartialTree if its root is the
+// given one. Only the keys whose path is in the PartialTree can be read or
+// changed.
+func (pt *PartialTree) Tree(newHash HashFunc, root HashID) (*IncrementalTree, error) {
+	if pt.Version != IncrementalVersion {
+		return nil, fmt.Errorf("Unknown version %d of partial tree", pt.Version)
+	}
+	size := len(newHash().Sum(nil))
+	pos := 0
+	var decode func(depth int, prefix HashID) (*trieNode, error)
+	decode = func(depth int, prefix HashID) (*trieNode, error) {
+		if pos == len(pt.Nodes) {
+			return nil, errors.New("Partial tree too short")
+		}
+		pn := pt.Nodes[pos]
+		pos++
+		if pn.Type == partialInner && depth >= size*8 {
+			return nil, errors.New("Partial tree too deep")
+		}
+		switch pn.Type {
+		case partialEmpty:
+			return nil, nil
+		case partialLeaf:
+			if len(pn.KeyHash) != size {
+				return nil, errors.New("Wrong size of key hash")
+			}
+			for d := 0; d < depth; d++ {
+				if trieBit(pn.KeyHash, d) != trieBit(prefix, d) {
+					return nil, errors.New("Leaf is not on its path")
+				}
+			}
+			return &trieNode{leaf: true, keyHash: pn.KeyHash,
+				value: pn.Value}, nil
+		case partialPruned:
+			return &trieNode{pruned: true, hash: pn.Hash}, nil
+		case partialInner:
+			n := &trieNode{}
+			var err error
+			if n.left, err = decode(depth+1, trieSetBit(prefix, depth, 0)); err != nil {
+				return nil, err
+			}
+			if n.right, err = decode(depth+1, trieSetBit(prefix, depth, 1)); err != nil {
+				return nil, err
+			}
+			return n, nil
+		}
+		return nil, fmt.Errorf("Unknown type %d of node", pn.Type)
+	}
+	top, err := decode(0, make(HashID, size))
+	if err != nil {
+		return nil, err
+	}
+	if pos != len(pt.Nodes) {
+		return nil, errors.New("Partial tree too long")
+	}
+	t := &IncrementalTree{newHash: newHash, top: top}
+	if subtle.ConstantTimeCompare(t.Root(), root) != 1 {
+		return nil, errors.New("Partial tree doesn't match root")
+	}
+	return t, nil
+}
+
+// Get returns the hash of the value of the key in the tree with the given
+// root and whether the key is in the tree.
+func (pt *PartialTree) Get(newHash HashFunc, root HashID, key []byte) (HashID, bool, error) {
+	t, err := pt.Tree(newHash, root)
+	if err != nil {
+		return nil, false, err
+	}
+	return t.Get(key)
+}
+
+// Verify returns nil if the update changes the tree with root OldRoot to the
+// tree with root newRoot.
+func (tu *TreeUpdate) Verify(newHash HashFunc, newRoot HashID) error {
+	if tu.Proof == nil {
+		return errors.New("No proof given")
+	}
+	t, err := tu.Proof.Tree(newHash, tu.OldRoot)
+	if err != nil {
+		return err
+	}
+	t, err = t.apply(tu.Ops)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(t.Root(), newRoot) != 1 {
+		return errors.New("Update doesn't lead to new root")
+	}
+	return nil
+}
+
+// keyHash returns the hash of the key, which gives its path in the tree.
+func (t *IncrementalTree) keyHash(key []byte) HashID {
+	h := t.newHash()
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// nodeHash returns the hash of the node and stores it in the node.
+func (t *IncrementalTree) nodeHash(n *trieNode) HashID {
+	if n == nil {
+		return make(HashID, t.newHash().Size())
+	}
+	if n.hash != nil {
+		return n.hash
+	}
+	h := t.newHash()
+	if n.leaf {
+		h.Write([]byte{merkleLeafPrefix})
+		h.Write(n.keyHash)
+		h.Write(n.value)
+	} else {
+		h.Write([]byte{merkleNodePrefix})
+		h.Write(t.nodeHash(n.left))
+		h.Write(t.nodeHash(n.right))
+	}
+	n.hash = h.Sum(nil)
+	return n.hash
+}
+
+// trieInsert returns the subtree n with the leaf inserted or replaced.
+func trieInsert(n, leaf *trieNode, depth int) (*trieNode, error) {
+	switch {
+	case n == nil:
+		return leaf, nil
+	case n.pruned:
+		return nil, errors.New("Path to key is not known")
+	case n.leaf:
+		if bytes.Equal(n.keyHash, leaf.keyHash) {
+			return leaf, nil
+		}
+		return trieSplit(n, leaf, depth), nil
+	}
+	nn := &trieNode{left: n.left, right: n.right}
+	var err error
+	if trieBit(leaf.keyHash, depth) == 0 {
+		nn.left, err = trieInsert(n.left, leaf, depth+1)
+	} else {
+		nn.right, err = trieInsert(n.right, leaf, depth+1)
+	}
+	return nn, err
+}
+
+// trieSplit returns the subtree holding the two leaves a and b.
+func trieSplit(a, b *trieNode, depth int) *trieNode {
+	ba, bb := trieBit(a.keyHash, depth), trieBit(b.keyHash, depth)
+	switch {
+	case ba == bb && ba == 0:
+		return &trieNode{left: trieSplit(a, b, depth+1)}
+	case ba == bb:
+		return &trieNode{right: trieSplit(a, b, depth+1)}
+	case ba == 0:
+		return &trieNode{left: a, right: b}
+	}
+	return &trieNode{left: b, right: a}
+}
+
+// trieDelete returns the subtree n without the leaf of the key hash. If only
+// one leaf is left in a subtree, it replaces the subtree.
+func trieDelete(n *trieNode, kh HashID, depth int) (*trieNode, error) {
+	switch {
+	case n == nil:
+		return nil, errors.New("Key not in tree")
+	case n.pruned:
+		return nil, errors.New("Path to key is not known")
+	case n.leaf:
+		if !bytes.Equal(n.keyHash, kh) {
+			return nil, errors.New("Key not in tree")
+		}
+		return nil, nil
+	}
+	nn := &trieNode{left: n.left, right: n.right}
+	var err error
+	if trieBit(kh, depth) == 0 {
+		nn.left, err = trieDelete(n.left, kh, depth+1)
+	} else {
+		nn.right, err = trieDelete(n.right, kh, depth+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case nn.left == nil && nn.right == nil:
+		return nil, nil
+	case nn.left == nil && nn.right.leaf:
+		return nn.right, nil
+	case nn.right == nil && nn.left.leaf:
+		return nn.left, nil
+	}
+	return nn, nil
+}
+
+// trieBit returns the bit of the hash at the given depth.
+func trieBit(h HashID, depth int) int {
+	return int(h[depth/8]>>uint(7-depth%8)) & 1
+}
+
+// trieSetBit returns a copy of the hash with the bit at depth set to b.
+func trieSetBit(h HashID, depth, b int) HashID {
+	c := append(HashID{}, h...)
+	mask := byte(1 << uint(7-depth%8))
+	if b == 0 {
+		c[depth/8] &^= mask
+	} else {
+		c[depth/8] |= mask
+	}
+	return c
+}
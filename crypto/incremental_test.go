@@ -0,0 +1,185 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func incValue(s string) HashID {
+	h := sha256.Sum256([]byte("value-" + s))
+	return h[:]
+}
+
+// incTree returns a tree holding the keys of order, inserted in that order.
+func incTree(t *testing.T, order []int) *IncrementalTree {
+	var ops []*TreeOp
+	for _, i := range order {
+		k := strconv.Itoa(i)
+		ops = append(ops, &TreeOp{Key: []byte(k), Value: incValue(k)})
+	}
+	tree, _, err := NewIncrementalTree(sha256.New).Apply(ops)
+	assert.Nil(t, err)
+	return tree
+}
+
+func TestIncrementalTree(t *testing.T) {
+	up := incTree(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	down := incTree(t, []int{9, 8, 7, 6, 5, 4, 3, 2, 1, 0})
+	assert.Equal(t, up.Root(), down.Root())
+	assert.NotEqual(t, NewIncrementalTree(sha256.New).Root(), up.Root())
+
+	for i := 0; i < 10; i++ {
+		k := strconv.Itoa(i)
+		v, ok, err := up.Get([]byte(k))
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, incValue(k), v)
+	}
+	_, ok, err := up.Get([]byte("10"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// Deleting keys gives the same root as never inserting them, and the
+	// old tree isn't changed.
+	root := up.Root()
+	small, _, err := up.Apply([]*TreeOp{
+		{Key: []byte("3"), Delete: true},
+		{Key: []byte("7"), Delete: true},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, incTree(t, []int{0, 1, 2, 4, 5, 6, 8, 9}).Root(), small.Root())
+	assert.Equal(t, root, up.Root())
+	empty, _, err := incTree(t, []int{1, 2}).Apply([]*TreeOp{
+		{Key: []byte("1"), Delete: true},
+		{Key: []byte("2"), Delete: true},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, NewIncrementalTree(sha256.New).Root(), empty.Root())
+
+	_, _, err = up.Apply([]*TreeOp{{Key: []byte("10"), Delete: true}})
+	assert.NotNil(t, err)
+}
+
+func TestIncrementalTree_Update(t *testing.T) {
+	order := make([]int, 200)
+	for i := range order {
+		order[i] = i
+	}
+	tree := incTree(t, order)
+	ops := []*TreeOp{
+		{Key: []byte("5"), Delete: true},
+		{Key: []byte("17"), Value: incValue("new")},
+		{Key: []byte("new"), Value: incValue("new")},
+		{Key: []byte("199"), Delete: true},
+		{Key: []byte("new2"), Value: incValue("new2")},
+		{Key: []byte("new2"), Delete: true},
+	}
+	nt, update, err := tree.Apply(ops)
+	assert.Nil(t, err)
+	assert.Equal(t, tree.Root(), update.OldRoot)
+	assert.Nil(t, update.Verify(sha256.New, nt.Root()))
+	// The proof is much smaller than the tree
+	assert.True(t, len(update.Proof.Nodes) < 200)
+
+	// Wrong new root, old root or operations
+	assert.NotNil(t, update.Verify(sha256.New, tree.Root()))
+	wrong := *update
+	wrong.OldRoot = nt.Root()
+	assert.NotNil(t, wrong.Verify(sha256.New, nt.Root()))
+	wrong = *update
+	wrong.Ops = ops[1:]
+	assert.NotNil(t, wrong.Verify(sha256.New, nt.Root()))
+	// Changing a key whose path isn't in the proof fails
+	wrong = *update
+	wrong.Ops = append(ops, &TreeOp{Key: []byte("42"), Delete: true})
+	assert.NotNil(t, wrong.Verify(sha256.New, nt.Root()))
+}
+
+func TestPartialTree_Get(t *testing.T) {
+	tree := incTree(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+	root := tree.Root()
+	pt := tree.Prove([]byte("4"), []byte("absent"))
+
+	v, ok, err := pt.Get(sha256.New, root, []byte("4"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, incValue("4"), v)
+	_, ok, err = pt.Get(sha256.New, root, []byte("absent"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, _, err = pt.Get(sha256.New, NewIncrementalTree(sha256.New).Root(), []byte("4"))
+	assert.NotNil(t, err)
+
+	// Keys whose path is not in the partial tree can't be read.
+	for i := 0; i < 13; i++ {
+		k := strconv.Itoa(i)
+		v, ok, err := pt.Get(sha256.New, root, []byte(k))
+		if err == nil {
+			assert.Equal(t, ok, v != nil)
+			if ok {
+				assert.Equal(t, incValue(k), v)
+			}
+		}
+	}
+
+	// Malformed partial trees
+	short := &PartialTree{pt.Version, pt.Nodes[:len(pt.Nodes)-1]}
+	_, err = short.Tree(sha256.New, root)
+	assert.NotNil(t, err)
+	long := &PartialTree{pt.Version, append(pt.Nodes, &PartialNode{})}
+	_, err = long.Tree(sha256.New, root)
+	assert.NotNil(t, err)
+	_, err = (&PartialTree{IncrementalVersion + 1, pt.Nodes}).Tree(sha256.New, root)
+	assert.NotNil(t, err)
+}
+
+// incBenchOps returns the operations to build a tree of merkleBenchLeaves
+// keys and to change merkleBenchProofs of them.
+func incBenchOps() ([]*TreeOp, []*TreeOp) {
+	build := make([]*TreeOp, merkleBenchLeaves)
+	for i := range build {
+		k := strconv.Itoa(i)
+		build[i] = &TreeOp{Key: []byte(k), Value: incValue(k)}
+	}
+	change := make([]*TreeOp, merkleBenchProofs)
+	for i := range change {
+		k := strconv.Itoa(i * merkleBenchLeaves / merkleBenchProofs)
+		change[i] = &TreeOp{Key: []byte(k), Value: incValue("new" + k)}
+	}
+	return build, change
+}
+
+func BenchmarkIncrementalTree_Rebuild(b *testing.B) {
+	build, _ := incBenchOps()
+	for n := 0; n < b.N; n++ {
+		tree, _, err := NewIncrementalTree(sha256.New).Apply(build)
+		if err != nil {
+			b.Fatal(err)
+		}
+		tree.Root()
+	}
+}
+
+func BenchmarkTreeUpdate_Verify(b *testing.B) {
+	build, change := incBenchOps()
+	tree, _, err := NewIncrementalTree(sha256.New).Apply(build)
+	if err != nil {
+		b.Fatal(err)
+	}
+	nt, update, err := tree.Apply(change)
+	if err != nil {
+		b.Fatal(err)
+	}
+	root := nt.Root()
+	b.Logf("Update of %d leaves: %d nodes", len(change), len(update.Proof.Nodes))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := update.Verify(sha256.New, root); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package crypto
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+/*
+MerkleTree builds a Merkle tree that, unlike ProofTree, can't be abused with
+second-preimages or ambiguous positions:
+  - leaves and inner nodes are hashed with a different prefix, so an inner
+    node can't be presented as a leaf
+  - the children of a node are hashed in their order and the tree is not
+    padded, so every position in the tree is unique
+  - the root is the hash of the version, the number of leaves and the top
+    node of the tree
+  - a MerkleProof holds the index of the leaf and the size of the tree, and
+    its verification fails if the path doesn't match them
+
+The tree is built level by level: two neighbouring nodes are hashed together
+and a node without a right neighbour is promoted to the next level. Up to the
+top node, this is the same tree as the one of RFC 6962.
+*/
+
+// MerkleVersion is the version of the construction used by MerkleTree.
+const MerkleVersion = 1
+
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+	merkleRootPrefix = 0x02
+)
+
+// MerkleProof is a proof of inclusion of a leaf in a tree built by
+// MerkleTree.
+type MerkleProof struct {
+	// Version of the construction of the tree
+	Version int
+	// Index of the leaf in the tree
+	Index int
+	// Size is the number of leaves in the tree
+	Size int
+	// Path holds the hashes of the siblings from the leaf to the root
+	Path []HashID
+}
+
+// MerkleLeafHash returns the hash of the leaf holding data.
+func MerkleLeafHash(newHash HashFunc, data []byte) HashID {
+	h := newHash()
+	h.Write([]byte{merkleLeafPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// MerkleNodeHash returns the hash of an inner node with the given children.
+func MerkleNodeHash(newHash HashFunc, left, right HashID) HashID {
+	h := newHash()
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// merkleRootHash returns the root of a tree of the given size and top node.
+func merkleRootHash(newHash HashFunc, version, size int, top HashID) HashID {
+	h := newHash()
+	h.Write([]byte{merkleRootPrefix})
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(version))
+	h.Write(b)
+	binary.BigEndian.PutUint64(b, uint64(size))
+	h.Write(b)
+	h.Write(top)
+	return h.Sum(nil)
+}
+
+// MerkleTree returns the root of the tree over the data of the leaves and a
+// proof for every leaf.
+func MerkleTree(newHash HashFunc, leaves []HashID) (HashID, []*MerkleProof) {
+	if len(leaves) == 0 {
+		return MerkleRoot(newHash, leaves), nil
+	}
+	level := make([]HashID, len(leaves))
+	proofs := make([]*MerkleProof, len(leaves))
+	for i, l := range leaves {
+		level[i] = MerkleLeafHash(newHash, l)
+		proofs[i] = &MerkleProof{
+			Version: MerkleVersion,
+			Index:   i,
+			Size:    len(leaves),
+		}
+	}
+	// pos[i] is the position of the ancestor of leaf i in the actual level.
+	pos := make([]int, len(leaves))
+	for i := range pos {
+		pos[i] = i
+	}
+	for len(level) > 1 {
+		for i, p := range proofs {
+			if s := pos[i] ^ 1; s < len(level) {
+				p.Path = append(p.Path, level[s])
+			}
+			pos[i] >>= 1
+		}
+		level = merkleNextLevel(newHash, level)
+	}
+	return merkleRootHash(newHash, MerkleVersion, len(leaves), level[0]), proofs
+}
+
+// MerkleRoot returns the root of the tree over the data of the leaves
+// without creating the proofs.
+func MerkleRoot(newHash HashFunc, leaves []HashID) HashID {
+	if len(leaves) == 0 {
+		return merkleRootHash(newHash, MerkleVersion, 0, nil)
+	}
+	level := make([]HashID, len(leaves))
+	for i, l := range leaves {
+		level[i] = MerkleLeafHash(newHash, l)
+	}
+	for len(level) > 1 {
+		level = merkleNextLevel(newHash, level)
+	}
+	return merkleRootHash(newHash, MerkleVersion, len(leaves), level[0])
+}
+
+// merkleNextLevel hashes the nodes of level two by two and promotes the last
+// one if it has no right neighbour.
+func merkleNextLevel(newHash HashFunc, level []HashID) []HashID {
+	next := make([]HashID, (len(level)+1)/2)
+	for i := range next {
+		if 2*i+1 < len(level) {
+			next[i] = MerkleNodeHash(newHash, level[2*i], level[2*i+1])
+		} else {
+			next[i] = level[2*i]
+		}
+	}
+	return next
+}
+
+// Calc returns the root computed from the data of the leaf and the proof. It
+// returns an error if the path doesn't match the index and the size of the
+// proof.
+func (p *MerkleProof) Calc(newHash HashFunc, leaf []byte) (HashID, error) {
+	if p.Version != MerkleVersion {
+		return nil, fmt.Errorf("Unknown version %d of Merkle proof", p.Version)
+	}
+	if p.Index < 0 || p.Index >= p.Size {
+		return nil, fmt.Errorf("Index %d out of tree of size %d", p.Index, p.Size)
+	}
+	h := MerkleLeafHash(newHash, leaf)
+	idx, size, used := p.Index, p.Size, 0
+	for ; size > 1; idx, size = idx>>1, (size+1)>>1 {
+		if idx&1 == 0 && idx+1 == size {
+			// No right neighbour, the node is promoted.
+			continue
+		}
+		if used == len(p.Path) {
+			return nil, errors.New("Merkle path too short")
+		}
+		if idx&1 == 1 {
+			h = MerkleNodeHash(newHash, p.Path[used], h)
+		} else {
+			h = MerkleNodeHash(newHash, h, p.Path[used])
+		}
+		used++
+	}
+	if used != len(p.Path) {
+		return nil, errors.New("Merkle path too long")
+	}
+	return merkleRootHash(newHash, p.Version, p.Size, h), nil
+}
+
+// Verify returns nil if the proof shows that leaf is in the tree with the
+// given root, at the index of the proof.
+func (p *MerkleProof) Verify(newHash HashFunc, root HashID, leaf []byte) error {
+	chk, err := p.Calc(newHash, leaf)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(chk, root) != 1 {
+		return errors.New("Merkle proof doesn't match root")
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func merkleLeaves(n int) []HashID {
+	leaves := make([]HashID, n)
+	for i := range leaves {
+		leaves[i] = []byte("data" + strconv.Itoa(i))
+	}
+	return leaves
+}
+
+func TestMerkleTree(t *testing.T) {
+	for n := 1; n < 40; n++ {
+		leaves := merkleLeaves(n)
+		root, proofs := MerkleTree(sha256.New, leaves)
+		assert.Equal(t, root, MerkleRoot(sha256.New, leaves))
+		assert.Equal(t, n, len(proofs))
+		for i, p := range proofs {
+			assert.Equal(t, i, p.Index)
+			assert.Equal(t, n, p.Size)
+			assert.Nil(t, p.Verify(sha256.New, root, leaves[i]),
+				"leaf %d of %d", i, n)
+		}
+	}
+}
+
+func TestMerkleTreeRFC6962(t *testing.T) {
+	// With 3 leaves, the third one is promoted and hashed with the node of
+	// the first two.
+	leaves := merkleLeaves(3)
+	l := make([]HashID, 3)
+	for i := range leaves {
+		l[i] = MerkleLeafHash(sha256.New, leaves[i])
+	}
+	top := MerkleNodeHash(sha256.New, MerkleNodeHash(sha256.New, l[0], l[1]), l[2])
+	root, proofs := MerkleTree(sha256.New, leaves)
+	assert.Equal(t, merkleRootHash(sha256.New, MerkleVersion, 3, top), root)
+	assert.Equal(t, 1, len(proofs[2].Path))
+
+	root, proofs = MerkleTree(sha256.New, nil)
+	assert.Equal(t, merkleRootHash(sha256.New, MerkleVersion, 0, nil), root)
+	assert.Nil(t, proofs)
+}
+
+func TestMerkleProofReject(t *testing.T) {
+	leaves := merkleLeaves(5)
+	root, proofs := MerkleTree(sha256.New, leaves)
+	p := proofs[1]
+
+	assert.NotNil(t, p.Verify(sha256.New, root, leaves[2]))
+	assert.NotNil(t, p.Verify(sha256.New, leaves[1], leaves[1]))
+
+	// An inner node can't be presented as a leaf, even if the size of the
+	// tree matches.
+	leaves = merkleLeaves(4)
+	root4, proofs4 := MerkleTree(sha256.New, leaves)
+	inner := MerkleNodeHash(sha256.New, MerkleLeafHash(sha256.New, leaves[0]),
+		MerkleLeafHash(sha256.New, leaves[1]))
+	fake := &MerkleProof{MerkleVersion, 0, 4, proofs4[0].Path[1:]}
+	assert.NotNil(t, fake.Verify(sha256.New, root4, inner))
+	fake = &MerkleProof{MerkleVersion, 0, 2, proofs4[0].Path[1:]}
+	assert.NotNil(t, fake.Verify(sha256.New, root4, inner))
+	leaves = merkleLeaves(5)
+
+	// Wrong index, size or version
+	for _, wrong := range []*MerkleProof{
+		{MerkleVersion, 0, p.Size, p.Path},
+		{MerkleVersion, p.Index, 4, p.Path},
+		{MerkleVersion, p.Index, 8, p.Path},
+		{MerkleVersion, 5, p.Size, p.Path},
+		{MerkleVersion, -1, p.Size, p.Path},
+		{MerkleVersion + 1, p.Index, p.Size, p.Path},
+		{MerkleVersion, p.Index, p.Size, p.Path[1:]},
+		{MerkleVersion, p.Index, p.Size, append(p.Path, root)},
+	} {
+		assert.NotNil(t, wrong.Verify(sha256.New, root, leaves[1]))
+	}
+	assert.Nil(t, p.Verify(sha256.New, root, leaves[1]))
+}
+
+// merkleBenchLeaves is the number of leaves in the tree of the benchmarks and
+// merkleBenchProofs the number of leaves to prove.
+const (
+	merkleBenchLeaves = 50000
+	merkleBenchProofs = 2000
+)
+
+func merkleBenchSetup() ([]HashID, []int) {
+	leaves := make([]HashID, merkleBenchLeaves)
+	for i := range leaves {
+		h := sha256.Sum256([]byte(strconv.Itoa(i)))
+		leaves[i] = h[:]
+	}
+	indices := make([]int, merkleBenchProofs)
+	for i := range indices {
+		indices[i] = i * merkleBenchLeaves / merkleBenchProofs
+	}
+	return leaves, indices
+}
+
+func BenchmarkMerkleProof_Verify(b *testing.B) {
+	leaves, indices := merkleBenchSetup()
+	root, proofs := MerkleTree(sha256.New, leaves)
+	var size int
+	for _, i := range indices {
+		size += 3 * 8
+		for _, h := range proofs[i].Path {
+			size += len(h)
+		}
+	}
+	b.Logf("%d individual proofs: %d bytes", len(indices), size)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, i := range indices {
+			if err := proofs[i].Verify(sha256.New, root, leaves[i]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -118,6 +118,8 @@ func sibling(i int) int {
 
 // ProofTree - Generates a Merkle proof tree for the given list of leaves,
 // yielding one output proof per leaf.
+// Leaves and nodes are hashed the same way and the proofs don't bind the
+// position of the leaf, so new code should use MerkleTree.
 func ProofTree(newHash func() gohash.Hash, leaves []HashID) (HashID, []Proof) {
 	if len(leaves) == 0 {
 		return HashID(""), nil
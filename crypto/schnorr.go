@@ -63,6 +63,19 @@ func VerifySchnorr(suite abstract.Suite, public abstract.Point, msg []byte, sig
 	return nil
 }
 
+// VerifySignature returns nil iff sig is present and a valid Schnorr signature
+// of public on msg. It is used to check requests signed by the owner or the
+// operator of a service.
+func VerifySignature(suite abstract.Suite, public abstract.Point, msg []byte, sig *SchnorrSig) error {
+	if sig == nil {
+		return errors.New("Missing signature")
+	}
+	if err := VerifySchnorr(suite, public, msg, *sig); err != nil {
+		return errors.New("Wrong signature: " + err.Error())
+	}
+	return nil
+}
+
 func hash(suite abstract.Suite, r abstract.Point, msg []byte) (abstract.Scalar, error) {
 	rBuf, err := r.MarshalBinary()
 	if err != nil {
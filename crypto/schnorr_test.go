@@ -21,3 +21,24 @@ func TestSchnorrSignature(t *testing.T) {
 		t.Fatalf("Couldn't verify signature: \n%+v\nfor msg:'%s'. Error:\n%v", s, msg, err)
 	}
 }
+
+func TestVerifySignature(t *testing.T) {
+	msg := []byte("Hello Schnorr")
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	kp := config.NewKeyPair(suite)
+	other := config.NewKeyPair(suite)
+
+	s, err := SignSchnorr(suite, kp.Secret, msg)
+	if err != nil {
+		t.Fatalf("Couldn't sign msg: %s: %v", msg, err)
+	}
+	if err := VerifySignature(suite, kp.Public, msg, &s); err != nil {
+		t.Fatal("Couldn't verify signature:", err)
+	}
+	if VerifySignature(suite, kp.Public, msg, nil) == nil {
+		t.Fatal("Missing signature accepted")
+	}
+	if VerifySignature(suite, other.Public, msg, &s) == nil {
+		t.Fatal("Signature of another key accepted")
+	}
+}
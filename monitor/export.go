@@ -0,0 +1,185 @@
+package monitor
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ExportKey is the key of the run config that holds the export formats, a
+// comma-separated list:
+//
+//	Export = "json,csv"
+//
+// Contrary to WriteHeader / WriteValues, the exported formats don't depend on
+// which measures were present in a run, so they can be read by name instead
+// of by column position.
+const ExportKey = "export"
+
+// ExportJSON writes one JSON object per run, one run per line (JSON Lines).
+const ExportJSON = "json"
+
+// ExportCSV writes one line per run and measure, with a fixed header.
+const ExportCSV = "csv"
+
+// ExportFormats parses a comma-separated list of formats and returns them
+// without duplicates. An empty string returns no formats.
+func ExportFormats(formats string) ([]string, error) {
+	var ret []string
+	seen := make(map[string]bool)
+	for _, f := range strings.Split(formats, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" || seen[f] {
+			continue
+		}
+		if f != ExportJSON && f != ExportCSV {
+			return nil, errors.New("Unknown export format: " + f)
+		}
+		seen[f] = true
+		ret = append(ret, f)
+	}
+	return ret, nil
+}
+
+// RunExport holds all data of one run: the running config and the
+// distribution of every measure.
+type RunExport struct {
+	Config   map[string]string `json:"config"`
+	Measures []*MeasureExport  `json:"measures"`
+}
+
+// MeasureExport holds the statistics of one measure. The statistics are
+// computed over the filtered values, while the percentiles, the histogram and
+// Samples are over every value received.
+type MeasureExport struct {
+	Name      string    `json:"name"`
+	N         int       `json:"n"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Avg       float64   `json:"avg"`
+	Sum       float64   `json:"sum"`
+	Dev       float64   `json:"dev"`
+	P50       float64   `json:"p50"`
+	P90       float64   `json:"p90"`
+	P99       float64   `json:"p99"`
+	Histogram []Bucket  `json:"histogram"`
+	Samples   []float64 `json:"samples"`
+}
+
+// csvFields are the fields written for every measure in the CSV export.
+// The percentiles come after the samples to keep the columns of older files.
+var csvFields = []string{"measure", "n", "min", "max", "avg", "sum", "dev",
+	"samples", "p50", "p90", "p99"}
+
+// Export returns the running config and all measures of this Stats, ordered
+// by name.
+func (s *Stats) Export() *RunExport {
+	s.valuesMutex.Lock()
+	defer s.valuesMutex.Unlock()
+	s.Collect()
+	re := &RunExport{
+		Config:   make(map[string]string),
+		Measures: make([]*MeasureExport, 0, len(s.keys)),
+	}
+	for k, v := range s.config {
+		re.Config[k] = v
+	}
+	for _, k := range s.keys {
+		v := s.values[k]
+		re.Measures = append(re.Measures, &MeasureExport{
+			Name:      k,
+			N:         v.NumValue(),
+			Min:       v.Min(),
+			Max:       v.Max(),
+			Avg:       v.Avg(),
+			Sum:       v.Sum(),
+			Dev:       v.Dev(),
+			P50:       v.Percentile(50),
+			P90:       v.Percentile(90),
+			P99:       v.Percentile(99),
+			Histogram: v.Histogram(),
+			Samples:   v.Samples(),
+		})
+	}
+	return re
+}
+
+// sortedKeys returns the keys of the map, sorted.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteJSON writes the export of this Stats as one JSON line.
+func (s *Stats) WriteJSON(w io.Writer) error {
+	re := s.Export()
+	// json.Marshal doesn't know about NaN, which is what the deviation is
+	// for a single value.
+	for _, m := range re.Measures {
+		m.Dev = finite(m.Dev)
+	}
+	return json.NewEncoder(w).Encode(re)
+}
+
+// WriteCSVHeader writes the header of the CSV export: all keys of the running
+// config, followed by the fields of a measure.
+func (s *Stats) WriteCSVHeader(w io.Writer) error {
+	s.valuesMutex.Lock()
+	header := append(sortedKeys(s.config), csvFields...)
+	s.valuesMutex.Unlock()
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteCSV writes one CSV line per measure. Each line starts with the values
+// of the running config, in the order of WriteCSVHeader. The samples are
+// separated by a ';'.
+func (s *Stats) WriteCSV(w io.Writer) error {
+	re := s.Export()
+	var config []string
+	for _, k := range sortedKeys(re.Config) {
+		config = append(config, re.Config[k])
+	}
+	cw := csv.NewWriter(w)
+	for _, m := range re.Measures {
+		samples := make([]string, len(m.Samples))
+		for i, v := range m.Samples {
+			samples[i] = formatFloat(v)
+		}
+		line := append(append([]string{}, config...), m.Name,
+			strconv.Itoa(m.N), formatFloat(m.Min), formatFloat(m.Max),
+			formatFloat(m.Avg), formatFloat(m.Sum), formatFloat(m.Dev),
+			strings.Join(samples, ";"),
+			formatFloat(m.P50), formatFloat(m.P90), formatFloat(m.P99))
+		if err := cw.Write(line); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(finite(f), 'f', -1, 64)
+}
+
+// finite returns 0 for NaN and infinite values.
+func finite(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	return f
+}
@@ -0,0 +1,80 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportFormats(t *testing.T) {
+	f, err := ExportFormats("")
+	require.Nil(t, err)
+	assert.Equal(t, 0, len(f))
+	f, err = ExportFormats(" JSON, csv,json")
+	require.Nil(t, err)
+	assert.Equal(t, []string{ExportJSON, ExportCSV}, f)
+	_, err = ExportFormats("json,xml")
+	assert.NotNil(t, err)
+}
+
+func TestStatsWriteJSON(t *testing.T) {
+	rc := map[string]string{"servers": "2", "hosts": "4", "suite": "stable"}
+	stats := NewStats(rc, "hosts")
+	stats.Update(NewSingleMeasure("round_wall", 10))
+	stats.Update(NewSingleMeasure("round_wall", 30))
+	stats.Update(NewSingleMeasure("setup_wall", 5))
+
+	buf := new(bytes.Buffer)
+	require.Nil(t, stats.WriteJSON(buf))
+	// Writing twice must not change the statistics
+	buf2 := new(bytes.Buffer)
+	require.Nil(t, stats.WriteJSON(buf2))
+	assert.Equal(t, buf.String(), buf2.String())
+
+	re := &RunExport{}
+	require.Nil(t, json.Unmarshal(buf.Bytes(), re))
+	assert.Equal(t, rc, re.Config)
+	require.Equal(t, 2, len(re.Measures))
+	round := re.Measures[0]
+	assert.Equal(t, "round_wall", round.Name)
+	assert.Equal(t, 2, round.N)
+	assert.Equal(t, 10.0, round.Min)
+	assert.Equal(t, 30.0, round.Max)
+	assert.Equal(t, 20.0, round.Avg)
+	assert.Equal(t, []float64{10, 30}, round.Samples)
+	assert.Equal(t, 2, len(round.Histogram))
+	assert.InEpsilon(t, 30, round.P99, DefaultSketchAccuracy)
+	setup := re.Measures[1]
+	assert.Equal(t, "setup_wall", setup.Name)
+	assert.Equal(t, 0.0, setup.Dev)
+}
+
+func TestStatsWriteCSV(t *testing.T) {
+	rc := map[string]string{"servers": "2", "hosts": "4"}
+	stats1 := NewStats(rc)
+	stats1.Update(NewSingleMeasure("round_wall", 10))
+	stats2 := NewStats(rc)
+	stats2.Update(NewSingleMeasure("round_wall", 30))
+	stats2.Update(NewSingleMeasure("setup_wall", 5))
+	avg := AverageStats([]*Stats{stats1, stats2})
+
+	buf := new(bytes.Buffer)
+	require.Nil(t, stats1.WriteCSVHeader(buf))
+	require.Nil(t, stats1.WriteCSV(buf))
+	require.Nil(t, avg.WriteCSV(buf))
+	lines, err := csv.NewReader(buf).ReadAll()
+	require.Nil(t, err)
+	require.Equal(t, 3, len(lines))
+	assert.Equal(t, []string{"hosts", "servers", "measure", "n", "min",
+		"max", "avg", "sum", "dev", "samples", "p50", "p90", "p99"}, lines[0])
+	assert.Equal(t, []string{"4", "2", "round_wall", "1", "10", "10", "10",
+		"10", "0", "10", "10", "10", "10"}, lines[1])
+	// The median is the value of the bucket of 10 in the merged sketch
+	assert.Equal(t, []string{"4", "2", "round_wall", "2", "10", "30", "20",
+		"40", "14.142135623730951", "10;30", "10.074696689511264", "30",
+		"30"}, lines[2])
+}
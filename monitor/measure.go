@@ -242,9 +242,20 @@ func (dm *DockerMeasure) Record() {
 	}
 }
 
-// Send transmits the given struct over the network.
+// Send transmits the given struct over the network. If the metrics are
+// enabled, the measure is also stored in the metrics, and it is not an error
+// to have no sink.
 func send(v interface{}) error {
+	m := getMetrics()
+	if m != nil && enabled {
+		if sm, ok := v.(*SingleMeasure); ok {
+			m.record(sm)
+		}
+	}
 	if encoder == nil {
+		if m != nil {
+			return nil
+		}
 		return fmt.Errorf("Monitor's sink connection not initalized. Can not send any measures")
 	}
 	if !enabled {
@@ -0,0 +1,290 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dedis/cothority/log"
+)
+
+// Metrics is the production counterpart of the Monitor: instead of sending
+// the measures to a sink, every measure recorded is kept locally, together
+// with the metrics of the registered Collectors, and served in the
+// OpenMetrics text format (https://openmetrics.io) so it can be scraped by
+// Prometheus and alike.
+//
+// Usage:
+//
+//	m := monitor.EnableMetrics()
+//	m.Register(host)
+//	go monitor.ServeMetrics("127.0.0.1:9100")
+//
+// Once enabled, all measures recorded through Measure.Record are stored as a
+// summary 'cothority_measure' with a 'name'-label holding the name of the
+// measure, even if no sink is connected.
+type Metrics struct {
+	collectors []Collector
+	measures   map[string]*measureSummary
+	sync.Mutex
+}
+
+// MetricType is the type of a metric as defined by OpenMetrics.
+type MetricType string
+
+// The types of metrics that can be returned by a Collector.
+const (
+	// MetricCounter is a value that only goes up. Its name must end with
+	// "_total".
+	MetricCounter MetricType = "counter"
+	// MetricGauge is a value that can go up and down
+	MetricGauge MetricType = "gauge"
+	// MetricSummary is a distribution of values, the Value being the sum
+	// of all values.
+	MetricSummary MetricType = "summary"
+)
+
+// Metric is one sample of a metric family. Metrics with the same name are
+// written together and must have the same Type and Help.
+type Metric struct {
+	Name   string
+	Type   MetricType
+	Help   string
+	Labels map[string]string
+	// Value is the value of a counter or gauge, or the sum of a summary
+	Value float64
+	// Count and Quantiles are only used by summaries
+	Count     uint64
+	Quantiles map[float64]float64
+}
+
+// Collector is implemented by all structures that want to expose their
+// metrics. Collect is called each time the metrics are scraped and must be
+// safe to be called concurrently.
+type Collector interface {
+	Collect() []*Metric
+}
+
+// SummaryQuantiles are the quantiles written for every summary.
+var SummaryQuantiles = []float64{0.5, 0.9, 0.99}
+
+// NewSummaryMetric returns a summary metric out of the given sketch and sum.
+func NewSummaryMetric(name, help string, labels map[string]string,
+	s *Sketch, sum float64) *Metric {
+	m := &Metric{
+		Name:      name,
+		Type:      MetricSummary,
+		Help:      help,
+		Labels:    labels,
+		Value:     sum,
+		Count:     s.Count(),
+		Quantiles: make(map[float64]float64),
+	}
+	for _, q := range SummaryQuantiles {
+		m.Quantiles[q] = s.Quantile(q)
+	}
+	return m
+}
+
+// measureSummary holds the distribution of all values of a measure
+type measureSummary struct {
+	sketch *Sketch
+	sum    float64
+}
+
+// metrics is nil as long as EnableMetrics has not been called.
+var metrics *Metrics
+var metricsMutex sync.Mutex
+
+// EnableMetrics starts keeping all measures recorded and returns the
+// Metrics. Calling it more than once returns the same Metrics.
+func EnableMetrics() *Metrics {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	if metrics == nil {
+		metrics = &Metrics{measures: make(map[string]*measureSummary)}
+	}
+	return metrics
+}
+
+// MetricsEnabled returns whether EnableMetrics has been called.
+func MetricsEnabled() bool {
+	return getMetrics() != nil
+}
+
+func getMetrics() *Metrics {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	return metrics
+}
+
+// Register adds a Collector whose metrics will be written together with the
+// measures.
+func (m *Metrics) Register(c Collector) {
+	m.Lock()
+	defer m.Unlock()
+	m.collectors = append(m.collectors, c)
+}
+
+// record stores the value of the measure.
+func (m *Metrics) record(sm *SingleMeasure) {
+	m.Lock()
+	defer m.Unlock()
+	ms, ok := m.measures[sm.Name]
+	if !ok {
+		ms = &measureSummary{sketch: NewSketch(DefaultSketchAccuracy)}
+		m.measures[sm.Name] = ms
+	}
+	ms.sketch.Add(sm.Value)
+	ms.sum += sm.Value
+}
+
+// Collect implements the Collector interface and returns one summary per
+// measure recorded.
+func (m *Metrics) Collect() []*Metric {
+	m.Lock()
+	defer m.Unlock()
+	names := make([]string, 0, len(m.measures))
+	for n := range m.measures {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var ret []*Metric
+	for _, n := range names {
+		ms := m.measures[n]
+		ret = append(ret, NewSummaryMetric("cothority_measure",
+			"Measures recorded by the cothority",
+			map[string]string{"name": n}, ms.sketch, ms.sum))
+	}
+	return ret
+}
+
+// Write writes the metrics of all registered Collectors and the measures in
+// the OpenMetrics text format.
+func (m *Metrics) Write(w io.Writer) error {
+	m.Lock()
+	collectors := append([]Collector{}, m.collectors...)
+	m.Unlock()
+	var all []*Metric
+	for _, c := range collectors {
+		all = append(all, c.Collect()...)
+	}
+	all = append(all, m.Collect()...)
+
+	// Group the metrics of the same family, keeping the order of the
+	// first appearance.
+	var families []string
+	samples := make(map[string][]*Metric)
+	for _, metric := range all {
+		if _, ok := samples[metric.Name]; !ok {
+			families = append(families, metric.Name)
+		}
+		samples[metric.Name] = append(samples[metric.Name], metric)
+	}
+	var buf bytes.Buffer
+	for _, f := range families {
+		first := samples[f][0]
+		family := strings.TrimSuffix(f, "_total")
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", family, first.Type)
+		if first.Help != "" {
+			fmt.Fprintf(&buf, "# HELP %s %s\n", family, escapeHelp(first.Help))
+		}
+		for _, metric := range samples[f] {
+			writeMetric(&buf, metric)
+		}
+	}
+	buf.WriteString("# EOF\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ContentTypeOpenMetrics is the content-type of the text written by Write.
+const ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// ServeHTTP implements the http.Handler interface and writes the metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentTypeOpenMetrics)
+	if err := m.Write(w); err != nil {
+		log.Error("Couldn't write metrics:", err)
+	}
+}
+
+// MetricsTimeout is the read and write timeout of the server started by
+// ServeMetrics, so that slow scrapers can't keep connections open.
+const MetricsTimeout = 10 * time.Second
+
+// ServeMetrics enables the metrics and serves them on addr under /metrics.
+// It blocks until the server stops and returns the error.
+func ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", EnableMetrics())
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  MetricsTimeout,
+		WriteTimeout: MetricsTimeout,
+	}
+	log.Lvl2("Serving metrics on", addr)
+	return server.ListenAndServe()
+}
+
+func writeMetric(w io.Writer, m *Metric) {
+	switch m.Type {
+	case MetricSummary:
+		qs := make([]float64, 0, len(m.Quantiles))
+		for q := range m.Quantiles {
+			qs = append(qs, q)
+		}
+		sort.Float64s(qs)
+		for _, q := range qs {
+			labels := map[string]string{"quantile": fmt.Sprint(q)}
+			for k, v := range m.Labels {
+				labels[k] = v
+			}
+			fmt.Fprintf(w, "%s%s %s\n", m.Name, formatLabels(labels),
+				formatFloat(m.Quantiles[q]))
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.Name, formatLabels(m.Labels),
+			formatFloat(m.Value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.Name, formatLabels(m.Labels),
+			m.Count)
+	default:
+		fmt.Fprintf(w, "%s%s %s\n", m.Name, formatLabels(m.Labels),
+			formatFloat(m.Value))
+	}
+}
+
+var invalidName = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// formatLabels returns the labels sorted by name, or an empty string if
+// there are no labels.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := sortedKeys(labels)
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", invalidName.ReplaceAllString(n, "_"),
+			escapeLabel(labels[n]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+var helpEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
@@ -0,0 +1,65 @@
+package monitor
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type dummyCollector struct{}
+
+func (d *dummyCollector) Collect() []*Metric {
+	return []*Metric{
+		{Name: "dummy_rx_bytes_total", Type: MetricCounter, Help: "Bytes received",
+			Value: 1024},
+		{Name: "dummy_chain_length", Type: MetricGauge,
+			Labels: map[string]string{"repo": "stable"}, Value: 3},
+		{Name: "dummy_chain_length", Type: MetricGauge,
+			Labels: map[string]string{"repo": "te\"sting"}, Value: 5},
+	}
+}
+
+func TestMetricsMeasures(t *testing.T) {
+	m := EnableMetrics()
+	defer func() { metrics = nil }()
+	assert.True(t, MetricsEnabled())
+	assert.Equal(t, m, EnableMetrics())
+	m.Register(&dummyCollector{})
+
+	// No sink is connected, but the measures must be recorded anyway.
+	NewSingleMeasure("verify", 1).Record()
+	NewSingleMeasure("verify", 3).Record()
+
+	buf := new(bytes.Buffer)
+	require.Nil(t, m.Write(buf))
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE dummy_rx_bytes counter",
+		"# HELP dummy_rx_bytes Bytes received",
+		"dummy_rx_bytes_total 1024",
+		"# TYPE dummy_chain_length gauge",
+		`dummy_chain_length{repo="stable"} 3`,
+		`dummy_chain_length{repo="te\"sting"} 5`,
+		"# TYPE cothority_measure summary",
+		`cothority_measure_sum{name="verify"} 4`,
+		`cothority_measure_count{name="verify"} 2`,
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+	assert.Contains(t, out, `cothority_measure{name="verify",quantile="0.99"} `)
+	assert.True(t, strings.HasSuffix(out, "# EOF\n"))
+	assert.Equal(t, 1, strings.Count(out, "# TYPE dummy_chain_length"))
+}
+
+func TestMetricsServeHTTP(t *testing.T) {
+	m := EnableMetrics()
+	defer func() { metrics = nil }()
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, ContentTypeOpenMetrics, rec.Header().Get("Content-Type"))
+	assert.Equal(t, "# EOF\n", rec.Body.String())
+}
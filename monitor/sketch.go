@@ -0,0 +1,198 @@
+package monitor
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// DefaultSketchAccuracy is the relative accuracy of the quantiles returned by
+// the Sketch of a Value: a quantile q is returned as a value within 1% of the
+// real value of q.
+const DefaultSketchAccuracy = 0.01
+
+// Sketch is a streaming quantile sketch with a relative accuracy, following
+// the idea of DDSketch (https://arxiv.org/abs/1908.10693). Values are
+// stored in buckets with logarithmically growing bounds, so two sketches with
+// the same accuracy can be merged by adding up their buckets, and the
+// quantiles of the merged sketch are as accurate as the quantiles of a sketch
+// that saw all values.
+type Sketch struct {
+	accuracy float64
+	gamma    float64
+	logGamma float64
+	// buckets for positive and negative values. The bucket k holds values
+	// in (gamma^(k-1), gamma^k]. Negative values are stored by their
+	// absolute value.
+	positive map[int]uint64
+	negative map[int]uint64
+	zeros    uint64
+	count    uint64
+	min      float64
+	max      float64
+}
+
+// Bucket is one bar of the histogram of a Sketch, holding the Count values
+// that are in (Lower, Upper].
+type Bucket struct {
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+	Count uint64  `json:"count"`
+}
+
+// NewSketch returns an empty Sketch with the given relative accuracy, which
+// must be in (0, 1).
+func NewSketch(accuracy float64) *Sketch {
+	gamma := (1 + accuracy) / (1 - accuracy)
+	return &Sketch{
+		accuracy: accuracy,
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		positive: make(map[int]uint64),
+		negative: make(map[int]uint64),
+	}
+}
+
+// Add stores the value in the sketch.
+func (s *Sketch) Add(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	switch {
+	case v > 0:
+		s.positive[s.index(v)]++
+	case v < 0:
+		s.negative[s.index(-v)]++
+	default:
+		s.zeros++
+	}
+}
+
+// Merge adds all values of other to this sketch. Both sketches must have the
+// same accuracy.
+func (s *Sketch) Merge(other *Sketch) error {
+	if other.accuracy != s.accuracy {
+		return errors.New("Cannot merge sketches with different accuracies")
+	}
+	if other.count == 0 {
+		return nil
+	}
+	if s.count == 0 || other.min < s.min {
+		s.min = other.min
+	}
+	if s.count == 0 || other.max > s.max {
+		s.max = other.max
+	}
+	for k, c := range other.positive {
+		s.positive[k] += c
+	}
+	for k, c := range other.negative {
+		s.negative[k] += c
+	}
+	s.zeros += other.zeros
+	s.count += other.count
+	return nil
+}
+
+// Count returns the number of values added.
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+// Quantile returns the value at the quantile q, with q in [0, 1]. An empty
+// sketch returns 0.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+	// nearest rank, starting at 0
+	rank := uint64(math.Ceil(q*float64(s.count))) - 1
+	var seen uint64
+	for _, b := range s.Histogram() {
+		seen += b.Count
+		if seen > rank {
+			return s.clamp(s.value(b))
+		}
+	}
+	return s.max
+}
+
+// Histogram returns the non-empty buckets of the sketch, ordered from the
+// smallest to the biggest values. Zeros are returned in a bucket with both
+// bounds at 0.
+func (s *Sketch) Histogram() []Bucket {
+	var buckets []Bucket
+	for _, k := range sortedIndexes(s.negative, true) {
+		buckets = append(buckets, Bucket{
+			Lower: -s.bound(k),
+			Upper: -s.bound(k - 1),
+			Count: s.negative[k],
+		})
+	}
+	if s.zeros > 0 {
+		buckets = append(buckets, Bucket{Count: s.zeros})
+	}
+	for _, k := range sortedIndexes(s.positive, false) {
+		buckets = append(buckets, Bucket{
+			Lower: s.bound(k - 1),
+			Upper: s.bound(k),
+			Count: s.positive[k],
+		})
+	}
+	return buckets
+}
+
+// index returns the bucket of the positive value v.
+func (s *Sketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// bound returns the upper bound of the bucket k.
+func (s *Sketch) bound(k int) float64 {
+	return math.Pow(s.gamma, float64(k))
+}
+
+// value returns the value representing all values of the bucket, which is
+// within the accuracy of all of them.
+func (s *Sketch) value(b Bucket) float64 {
+	if b.Lower == 0 && b.Upper == 0 {
+		return 0
+	}
+	if b.Upper < 0 {
+		return 2 * b.Lower / (s.gamma + 1)
+	}
+	return 2 * b.Upper / (s.gamma + 1)
+}
+
+// clamp makes sure v is in [min, max].
+func (s *Sketch) clamp(v float64) float64 {
+	return math.Max(s.min, math.Min(s.max, v))
+}
+
+// sortedIndexes returns the indexes of the buckets, in increasing or
+// decreasing order.
+func sortedIndexes(buckets map[int]uint64, decreasing bool) []int {
+	indexes := make([]int, 0, len(buckets))
+	for k := range buckets {
+		indexes = append(indexes, k)
+	}
+	if decreasing {
+		sort.Sort(sort.Reverse(sort.IntSlice(indexes)))
+	} else {
+		sort.Ints(indexes)
+	}
+	return indexes
+}
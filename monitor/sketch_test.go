@@ -0,0 +1,80 @@
+package monitor
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSketchQuantile(t *testing.T) {
+	s := NewSketch(DefaultSketchAccuracy)
+	assert.Equal(t, 0.0, s.Quantile(0.5))
+	var values []float64
+	for i := 0; i < 10000; i++ {
+		v := rand.ExpFloat64() * 100
+		values = append(values, v)
+		s.Add(v)
+	}
+	sort.Float64s(values)
+	assert.Equal(t, uint64(len(values)), s.Count())
+	assert.Equal(t, values[0], s.Quantile(0))
+	assert.Equal(t, values[len(values)-1], s.Quantile(1))
+	for _, q := range []float64{0.1, 0.5, 0.9, 0.99} {
+		exact := values[int(q*float64(len(values)-1))]
+		assert.InEpsilon(t, exact, s.Quantile(q), DefaultSketchAccuracy)
+	}
+}
+
+func TestSketchNegative(t *testing.T) {
+	s := NewSketch(DefaultSketchAccuracy)
+	for _, v := range []float64{-10, -5, 0, 5, 10} {
+		s.Add(v)
+	}
+	assert.InEpsilon(t, -10, s.Quantile(0.1), DefaultSketchAccuracy)
+	assert.InEpsilon(t, -5, s.Quantile(0.25), DefaultSketchAccuracy)
+	assert.Equal(t, 0.0, s.Quantile(0.5))
+	assert.InEpsilon(t, 5, s.Quantile(0.75), DefaultSketchAccuracy)
+	h := s.Histogram()
+	require.Equal(t, 5, len(h))
+	for i := 1; i < len(h); i++ {
+		assert.True(t, h[i-1].Upper <= h[i].Lower)
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	all := NewSketch(DefaultSketchAccuracy)
+	s1 := NewSketch(DefaultSketchAccuracy)
+	s2 := NewSketch(DefaultSketchAccuracy)
+	for i := 1; i <= 1000; i++ {
+		all.Add(float64(i))
+		if i%3 == 0 {
+			s1.Add(float64(i))
+		} else {
+			s2.Add(float64(i))
+		}
+	}
+	require.Nil(t, s1.Merge(s2))
+	for _, q := range []float64{0, 0.5, 0.9, 0.99, 1} {
+		assert.Equal(t, all.Quantile(q), s1.Quantile(q))
+	}
+	assert.NotNil(t, s1.Merge(NewSketch(0.05)))
+}
+
+func TestValuePercentile(t *testing.T) {
+	v1 := NewValue("latency")
+	v2 := NewValue("latency")
+	for i := 1; i <= 100; i++ {
+		v1.Store(float64(i))
+		v2.Store(float64(i + 100))
+	}
+	assert.InEpsilon(t, 50, v1.Percentile(50), DefaultSketchAccuracy)
+	avg := AverageValue(v1, v2)
+	assert.InEpsilon(t, 100, avg.Percentile(50), DefaultSketchAccuracy)
+	assert.InEpsilon(t, 180, avg.Percentile(90), DefaultSketchAccuracy)
+	assert.InEpsilon(t, 198, avg.Percentile(99), DefaultSketchAccuracy)
+	assert.False(t, math.IsNaN(new(Value).Percentile(50)))
+}
@@ -25,6 +25,8 @@ type Stats struct {
 	// running config.
 	static     map[string]int
 	staticKeys []string
+	// The complete running config, kept for the exports
+	config map[string]string
 
 	// The received measures we have and the keys ordered
 	values map[string]*Value
@@ -50,6 +52,7 @@ func (s *Stats) init() *Stats {
 	s.keys = make([]string, 0)
 	s.static = make(map[string]int)
 	s.staticKeys = make([]string, 0)
+	s.config = make(map[string]string)
 	return s
 }
 
@@ -86,6 +89,11 @@ func (s *Stats) WriteHeader(w io.Writer) {
 		v := s.values[k]
 		fields = append(fields, v.HeaderFields()...)
 	}
+	// The percentiles come last to keep the columns of older files
+	for _, k := range s.keys {
+		v := s.values[k]
+		fields = append(fields, v.PercentileHeaderFields()...)
+	}
 	fmt.Fprintf(w, "%s", strings.Join(fields, ","))
 	fmt.Fprintf(w, "\n")
 }
@@ -108,6 +116,10 @@ func (s *Stats) WriteValues(w io.Writer) {
 		v := s.values[k]
 		values = append(values, v.Values()...)
 	}
+	for _, k := range s.keys {
+		v := s.values[k]
+		values = append(values, v.PercentileValues()...)
+	}
 	fmt.Fprintf(w, "%s", strings.Join(values, ","))
 	fmt.Fprintf(w, "\n")
 }
@@ -123,6 +135,7 @@ func AverageStats(stats []*Stats) *Stats {
 	s.filter = stats[0].filter
 	s.static = stats[0].static
 	s.staticKeys = stats[0].staticKeys
+	s.config = stats[0].config
 	s.keys = stats[0].keys
 	// Average
 	for _, k := range s.keys {
@@ -248,6 +261,9 @@ func (s *Stats) String() string {
 
 // Read a config file and fills up some fields for Stats struct
 func (s *Stats) readRunConfig(rc map[string]string, defaults ...string) {
+	for k, v := range rc {
+		s.config[k] = v
+	}
 	// First find the defaults keys
 	for _, def := range defaults {
 		valStr, ok := rc[def]
@@ -311,11 +327,18 @@ type Value struct {
 
 	// Store where are kept the values
 	store []float64
+	// filtered holds the values of store that passed the DataFilter, so
+	// that filtering twice doesn't cut off more values.
+	filtered []float64
+	// sketch holds the distribution of all values stored, it is merged
+	// when averaging Values.
+	sketch *Sketch
 }
 
 // NewValue returns a new value object with this name
 func NewValue(name string) *Value {
-	return &Value{name: name, store: make([]float64, 0)}
+	return &Value{name: name, store: make([]float64, 0),
+		sketch: NewSketch(DefaultSketchAccuracy)}
 }
 
 // Store takes this new time and stores it for later analysis
@@ -325,6 +348,11 @@ func NewValue(name string) *Value {
 // growing to big.
 func (t *Value) Store(newTime float64) {
 	t.store = append(t.store, newTime)
+	t.filtered = nil
+	if t.sketch == nil {
+		t.sketch = NewSketch(DefaultSketchAccuracy)
+	}
+	t.sketch.Add(newTime)
 }
 
 // Collect will collect all float64 stored in the store's Value and will compute
@@ -333,8 +361,15 @@ func (t *Value) Collect() {
 	// It is kept as a streaming average / dev processus for the moment (not the most
 	// optimized).
 	// streaming dev algo taken from http://www.johndcook.com/blog/standard_deviation/
-	t.sum = 0
-	for _, newTime := range t.store {
+	// All fields are reset so that calling Collect twice gives the same result.
+	values := t.store
+	if t.filtered != nil {
+		values = t.filtered
+	}
+	t.min, t.max, t.sum, t.dev = 0, 0, 0, 0
+	t.n = 0
+	t.oldM, t.newM, t.oldS, t.newS = 0, 0, 0, 0
+	for _, newTime := range values {
 		// nothings takes 0 ms to complete, so we know it's the first time
 		if t.min > newTime || t.n == 0 {
 			t.min = newTime
@@ -359,9 +394,10 @@ func (t *Value) Collect() {
 	}
 }
 
-// Filter outs its Values
+// Filter outs its Values. The stored values are kept, only the values used by
+// Collect are changed.
 func (t *Value) Filter(filt DataFilter) {
-	t.store = filt.Filter(t.name, t.store)
+	t.filtered = filt.Filter(t.name, t.store)
 }
 
 // AverageValue will create a Value averaging all Values given
@@ -370,6 +406,7 @@ func AverageValue(st ...*Value) *Value {
 		return new(Value)
 	}
 	var t Value
+	t.sketch = NewSketch(DefaultSketchAccuracy)
 	name := st[0].name
 	for _, s := range st {
 		if s.name != name {
@@ -377,6 +414,11 @@ func AverageValue(st ...*Value) *Value {
 			return new(Value)
 		}
 		t.store = append(t.store, s.store...)
+		if s.sketch != nil {
+			if err := t.sketch.Merge(s.sketch); err != nil {
+				log.Error("Couldn't merge the distributions:", err)
+			}
+		}
 	}
 	t.name = name
 	return &t
@@ -397,6 +439,11 @@ func (t *Value) Sum() float64 {
 	return t.sum
 }
 
+// Samples returns a copy of all float64 stored, before any filtering
+func (t *Value) Samples() []float64 {
+	return append([]float64{}, t.store...)
+}
+
 // NumValue returns the number of Value added
 func (t *Value) NumValue() int {
 	return t.n
@@ -412,6 +459,24 @@ func (t *Value) Dev() float64 {
 	return t.dev
 }
 
+// Percentile returns the value at the given percentile, in [0, 100], of all
+// values stored. Contrary to the other statistics it doesn't take into
+// account the DataFilter, which is a percentile cut itself.
+func (t *Value) Percentile(percent float64) float64 {
+	if t.sketch == nil {
+		return 0
+	}
+	return t.sketch.Quantile(percent / 100)
+}
+
+// Histogram returns the histogram of all values stored
+func (t *Value) Histogram() []Bucket {
+	if t.sketch == nil {
+		return nil
+	}
+	return t.sketch.Histogram()
+}
+
 // HeaderFields returns the first line of the CSV-file
 func (t *Value) HeaderFields() []string {
 	return []string{t.name + "_min", t.name + "_max", t.name + "_avg", t.name + "_sum", t.name + "_dev"}
@@ -421,3 +486,15 @@ func (t *Value) HeaderFields() []string {
 func (t *Value) Values() []string {
 	return []string{fmt.Sprintf("%f", t.Min()), fmt.Sprintf("%f", t.Max()), fmt.Sprintf("%f", t.Avg()), fmt.Sprintf("%f", t.Sum()), fmt.Sprintf("%f", t.Dev())}
 }
+
+// PercentileHeaderFields returns the header of the percentile columns, which
+// are written after the columns of HeaderFields of all values.
+func (t *Value) PercentileHeaderFields() []string {
+	return []string{t.name + "_p50", t.name + "_p90", t.name + "_p99"}
+}
+
+// PercentileValues returns the string representation of the percentiles of a
+// Value, in the order of PercentileHeaderFields.
+func (t *Value) PercentileValues() []string {
+	return []string{fmt.Sprintf("%f", t.Percentile(50)), fmt.Sprintf("%f", t.Percentile(90)), fmt.Sprintf("%f", t.Percentile(99))}
+}
@@ -0,0 +1,347 @@
+package network
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/dedis/cothority/log"
+	"golang.org/x/net/context"
+)
+
+// FaultScenario describes the faults injected by a FaultInjector into the
+// connections of one or more FaultyHosts. All times are relative to the
+// creation of the FaultInjector. A scenario can be read from a toml-file:
+//
+//	[Default]
+//	Delay = "10ms"
+//	Jitter = "2ms"
+//
+//	[[Links]]
+//	From = "127.0.0.1:2000"
+//	To = "*"
+//	DropRate = 0.1
+//
+//	[[Partitions]]
+//	Start = "5s"
+//	End = "10s"
+//	Groups = [["127.0.0.1:2000", "127.0.0.1:2002"], ["127.0.0.1:2004"]]
+type FaultScenario struct {
+	// Default is applied to all links that don't match any of Links
+	Default LinkFault
+	// Links are the faults of the individual links. If more than one entry
+	// matches a link, the last one is taken.
+	Links []LinkFault
+	// Partitions split the hosts into groups that cannot talk to each other
+	Partitions []Partition
+}
+
+// LinkFault describes the faults of the messages sent from From to To. Both
+// From and To are addresses of a host, an empty address or "*" matches all
+// hosts. The fault is only active between Start and End, an End of 0 meaning
+// forever.
+type LinkFault struct {
+	From  string
+	To    string
+	Start Duration
+	End   Duration
+	// Delay is added to every message sent
+	Delay Duration
+	// Jitter is a random additional delay in [0, Jitter)
+	Jitter Duration
+	// DropRate is the probability in [0, 1] that a message is lost
+	DropRate float64
+	// Bandwidth in bytes per second, 0 meaning unlimited
+	Bandwidth int
+}
+
+// Partition splits the hosts in Groups between Start and End, an End of 0
+// meaning forever. A host that is in a group can only reach the hosts of
+// the same group. Hosts that are in no group can reach each other, but none
+// of the hosts that are in a group.
+type Partition struct {
+	Start  Duration
+	End    Duration
+	Groups [][]string
+}
+
+// Duration is a time.Duration that can be read from a toml-file as a string
+// like "1m30s".
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText parses the duration from a string accepted by
+// time.ParseDuration.
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// ParseFaultScenario reads a FaultScenario in toml-format.
+func ParseFaultScenario(conf string) (*FaultScenario, error) {
+	fs := &FaultScenario{}
+	if _, err := toml.Decode(conf, fs); err != nil {
+		return nil, err
+	}
+	for _, l := range fs.Links {
+		if l.DropRate < 0 || l.DropRate > 1 {
+			return nil, errors.New("DropRate must be between 0 and 1")
+		}
+	}
+	return fs, nil
+}
+
+// FaultInjector applies a FaultScenario to all FaultyHosts created with it.
+// The scenario can be changed while the hosts are running, which is useful
+// to simulate failing nodes in tests.
+type FaultInjector struct {
+	scenario FaultScenario
+	start    time.Time
+	rand     *rand.Rand
+	sync.Mutex
+}
+
+// NewFaultInjector returns a FaultInjector for the scenario, which may be nil
+// for no faults. The timeline of the scenario starts now.
+func NewFaultInjector(fs *FaultScenario) *FaultInjector {
+	f := &FaultInjector{
+		start: time.Now(),
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if fs != nil {
+		f.scenario.Default = fs.Default
+		f.scenario.Links = append(f.scenario.Links, fs.Links...)
+		f.scenario.Partitions = append(f.scenario.Partitions, fs.Partitions...)
+	}
+	return f
+}
+
+// AddLink adds a fault that takes precedence over all faults already present
+// for the same link.
+func (f *FaultInjector) AddLink(l LinkFault) {
+	f.Lock()
+	defer f.Unlock()
+	f.scenario.Links = append(f.scenario.Links, l)
+}
+
+// AddPartition adds a partition to the scenario.
+func (f *FaultInjector) AddPartition(p Partition) {
+	f.Lock()
+	defer f.Unlock()
+	f.scenario.Partitions = append(f.scenario.Partitions, p)
+}
+
+// Isolate cuts the hosts with the given addresses from all other hosts,
+// starting now. It simulates the failure of these hosts.
+func (f *FaultInjector) Isolate(addresses ...string) {
+	f.Lock()
+	defer f.Unlock()
+	p := Partition{Start: Duration{f.elapsed()}}
+	for _, a := range addresses {
+		p.Groups = append(p.Groups, []string{a})
+	}
+	f.scenario.Partitions = append(f.scenario.Partitions, p)
+}
+
+// Heal ends all partitions that are active now.
+func (f *FaultInjector) Heal() {
+	f.Lock()
+	defer f.Unlock()
+	now := f.elapsed()
+	for i, p := range f.scenario.Partitions {
+		if active(p.Start, p.End, now) {
+			f.scenario.Partitions[i].End = Duration{now}
+		}
+	}
+}
+
+// Reachable returns whether a message from the address 'from' can reach the
+// host with the addresses 'to' right now.
+func (f *FaultInjector) Reachable(from string, to []string) bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.reachable(from, to, f.elapsed())
+}
+
+// delivery returns how long to wait before sending a message of 'size'
+// bytes from 'from' to 'to', and whether it is delivered at all. Messages
+// to ourselves are never touched. The size is only computed if the link has
+// a bandwidth, and outside of the lock, as it may marshal a big message.
+func (f *FaultInjector) delivery(from string, to []string, size func() int) (time.Duration, bool) {
+	if matchAny(from, to) {
+		return 0, true
+	}
+	delay, bandwidth, ok := f.linkDelay(from, to)
+	if !ok {
+		return 0, false
+	}
+	if bandwidth > 0 {
+		delay += time.Duration(size()) * time.Second /
+			time.Duration(bandwidth)
+	}
+	return delay, true
+}
+
+// linkDelay returns the delay and bandwidth of the link from 'from' to 'to'
+// right now, and whether the message is delivered.
+func (f *FaultInjector) linkDelay(from string, to []string) (time.Duration, int, bool) {
+	f.Lock()
+	defer f.Unlock()
+	now := f.elapsed()
+	if !f.reachable(from, to, now) {
+		return 0, 0, false
+	}
+	lf := f.scenario.Default
+	for _, l := range f.scenario.Links {
+		if active(l.Start, l.End, now) && match(l.From, from) &&
+			matchAny(l.To, to) {
+			lf = l
+		}
+	}
+	if lf.DropRate > 0 && f.rand.Float64() < lf.DropRate {
+		return 0, 0, false
+	}
+	delay := lf.Delay.Duration
+	if lf.Jitter.Duration > 0 {
+		delay += time.Duration(f.rand.Int63n(int64(lf.Jitter.Duration)))
+	}
+	return delay, lf.Bandwidth, true
+}
+
+func (f *FaultInjector) reachable(from string, to []string, now time.Duration) bool {
+	for _, p := range f.scenario.Partitions {
+		if !active(p.Start, p.End, now) {
+			continue
+		}
+		fromGroup, toGroup := -1, -1
+		for i, g := range p.Groups {
+			for _, a := range g {
+				if a == from {
+					fromGroup = i
+				}
+				if matchAny(a, to) {
+					toGroup = i
+				}
+			}
+		}
+		if fromGroup != toGroup {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *FaultInjector) elapsed() time.Duration {
+	return time.Since(f.start)
+}
+
+// active returns whether 'now' is in the window [start, end), an end of 0
+// meaning forever.
+func active(start, end Duration, now time.Duration) bool {
+	return now >= start.Duration && (end.Duration == 0 || now < end.Duration)
+}
+
+// match returns whether the address matches the pattern, which is either an
+// address or empty or "*" for all addresses.
+func match(pattern, address string) bool {
+	return pattern == "" || pattern == "*" || pattern == address
+}
+
+func matchAny(pattern string, addresses []string) bool {
+	for _, a := range addresses {
+		if match(pattern, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// FaultyHost is a SecureHost whose connections are subject to the faults of
+// a FaultInjector. Only the sending side of a connection is affected, so
+// the faults of a link between two FaultyHosts are applied by the sender.
+// Dropped messages are silently discarded, as TCP would have lost the
+// connection to a crashed or unreachable host.
+type FaultyHost struct {
+	SecureHost
+	injector *FaultInjector
+}
+
+// NewFaultyHost returns a FaultyHost wrapping the host. It has to be created
+// before the host starts to listen.
+func NewFaultyHost(host SecureHost, f *FaultInjector) *FaultyHost {
+	return &FaultyHost{
+		SecureHost: host,
+		injector:   f,
+	}
+}
+
+// Listen wraps all incoming connections before passing them to 'fn'.
+func (fh *FaultyHost) Listen(fn func(SecureConn)) error {
+	return fh.SecureHost.Listen(func(c SecureConn) {
+		fn(fh.wrap(c))
+	})
+}
+
+// Open returns an error if the host is unreachable, else it opens a
+// connection that is subject to the faults.
+func (fh *FaultyHost) Open(si *ServerIdentity) (SecureConn, error) {
+	local := fh.WorkingAddress()
+	if !matchAny(local, si.Addresses) &&
+		!fh.injector.Reachable(local, si.Addresses) {
+		return nil, errors.New("Host " + si.First() + " is unreachable")
+	}
+	c, err := fh.SecureHost.Open(si)
+	if err != nil {
+		return nil, err
+	}
+	return fh.wrap(c), nil
+}
+
+func (fh *FaultyHost) wrap(c SecureConn) SecureConn {
+	return &faultyConn{
+		SecureConn: c,
+		host:       fh,
+	}
+}
+
+// faultyConn delays or drops the messages sent according to the
+// FaultInjector of its host.
+type faultyConn struct {
+	SecureConn
+	host *FaultyHost
+	// sendMutex keeps the order of the delayed messages
+	sendMutex sync.Mutex
+}
+
+// Send waits for the delay of the link before sending the message, or
+// drops it.
+func (c *faultyConn) Send(ctx context.Context, obj Body) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	size := func() int {
+		buf, err := MarshalRegisteredType(obj)
+		if err != nil {
+			return 0
+		}
+		return len(buf)
+	}
+	local := c.host.WorkingAddress()
+	remote := c.ServerIdentity().Addresses
+	delay, ok := c.host.injector.delivery(local, remote, size)
+	if !ok {
+		log.Lvl3("Dropping message from", local, "to", remote)
+		return nil
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ErrCanceled
+		}
+	}
+	return c.SecureConn.Send(ctx, obj)
+}
@@ -0,0 +1,159 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dedis/crypto/abstract"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestParseFaultScenario(t *testing.T) {
+	fs, err := ParseFaultScenario(`
+[Default]
+Delay = "10ms"
+
+[[Links]]
+From = "localhost:2000"
+To = "*"
+DropRate = 0.5
+Bandwidth = 1000
+
+[[Partitions]]
+Start = "1s"
+End = "2s"
+Groups = [["localhost:2000"], ["localhost:2002"]]
+`)
+	require.Nil(t, err)
+	assert.Equal(t, 10*time.Millisecond, fs.Default.Delay.Duration)
+	require.Equal(t, 1, len(fs.Links))
+	assert.Equal(t, 0.5, fs.Links[0].DropRate)
+	assert.Equal(t, 1000, fs.Links[0].Bandwidth)
+	require.Equal(t, 1, len(fs.Partitions))
+	assert.Equal(t, time.Second, fs.Partitions[0].Start.Duration)
+	assert.Equal(t, 2, len(fs.Partitions[0].Groups))
+
+	_, err = ParseFaultScenario("[[Links]]\nDropRate = 2.0")
+	assert.NotNil(t, err)
+	_, err = ParseFaultScenario("[Default]\nDelay = \"soon\"")
+	assert.NotNil(t, err)
+}
+
+func TestFaultInjector_Partitions(t *testing.T) {
+	a, b, c := "a:1", "b:1", "c:1"
+	f := NewFaultInjector(&FaultScenario{
+		Partitions: []Partition{{
+			Start:  Duration{time.Hour},
+			Groups: [][]string{{a}},
+		}},
+	})
+	assert.True(t, f.Reachable(a, []string{b}))
+
+	f.AddPartition(Partition{Groups: [][]string{{a, b}, {c}}})
+	assert.True(t, f.Reachable(a, []string{b}))
+	assert.False(t, f.Reachable(a, []string{c}))
+	assert.False(t, f.Reachable(c, []string{b}))
+	f.Heal()
+	assert.True(t, f.Reachable(a, []string{c}))
+
+	f.Isolate(b)
+	assert.False(t, f.Reachable(a, []string{b}))
+	assert.False(t, f.Reachable(b, []string{c}))
+	assert.True(t, f.Reachable(a, []string{c}))
+	f.Heal()
+	assert.True(t, f.Reachable(b, []string{c}))
+}
+
+func TestFaultInjector_Delivery(t *testing.T) {
+	a, b := "a:1", "b:1"
+	size := func() int { return 500 }
+	f := NewFaultInjector(&FaultScenario{
+		Default: LinkFault{Delay: Duration{time.Second}},
+	})
+	delay, ok := f.delivery(a, []string{b}, size)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, delay)
+	// messages to ourselves are never delayed
+	delay, ok = f.delivery(a, []string{a}, size)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), delay)
+
+	f.AddLink(LinkFault{From: a, To: b, Bandwidth: 1000})
+	delay, _ = f.delivery(a, []string{b}, size)
+	assert.Equal(t, time.Second/2, delay)
+	delay, _ = f.delivery(b, []string{a}, size)
+	assert.Equal(t, time.Second, delay)
+	// The size is computed without holding the lock of the injector
+	delay, _ = f.delivery(a, []string{b}, func() int {
+		f.Reachable(a, []string{b})
+		return 500
+	})
+	assert.Equal(t, time.Second/2, delay)
+
+	f.AddLink(LinkFault{To: b, DropRate: 1})
+	_, ok = f.delivery(a, []string{b}, size)
+	assert.False(t, ok)
+	_, ok = f.delivery(b, []string{a}, size)
+	assert.True(t, ok)
+}
+
+func TestFaultyHost(t *testing.T) {
+	f := NewFaultInjector(nil)
+	priv1, id1 := genServerIdentity("localhost:0")
+	priv2, id2 := genServerIdentity("localhost:0")
+	host1, _ := newFaultyListener(t, f, priv1, id1)
+	host2, received := newFaultyListener(t, f, priv2, id2)
+	defer host1.Close()
+	defer host2.Close()
+
+	send := func(name string) {
+		c, err := host1.Open(id2)
+		require.Nil(t, err)
+		require.Nil(t, c.Send(context.TODO(), &SimplePacket{name}))
+	}
+
+	send("plain")
+	assert.Equal(t, "plain", <-received)
+
+	f.AddLink(LinkFault{Delay: Duration{100 * time.Millisecond}})
+	start := time.Now()
+	send("delayed")
+	assert.Equal(t, "delayed", <-received)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	f.AddLink(LinkFault{DropRate: 1})
+	send("dropped")
+	select {
+	case name := <-received:
+		t.Fatal("Received dropped message", name)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	f.Isolate(id2.First())
+	_, err := host1.Open(id2)
+	assert.NotNil(t, err)
+	f.Heal()
+	f.AddLink(LinkFault{})
+	send("healed")
+	assert.Equal(t, "healed", <-received)
+}
+
+// newFaultyListener returns a listening FaultyHost and a channel with the
+// names of all SimplePackets it receives.
+func newFaultyListener(t *testing.T, f *FaultInjector, priv abstract.Scalar,
+	si *ServerIdentity) (*FaultyHost, chan string) {
+	fh := NewFaultyHost(NewSecureTCPHost(priv, si), f)
+	names := make(chan string, 10)
+	require.Nil(t, fh.Listen(func(c SecureConn) {
+		for {
+			nm, err := c.Receive(context.TODO())
+			if err != nil {
+				return
+			}
+			names <- nm.Msg.(SimplePacket).Name
+		}
+	}))
+	return fh, names
+}
@@ -15,7 +15,6 @@ package network
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"net"
 	"time"
@@ -44,6 +43,7 @@ func NewTCPHost() *TCPHost {
 		quit:          make(chan bool),
 		constructors:  DefaultConstructors(Suite),
 		quitListener:  make(chan bool),
+		limits:        DefaultLimits,
 	}
 }
 
@@ -383,56 +383,41 @@ func (c *TCPConn) Local() string {
 
 // Receive waits for any input on the connection and returns
 // the ApplicationMessage **decoded** and an error if something
-// wrong occured
+// wrong occured. Packets bigger than the MaxPacketSize of the host are
+// refused with a PacketSizeError, while streams of chunks are put together
+// and returned as one packet.
 func (c *TCPConn) Receive(ctx context.Context) (nm Packet, e error) {
 	c.receiveMutex.Lock()
 	defer c.receiveMutex.Unlock()
 	var am Packet
 	am.Constructors = c.host.constructors
-	var err error
-	//c.Conn.SetReadDeadline(time.Now().Add(timeOut))
-	// First read the size
-	var total Size
 	defer func() {
 		if err := recover(); err != nil {
 			nm = EmptyApplicationPacket
-			e = fmt.Errorf("Error Received message (size=%d): %v", total, err)
+			e = fmt.Errorf("Error Received message: %v", err)
 		}
 	}()
 	log.Lvl5("Starting to receive on", c.Local(), "from", c.Remote())
-	if err = binary.Read(c.conn, globalOrder, &total); err != nil {
-		return EmptyApplicationPacket, handleError(err)
-	}
-	log.Lvl5("Received some bytes on", c.Local(), "from", c.Remote(), total)
-	b := make([]byte, total)
-	var read Size
-	var buffer bytes.Buffer
-	for read < total {
-		// read the size of the next packet
-		n, err := c.conn.Read(b)
-		// if error then quit
+	b, err := c.receiveRaw(c.host.Limits().MaxPacketSize)
+	if err != nil {
+		return EmptyApplicationPacket, err
+	}
+	err = am.UnmarshalBinary(b)
+	if err == nil && am.MsgType == StreamChunkType {
+		chunk := am.Msg.(StreamChunk)
+		b, err = c.receiveStream(ctx, &chunk)
 		if err != nil {
-			e := handleError(err)
-			return EmptyApplicationPacket, e
-		}
-		// put it in the longterm buffer
-		if _, err := buffer.Write(b[:n]); err != nil {
-			log.Error("Couldn't write to buffer:", err)
+			return EmptyApplicationPacket, err
 		}
-		read += Size(n)
-		b = b[n:]
-		log.Lvl5("Read", read, "out of", total, "bytes")
+		am = Packet{Constructors: c.host.constructors}
+		err = am.UnmarshalBinary(b)
 	}
-
-	err = am.UnmarshalBinary(buffer.Bytes())
 	if err != nil {
-		log.Errorf("Read %d out of %d - buffer is %x", read, total, buffer.Bytes())
+		log.Errorf("Couldn't unmarshal %d bytes - buffer is %x", len(b), b)
 		DumpTypes()
 		return EmptyApplicationPacket, fmt.Errorf("Error unmarshaling message type %s: %s", am.MsgType.String(), err.Error())
 	}
 	am.From = c.Remote()
-	// set the size read
-	c.addReadBytes(uint64(total))
 	return am, nil
 }
 
@@ -442,7 +427,8 @@ func (c *TCPConn) Receive(ctx context.Context) (nm Packet, e error) {
 const maxChunkSize Size = 1400
 
 // Send will convert the NetworkMessage into an ApplicationMessage
-// and send it with the size through the network.
+// and send it with the size through the network. If it is bigger than the
+// StreamChunkSize of the host, it is sent as a stream of chunks.
 // Returns an error if anything was wrong
 func (c *TCPConn) Send(ctx context.Context, obj Body) error {
 	c.sendMutex.Lock()
@@ -457,37 +443,15 @@ func (c *TCPConn) Send(ctx context.Context, obj Body) error {
 	if err != nil {
 		return fmt.Errorf("Error marshaling  message: %s", err.Error())
 	}
-	// First write the size
-	packetSize := Size(len(b))
-	if err := binary.Write(c.conn, globalOrder, packetSize); err != nil {
-		return err
+	if Size(len(b)) > c.host.Limits().StreamChunkSize {
+		err = c.sendStream(ctx, bytes.NewReader(b), uint64(len(b)))
+	} else {
+		err = c.sendRaw(b)
 	}
-	// Then send everything through the connection
-	// Send chunk by chunk
-	var sent Size
-	for sent < packetSize {
-		length := packetSize - sent
-		if length > maxChunkSize {
-			length = maxChunkSize
-		}
-
-		// Sending 'length' bytes
-		log.Lvl4("Sending from", c.conn.LocalAddr(), "to", c.conn.RemoteAddr())
-		n, err := c.conn.Write(b[:length])
-		if err != nil {
-			log.Error("Couldn't write chunk starting at", sent, "size", length, err)
-			log.Error(log.Stack())
-			return handleError(err)
-		}
-		sent += Size(n)
-		log.Lvl5("Sent", sent, "out of", packetSize)
-
-		// bytes left to send
-		b = b[n:]
+	if err != nil {
+		return err
 	}
-	log.Lvl5(c.Local(), c.Remote(), "Sent a total of", sent, "bytes")
-	// update stats on the connection
-	c.addWrittenBytes(uint64(packetSize))
+	log.Lvl5(c.Local(), c.Remote(), "Sent a total of", len(b), "bytes")
 	return nil
 }
 
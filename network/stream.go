@@ -0,0 +1,328 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dedis/cothority/log"
+	"golang.org/x/net/context"
+)
+
+// Limits are the sizes a host accepts for the packets it receives. Packets
+// that are bigger than StreamChunkSize are sent as a stream of chunks, so
+// that the receiver never allocates more than MaxPacketSize at once, and
+// only grows its buffer as the chunks of a stream arrive. Data that doesn't
+// need to be in memory as a whole is better sent with SendStream and
+// ReceiveStream, which only hold one chunk at a time.
+type Limits struct {
+	// MaxPacketSize is the biggest packet read from the network. The size
+	// of a packet is checked before any memory is allocated for it.
+	MaxPacketSize Size
+	// StreamChunkSize is the biggest packet sent in one piece. Bigger
+	// packets are split into chunks of this size. It has to be smaller
+	// than the MaxPacketSize of the receiver.
+	StreamChunkSize Size
+	// MaxStreamSize is the biggest packet that is received as a stream of
+	// chunks. It is held in memory as a whole by Receive.
+	MaxStreamSize uint64
+}
+
+// DefaultLimits are the Limits of a newly created host.
+var DefaultLimits = Limits{
+	MaxPacketSize:   4 * 1024 * 1024,
+	StreamChunkSize: 1024 * 1024,
+	MaxStreamSize:   16 * 1024 * 1024,
+}
+
+// PacketSizeError is returned when a packet is bigger than what the Limits
+// allow. If it is returned by Receive, the connection has been closed, as
+// the rest of the packet is not read.
+type PacketSizeError struct {
+	Size uint64
+	Max  uint64
+}
+
+func (e *PacketSizeError) Error() string {
+	return fmt.Sprintf("Packet of %d bytes is bigger than the limit of %d bytes",
+		e.Size, e.Max)
+}
+
+// StreamChunk is one part of a packet that is too big to be sent in one
+// piece. The chunks of a packet are sent one after the other on the same
+// connection, and put together again by Receive.
+type StreamChunk struct {
+	// Index of the chunk in the stream, starting at 0
+	Index uint32
+	// Total is the size of the whole packet
+	Total uint64
+	Data  []byte
+}
+
+// StreamChunkType is the type of a StreamChunk
+var StreamChunkType = RegisterPacketType(StreamChunk{})
+
+// streamChunkOverhead is more than the bytes needed to send a StreamChunk
+// in addition to its Data.
+const streamChunkOverhead = 64
+
+// SetLimits sets the limits for all connections of this host.
+func (t *TCPHost) SetLimits(l Limits) error {
+	if l.MaxPacketSize == 0 || l.StreamChunkSize == 0 {
+		return errors.New("Limits must not be 0")
+	}
+	if l.StreamChunkSize+streamChunkOverhead > l.MaxPacketSize {
+		return errors.New("StreamChunkSize must be smaller than MaxPacketSize")
+	}
+	t.limitsMut.Lock()
+	defer t.limitsMut.Unlock()
+	t.limits = l
+	return nil
+}
+
+// Limits returns the limits of this host.
+func (t *TCPHost) Limits() Limits {
+	t.limitsMut.Lock()
+	defer t.limitsMut.Unlock()
+	return t.limits
+}
+
+// receiveRaw reads one size-prefixed packet from the connection. If it is
+// bigger than 'max', the connection is closed and a PacketSizeError is
+// returned.
+func (c *TCPConn) receiveRaw(max Size) ([]byte, error) {
+	var total Size
+	if err := binary.Read(c.conn, globalOrder, &total); err != nil {
+		return nil, handleError(err)
+	}
+	if total > max {
+		log.Warn("Closing connection from", c.Remote(), ": packet of",
+			total, "bytes is too big")
+		if err := c.Close(); err != nil {
+			log.Error("Couldn't close connection:", err)
+		}
+		return nil, &PacketSizeError{Size: uint64(total), Max: uint64(max)}
+	}
+	log.Lvl5("Received some bytes on", c.Local(), "from", c.Remote(), total)
+	b := make([]byte, total)
+	if _, err := io.ReadFull(c.conn, b); err != nil {
+		return nil, handleError(err)
+	}
+	c.addReadBytes(uint64(total))
+	return b, nil
+}
+
+// receiveStream reads all chunks following 'first' and returns the whole
+// packet.
+func (c *TCPConn) receiveStream(ctx context.Context, first *StreamChunk) ([]byte, error) {
+	// Only grow the buffer with the data received, so a peer cannot make
+	// us allocate the announced total without sending it.
+	var buf bytes.Buffer
+	if err := c.readStream(ctx, first, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReceiveStream reads a stream of chunks sent by SendStream and writes it to
+// 'w' as the chunks arrive, so only one chunk is held in memory. The stream
+// may not be bigger than the MaxStreamSize of the host. It returns the
+// number of bytes written. If the next packet is not the start of a stream,
+// or if ctx is done before the last chunk, the connection is closed.
+func (c *TCPConn) ReceiveStream(ctx context.Context, w io.Writer) (uint64, error) {
+	c.receiveMutex.Lock()
+	defer c.receiveMutex.Unlock()
+	chunk, err := c.receiveChunk()
+	if err != nil {
+		c.closeStream()
+		return 0, err
+	}
+	if err := c.readStream(ctx, chunk, w); err != nil {
+		return 0, err
+	}
+	return chunk.Total, nil
+}
+
+// readStream writes 'first' and all chunks following it to 'w'. The chunks
+// must arrive in order and may not be bigger than the MaxStreamSize. As we
+// cannot find the beginning of the next packet of a broken stream, the
+// connection is closed on any error.
+func (c *TCPConn) readStream(ctx context.Context, first *StreamChunk, w io.Writer) error {
+	if err := c.copyStream(ctx, first, w); err != nil {
+		c.closeStream()
+		return err
+	}
+	return nil
+}
+
+func (c *TCPConn) copyStream(ctx context.Context, first *StreamChunk, w io.Writer) error {
+	limits := c.host.Limits()
+	if first.Index != 0 {
+		return errors.New("Stream doesn't start with the first chunk")
+	}
+	if first.Total > limits.MaxStreamSize {
+		return &PacketSizeError{Size: first.Total, Max: limits.MaxStreamSize}
+	}
+	if uint64(len(first.Data)) > first.Total {
+		return errors.New("Stream is longer than announced")
+	}
+	if _, err := w.Write(first.Data); err != nil {
+		return err
+	}
+	read := uint64(len(first.Data))
+	for index := uint32(1); read < first.Total; index++ {
+		if err := ctxErr(ctx); err != nil {
+			return err
+		}
+		chunk, err := c.receiveChunk()
+		if err != nil {
+			return err
+		}
+		if len(chunk.Data) == 0 {
+			return errors.New("Empty chunk in stream")
+		}
+		if chunk.Index != index || chunk.Total != first.Total {
+			return errors.New("Chunk doesn't belong to the stream")
+		}
+		read += uint64(len(chunk.Data))
+		if read > first.Total {
+			return errors.New("Stream is longer than announced")
+		}
+		if _, err := w.Write(chunk.Data); err != nil {
+			return err
+		}
+		log.Lvl5("Read", read, "out of", first.Total, "bytes")
+	}
+	return nil
+}
+
+// receiveChunk reads the next packet, which has to be a chunk of a stream.
+func (c *TCPConn) receiveChunk() (*StreamChunk, error) {
+	b, err := c.receiveRaw(c.host.Limits().MaxPacketSize)
+	if err != nil {
+		return nil, err
+	}
+	var am Packet
+	am.Constructors = c.host.constructors
+	if err := am.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	chunk, ok := am.Msg.(StreamChunk)
+	if !ok {
+		return nil, errors.New("Expected a chunk of a stream")
+	}
+	return &chunk, nil
+}
+
+// ctxErr returns ErrCanceled if ctx is done, so that a stream can be
+// cancelled between two chunks.
+func ctxErr(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ErrCanceled
+	default:
+		return nil
+	}
+}
+
+// closeStream closes the connection after a broken stream.
+func (c *TCPConn) closeStream() {
+	if err := c.Close(); err != nil {
+		log.Error("Couldn't close connection:", err)
+	}
+}
+
+// sendRaw writes the size of the packet followed by the packet.
+func (c *TCPConn) sendRaw(b []byte) error {
+	packetSize := Size(len(b))
+	if err := binary.Write(c.conn, globalOrder, packetSize); err != nil {
+		return err
+	}
+	// Send chunk by chunk
+	var sent Size
+	for sent < packetSize {
+		length := packetSize - sent
+		if length > maxChunkSize {
+			length = maxChunkSize
+		}
+		n, err := c.conn.Write(b[:length])
+		if err != nil {
+			log.Error("Couldn't write chunk starting at", sent, "size", length, err)
+			log.Error(log.Stack())
+			return handleError(err)
+		}
+		sent += Size(n)
+		log.Lvl5("Sent", sent, "out of", packetSize)
+		b = b[n:]
+	}
+	c.addWrittenBytes(uint64(packetSize))
+	return nil
+}
+
+// SendStream sends 'total' bytes read from 'r' as a stream of chunks of the
+// StreamChunkSize of our host, so only one chunk is held in memory. The
+// receiver reads it with ReceiveStream. An empty stream is sent as one empty
+// chunk. If 'r' has less than 'total' bytes, or if ctx is done, once some
+// chunks have been sent, the connection is closed, as the receiver can't
+// find the end of the stream.
+func (c *TCPConn) SendStream(ctx context.Context, r io.Reader, total uint64) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	return c.sendStream(ctx, r, total)
+}
+
+// sendStream sends 'total' bytes read from 'r' as a stream of chunks of the
+// StreamChunkSize of our host.
+func (c *TCPConn) sendStream(ctx context.Context, r io.Reader, total uint64) error {
+	limits := c.host.Limits()
+	if total > limits.MaxStreamSize {
+		return &PacketSizeError{Size: total, Max: limits.MaxStreamSize}
+	}
+	log.Lvl4("Streaming", total, "bytes to", c.Remote())
+	data := make([]byte, limits.StreamChunkSize)
+	for index, left := uint32(0), total; index == 0 || left > 0; index++ {
+		if err := c.sendChunk(ctx, r, data, index, total, left); err != nil {
+			if index > 0 {
+				c.closeStream()
+			}
+			return err
+		}
+		if left < uint64(len(data)) {
+			left = 0
+		} else {
+			left -= uint64(len(data))
+		}
+	}
+	return nil
+}
+
+// sendChunk sends the chunk with the given index of a stream of 'total'
+// bytes, of which 'left' bytes are not sent yet.
+func (c *TCPConn) sendChunk(ctx context.Context, r io.Reader, data []byte,
+	index uint32, total, left uint64) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	length := uint64(len(data))
+	if length > left {
+		length = left
+	}
+	if _, err := io.ReadFull(r, data[:length]); err != nil {
+		return err
+	}
+	am, err := NewNetworkPacket(&StreamChunk{
+		Index: index,
+		Total: total,
+		Data:  data[:length],
+	})
+	if err != nil {
+		return err
+	}
+	buf, err := am.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return c.sendRaw(buf)
+}
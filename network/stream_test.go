@@ -0,0 +1,174 @@
+package network
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+var smallLimits = Limits{
+	MaxPacketSize:   1024,
+	StreamChunkSize: 512,
+	MaxStreamSize:   10 * 1024,
+}
+
+func TestTCPHost_SetLimits(t *testing.T) {
+	h := NewTCPHost()
+	assert.Equal(t, DefaultLimits, h.Limits())
+	assert.NotNil(t, h.SetLimits(Limits{}))
+	assert.NotNil(t, h.SetLimits(Limits{MaxPacketSize: 512,
+		StreamChunkSize: 512, MaxStreamSize: 1024}))
+	require.Nil(t, h.SetLimits(smallLimits))
+	assert.Equal(t, smallLimits, h.Limits())
+}
+
+func TestTCPConn_Stream(t *testing.T) {
+	sender, receiver, received := newLimitedPair(t, smallLimits, smallLimits)
+	defer sender.Close()
+	defer receiver.Close()
+	c, err := sender.Open(receiver.serverIdentity)
+	require.Nil(t, err)
+
+	// A small packet, followed by one that has to be streamed, and again
+	// a small one to make sure the stream has been read completely.
+	big := strings.Repeat("cothority", 1000)
+	for _, name := range []string{"small", big, "again"} {
+		require.Nil(t, c.Send(context.TODO(), &SimplePacket{name}))
+		nm := <-received
+		require.Nil(t, nm.Error())
+		assert.Equal(t, name, nm.Msg.(SimplePacket).Name)
+	}
+
+	// Bigger than MaxStreamSize
+	err = c.Send(context.TODO(), &SimplePacket{strings.Repeat("x", 11*1024)})
+	require.NotNil(t, err)
+	_, ok := err.(*PacketSizeError)
+	assert.True(t, ok)
+}
+
+func TestTCPConn_SendStream(t *testing.T) {
+	sender, receiver, received := newStreamPair(context.TODO(), t)
+	defer sender.Close()
+	defer receiver.Close()
+	c, err := sender.Open(receiver.serverIdentity)
+	require.Nil(t, err)
+
+	data := strings.Repeat("cothority", 1000)
+	require.Nil(t, c.SendStream(context.TODO(), strings.NewReader(data),
+		uint64(len(data))))
+	assert.Equal(t, data, <-received)
+	// An empty stream is sent as one empty chunk
+	require.Nil(t, c.SendStream(context.TODO(), strings.NewReader(""), 0))
+	assert.Equal(t, "", <-received)
+
+	// Cancelled before the first chunk, the connection stays usable
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, ErrCanceled, c.SendStream(ctx,
+		strings.NewReader(data), uint64(len(data))))
+	// Bigger than MaxStreamSize
+	big := strings.Repeat("x", 11*1024)
+	err = c.SendStream(context.TODO(), strings.NewReader(big), uint64(len(big)))
+	_, ok := err.(*PacketSizeError)
+	assert.True(t, ok)
+	require.Nil(t, c.SendStream(context.TODO(), strings.NewReader("again"), 5))
+	assert.Equal(t, "again", <-received)
+
+	// Shorter than announced: the connection is closed once the reader
+	// runs out of data.
+	assert.NotNil(t, c.SendStream(context.TODO(), strings.NewReader(data),
+		uint64(len(data)+1)))
+	assert.NotEqual(t, data, <-received)
+	assert.NotNil(t, c.SendStream(context.TODO(), strings.NewReader("again"), 5))
+}
+
+func TestTCPConn_ReceiveStreamCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sender, receiver, received := newStreamPair(ctx, t)
+	defer sender.Close()
+	defer receiver.Close()
+	c, err := sender.Open(receiver.serverIdentity)
+	require.Nil(t, err)
+
+	// The first chunk is read, the cancellation is noticed before the next
+	data := strings.Repeat("cothority", 1000)
+	c.SendStream(context.TODO(), strings.NewReader(data), uint64(len(data)))
+	assert.Equal(t, ErrCanceled.Error(), <-received)
+}
+
+// newStreamPair returns two hosts with small limits. The receiver reads
+// streams with ctx and sends their content, or the error, to the channel.
+func newStreamPair(ctx context.Context, t *testing.T) (*SecureTCPHost,
+	*SecureTCPHost, chan string) {
+	priv1, id1 := genServerIdentity("localhost:0")
+	priv2, id2 := genServerIdentity("localhost:0")
+	sender := NewSecureTCPHost(priv1, id1)
+	receiver := NewSecureTCPHost(priv2, id2)
+	require.Nil(t, sender.SetLimits(smallLimits))
+	require.Nil(t, receiver.SetLimits(smallLimits))
+	received := make(chan string, 10)
+	require.Nil(t, receiver.Listen(func(c SecureConn) {
+		for {
+			var buf bytes.Buffer
+			n, err := c.ReceiveStream(ctx, &buf)
+			if err != nil {
+				received <- err.Error()
+				return
+			}
+			received <- buf.String()[:n]
+		}
+	}))
+	return sender, receiver, received
+}
+
+func TestTCPConn_PacketTooBig(t *testing.T) {
+	// The sender sends packets of up to 2048 bytes in one piece, which
+	// is more than the receiver accepts.
+	sendLimits := Limits{
+		MaxPacketSize:   4096,
+		StreamChunkSize: 2048,
+		MaxStreamSize:   10 * 1024,
+	}
+	sender, receiver, received := newLimitedPair(t, sendLimits, smallLimits)
+	defer sender.Close()
+	defer receiver.Close()
+	c, err := sender.Open(receiver.serverIdentity)
+	require.Nil(t, err)
+
+	require.Nil(t, c.Send(context.TODO(), &SimplePacket{strings.Repeat("x", 1500)}))
+	nm := <-received
+	require.NotNil(t, nm.Error())
+	pse, ok := nm.Error().(*PacketSizeError)
+	require.True(t, ok)
+	assert.Equal(t, uint64(1024), pse.Max)
+}
+
+// newLimitedPair returns two hosts with the given limits. All packets
+// received by the receiver, or the error, are sent to the channel.
+func newLimitedPair(t *testing.T, sendLimits, recLimits Limits) (*SecureTCPHost,
+	*SecureTCPHost, chan Packet) {
+	priv1, id1 := genServerIdentity("localhost:0")
+	priv2, id2 := genServerIdentity("localhost:0")
+	sender := NewSecureTCPHost(priv1, id1)
+	receiver := NewSecureTCPHost(priv2, id2)
+	require.Nil(t, sender.SetLimits(sendLimits))
+	require.Nil(t, receiver.SetLimits(recLimits))
+	received := make(chan Packet, 10)
+	require.Nil(t, receiver.Listen(func(c SecureConn) {
+		for {
+			nm, err := c.Receive(context.TODO())
+			if err != nil {
+				nm.SetError(err)
+				received <- nm
+				return
+			}
+			received <- nm
+		}
+	}))
+	return sender, receiver, received
+}
@@ -77,6 +77,12 @@ type Conn interface {
 	Send(ctx context.Context, obj Body) error
 	// Receive any message through the connection.
 	Receive(ctx context.Context) (Packet, error)
+	// SendStream sends 'total' bytes read from 'r' as a stream of chunks,
+	// which is read by ReceiveStream of the remote endpoint.
+	SendStream(ctx context.Context, r io.Reader, total uint64) error
+	// ReceiveStream writes a stream sent by SendStream to 'w' and returns
+	// the number of bytes written.
+	ReceiveStream(ctx context.Context, w io.Writer) (uint64, error)
 	Close() error
 	monitor.CounterIO
 }
@@ -104,6 +110,9 @@ type TCPHost struct {
 	closedLock sync.Mutex
 	// a list of constructors for en/decoding
 	constructors protobuf.Constructors
+	// the sizes of the packets we accept
+	limits    Limits
+	limitsMut sync.Mutex
 }
 
 // TCPConn is the underlying implementation of
@@ -141,6 +150,8 @@ type SecureHost interface {
 	Open(*ServerIdentity) (SecureConn, error)
 	String() string
 	WorkingAddress() string
+	// SetLimits sets the sizes of the packets accepted
+	SetLimits(Limits) error
 	monitor.CounterIO
 }
 
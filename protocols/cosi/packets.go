@@ -1,6 +1,8 @@
-package swupdate
+package cosi
 
 import (
+	"time"
+
 	"github.com/dedis/cothority/sda"
 	"github.com/dedis/crypto/abstract"
 )
@@ -10,6 +12,8 @@ import (
 // Announcement is broadcasted message initiated and signed by proposer.
 type Announcement struct {
 	Data []byte
+	// Timeout for every level of the tree
+	Timeout time.Duration
 }
 
 // Commitment of all nodes together with the data they want
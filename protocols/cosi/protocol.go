@@ -1,10 +1,17 @@
-// Package swupdate implements a round of a Collective Signing protocol (like .
-package swupdate
+// Package cosi implements a round of the Collective Signing protocol for the
+// services. The message to sign is passed in the announcement and every node
+// checks it with a verification hook before deciding to sign. The nodes that
+// refuse to sign or don't answer in time are recorded as exceptions in the
+// signature. Sign runs a round from a service and returns a Signature.
+package cosi
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/sda"
@@ -13,9 +20,13 @@ import (
 )
 
 // ProtocolName defines the name of this protocol.
-const ProtocolName = "CoSiUpdate"
+const ProtocolName = "ServiceCoSi"
 
-// CoSiUpdate protocol is a CoSi version with
+// DefaultTimeout is how long a node waits for the commitments of its children
+// for every level of its subtree.
+const DefaultTimeout = 5 * time.Second
+
+// CoSi protocol is a CoSi version with
 // four phases:
 //  - Announcement: The message is being passed into this pass down the tree
 //  - Commitment: Each node have decided if they agree to sign or not and let
@@ -23,12 +34,16 @@ const ProtocolName = "CoSiUpdate"
 //  - Challenge: as in vanilla cosi
 //  - Response: as in vanilla cosi
 // When registering this protocol, you must give to the constructor of
-// CoSiUpdate, a VerificationHook that will be called when the Announcement
+// CoSi, a VerificationHook that will be called when the Announcement
 // message is received with the proper embedded data.
 // If the function returns true (the function is called in a goroutine),
 // then the local node will participate in the signing, otherwise, he won't and
 // will just have a role of relay.
-type CoSiUpdate struct {
+// A node waits at most Timeout times the height of its subtree for the
+// commitments of its children. The nodes of the subtrees that didn't commit
+// in time are added, with the nodes that refused to sign, to the exceptions
+// of the signature.
+type CoSi struct {
 	// The node that represents us
 	*sda.TreeNodeInstance
 	// TreeNodeId cached
@@ -38,6 +53,8 @@ type CoSiUpdate struct {
 	cosi *cosi.CoSi
 	// the message we want to sign typically given by the Root
 	Message []byte
+	// Timeout for every level of the tree, given by the Root
+	Timeout time.Duration
 	// The channel waiting for Announcement message
 	announce chan chanAnnouncement
 	// the channel waiting for Commitment message
@@ -48,6 +65,14 @@ type CoSiUpdate struct {
 	response chan chanResponse
 	// the channel that indicates if we are finished or not
 	done chan bool
+	// makes sure done is only closed once
+	doneOnce sync.Once
+	// the channel that indicates that the commitments took too long
+	commitTimeout chan bool
+	// children whose commitment has been received in time
+	commitFrom map[sda.TreeNodeID]bool
+	// whether the commitment has already been sent
+	committed bool
 	// temporary buffer of commitment messages
 	tempCommitment []abstract.Point
 	// temp buffer of index of refusing-to-sign nodes
@@ -79,28 +104,25 @@ type VerificationHook func(data []byte) bool
 // (it's called on the root since only the root has the final signature)
 type SignatureHook func(sig []byte)
 
-// NewCoSiUpdate takes a verification function and a TreeNodeInstance and will
-// return a fresh CoSiUpdate ProtocolInstance.
+// NewCoSi takes a verification function and a TreeNodeInstance and will
+// return a fresh CoSi ProtocolInstance.
 // Use this function like this:
 // ```
 // fn := func(n *sda.TreeNodeInstance) sda.ProtocolInstance {
-//      pc := NewCoSiUpdate(n,myVerificationFunction)
+//      pc := NewCoSi(n,myVerificationFunction)
 //		return pc
 // }
 // sda.RegisterNewProtocolName("MyCoSiSoftwareUpdate",fn)
 // ```
-func NewCoSiUpdate(node *sda.TreeNodeInstance, fn VerificationHook) (*CoSiUpdate, error) {
+func NewCoSi(node *sda.TreeNodeInstance, fn VerificationHook) (*CoSi, error) {
 	var err error
-	// XXX just need to take care to take the global list of cosigners once we
-	// do the exception stuff
-	publics := make([]abstract.Point, len(node.Roster().List))
-	for i, e := range node.Roster().List {
-		publics[i] = e.Public
-	}
-	c := &CoSiUpdate{
-		cosi:             cosi.NewCosi(node.Suite(), node.Private(), publics),
+	c := &CoSi{
+		cosi:             cosi.NewCosi(node.Suite(), node.Private(), node.Roster().Publics()),
 		TreeNodeInstance: node,
+		Timeout:          DefaultTimeout,
 		done:             make(chan bool),
+		commitTimeout:    make(chan bool),
+		commitFrom:       make(map[sda.TreeNodeID]bool),
 		tempCommitLock:   new(sync.Mutex),
 		tempResponseLock: new(sync.Mutex),
 		tempRefusing:     make([]uint32, 0), // in case there's no exception, protobuf fails otherwise
@@ -126,18 +148,20 @@ func NewCoSiUpdate(node *sda.TreeNodeInstance, fn VerificationHook) (*CoSiUpdate
 }
 
 // Dispatch will listen on the four channels we use (i.e. four steps)
-func (c *CoSiUpdate) Dispatch() error {
+func (c *CoSi) Dispatch() error {
 	for {
 		var err error
 		select {
 		case packet := <-c.announce:
 			err = c.handleAnnouncement(&packet.Announcement)
 		case packet := <-c.commit:
-			err = c.handleCommitment(&packet.Commitment)
+			err = c.handleCommitment(packet.TreeNode, &packet.Commitment)
+		case <-c.commitTimeout:
+			err = c.handleCommitmentTimeout()
 		case packet := <-c.challenge:
 			err = c.handleChallenge(&packet.Challenge)
 		case packet := <-c.response:
-			err = c.handleResponse(&packet.Response)
+			err = c.handleResponse(packet.TreeNode, &packet.Response)
 		case <-c.done:
 			return nil
 		}
@@ -149,8 +173,8 @@ func (c *CoSiUpdate) Dispatch() error {
 
 // Start will call the announcement function of its inner Round structure. It
 // will pass nil as *in* message.
-func (c *CoSiUpdate) Start() error {
-	out := &Announcement{Data: c.Message}
+func (c *CoSi) Start() error {
+	out := &Announcement{Data: c.Message, Timeout: c.Timeout}
 	return c.handleAnnouncement(out)
 }
 
@@ -162,10 +186,53 @@ func VerifySignature(suite abstract.Suite, publics []abstract.Point, msg, sig []
 	return cosi.VerifySignature(suite, publics, msg, sig)
 }
 
+// VerifySignatureThreshold verifies the signature like VerifySignature, the
+// exceptions of the signature being removed from publics, and checks that at
+// least threshold nodes signed.
+func VerifySignatureThreshold(suite abstract.Suite, publics []abstract.Point,
+	msg, sig []byte, threshold int) error {
+	exceptions, err := Exceptions(suite, len(publics), sig)
+	if err != nil {
+		return err
+	}
+	if signers := len(publics) - len(exceptions); signers < threshold {
+		return errors.New("Only " + strconv.Itoa(signers) + " out of " +
+			strconv.Itoa(len(publics)) + " nodes signed, need " +
+			strconv.Itoa(threshold))
+	}
+	return cosi.VerifySignature(suite, publics, msg, sig)
+}
+
+// Exceptions returns the indices in the roster of the nodes that didn't sign,
+// as given by the mask of the signature. n is the size of the roster.
+func Exceptions(suite abstract.Suite, n int, sig []byte) ([]int, error) {
+	lenSig := suite.PointLen() + suite.ScalarLen()
+	if len(sig) != lenSig+(n+7)/8 {
+		return nil, errors.New("Wrong length of signature")
+	}
+	mask := sig[lenSig:]
+	var exceptions []int
+	for i := 0; i < n; i++ {
+		if mask[i/8]&(1<<uint(i&7)) == 0 {
+			exceptions = append(exceptions, i)
+		}
+	}
+	return exceptions, nil
+}
+
+// DefaultThreshold returns the number of nodes out of n that have to sign so
+// that less than a third of the nodes failed.
+func DefaultThreshold(n int) int {
+	return n - (n-1)/3
+}
+
 // handleAnnouncement will pass the message to the round and send back the
 // output. If in == nil, we are root and we start the round.
-func (c *CoSiUpdate) handleAnnouncement(in *Announcement) error {
+func (c *CoSi) handleAnnouncement(in *Announcement) error {
 	log.Lvl3("Message:", c.Message)
+	if in.Timeout > 0 {
+		c.Timeout = in.Timeout
+	}
 	if c.verificationHook != nil {
 		// write to the channel when the verification function is done
 		go func() {
@@ -184,27 +251,70 @@ func (c *CoSiUpdate) handleAnnouncement(in *Announcement) error {
 
 	// If we are leaf, we should go to commitment
 	if c.IsLeaf() {
-		return c.handleCommitment(nil)
+		return c.handleCommitment(nil, nil)
 	}
+	// wait for the commitments of the subtree
+	wait := time.Duration(subtreeHeight(c.TreeNode())) * c.Timeout
+	time.AfterFunc(wait, func() {
+		select {
+		case c.commitTimeout <- true:
+		case <-c.done:
+		}
+	})
 	// send to children
 	return c.SendToChildren(in)
 }
 
-// handleAllCommitment relay the commitments up in the tree
-// It expects *in* to be the full set of messages from the children.
-// The children's commitment must remain constants.
-func (c *CoSiUpdate) handleCommitment(in *Commitment) error {
+// handleCommitment stores the commitment of a child and relays the
+// commitments up in the tree once all children committed. Commitments coming
+// after the timeout are ignored.
+func (c *CoSi) handleCommitment(from *sda.TreeNode, in *Commitment) error {
 	if !c.IsLeaf() {
 		// add to temporary
 		c.tempCommitLock.Lock()
+		if c.committed || c.commitFrom[from.ID] {
+			c.tempCommitLock.Unlock()
+			log.Lvl2(c.Name(), "ignoring late commitment from", from.Name())
+			return nil
+		}
+		c.commitFrom[from.ID] = true
 		c.tempCommitment = append(c.tempCommitment, in.Comm)
 		c.tempRefusing = append(c.tempRefusing, in.RefusingNodes...)
+		enough := len(c.commitFrom) == len(c.Children())
 		c.tempCommitLock.Unlock()
 		// do we have enough ?
-		if len(c.tempCommitment) < len(c.Children()) {
+		if !enough {
 			return nil
 		}
 	}
+	return c.commit()
+}
+
+// handleCommitmentTimeout adds the nodes of the subtrees that didn't commit
+// in time to the exceptions and relays the commitments received so far.
+func (c *CoSi) handleCommitmentTimeout() error {
+	c.tempCommitLock.Lock()
+	if c.committed {
+		c.tempCommitLock.Unlock()
+		return nil
+	}
+	for _, child := range c.Children() {
+		if !c.commitFrom[child.ID] {
+			log.Lvl2(c.Name(), "timeout waiting for commitment of", child.Name())
+			c.tempRefusing = append(c.tempRefusing, subtreeIndices(child)...)
+		}
+	}
+	c.tempCommitLock.Unlock()
+	return c.commit()
+}
+
+// commit aggregates the commitments of the children with our own, if we
+// sign, and sends them up in the tree together with the exceptions.
+func (c *CoSi) commit() error {
+	c.tempCommitLock.Lock()
+	c.committed = true
+	refusing := c.tempRefusing
+	c.tempCommitLock.Unlock()
 	log.Lvl3(c.Name(), "aggregated")
 
 	// wait for the verification function to return
@@ -212,7 +322,6 @@ func (c *CoSiUpdate) handleCommitment(in *Commitment) error {
 	c.isSigning = <-c.verificationChan
 
 	var out = c.Suite().Point().Null()
-	var refusing []uint32
 	// this node should not sign, so it just relays the commitment it received
 	if c.isSigning {
 		// go to Commit()
@@ -240,11 +349,11 @@ func (c *CoSiUpdate) handleCommitment(in *Commitment) error {
 }
 
 // StartChallenge starts the challenge phase. Typically called by the Root ;)
-func (c *CoSiUpdate) startChallenge() error {
+func (c *CoSi) startChallenge() error {
 	// remove the non-participating nodes from the Challenge + responses phases
 	var max = uint32(len(c.Roster().List))
 	for _, idx := range c.tempRefusing {
-		if idx > max {
+		if idx >= max {
 			log.Lvl3("Error indexing a refusing node:", idx, "/", max)
 			continue
 		}
@@ -265,40 +374,54 @@ func (c *CoSiUpdate) startChallenge() error {
 
 // handleChallenge dispatch the challenge to the round and then dispatch the
 // results down the tree.
-func (c *CoSiUpdate) handleChallenge(in *Challenge) error {
+func (c *CoSi) handleChallenge(in *Challenge) error {
 	log.Lvl3(c.Name(), "chal=", fmt.Sprintf("%+v", in.Chall))
 
 	c.cosi.Challenge(in.Chall)
 
 	// if we are leaf, then go to response
 	if c.IsLeaf() {
-		return c.handleResponse(nil)
+		return c.respond()
 	}
 
-	// otherwise send it to children
-	return c.SendToChildren(in)
+	// otherwise send it to children, also to the ones that didn't commit in
+	// time so that they can finish.
+	if err := c.SendToChildren(in); err != nil {
+		return err
+	}
+	if len(c.commitFrom) == 0 {
+		return c.respond()
+	}
+	return nil
 }
 
-// handleResponse brings up the response of each node in the tree to the root.
-func (c *CoSiUpdate) handleResponse(in *Response) error {
-	if !c.IsLeaf() {
-		// add to temporary
-		c.tempResponseLock.Lock()
-		c.tempResponse = append(c.tempResponse, in.Resp)
-		c.tempResponseLock.Unlock()
-		// do we have enough ?
-		log.Lvl3(c.Name(), "has", len(c.tempResponse), "responses")
-		if len(c.tempResponse) < len(c.Children()) {
-			return nil
-		}
+// handleResponse stores the response of a child, and brings up the
+// responses to the root once all children that committed in time responded.
+func (c *CoSi) handleResponse(from *sda.TreeNode, in *Response) error {
+	if !c.commitFrom[from.ID] {
+		log.Lvl2(c.Name(), "ignoring response of", from.Name())
+		return nil
+	}
+	// add to temporary
+	c.tempResponseLock.Lock()
+	c.tempResponse = append(c.tempResponse, in.Resp)
+	enough := len(c.tempResponse) == len(c.commitFrom)
+	c.tempResponseLock.Unlock()
+	// do we have enough ?
+	log.Lvl3(c.Name(), "has", len(c.tempResponse), "responses")
+	if !enough {
+		return nil
 	}
+	return c.respond()
+}
+
+// respond sends our response, aggregated with the ones of the children, to
+// the parent. The root gives the signature to the signature hook.
+func (c *CoSi) respond() error {
 	log.Lvl3(c.Name(), "aggregated all responses")
 
-	defer func() {
-		// protocol is finished
-		close(c.done)
-		c.Done()
-	}()
+	// protocol is finished
+	defer c.finish()
 
 	var out = c.Suite().Scalar().Zero()
 	var err error
@@ -333,27 +456,61 @@ func (c *CoSiUpdate) handleResponse(in *Response) error {
 	return nil
 }
 
+// Stop ends the protocol on this node without waiting for the signature.
+func (c *CoSi) Stop() {
+	c.finish()
+}
+
+// finish stops Dispatch and releases the resources of the node.
+func (c *CoSi) finish() {
+	c.doneOnce.Do(func() {
+		close(c.done)
+		c.Done()
+	})
+}
+
 // VerifyResponses allows to check at each intermediate node whether the
 // responses are valid
-func (c *CoSiUpdate) VerifyResponses(agg abstract.Point) error {
+func (c *CoSi) VerifyResponses(agg abstract.Point) error {
 	return c.cosi.VerifyResponses(agg)
 }
 
 // SigningMessage simply set the message to sign for this round
-func (c *CoSiUpdate) SigningMessage(msg []byte) {
+func (c *CoSi) SigningMessage(msg []byte) {
 	c.Message = msg
 	log.Lvlf2(c.Name(), "Root will sign message %x", c.Message)
 }
 
 // RegisterSignatureHook allows for handling what should happen when
 // the protocol is done
-func (c *CoSiUpdate) RegisterSignatureHook(fn SignatureHook) {
+func (c *CoSi) RegisterSignatureHook(fn SignatureHook) {
 	c.signatureHook = fn
 }
 
 // RegisterVerificationHook can be used to register a handler which will be
 // called during the Announcement phase. It will be called on the message which
 // is passed during the announcement phase.
-func (c *CoSiUpdate) RegisterVerificationHook(fn VerificationHook) {
+func (c *CoSi) RegisterVerificationHook(fn VerificationHook) {
 	c.verificationHook = fn
 }
+
+// subtreeHeight returns the number of levels of the subtree below the node.
+func subtreeHeight(tn *sda.TreeNode) int {
+	height := 0
+	for _, child := range tn.Children {
+		if h := subtreeHeight(child) + 1; h > height {
+			height = h
+		}
+	}
+	return height
+}
+
+// subtreeIndices returns the indices in the roster of the nodes of the
+// subtree.
+func subtreeIndices(tn *sda.TreeNode) []uint32 {
+	indices := []uint32{uint32(tn.ServerIdentityIdx)}
+	for _, child := range tn.Children {
+		indices = append(indices, subtreeIndices(child)...)
+	}
+	return indices
+}
@@ -1,4 +1,4 @@
-package swupdate
+package cosi
 
 import (
 	"math"
@@ -14,7 +14,7 @@ import (
 	"github.com/dedis/crypto/cosi"
 )
 
-var name = "TestCoSiUpdate"
+var name = "TestCoSi"
 
 func TestCosi(t *testing.T) {
 	defer log.AfterTest(t)
@@ -39,7 +39,7 @@ func TestCosi(t *testing.T) {
 		msg := []byte("Hello World Cosi")
 
 		// Register the function generating the protocol instance
-		var root *CoSiUpdate
+		var root *CoSi
 		// function that will be called when protocol is finished by the root
 		doneFunc := func(sig []byte) {
 			suite := hosts[0].Suite()
@@ -58,7 +58,7 @@ func TestCosi(t *testing.T) {
 		if err != nil {
 			t.Fatal("Couldn't create new node:", err)
 		}
-		root = p.(*CoSiUpdate)
+		root = p.(*CoSi)
 		root.Message = msg
 		root.RegisterSignatureHook(doneFunc)
 		go root.StartProtocol()
@@ -95,7 +95,7 @@ func registerProtocol(protoName string, nbrHosts, failing int) {
 		} else {
 			fn = successFn
 		}
-		return NewCoSiUpdate(n, fn)
+		return NewCoSi(n, fn)
 	})
 }
 
@@ -103,3 +103,76 @@ func protoName(round int) string {
 	r := strconv.Itoa(round)
 	return name + "_" + r
 }
+
+func TestCosiTimeout(t *testing.T) {
+	defer log.AfterTest(t)
+	log.TestOutput(testing.Verbose(), 4)
+	nbrHosts := 7
+	slow := nbrHosts - 1
+	protocolName := name + "_timeout"
+	sda.ProtocolRegisterName(protocolName, func(n *sda.TreeNodeInstance) (sda.ProtocolInstance, error) {
+		return NewCoSi(n, func(data []byte) bool {
+			if n.Index() == slow {
+				time.Sleep(time.Second)
+			}
+			return true
+		})
+	})
+
+	local := sda.NewLocalTest()
+	defer local.CloseAll()
+	hosts, el, tree := local.GenBigTree(nbrHosts, nbrHosts, 2, true, true)
+	suite := hosts[0].Suite()
+	msg := []byte("Hello World Cosi")
+
+	p, err := local.CreateProtocol(tree, protocolName)
+	if err != nil {
+		t.Fatal("Couldn't create new node:", err)
+	}
+	root := p.(*CoSi)
+	root.Message = msg
+	root.Timeout = 100 * time.Millisecond
+	done := make(chan []byte)
+	root.RegisterSignatureHook(func(sig []byte) {
+		done <- sig
+	})
+	go root.StartProtocol()
+	var sig []byte
+	select {
+	case sig = <-done:
+	case <-time.After(time.Second * 2):
+		t.Fatal("Slow node stalled the signature")
+	}
+
+	exceptions, err := Exceptions(suite, nbrHosts, sig)
+	log.ErrFatal(err)
+	found := false
+	for _, e := range exceptions {
+		found = found || e == slow
+	}
+	if !found {
+		t.Fatal("Slow node is not an exception:", exceptions)
+	}
+	publics := el.Publics()
+	if err := VerifySignature(suite, publics, msg, sig); err != nil {
+		t.Fatal("Error verifying signature:", err)
+	}
+	signers := nbrHosts - len(exceptions)
+	if err := VerifySignatureThreshold(suite, publics, msg, sig, signers); err != nil {
+		t.Fatal("Error verifying signature:", err)
+	}
+	if VerifySignatureThreshold(suite, publics, msg, sig, signers+1) == nil {
+		t.Fatal("Threshold should not be reached")
+	}
+	if VerifySignatureThreshold(suite, publics, []byte("other"), sig, signers) == nil {
+		t.Fatal("Signature verified for another message")
+	}
+}
+
+func TestDefaultThreshold(t *testing.T) {
+	for n, threshold := range map[int]int{1: 1, 3: 3, 4: 3, 7: 5, 10: 7} {
+		if DefaultThreshold(n) != threshold {
+			t.Fatal("Wrong threshold for", n, DefaultThreshold(n))
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package cosi
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/crypto/abstract"
+	"golang.org/x/net/context"
+)
+
+// DefaultRoundTimeout is how long a service should wait for a whole signing
+// round before giving up.
+const DefaultRoundTimeout = time.Minute
+
+// TreeShape defines how the nodes of the roster are arranged for a round.
+type TreeShape int
+
+const (
+	// BinaryTree puts the roster in a binary tree. This is the default.
+	BinaryTree TreeShape = iota
+	// StarTree connects all nodes directly to the root.
+	StarTree
+	// NaryTree uses Config.Branching children for every node.
+	NaryTree
+)
+
+// Config holds the parameters of a signing round. Only Roster is mandatory,
+// the first node of the roster being the root of the round.
+type Config struct {
+	Roster *sda.Roster
+	Shape  TreeShape
+	// Branching factor of the tree, only used with NaryTree
+	Branching int
+	// Timeout for the commitments of every level of the tree, DefaultTimeout
+	// if zero
+	Timeout time.Duration
+	// Threshold of nodes that have to sign, DefaultThreshold if zero
+	Threshold int
+}
+
+// Tree returns the tree of the round with the shape of the configuration.
+func (conf *Config) Tree() (*sda.Tree, error) {
+	n := len(conf.Roster.List)
+	switch conf.Shape {
+	case BinaryTree:
+		return conf.Roster.GenerateBinaryTree(), nil
+	case StarTree:
+		if n < 2 {
+			return conf.Roster.GenerateNaryTree(1), nil
+		}
+		return conf.Roster.GenerateNaryTree(n - 1), nil
+	case NaryTree:
+		if conf.Branching < 1 {
+			return nil, errors.New("Branching of the tree must be positive")
+		}
+		return conf.Roster.GenerateNaryTree(conf.Branching), nil
+	}
+	return nil, errors.New("Unknown tree shape")
+}
+
+// threshold returns the number of nodes that have to sign.
+func (conf *Config) threshold() int {
+	if conf.Threshold > 0 {
+		return conf.Threshold
+	}
+	return DefaultThreshold(len(conf.Roster.List))
+}
+
+// Signature is the outcome of a signing round.
+type Signature struct {
+	// Msg is the signed message
+	Msg []byte
+	// Sig is the collective signature, including the mask of the signers
+	Sig []byte
+	// Exceptions are the indices in the roster of the nodes that didn't sign
+	Exceptions []int
+}
+
+// Verify returns nil if the signature is valid for the publics and at least
+// threshold nodes signed.
+func (s *Signature) Verify(suite abstract.Suite, publics []abstract.Point,
+	threshold int) error {
+	return VerifySignatureThreshold(suite, publics, s.Msg, s.Sig, threshold)
+}
+
+// Sign runs a signing round of msg from the service with the given
+// configuration. fn is used by the other nodes of the round to decide if
+// they sign, the service's NewProtocol has to create their instances with
+// NewCoSi. The round is aborted if ctx is done before the signature is
+// ready. An error is returned if less than the threshold of the
+// configuration signed.
+func Sign(ctx context.Context, c *sda.Context, conf *Config, msg []byte,
+	fn VerificationHook) (*Signature, error) {
+	tree, err := conf.Tree()
+	if err != nil {
+		return nil, err
+	}
+	tni := c.NewTreeNodeInstance(tree, tree.Root, ProtocolName)
+	pi, err := NewCoSi(tni, fn)
+	if err != nil {
+		return nil, errors.New("Couldn't make new protocol: " + err.Error())
+	}
+	if err := c.RegisterProtocolInstance(pi); err != nil {
+		return nil, err
+	}
+	pi.SigningMessage(msg)
+	if conf.Timeout > 0 {
+		pi.Timeout = conf.Timeout
+	}
+	response := make(chan []byte, 1)
+	pi.RegisterSignatureHook(func(sig []byte) {
+		response <- sig
+	})
+	go pi.Dispatch()
+	go func() {
+		if err := pi.Start(); err != nil {
+			log.Error("Couldn't start signing round:", err)
+		}
+	}()
+
+	var sig []byte
+	select {
+	case sig = <-response:
+	case <-ctx.Done():
+		pi.Stop()
+		return nil, errors.New("Signing round aborted: " + ctx.Err().Error())
+	}
+	log.Lvl2("Received cosi response")
+	exceptions, err := Exceptions(network.Suite, len(conf.Roster.List), sig)
+	if err != nil {
+		return nil, err
+	}
+	s := &Signature{Msg: msg, Sig: sig, Exceptions: exceptions}
+	if err := s.Verify(network.Suite, conf.Roster.Publics(),
+		conf.threshold()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,32 @@
+package cosi
+
+import (
+	"testing"
+
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/sda"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_Tree(t *testing.T) {
+	defer log.AfterTest(t)
+	local := sda.NewLocalTest()
+	defer local.CloseAll()
+	_, el, _ := local.GenTree(7, false, false, false)
+
+	tree, err := (&Config{Roster: el}).Tree()
+	log.ErrFatal(err)
+	assert.True(t, tree.IsBinary(tree.Root))
+	tree, err = (&Config{Roster: el, Shape: StarTree}).Tree()
+	log.ErrFatal(err)
+	assert.Equal(t, 6, len(tree.Root.Children))
+	tree, err = (&Config{Roster: el, Shape: NaryTree, Branching: 3}).Tree()
+	log.ErrFatal(err)
+	assert.Equal(t, 3, len(tree.Root.Children))
+	assert.Equal(t, 7, tree.Size())
+
+	_, err = (&Config{Roster: el, Shape: NaryTree}).Tree()
+	assert.NotNil(t, err)
+	_, err = (&Config{Roster: el, Shape: NaryTree + 1}).Tree()
+	assert.NotNil(t, err)
+}
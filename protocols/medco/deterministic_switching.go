@@ -0,0 +1,125 @@
+package medco
+
+import (
+	"errors"
+
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/medco/libmedco"
+	"github.com/dedis/crypto/abstract"
+)
+
+// DeterministicSwitchingProtocolName is the registered name for the deterministic switching protocol.
+const DeterministicSwitchingProtocolName = "DeterministicSwitching"
+
+func init() {
+	network.RegisterPacketType(DeterministicSwitchedMessage{})
+	sda.ProtocolRegisterName(DeterministicSwitchingProtocolName, NewDeterministSwitchingProtocol)
+}
+
+// DeterministicSwitchedMessage contains the data that is being switched, passed from one node to the next.
+type DeterministicSwitchedMessage struct {
+	SurveyID libmedco.SurveyID
+	Data     []CipherVectorEntry
+}
+
+// DeterministicSwitchedStruct is the wrapper of DeterministicSwitchedMessage to be used in a channel.
+type DeterministicSwitchedStruct struct {
+	*sda.TreeNode
+	DeterministicSwitchedMessage
+}
+
+// DeterministicSwitchingProtocol switches ElGamal ciphertexts, encrypted under the collective key, to deterministic
+// ciphertexts. Every node replaces its part of the ElGamal secret by a part made with its SurveyPHKey, so that
+// once all nodes did their step, equal messages have equal ciphertexts.
+type DeterministicSwitchingProtocol struct {
+	*sda.TreeNodeInstance
+
+	// FeedbackChannel receives the deterministic ciphertexts at the root.
+	FeedbackChannel chan map[libmedco.TempID]libmedco.DeterministCipherVector
+
+	// PreviousNodeInPathChannel receives the data from the previous node.
+	PreviousNodeInPathChannel chan DeterministicSwitchedStruct
+
+	// TargetOfSwitch is the data to switch, only needed at the root.
+	TargetOfSwitch *map[libmedco.TempID]libmedco.CipherVector
+	// SurveyPHKey is the secret of this node for the deterministic encryption.
+	SurveyPHKey *abstract.Scalar
+	// SurveyID is sent along the data, OnSurvey is called with it on the
+	// other nodes.
+	SurveyID libmedco.SurveyID
+	OnSurvey SurveyCallback
+
+	nextNodeInCircuit *sda.TreeNode
+}
+
+// NewDeterministSwitchingProtocol constructs a deterministic switching protocol instance.
+func NewDeterministSwitchingProtocol(n *sda.TreeNodeInstance) (sda.ProtocolInstance, error) {
+	dsp := &DeterministicSwitchingProtocol{
+		TreeNodeInstance:  n,
+		FeedbackChannel:   make(chan map[libmedco.TempID]libmedco.DeterministCipherVector, 1),
+		nextNodeInCircuit: nextNodeInCircuit(n),
+	}
+	if err := dsp.RegisterChannel(&dsp.PreviousNodeInPathChannel); err != nil {
+		return nil, errors.New("couldn't register data reference channel: " + err.Error())
+	}
+	return dsp, nil
+}
+
+// Start is called at the root and switches the target of the switch first.
+func (p *DeterministicSwitchingProtocol) Start() error {
+	if p.TargetOfSwitch == nil {
+		return errors.New("No ciphertext given as deterministic switching target")
+	}
+	if p.SurveyPHKey == nil {
+		return errors.New("No PH key given")
+	}
+	log.Lvl2(p.ServerIdentity(), "started a Deterministic Switching Protocol (", len(*p.TargetOfSwitch), "rows)")
+	p.PreviousNodeInPathChannel <- DeterministicSwitchedStruct{p.TreeNode(),
+		DeterministicSwitchedMessage{p.SurveyID, cipherVectorEntries(*p.TargetOfSwitch)}}
+	return nil
+}
+
+// Dispatch switches the data received and passes it to the next node. Once the data comes back to the root, it
+// is sent to the FeedbackChannel.
+func (p *DeterministicSwitchingProtocol) Dispatch() error {
+	msg := <-p.PreviousNodeInPathChannel
+	if !p.IsRoot() && p.OnSurvey != nil {
+		if err := p.OnSurvey(msg.SurveyID); err != nil {
+			return err
+		}
+	}
+	if p.SurveyPHKey == nil {
+		return errors.New("No PH key given")
+	}
+	phContrib := suite.Point().Mul(suite.Point().Base(), *p.SurveyPHKey)
+	data := msg.Data
+	for i, e := range data {
+		data[i].Vector = *libmedco.NewCipherVector(len(e.Vector)).
+			DeterministicSwitching(&e.Vector, p.Private(), phContrib)
+	}
+
+	if len(p.List()) > 1 {
+		if err := p.SendTo(p.nextNodeInCircuit, &DeterministicSwitchedMessage{msg.SurveyID, data}); err != nil {
+			return err
+		}
+		if !p.IsRoot() {
+			return nil
+		}
+		// Wait for the data to be switched by all other nodes.
+		data = (<-p.PreviousNodeInPathChannel).Data
+	}
+
+	result := make(map[libmedco.TempID]libmedco.DeterministCipherVector, len(data))
+	for _, e := range data {
+		dcv := make(libmedco.DeterministCipherVector, len(e.Vector))
+		for i, c := range e.Vector {
+			dcv[i] = libmedco.DeterministCipherText{Point: c.C}
+		}
+		result[e.ID] = dcv
+	}
+	log.Lvl2(p.ServerIdentity(), "completed deterministic switching (", len(result), "rows)")
+	p.FeedbackChannel <- result
+	return nil
+}
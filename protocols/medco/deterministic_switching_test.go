@@ -0,0 +1,89 @@
+package medco_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/protocols/medco"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/medco/libmedco"
+	"github.com/dedis/crypto/abstract"
+	"github.com/dedis/crypto/random"
+)
+
+// testPHKey is the PH key of all nodes in the tests.
+var testPHKey = network.Suite.Scalar().Pick(random.Stream)
+
+func init() {
+	sda.ProtocolRegisterName("DeterministicSwitchingTest", NewDeterministicSwitchingTest)
+}
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+// NewDeterministicSwitchingTest is a test specific protocol instance constructor that injects the test PH key.
+func NewDeterministicSwitchingTest(tni *sda.TreeNodeInstance) (sda.ProtocolInstance, error) {
+	pi, err := medco.NewDeterministSwitchingProtocol(tni)
+	if err != nil {
+		return nil, err
+	}
+	pi.(*medco.DeterministicSwitchingProtocol).SurveyPHKey = &testPHKey
+	return pi, nil
+}
+
+func TestDeterministicSwitching(t *testing.T) {
+	for _, nbrHosts := range []int{1, 5} {
+		log.Lvl2("Running deterministic switching with", nbrHosts, "hosts")
+		local := sda.NewLocalTest()
+		_, el, tree := local.GenTree(nbrHosts, true, true, true)
+
+		pi, err := local.CreateProtocol("DeterministicSwitchingTest", tree)
+		if err != nil {
+			t.Fatal("Couldn't create new protocol:", err)
+		}
+		protocol := pi.(*medco.DeterministicSwitchingProtocol)
+
+		target := map[libmedco.TempID]libmedco.CipherVector{
+			0: *libmedco.EncryptIntVector(el.Aggregate, []int64{1, 2, 3}),
+			1: *libmedco.EncryptIntVector(el.Aggregate, []int64{1, 2, 3}),
+			2: *libmedco.EncryptIntVector(el.Aggregate, []int64{3, 2, 1}),
+		}
+		protocol.TargetOfSwitch = &target
+		if err := protocol.Start(); err != nil {
+			t.Fatal("Couldn't start protocol:", err)
+		}
+
+		select {
+		case result := <-protocol.FeedbackChannel:
+			if len(result) != len(target) {
+				t.Fatal("Wrong number of results:", len(result))
+			}
+			r0, r1, r2 := result[0], result[1], result[2]
+			if !r0.Equal(&r1) {
+				t.Error("Equal values should have equal deterministic ciphertexts")
+			}
+			if r0.Equal(&r2) {
+				t.Error("Different values should have different deterministic ciphertexts")
+			}
+			// The deterministic ciphertext of v is v*B + n*s*B.
+			expected := deterministicPoint(1, nbrHosts)
+			if !r0[0].Point.Equal(expected) {
+				t.Error("Wrong deterministic ciphertext")
+			}
+		case <-time.After(time.Second * 10):
+			t.Fatal("Protocol didn't finish in time")
+		}
+		local.CloseAll()
+	}
+}
+
+// deterministicPoint returns the deterministic ciphertext of v after a
+// switching by nbrHosts nodes using testPHKey.
+func deterministicPoint(v int64, nbrHosts int) abstract.Point {
+	s := network.Suite.Scalar().Mul(network.Suite.Scalar().SetInt64(int64(nbrHosts)), testPHKey)
+	s.Add(s, network.Suite.Scalar().SetInt64(v))
+	return network.Suite.Point().Mul(network.Suite.Point().Base(), s)
+}
@@ -0,0 +1,22 @@
+/*
+Package medco contains the tree protocols used by the MedCo service to run a
+privacy-preserving survey.
+
+The data of a survey is ElGamal-encrypted under the collective key of the
+roster, which is the sum of the public keys of all nodes. The protocols
+change the encryption of this data without ever decrypting it:
+
+  - DeterministicSwitching replaces the ElGamal encryption by a deterministic
+    one, so that equal values can be grouped.
+  - PrivateAggregate sums up the encrypted values of all nodes, per group.
+  - ProbabilisticSwitching replaces the deterministic encryption by an ElGamal
+    encryption under the key of the querier.
+  - KeySwitching replaces the collective key by the key of the querier.
+  - The pipeline protocol (MedcoServiceProtocol) runs the phases above on all
+    nodes through the MedCo service.
+
+DeterministicSwitching, ProbabilisticSwitching and KeySwitching pass the data
+along all nodes of the tree, one after the other, starting and ending at the
+root. Every node of the roster must appear exactly once in the tree.
+*/
+package medco
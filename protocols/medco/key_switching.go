@@ -0,0 +1,131 @@
+package medco
+
+import (
+	"errors"
+
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/medco/libmedco"
+	"github.com/dedis/crypto/abstract"
+)
+
+// KeySwitchingProtocolName is the registered name for the key switching protocol.
+const KeySwitchingProtocolName = "KeySwitching"
+
+func init() {
+	network.RegisterPacketType(KeySwitchedCipherMessage{})
+	sda.ProtocolRegisterName(KeySwitchingProtocolName, NewKeySwitchingProtocol)
+}
+
+// KeySwitchedEntry holds a CipherVector being switched together with the original one, whose ephemeral keys are
+// needed by every node to remove its part of the collective key.
+type KeySwitchedEntry struct {
+	ID       libmedco.TempID
+	Original libmedco.CipherVector
+	Switched libmedco.CipherVector
+}
+
+// KeySwitchedCipherMessage contains the data that is being switched and the key it is switched to.
+type KeySwitchedCipherMessage struct {
+	Data   []KeySwitchedEntry
+	NewKey abstract.Point
+}
+
+// KeySwitchedCipherStruct is the wrapper of KeySwitchedCipherMessage to be used in a channel.
+type KeySwitchedCipherStruct struct {
+	*sda.TreeNode
+	KeySwitchedCipherMessage
+}
+
+// KeySwitchingProtocol switches ElGamal ciphertexts encrypted under the collective key to ciphertexts encrypted
+// under the TargetPublicKey. Every node removes its part of the collective key and adds a random ElGamal part for
+// the new key.
+type KeySwitchingProtocol struct {
+	*sda.TreeNodeInstance
+
+	// FeedbackChannel receives the ciphertexts under the target key at the root.
+	FeedbackChannel chan map[libmedco.TempID]libmedco.CipherVector
+
+	// PreviousNodeInPathChannel receives the data from the previous node.
+	PreviousNodeInPathChannel chan KeySwitchedCipherStruct
+
+	// TargetOfSwitch and TargetPublicKey are only needed at the root.
+	TargetOfSwitch  *map[libmedco.TempID]libmedco.CipherVector
+	TargetPublicKey *abstract.Point
+
+	nextNodeInCircuit *sda.TreeNode
+}
+
+// NewKeySwitchingProtocol constructs a key switching protocol instance.
+func NewKeySwitchingProtocol(n *sda.TreeNodeInstance) (sda.ProtocolInstance, error) {
+	ksp := &KeySwitchingProtocol{
+		TreeNodeInstance:  n,
+		FeedbackChannel:   make(chan map[libmedco.TempID]libmedco.CipherVector, 1),
+		nextNodeInCircuit: nextNodeInCircuit(n),
+	}
+	if err := ksp.RegisterChannel(&ksp.PreviousNodeInPathChannel); err != nil {
+		return nil, errors.New("couldn't register data reference channel: " + err.Error())
+	}
+	return ksp, nil
+}
+
+// Start is called at the root and switches the target of the switch first.
+func (p *KeySwitchingProtocol) Start() error {
+	if p.TargetOfSwitch == nil {
+		return errors.New("No ciphertext given as key switching target")
+	}
+	if p.TargetPublicKey == nil {
+		return errors.New("No new public key to be switched on provided")
+	}
+	log.Lvl2(p.ServerIdentity(), "started a Key Switching Protocol (", len(*p.TargetOfSwitch), "rows)")
+
+	// The switched ciphertexts start with a null ephemeral key, which is
+	// built up by the random parts of all nodes.
+	var data []KeySwitchedEntry
+	for _, e := range cipherVectorEntries(*p.TargetOfSwitch) {
+		switched := make(libmedco.CipherVector, len(e.Vector))
+		for i, c := range e.Vector {
+			switched[i] = libmedco.CipherText{K: suite.Point().Null(), C: c.C}
+		}
+		data = append(data, KeySwitchedEntry{e.ID, e.Vector, switched})
+	}
+	p.PreviousNodeInPathChannel <- KeySwitchedCipherStruct{p.TreeNode(),
+		KeySwitchedCipherMessage{data, *p.TargetPublicKey}}
+	return nil
+}
+
+// Dispatch switches the data received and passes it to the next node. Once the data comes back to the root, it
+// is sent to the FeedbackChannel.
+func (p *KeySwitchingProtocol) Dispatch() error {
+	msg := <-p.PreviousNodeInPathChannel
+	data := msg.Data
+	for i, e := range data {
+		ephemeralKeys := make([]abstract.Point, len(e.Original))
+		for j, c := range e.Original {
+			ephemeralKeys[j] = c.K
+		}
+		data[i].Switched = *libmedco.NewCipherVector(len(e.Switched)).
+			KeySwitching(&e.Switched, &ephemeralKeys, msg.NewKey, p.Private())
+	}
+
+	if len(p.List()) > 1 {
+		if err := p.SendTo(p.nextNodeInCircuit,
+			&KeySwitchedCipherMessage{data, msg.NewKey}); err != nil {
+			return err
+		}
+		if !p.IsRoot() {
+			return nil
+		}
+		// Wait for the data to be switched by all other nodes.
+		data = (<-p.PreviousNodeInPathChannel).Data
+	}
+
+	result := make(map[libmedco.TempID]libmedco.CipherVector, len(data))
+	for _, e := range data {
+		result[e.ID] = e.Switched
+	}
+	log.Lvl2(p.ServerIdentity(), "completed key switching (", len(result), "rows)")
+	p.FeedbackChannel <- result
+	return nil
+}
@@ -0,0 +1,59 @@
+package medco_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/protocols/medco"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/medco/libmedco"
+	"github.com/dedis/crypto/config"
+)
+
+func TestKeySwitching(t *testing.T) {
+	for _, nbrHosts := range []int{1, 5} {
+		log.Lvl2("Running key switching with", nbrHosts, "hosts")
+		local := sda.NewLocalTest()
+		_, el, tree := local.GenTree(nbrHosts, true, true, true)
+
+		pi, err := local.CreateProtocol(medco.KeySwitchingProtocolName, tree)
+		if err != nil {
+			t.Fatal("Couldn't create new protocol:", err)
+		}
+		protocol := pi.(*medco.KeySwitchingProtocol)
+
+		expected := map[libmedco.TempID][]int64{
+			0: {1, 2, 3},
+			1: {0, 5},
+		}
+		target := make(map[libmedco.TempID]libmedco.CipherVector)
+		for id, values := range expected {
+			target[id] = *libmedco.EncryptIntVector(el.Aggregate, values)
+		}
+		keys := config.NewKeyPair(network.Suite)
+		protocol.TargetOfSwitch = &target
+		protocol.TargetPublicKey = &keys.Public
+		if err := protocol.Start(); err != nil {
+			t.Fatal("Couldn't start protocol:", err)
+		}
+
+		select {
+		case result := <-protocol.FeedbackChannel:
+			if len(result) != len(expected) {
+				t.Fatal("Wrong number of results:", len(result))
+			}
+			for id, values := range expected {
+				cv := result[id]
+				if got, err := libmedco.DecryptIntVector(keys.Secret, &cv); err != nil || !reflect.DeepEqual(got, values) {
+					t.Error("Wrong decryption for", id, ":", got, "instead of", values, err)
+				}
+			}
+		case <-time.After(time.Second * 10):
+			t.Fatal("Protocol didn't finish in time")
+		}
+		local.CloseAll()
+	}
+}
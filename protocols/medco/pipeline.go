@@ -0,0 +1,127 @@
+package medco
+
+import (
+	"errors"
+
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/medco/libmedco"
+)
+
+// MedcoServiceProtocolName is the registered name for the medco service protocol.
+const MedcoServiceProtocolName = "MedcoServiceProtocol"
+
+func init() {
+	network.RegisterPacketType(TriggerFlushCollectedDataMessage{})
+	network.RegisterPacketType(DoneFlushCollectedDataMessage{})
+	sda.ProtocolRegisterName(MedcoServiceProtocolName, NewPipelineProcotol)
+}
+
+// MedcoServiceInterface defines the phases a medco service has to run on a survey.
+type MedcoServiceInterface interface {
+	DeterministicSwitchingPhase(libmedco.SurveyID) error
+	AggregationPhase(libmedco.SurveyID) error
+	KeySwitchingPhase(libmedco.SurveyID) error
+}
+
+// TriggerFlushCollectedDataMessage is sent down the tree to ask the nodes to switch their collected data.
+type TriggerFlushCollectedDataMessage struct {
+	SurveyID libmedco.SurveyID
+}
+
+// TriggerFlushCollectedDataStruct is the wrapper of TriggerFlushCollectedDataMessage to be used in a channel.
+type TriggerFlushCollectedDataStruct struct {
+	*sda.TreeNode
+	TriggerFlushCollectedDataMessage
+}
+
+// DoneFlushCollectedDataMessage is sent up the tree once a node and its subtree switched their data.
+type DoneFlushCollectedDataMessage struct{}
+
+// DoneFlushCollectedDataStruct is the wrapper of DoneFlushCollectedDataMessage to be used in a channel.
+type DoneFlushCollectedDataStruct struct {
+	*sda.TreeNode
+	DoneFlushCollectedDataMessage
+}
+
+// PipelineProtocol runs a survey through the phases of the medco service. All nodes first switch their collected
+// data to deterministic ciphertexts, then the root aggregates the data and switches it to the key of the querier.
+type PipelineProtocol struct {
+	*sda.TreeNodeInstance
+
+	// FeedbackChannel receives a value at the root once the survey has been
+	// processed.
+	FeedbackChannel chan bool
+
+	TriggerFlushCollectedData chan TriggerFlushCollectedDataStruct
+	DoneFlushCollectedData    chan []DoneFlushCollectedDataStruct
+
+	MedcoServiceInstance MedcoServiceInterface
+	TargetSurvey         *libmedco.Survey
+}
+
+// NewPipelineProcotol constructs a medco service protocol instance.
+func NewPipelineProcotol(n *sda.TreeNodeInstance) (sda.ProtocolInstance, error) {
+	pp := &PipelineProtocol{
+		TreeNodeInstance: n,
+		FeedbackChannel:  make(chan bool, 1),
+	}
+	if err := pp.RegisterChannel(&pp.TriggerFlushCollectedData); err != nil {
+		return nil, errors.New("couldn't register trigger channel: " + err.Error())
+	}
+	if err := pp.RegisterChannel(&pp.DoneFlushCollectedData); err != nil {
+		return nil, errors.New("couldn't register done channel: " + err.Error())
+	}
+	return pp, nil
+}
+
+// Start is called at the root and triggers the switching of the collected data on all nodes.
+func (p *PipelineProtocol) Start() error {
+	if p.MedcoServiceInstance == nil {
+		return errors.New("No medco service given")
+	}
+	if p.TargetSurvey == nil {
+		return errors.New("No target survey given")
+	}
+	log.Lvl1(p.ServerIdentity(), "started a Medco Service Protocol for survey", p.TargetSurvey.ID)
+	p.TriggerFlushCollectedData <- TriggerFlushCollectedDataStruct{p.TreeNode(),
+		TriggerFlushCollectedDataMessage{p.TargetSurvey.ID}}
+	return nil
+}
+
+// Dispatch runs the deterministic switching on every node and, once all nodes are done, the aggregation and the
+// key switching at the root.
+func (p *PipelineProtocol) Dispatch() error {
+	msg := <-p.TriggerFlushCollectedData
+	if p.MedcoServiceInstance == nil {
+		return errors.New("No medco service given")
+	}
+	surveyID := msg.SurveyID
+
+	if !p.IsLeaf() {
+		if err := p.SendToChildren(&msg.TriggerFlushCollectedDataMessage); err != nil {
+			return err
+		}
+	}
+	if err := p.MedcoServiceInstance.DeterministicSwitchingPhase(surveyID); err != nil {
+		return err
+	}
+	if !p.IsLeaf() {
+		<-p.DoneFlushCollectedData
+	}
+
+	if !p.IsRoot() {
+		return p.SendToParent(&DoneFlushCollectedDataMessage{})
+	}
+
+	if err := p.MedcoServiceInstance.AggregationPhase(surveyID); err != nil {
+		return err
+	}
+	if err := p.MedcoServiceInstance.KeySwitchingPhase(surveyID); err != nil {
+		return err
+	}
+	log.Lvl1(p.ServerIdentity(), "completed the survey", surveyID)
+	p.FeedbackChannel <- true
+	return nil
+}
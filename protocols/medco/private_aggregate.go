@@ -0,0 +1,127 @@
+package medco
+
+import (
+	"errors"
+
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/medco/libmedco"
+)
+
+// PrivateAggregateProtocolName is the registered name for the private aggregate protocol.
+const PrivateAggregateProtocolName = "PrivateAggregate"
+
+func init() {
+	network.RegisterPacketType(DataReferenceMessage{})
+	network.RegisterPacketType(ChildAggregatedDataMessage{})
+	sda.ProtocolRegisterName(PrivateAggregateProtocolName, NewPrivateAggregate)
+}
+
+// DataReferenceMessage is sent down the tree to ask the nodes for their data.
+type DataReferenceMessage struct {
+	SurveyID libmedco.SurveyID
+}
+
+// DataReferenceStruct is the wrapper of DataReferenceMessage to be used in a channel.
+type DataReferenceStruct struct {
+	*sda.TreeNode
+	DataReferenceMessage
+}
+
+// ChildAggregatedDataMessage contains the data aggregated by a node and its subtree.
+type ChildAggregatedDataMessage struct {
+	ChildData []GroupEntry
+}
+
+// ChildAggregatedDataStruct is the wrapper of ChildAggregatedDataMessage to be used in a channel.
+type ChildAggregatedDataStruct struct {
+	*sda.TreeNode
+	ChildAggregatedDataMessage
+}
+
+// CothorityAggregatedData is the result of the protocol, the groups and their data aggregated over all nodes.
+type CothorityAggregatedData struct {
+	Groups      map[libmedco.GroupingKey]libmedco.GroupingAttributes
+	GroupedData map[libmedco.GroupingKey]libmedco.CipherVector
+}
+
+// PrivateAggregateProtocol sums up the encrypted data of all nodes, per group. The root asks the tree for the data
+// and every node sends the sum of its own data and the data of its children to its parent.
+type PrivateAggregateProtocol struct {
+	*sda.TreeNodeInstance
+
+	// FeedbackChannel receives the aggregated data at the root.
+	FeedbackChannel chan CothorityAggregatedData
+
+	DataReferenceChannel chan DataReferenceStruct
+	ChildDataChannel     chan []ChildAggregatedDataStruct
+
+	// GroupedData and Groups are the local data of this node, nil counts
+	// as no data.
+	GroupedData *map[libmedco.GroupingKey]libmedco.CipherVector
+	Groups      *map[libmedco.GroupingKey]libmedco.GroupingAttributes
+	// SurveyID is sent along the data reference, OnSurvey is called with it
+	// on the other nodes.
+	SurveyID libmedco.SurveyID
+	OnSurvey SurveyCallback
+}
+
+// NewPrivateAggregate constructs a private aggregate protocol instance.
+func NewPrivateAggregate(n *sda.TreeNodeInstance) (sda.ProtocolInstance, error) {
+	pap := &PrivateAggregateProtocol{
+		TreeNodeInstance: n,
+		FeedbackChannel:  make(chan CothorityAggregatedData, 1),
+	}
+	if err := pap.RegisterChannel(&pap.DataReferenceChannel); err != nil {
+		return nil, errors.New("couldn't register data reference channel: " + err.Error())
+	}
+	if err := pap.RegisterChannel(&pap.ChildDataChannel); err != nil {
+		return nil, errors.New("couldn't register child data channel: " + err.Error())
+	}
+	return pap, nil
+}
+
+// Start is called at the root and asks the tree for the data.
+func (p *PrivateAggregateProtocol) Start() error {
+	if p.GroupedData == nil || p.Groups == nil {
+		return errors.New("No data reference provided for aggregation")
+	}
+	log.Lvl2(p.ServerIdentity(), "started a Private Aggregate Protocol (", len(*p.GroupedData), "local groups)")
+	p.DataReferenceChannel <- DataReferenceStruct{p.TreeNode(), DataReferenceMessage{p.SurveyID}}
+	return nil
+}
+
+// Dispatch passes the data reference down the tree and the aggregated data up the tree. The root sends the
+// aggregation of all nodes to the FeedbackChannel.
+func (p *PrivateAggregateProtocol) Dispatch() error {
+	msg := <-p.DataReferenceChannel
+	if !p.IsRoot() && p.OnSurvey != nil {
+		if err := p.OnSurvey(msg.SurveyID); err != nil {
+			return err
+		}
+	}
+
+	groups := make(map[libmedco.GroupingKey]libmedco.GroupingAttributes)
+	data := make(map[libmedco.GroupingKey]libmedco.CipherVector)
+	if p.GroupedData != nil && p.Groups != nil {
+		addGroups(groups, data, groupEntries(*p.Groups, *p.GroupedData))
+	}
+
+	if !p.IsLeaf() {
+		if err := p.SendToChildren(&msg.DataReferenceMessage); err != nil {
+			return err
+		}
+		for _, child := range <-p.ChildDataChannel {
+			addGroups(groups, data, child.ChildData)
+		}
+	}
+
+	if !p.IsRoot() {
+		return p.SendToParent(&ChildAggregatedDataMessage{groupEntries(groups, data)})
+	}
+
+	log.Lvl2(p.ServerIdentity(), "completed aggregation (", len(data), "groups)")
+	p.FeedbackChannel <- CothorityAggregatedData{groups, data}
+	return nil
+}
@@ -0,0 +1,84 @@
+package medco_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/protocols/medco"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/medco/libmedco"
+	"github.com/dedis/crypto/config"
+)
+
+// aggregateKeys is the key pair the data of the aggregation test is encrypted with.
+var aggregateKeys = config.NewKeyPair(network.Suite)
+
+// testGroups returns the groups every node holds in the aggregation test.
+func testGroups() (map[libmedco.GroupingKey]libmedco.GroupingAttributes, map[libmedco.GroupingKey]libmedco.CipherVector) {
+	groups := map[libmedco.GroupingKey]libmedco.GroupingAttributes{
+		"a": {},
+		"b": {},
+	}
+	data := map[libmedco.GroupingKey]libmedco.CipherVector{
+		"a": *libmedco.EncryptIntVector(aggregateKeys.Public, []int64{1, 2, 3}),
+		"b": *libmedco.EncryptIntVector(aggregateKeys.Public, []int64{0, 1}),
+	}
+	return groups, data
+}
+
+func init() {
+	sda.ProtocolRegisterName("PrivateAggregateTest", NewPrivateAggregateTest)
+}
+
+// NewPrivateAggregateTest is a test specific protocol instance constructor that injects the test data.
+func NewPrivateAggregateTest(tni *sda.TreeNodeInstance) (sda.ProtocolInstance, error) {
+	pi, err := medco.NewPrivateAggregate(tni)
+	if err != nil {
+		return nil, err
+	}
+	groups, data := testGroups()
+	pi.(*medco.PrivateAggregateProtocol).Groups = &groups
+	pi.(*medco.PrivateAggregateProtocol).GroupedData = &data
+	return pi, nil
+}
+
+func TestPrivateAggregate(t *testing.T) {
+	for _, nbrHosts := range []int{1, 5} {
+		log.Lvl2("Running private aggregate with", nbrHosts, "hosts")
+		local := sda.NewLocalTest()
+		_, _, tree := local.GenTree(nbrHosts, true, true, true)
+
+		pi, err := local.CreateProtocol("PrivateAggregateTest", tree)
+		if err != nil {
+			t.Fatal("Couldn't create new protocol:", err)
+		}
+		protocol := pi.(*medco.PrivateAggregateProtocol)
+		if err := protocol.Start(); err != nil {
+			t.Fatal("Couldn't start protocol:", err)
+		}
+
+		n := int64(nbrHosts)
+		expected := map[libmedco.GroupingKey][]int64{
+			"a": {n, 2 * n, 3 * n},
+			"b": {0, n},
+		}
+		select {
+		case result := <-protocol.FeedbackChannel:
+			if len(result.Groups) != len(expected) || len(result.GroupedData) != len(expected) {
+				t.Fatal("Wrong number of groups:", len(result.GroupedData))
+			}
+			for key, values := range expected {
+				cv := result.GroupedData[key]
+				if got, err := libmedco.DecryptIntVector(aggregateKeys.Secret, &cv); err != nil || !reflect.DeepEqual(got, values) {
+					t.Error("Wrong aggregation for", key, ":", got, "instead of", values, err)
+				}
+			}
+		case <-time.After(time.Second * 10):
+			t.Fatal("Protocol didn't finish in time")
+		}
+		local.CloseAll()
+	}
+}
@@ -0,0 +1,134 @@
+package medco
+
+import (
+	"errors"
+
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/medco/libmedco"
+	"github.com/dedis/crypto/abstract"
+)
+
+// ProbabilisticSwitchingProtocolName is the registered name for the probabilistic switching protocol.
+const ProbabilisticSwitchingProtocolName = "ProbabilisticSwitching"
+
+func init() {
+	network.RegisterPacketType(ProbabilisticSwitchedMessage{})
+	sda.ProtocolRegisterName(ProbabilisticSwitchingProtocolName, NewProbabilisticSwitchingProtocol)
+}
+
+// ProbabilisticSwitchedMessage contains the data that is being switched and the key it is switched to.
+type ProbabilisticSwitchedMessage struct {
+	SurveyID        libmedco.SurveyID
+	Data            []CipherVectorEntry
+	TargetPublicKey abstract.Point
+}
+
+// ProbabilisticSwitchedStruct is the wrapper of ProbabilisticSwitchedMessage to be used in a channel.
+type ProbabilisticSwitchedStruct struct {
+	*sda.TreeNode
+	ProbabilisticSwitchedMessage
+}
+
+// ProbabilisticSwitchingProtocol switches deterministic ciphertexts back to ElGamal ciphertexts, encrypted under
+// the TargetPublicKey. Every node removes its deterministic part, made with its SurveyPHKey, and adds a random
+// ElGamal part.
+type ProbabilisticSwitchingProtocol struct {
+	*sda.TreeNodeInstance
+
+	// FeedbackChannel receives the ciphertexts under the target key at the root.
+	FeedbackChannel chan map[libmedco.TempID]libmedco.CipherVector
+
+	// PreviousNodeInPathChannel receives the data from the previous node.
+	PreviousNodeInPathChannel chan ProbabilisticSwitchedStruct
+
+	// TargetOfSwitch and TargetPublicKey are only needed at the root.
+	TargetOfSwitch  *map[libmedco.TempID]libmedco.DeterministCipherVector
+	TargetPublicKey *abstract.Point
+	// SurveyPHKey is the secret of this node used in the deterministic switching.
+	SurveyPHKey *abstract.Scalar
+	// SurveyID is sent along the data, OnSurvey is called with it on the
+	// other nodes.
+	SurveyID libmedco.SurveyID
+	OnSurvey SurveyCallback
+
+	nextNodeInCircuit *sda.TreeNode
+}
+
+// NewProbabilisticSwitchingProtocol constructs a probabilistic switching protocol instance.
+func NewProbabilisticSwitchingProtocol(n *sda.TreeNodeInstance) (sda.ProtocolInstance, error) {
+	psp := &ProbabilisticSwitchingProtocol{
+		TreeNodeInstance:  n,
+		FeedbackChannel:   make(chan map[libmedco.TempID]libmedco.CipherVector, 1),
+		nextNodeInCircuit: nextNodeInCircuit(n),
+	}
+	if err := psp.RegisterChannel(&psp.PreviousNodeInPathChannel); err != nil {
+		return nil, errors.New("couldn't register data reference channel: " + err.Error())
+	}
+	return psp, nil
+}
+
+// Start is called at the root and switches the target of the switch first.
+func (p *ProbabilisticSwitchingProtocol) Start() error {
+	if p.TargetOfSwitch == nil {
+		return errors.New("No map given as probabilistic switching target")
+	}
+	if p.TargetPublicKey == nil {
+		return errors.New("No public key to switch to given")
+	}
+	if p.SurveyPHKey == nil {
+		return errors.New("No PH key given")
+	}
+	log.Lvl2(p.ServerIdentity(), "started a Probabilistic Switching Protocol (", len(*p.TargetOfSwitch), "rows)")
+
+	// The deterministic ciphertexts are ElGamal ciphertexts with a null
+	// ephemeral key.
+	target := make(map[libmedco.TempID]libmedco.CipherVector, len(*p.TargetOfSwitch))
+	for id, dcv := range *p.TargetOfSwitch {
+		cv := make(libmedco.CipherVector, len(dcv))
+		for i, dc := range dcv {
+			cv[i] = libmedco.CipherText{K: suite.Point().Null(), C: dc.Point}
+		}
+		target[id] = cv
+	}
+	p.PreviousNodeInPathChannel <- ProbabilisticSwitchedStruct{p.TreeNode(),
+		ProbabilisticSwitchedMessage{p.SurveyID, cipherVectorEntries(target), *p.TargetPublicKey}}
+	return nil
+}
+
+// Dispatch switches the data received and passes it to the next node. Once the data comes back to the root, it
+// is sent to the FeedbackChannel.
+func (p *ProbabilisticSwitchingProtocol) Dispatch() error {
+	msg := <-p.PreviousNodeInPathChannel
+	if !p.IsRoot() && p.OnSurvey != nil {
+		if err := p.OnSurvey(msg.SurveyID); err != nil {
+			return err
+		}
+	}
+	if p.SurveyPHKey == nil {
+		return errors.New("No PH key given")
+	}
+	phContrib := suite.Point().Mul(suite.Point().Base(), *p.SurveyPHKey)
+	data := msg.Data
+	for i, e := range data {
+		data[i].Vector = *libmedco.NewCipherVector(len(e.Vector)).
+			ProbabilisticSwitching(&e.Vector, phContrib, msg.TargetPublicKey)
+	}
+
+	if len(p.List()) > 1 {
+		if err := p.SendTo(p.nextNodeInCircuit,
+			&ProbabilisticSwitchedMessage{msg.SurveyID, data, msg.TargetPublicKey}); err != nil {
+			return err
+		}
+		if !p.IsRoot() {
+			return nil
+		}
+		// Wait for the data to be switched by all other nodes.
+		data = (<-p.PreviousNodeInPathChannel).Data
+	}
+
+	log.Lvl2(p.ServerIdentity(), "completed probabilistic switching (", len(data), "rows)")
+	p.FeedbackChannel <- cipherVectorMap(data)
+	return nil
+}
@@ -0,0 +1,77 @@
+package medco_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/protocols/medco"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/medco/libmedco"
+	"github.com/dedis/crypto/config"
+)
+
+func init() {
+	sda.ProtocolRegisterName("ProbabilisticSwitchingTest", NewProbabilisticSwitchingTest)
+}
+
+// NewProbabilisticSwitchingTest is a test specific protocol instance constructor that injects the test PH key.
+func NewProbabilisticSwitchingTest(tni *sda.TreeNodeInstance) (sda.ProtocolInstance, error) {
+	pi, err := medco.NewProbabilisticSwitchingProtocol(tni)
+	if err != nil {
+		return nil, err
+	}
+	pi.(*medco.ProbabilisticSwitchingProtocol).SurveyPHKey = &testPHKey
+	return pi, nil
+}
+
+func TestProbabilisticSwitching(t *testing.T) {
+	for _, nbrHosts := range []int{1, 5} {
+		log.Lvl2("Running probabilistic switching with", nbrHosts, "hosts")
+		local := sda.NewLocalTest()
+		_, _, tree := local.GenTree(nbrHosts, true, true, true)
+
+		pi, err := local.CreateProtocol("ProbabilisticSwitchingTest", tree)
+		if err != nil {
+			t.Fatal("Couldn't create new protocol:", err)
+		}
+		protocol := pi.(*medco.ProbabilisticSwitchingProtocol)
+
+		expected := map[libmedco.TempID][]int64{
+			0: {1, 2, 3},
+			1: {0, 5},
+		}
+		target := make(map[libmedco.TempID]libmedco.DeterministCipherVector)
+		for id, values := range expected {
+			dcv := make(libmedco.DeterministCipherVector, len(values))
+			for i, v := range values {
+				dcv[i] = libmedco.DeterministCipherText{Point: deterministicPoint(v, nbrHosts)}
+			}
+			target[id] = dcv
+		}
+		keys := config.NewKeyPair(network.Suite)
+		protocol.TargetOfSwitch = &target
+		protocol.TargetPublicKey = &keys.Public
+		if err := protocol.Start(); err != nil {
+			t.Fatal("Couldn't start protocol:", err)
+		}
+
+		select {
+		case result := <-protocol.FeedbackChannel:
+			if len(result) != len(expected) {
+				t.Fatal("Wrong number of results:", len(result))
+			}
+			for id, values := range expected {
+				cv := result[id]
+				if got, err := libmedco.DecryptIntVector(keys.Secret, &cv); err != nil || !reflect.DeepEqual(got, values) {
+					t.Error("Wrong decryption for", id, ":", got, "instead of", values, err)
+				}
+			}
+		case <-time.After(time.Second * 10):
+			t.Fatal("Protocol didn't finish in time")
+		}
+		local.CloseAll()
+	}
+}
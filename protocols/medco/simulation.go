@@ -0,0 +1,86 @@
+package medco
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/BurntSushi/toml"
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/monitor"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/medco/libmedco"
+	"github.com/dedis/crypto/config"
+)
+
+func init() {
+	sda.SimulationRegister("KeySwitching", NewKeySwitchingSimulation)
+}
+
+// KeySwitchingSimulation holds the state of a simulation of the key switching protocol.
+type KeySwitchingSimulation struct {
+	sda.SimulationBFTree
+}
+
+// NewKeySwitchingSimulation constructs a key switching simulation.
+func NewKeySwitchingSimulation(conf string) (sda.Simulation, error) {
+	sim := &KeySwitchingSimulation{}
+	_, err := toml.Decode(conf, sim)
+	if err != nil {
+		return nil, err
+	}
+	return sim, nil
+}
+
+// Setup initializes the simulation.
+func (sim *KeySwitchingSimulation) Setup(dir string, hosts []string) (*sda.SimulationConfig, error) {
+	sc := &sda.SimulationConfig{}
+	sim.CreateRoster(sc, hosts, 2000)
+	err := sim.CreateTree(sc)
+	if err != nil {
+		return nil, err
+	}
+	log.Lvl1("Setup done")
+	return sc, nil
+}
+
+// Run starts the simulation. Every round switches a few vectors, encrypted under the collective key, to a fresh
+// key and checks the decrypted result.
+func (sim *KeySwitchingSimulation) Run(conf *sda.SimulationConfig) error {
+	for round := 0; round < sim.Rounds; round++ {
+		log.Lvl1("Starting round", round)
+		roundM := monitor.NewTimeMeasure("round")
+
+		pi, err := conf.Overlay.CreateProtocolSDA(KeySwitchingProtocolName, conf.Tree)
+		if err != nil {
+			return err
+		}
+		protocol := pi.(*KeySwitchingProtocol)
+
+		aggregateKey := conf.Tree.Roster.Aggregate
+		expected := []int64{1, 2, 3, 4, 5}
+		target := make(map[libmedco.TempID]libmedco.CipherVector)
+		for i := libmedco.TempID(0); i < 4; i++ {
+			target[i] = *libmedco.EncryptIntVector(aggregateKey, expected)
+		}
+		keys := config.NewKeyPair(suite)
+		protocol.TargetOfSwitch = &target
+		protocol.TargetPublicKey = &keys.Public
+
+		if err := protocol.Start(); err != nil {
+			return err
+		}
+		result := <-protocol.FeedbackChannel
+		roundM.Record()
+
+		for _, cv := range result {
+			got, err := libmedco.DecryptIntVector(keys.Secret, &cv)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(got, expected) {
+				return errors.New("Wrong result after key switching")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package medco
+
+import (
+	"sort"
+
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/medco/libmedco"
+)
+
+var suite = network.Suite
+
+// CipherVectorEntry is a CipherVector with its ID. The protocols send their
+// data as slices of entries instead of maps.
+type CipherVectorEntry struct {
+	ID     libmedco.TempID
+	Vector libmedco.CipherVector
+}
+
+// GroupEntry is the aggregated CipherVector of one group.
+type GroupEntry struct {
+	Key    libmedco.GroupingKey
+	Group  libmedco.GroupingAttributes
+	Vector libmedco.CipherVector
+}
+
+// SurveyCallback is called on the nodes other than the root with the survey a protocol instance runs for, before
+// the survey specific fields of the instance are used. It lets the service fill in these fields.
+type SurveyCallback func(libmedco.SurveyID) error
+
+// nextNodeInCircuit returns the node that follows us in the list of the
+// nodes of the tree, and the root for the last node.
+func nextNodeInCircuit(n *sda.TreeNodeInstance) *sda.TreeNode {
+	list := n.List()
+	for i, tn := range list {
+		if tn.Equal(n.TreeNode()) {
+			return list[(i+1)%len(list)]
+		}
+	}
+	return n.Root()
+}
+
+// sortedIDs returns the IDs of the map in increasing order, so the entries
+// are always sent in the same order.
+func sortedIDs(m map[libmedco.TempID]libmedco.CipherVector) []libmedco.TempID {
+	ids := make([]libmedco.TempID, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Sort(tempIDs(ids))
+	return ids
+}
+
+type tempIDs []libmedco.TempID
+
+func (t tempIDs) Len() int           { return len(t) }
+func (t tempIDs) Less(i, j int) bool { return t[i] < t[j] }
+func (t tempIDs) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+// cipherVectorEntries converts a map of CipherVectors to entries.
+func cipherVectorEntries(m map[libmedco.TempID]libmedco.CipherVector) []CipherVectorEntry {
+	entries := make([]CipherVectorEntry, 0, len(m))
+	for _, id := range sortedIDs(m) {
+		entries = append(entries, CipherVectorEntry{id, m[id]})
+	}
+	return entries
+}
+
+// cipherVectorMap converts entries back to a map of CipherVectors.
+func cipherVectorMap(entries []CipherVectorEntry) map[libmedco.TempID]libmedco.CipherVector {
+	m := make(map[libmedco.TempID]libmedco.CipherVector, len(entries))
+	for _, e := range entries {
+		m[e.ID] = e.Vector
+	}
+	return m
+}
+
+// addGroups adds the entries to the groups and their aggregated data.
+func addGroups(groups map[libmedco.GroupingKey]libmedco.GroupingAttributes,
+	data map[libmedco.GroupingKey]libmedco.CipherVector, entries []GroupEntry) {
+	for _, e := range entries {
+		if old, ok := data[e.Key]; ok {
+			data[e.Key] = *libmedco.NewCipherVector(len(old)).Add(old, e.Vector)
+		} else {
+			data[e.Key] = e.Vector
+		}
+		if _, ok := groups[e.Key]; !ok {
+			groups[e.Key] = e.Group
+		}
+	}
+}
+
+// groupEntries converts the groups and their aggregated data to entries.
+func groupEntries(groups map[libmedco.GroupingKey]libmedco.GroupingAttributes,
+	data map[libmedco.GroupingKey]libmedco.CipherVector) []GroupEntry {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, string(k))
+	}
+	sort.Strings(keys)
+	entries := make([]GroupEntry, 0, len(data))
+	for _, k := range keys {
+		key := libmedco.GroupingKey(k)
+		entries = append(entries, GroupEntry{key, groups[key], data[key]})
+	}
+	return entries
+}
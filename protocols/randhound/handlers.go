@@ -7,7 +7,9 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"sort"
 
+	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/sda"
 	"github.com/dedis/crypto/abstract"
 	"github.com/dedis/crypto/config"
@@ -26,9 +28,10 @@ type I1 struct {
 
 // R1 is the reply sent by the peers to the leader in phase 1.
 type R1 struct {
-	Src uint32 // Source of the message
-	HI1 []byte // Hash of I1 message
-	HRs []byte // Peer's trustee-randomness commit
+	Src uint32             // Source of the message
+	HI1 []byte             // Hash of I1 message
+	HRs []byte             // Peer's trustee-randomness commit
+	Sig *crypto.SchnorrSig // Peer's signature on Hash
 }
 
 // I2 is the message sent by the leader to the peers in phase 2.
@@ -39,10 +42,11 @@ type I2 struct {
 
 // R2 is the reply sent by the peers to the leader in phase 2.
 type R2 struct {
-	Src  uint32 // Source of the message
-	HI2  []byte // Hash of I2 message
-	Rs   []byte // Peers' trustee-selection randomness
-	Deal []byte // Peer's secret-sharing to trustees
+	Src  uint32             // Source of the message
+	HI2  []byte             // Hash of I2 message
+	Rs   []byte             // Peers' trustee-selection randomness
+	Deal []byte             // Peer's secret-sharing to trustees
+	Sig  *crypto.SchnorrSig // Peer's signature on Hash
 }
 
 // I3 is the message sent by the leader to the peers in phase 3.
@@ -76,6 +80,7 @@ type R4 struct {
 	Src    uint32              // Source of the message
 	HI4    []byte              // Hash of I4 message
 	Shares map[uint32]*R4Share // Revealed secret-shares
+	Sig    *crypto.SchnorrSig  // Peer's signature on Hash
 }
 
 // R4Share encapsulates a peer's share together with some metadata.
@@ -85,6 +90,52 @@ type R4Share struct {
 	Share     abstract.Scalar // Decrypted share dealt to this server
 }
 
+// Hash returns the hash of the message signed by the peer.
+func (r1 *R1) Hash(suite abstract.Suite) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r1.Src); err != nil {
+		return nil, err
+	}
+	return abstract.Sum(suite, buf.Bytes(), r1.HI1, r1.HRs), nil
+}
+
+// Hash returns the hash of the message signed by the peer.
+func (r2 *R2) Hash(suite abstract.Suite) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r2.Src); err != nil {
+		return nil, err
+	}
+	return abstract.Sum(suite, buf.Bytes(), r2.HI2, r2.Rs, r2.Deal), nil
+}
+
+// Hash returns the hash of the message signed by the peer. The shares are
+// hashed in the order of their dealers.
+func (r4 *R4) Hash(suite abstract.Suite) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r4.Src); err != nil {
+		return nil, err
+	}
+	buf.Write(r4.HI4)
+	var dealers []int
+	for d := range r4.Shares {
+		dealers = append(dealers, int(d))
+	}
+	sort.Ints(dealers)
+	for _, d := range dealers {
+		share := r4.Shares[uint32(d)]
+		if err := binary.Write(buf, binary.LittleEndian,
+			[]uint32{share.DealerIdx, share.ShareIdx}); err != nil {
+			return nil, err
+		}
+		sb, err := share.Share.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(sb)
+	}
+	return abstract.Sum(suite, buf.Bytes()), nil
+}
+
 // WI1 is a SDA-wrapper around I1
 type WI1 struct {
 	*sda.TreeNode
@@ -166,6 +217,11 @@ func (rh *RandHound) handleI1(i1 WI1) error {
 		),
 		HRs: rh.hash(rh.Peer.rs),
 	}
+	sig, err := rh.sign(rh.Peer.r1)
+	if err != nil {
+		return err
+	}
+	rh.Peer.r1.Sig = sig
 	return rh.SendToParent(rh.Peer.r1)
 }
 
@@ -182,6 +238,9 @@ func (rh *RandHound) handleR1(r1 WR1) error {
 		if !bytes.Equal(r1.R1.HI1, rh.hash(rh.SID, rh.GID, rh.Leader.i1.HRc)) {
 			return fmt.Errorf("R1: peer %d replied to wrong I1 message", r1.Src)
 		}
+		if err := verifyReply(rh.Suite(), rh.Roster().Publics(), r1.Src, &r1.R1, r1.Sig); err != nil {
+			return fmt.Errorf("R1: %s", err)
+		}
 
 		// Collect replies of the peers
 		rh.Leader.r1[r1.Src] = &r1.R1
@@ -240,6 +299,9 @@ func (rh *RandHound) handleI2(i2 WI2) error {
 		Rs:   rh.Peer.rs,
 		Deal: db,
 	}
+	if rh.Peer.r2.Sig, err = rh.sign(rh.Peer.r2); err != nil {
+		return err
+	}
 	return rh.SendToParent(rh.Peer.r2)
 }
 
@@ -256,6 +318,9 @@ func (rh *RandHound) handleR2(r2 WR2) error {
 		if !bytes.Equal(r2.R2.HI2, rh.hash(rh.SID, rh.Leader.i2.Rc)) {
 			return fmt.Errorf("R2: peer %d replied to wrong I2 message", r2.Src)
 		}
+		if err := verifyReply(rh.Suite(), rh.Roster().Publics(), r2.Src, &r2.R2, r2.Sig); err != nil {
+			return fmt.Errorf("R2: %s", err)
+		}
 
 		// Collect replies of the peers
 		rh.Leader.r2[r2.Src] = &r2.R2
@@ -430,6 +495,11 @@ func (rh *RandHound) handleI4(i4 WI4) error {
 			buf.Bytes()),
 		Shares: rh.Peer.shares,
 	}
+	sig, err := rh.sign(rh.Peer.r4)
+	if err != nil {
+		return err
+	}
+	rh.Peer.r4.Sig = sig
 	return rh.SendToParent(rh.Peer.r4)
 }
 
@@ -453,6 +523,9 @@ func (rh *RandHound) handleR4(r4 WR4) error {
 		if !bytes.Equal(r4.R4.HI4, rh.hash(rh.SID, buf.Bytes())) {
 			return fmt.Errorf("R4: peer %d replied to wrong I4 message", r4.Src)
 		}
+		if err := verifyReply(rh.Suite(), rh.Roster().Publics(), r4.Src, &r4.R4, r4.Sig); err != nil {
+			return fmt.Errorf("R4: %s", err)
+		}
 
 		// Collect replies of the peers
 		rh.Leader.r4[r4.Src] = &r4.R4
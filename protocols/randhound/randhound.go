@@ -163,75 +163,91 @@ func (rh *RandHound) Start() error {
 
 func (rh *RandHound) newSession(public abstract.Point, purpose string, time time.Time) (*Session, []byte, error) {
 
-	buf := new(bytes.Buffer)
-
 	pub, err := public.MarshalBinary()
 	if err != nil {
 		return nil, nil, err
 	}
-	if err = binary.Write(buf, binary.LittleEndian, pub); err != nil {
-		return nil, nil, err
-	}
-	tm, err := time.MarshalBinary()
+	session := &Session{
+		Fingerprint: pub,
+		Purpose:     purpose,
+		Time:        time}
+	sid, err := sessionID(rh.Suite(), session)
 	if err != nil {
 		return nil, nil, err
 	}
-	if err = binary.Write(buf, binary.LittleEndian, tm); err != nil {
-		return nil, nil, err
+	return session, sid, nil
+}
+
+// sessionID returns the hash of the session parameters.
+func sessionID(suite abstract.Suite, session *Session) ([]byte, error) {
+
+	buf := new(bytes.Buffer)
+
+	if err := binary.Write(buf, binary.LittleEndian, session.Fingerprint); err != nil {
+		return nil, err
 	}
-	if err = binary.Write(buf, binary.LittleEndian, []byte(purpose)); err != nil {
-		return nil, nil, err
+	// Only the nanoseconds are kept when the session is sent to the peers
+	if err := binary.Write(buf, binary.LittleEndian, session.Time.UnixNano()); err != nil {
+		return nil, err
 	}
-
-	return &Session{
-		Fingerprint: pub,
-		Purpose:     purpose,
-		Time:        time}, rh.hash(buf.Bytes()), nil
+	if err := binary.Write(buf, binary.LittleEndian, []byte(session.Purpose)); err != nil {
+		return nil, err
+	}
+	return abstract.Sum(suite, buf.Bytes()), nil
 }
 
 func (rh *RandHound) newGroup(nodes uint32, trustees uint32) (*Group, []byte, error) {
+	group := newGroup(nodes, trustees)
+	gid, err := groupID(rh.Suite(), rh.Roster().Publics(), group)
+	if err != nil {
+		return nil, nil, err
+	}
+	return group, gid, nil
+}
 
-	buf := new(bytes.Buffer)
+// newGroup returns the group parameters for the given number of nodes and
+// trustees.
+func newGroup(nodes uint32, trustees uint32) *Group {
 
 	n := nodes    // Number of nodes (peers + leader)
 	k := trustees // Number of trustees (= shares generaetd per peer)
 
 	// Setup group parameters: note that T <= R <= K must hold;
 	// T = R for simplicity, might change later
-	gp := [6]uint32{
-		n,           // N: total number of nodes (peers + leader)
-		n / 3,       // F: maximum number of Byzantine nodes tolerated
-		n - (n / 3), // L: minimum number of non-Byzantine nodes required
-		k,           // K: total number of trustees (= shares generated per peer)
-		(k + 1) / 2, // R: minimum number of signatures needed to certify a deal
-		(k + 1) / 2, // T: minimum number of shares needed to reconstruct a secret
+	return &Group{
+		N: n,           // N: total number of nodes (peers + leader)
+		F: n / 3,       // F: maximum number of Byzantine nodes tolerated
+		L: n - (n / 3), // L: minimum number of non-Byzantine nodes required
+		K: k,           // K: total number of trustees (= shares generated per peer)
+		R: (k + 1) / 2, // R: minimum number of signatures needed to certify a deal
+		T: (k + 1) / 2, // T: minimum number of shares needed to reconstruct a secret
 	}
+}
+
+// groupID returns the hash of the public keys of the nodes and of the group
+// parameters.
+func groupID(suite abstract.Suite, publics []abstract.Point, group *Group) ([]byte, error) {
+
+	buf := new(bytes.Buffer)
 
 	// Include public keys of all nodes into group ID
-	for _, x := range rh.List() {
-		pub, err := x.ServerIdentity.Public.MarshalBinary()
+	for _, x := range publics {
+		pub, err := x.MarshalBinary()
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 		if err = binary.Write(buf, binary.LittleEndian, pub); err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 	}
 
 	// Include group parameters into group ID
-	for _, g := range gp {
+	for _, g := range []uint32{group.N, group.F, group.L, group.K, group.R, group.T} {
 		if err := binary.Write(buf, binary.LittleEndian, g); err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 	}
-
-	return &Group{
-		N: gp[0],
-		F: gp[1],
-		L: gp[2],
-		K: gp[3],
-		R: gp[4],
-		T: gp[5]}, rh.hash(buf.Bytes()), nil
+	return abstract.Sum(suite, buf.Bytes()), nil
 }
 
 func (rh *RandHound) newLeader() (*Leader, error) {
@@ -5,8 +5,10 @@ import (
 	"time"
 
 	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
 	"github.com/dedis/cothority/protocols/randhound"
 	"github.com/dedis/cothority/sda"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestMain(m *testing.M) {
@@ -58,3 +60,70 @@ func TestRandHound(t *testing.T) {
 		t.Fatal("RandHound – time out")
 	}
 }
+
+func TestTranscript(t *testing.T) {
+
+	var nodes uint32 = 7
+	var trustees uint32 = 4
+
+	local := sda.NewLocalTest()
+	_, roster, tree := local.GenTree(int(nodes), false, true, true)
+	defer local.CloseAll()
+
+	leader, err := local.CreateProtocol("RandHound", tree)
+	log.ErrFatal(err)
+	rh := leader.(*randhound.RandHound)
+	log.ErrFatal(rh.Setup(nodes, trustees, "RandHound transcript"))
+	_, err = rh.Transcript()
+	assert.NotNil(t, err, "Transcript of unfinished run")
+	log.ErrFatal(leader.Start())
+
+	select {
+	case <-rh.Leader.Done:
+	case <-time.After(time.Second * 60):
+		t.Fatal("RandHound – time out")
+	}
+	transcript, err := rh.Transcript()
+	log.ErrFatal(err)
+	rnd, err := rh.Random()
+	log.ErrFatal(err)
+	assert.Equal(t, rnd, transcript.Random)
+	log.ErrFatal(randhound.VerifyTranscript(network.Suite, roster, transcript))
+
+	// A different output is refused
+	random := transcript.Random
+	transcript.Random = append([]byte{}, random...)
+	transcript.Random[0] ^= 1
+	assert.NotNil(t, randhound.VerifyTranscript(network.Suite, roster, transcript))
+	transcript.Random = random
+
+	// Changing the leader's randomness changes the choice of the trustees
+	rc := transcript.Rc
+	transcript.Rc = append([]byte{}, rc...)
+	transcript.Rc[0] ^= 1
+	assert.NotNil(t, randhound.VerifyTranscript(network.Suite, roster, transcript))
+	transcript.Rc = rc
+
+	// Replies can't be changed without the key of the peer
+	for _, r2 := range transcript.R2s {
+		r2.Rs = append([]byte{}, r2.Rs...)
+		r2.Rs[0] ^= 1
+		break
+	}
+	assert.NotNil(t, randhound.VerifyTranscript(network.Suite, roster, transcript))
+
+	// Group parameters out of bounds are refused
+	group := *transcript.Group
+	transcript.Group.K = nodes
+	transcript.Group.T = (nodes + 1) / 2
+	transcript.Group.R = transcript.Group.T
+	assert.NotNil(t, randhound.VerifyTranscript(network.Suite, roster, transcript))
+	transcript.Group.K = 0
+	transcript.Group.T = 0
+	assert.NotNil(t, randhound.VerifyTranscript(network.Suite, roster, transcript))
+	*transcript.Group = group
+
+	// A transcript only verifies with the roster of the run
+	_, other, _ := local.GenTree(int(nodes), false, false, false)
+	assert.NotNil(t, randhound.VerifyTranscript(network.Suite, other, transcript))
+}
@@ -0,0 +1,214 @@
+package randhound
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/crypto/abstract"
+	"github.com/dedis/crypto/poly"
+)
+
+// Transcript holds the messages of a finished RandHound run that are needed
+// to recompute its output. Together with the roster of the run it allows
+// anybody to verify the public randomness without contacting the nodes. The
+// R3 replies are not included as they don't influence the output: a dealer
+// with invalid responses is detected with the revealed shares.
+type Transcript struct {
+	SID     []byte               // Session identifier
+	Session *Session             // Session parameters
+	GID     []byte               // Group identifier
+	Group   *Group               // Group parameters
+	Rc      []byte               // Leader's trustee-selection randomness
+	R1s     map[uint32]*R1       // Signed R1 replies of the peers
+	R2s     map[uint32]*R2       // Signed R2 replies of the peers
+	Invalid map[uint32]*[]uint32 // Invalid shares sent with I4
+	R4s     map[uint32]*R4       // Signed R4 replies of the peers
+	Random  []byte               // Public randomness of the run
+}
+
+// Transcript returns the transcript of a finished run. It can only be called
+// from the leader node.
+func (rh *RandHound) Transcript() (*Transcript, error) {
+
+	if !rh.IsRoot() {
+		return nil, fmt.Errorf("Transcript function can only be called from the leader node")
+	}
+	if rh.Group == nil || uint32(len(rh.Leader.r4)) != rh.Group.N-1 {
+		return nil, fmt.Errorf("RandHound run is not finished")
+	}
+	rnd, err := rh.Random()
+	if err != nil {
+		return nil, err
+	}
+	return &Transcript{
+		SID:     rh.SID,
+		Session: rh.Session,
+		GID:     rh.GID,
+		Group:   rh.Group,
+		Rc:      rh.Leader.rc,
+		R1s:     rh.Leader.r1,
+		R2s:     rh.Leader.r2,
+		Invalid: rh.Leader.invalid,
+		R4s:     rh.Leader.r4,
+		Random:  rnd,
+	}, nil
+}
+
+// VerifyTranscript returns nil if the transcript is the one of a valid run of
+// the nodes of the roster: the replies of all peers are signed and bound to
+// the session, the revealed shares were dealt to the peers that revealed
+// them, and the output is the sum of the secrets reconstructed from the
+// shares.
+func VerifyTranscript(suite abstract.Suite, roster *sda.Roster, t *Transcript) error {
+
+	if t.Session == nil || t.Group == nil {
+		return fmt.Errorf("Transcript without session or group parameters")
+	}
+	publics := roster.Publics()
+	if t.Group.N != uint32(len(publics)) {
+		return fmt.Errorf("Transcript for %d nodes, roster has %d", t.Group.N, len(publics))
+	}
+
+	// Verify group and session parameters, the bounds first as the choice
+	// of the trustees doesn't terminate otherwise
+	if t.Group.K == 0 || t.Group.K > t.Group.N-1 || t.Group.N < 2 {
+		return fmt.Errorf("Wrong number of trustees: %d for %d nodes", t.Group.K, t.Group.N)
+	}
+	if t.Group.T < 1 || t.Group.T > t.Group.K {
+		return fmt.Errorf("Wrong threshold: %d for %d trustees", t.Group.T, t.Group.K)
+	}
+	if *newGroup(t.Group.N, t.Group.K) != *t.Group {
+		return fmt.Errorf("Wrong group parameters")
+	}
+	gid, err := groupID(suite, publics, t.Group)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(gid, t.GID) {
+		return fmt.Errorf("Wrong group ID")
+	}
+	sid, err := sessionID(suite, t.Session)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sid, t.SID) {
+		return fmt.Errorf("Wrong session ID")
+	}
+	leader, err := leaderIndex(publics, t.Session.Fingerprint)
+	if err != nil {
+		return err
+	}
+
+	// Verify the deals of the peers
+	hi1 := abstract.Sum(suite, t.SID, t.GID, abstract.Sum(suite, t.Rc))
+	hi2 := abstract.Sum(suite, t.SID, t.Rc)
+	states := make(map[uint32]*poly.State)
+	for i := uint32(0); i < t.Group.N; i++ {
+		if i == leader {
+			continue
+		}
+		r1, r2 := t.R1s[i], t.R2s[i]
+		if r1 == nil || r2 == nil || r1.Src != i || r2.Src != i {
+			return fmt.Errorf("Missing replies of peer %d", i)
+		}
+		if !bytes.Equal(r1.HI1, hi1) {
+			return fmt.Errorf("R1: peer %d replied to wrong I1 message", i)
+		}
+		if err := verifyReply(suite, publics, i, r1, r1.Sig); err != nil {
+			return fmt.Errorf("R1: %s", err)
+		}
+		if !bytes.Equal(r2.HI2, hi2) {
+			return fmt.Errorf("R2: peer %d replied to wrong I2 message", i)
+		}
+		if err := verifyReply(suite, publics, i, r2, r2.Sig); err != nil {
+			return fmt.Errorf("R2: %s", err)
+		}
+		if !bytes.Equal(r1.HRs, abstract.Sum(suite, r2.Rs)) {
+			return fmt.Errorf("R2: peer %d revealed wrong trustee-selection randomness", i)
+		}
+
+		deal := &poly.Deal{}
+		deal.UnmarshalInit(int(t.Group.T), int(t.Group.R), int(t.Group.K), suite)
+		if err := deal.UnmarshalBinary(r2.Deal); err != nil {
+			return err
+		}
+		state := poly.State{}
+		states[i] = state.Init(*deal)
+	}
+
+	// Verify and collect the revealed shares
+	shares := make(map[uint32]uint32)
+	for i := uint32(0); i < t.Group.N; i++ {
+		if i == leader {
+			continue
+		}
+		r4, invalid := t.R4s[i], t.Invalid[i]
+		if r4 == nil || invalid == nil || r4.Src != i {
+			return fmt.Errorf("Missing replies of peer %d", i)
+		}
+		buf := new(bytes.Buffer)
+		for _, dealerIdx := range *invalid {
+			if err := binary.Write(buf, binary.LittleEndian, dealerIdx); err != nil {
+				return err
+			}
+		}
+		if !bytes.Equal(r4.HI4, abstract.Sum(suite, t.SID, buf.Bytes())) {
+			return fmt.Errorf("R4: peer %d replied to wrong I4 message", i)
+		}
+		if err := verifyReply(suite, publics, i, r4, r4.Sig); err != nil {
+			return fmt.Errorf("R4: %s", err)
+		}
+
+		for dIdx, r4share := range r4.Shares {
+			state, ok := states[dIdx]
+			if !ok || r4share.DealerIdx != dIdx {
+				return fmt.Errorf("R4: server %d revealed share of unknown dealer %d", i, dIdx)
+			}
+			for _, inv := range *invalid {
+				if inv == dIdx {
+					return fmt.Errorf("R4: server %d revealed invalid share of dealer %d", i, dIdx)
+				}
+			}
+			shareIdx, _ := chooseTrustees(suite, publics, leader, t.Group.K, t.Rc, t.R2s[dIdx].Rs)
+			if sIdx, ok := shareIdx[i]; !ok || sIdx != r4share.ShareIdx {
+				return fmt.Errorf("R4: server %d claimed share it wasn't dealt", i)
+			}
+			if err := state.Deal.VerifyRevealedShare(int(r4share.ShareIdx), r4share.Share); err != nil {
+				return err
+			}
+			state.PriShares.SetShare(int(r4share.ShareIdx), r4share.Share)
+			shares[dIdx]++
+		}
+	}
+	for dIdx := range states {
+		if shares[dIdx] < t.Group.T {
+			return fmt.Errorf("Not enough shares to recover the secret of peer %d", dIdx)
+		}
+	}
+
+	// Verify the output
+	rnd, err := randomOutput(suite, states)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(rnd, t.Random) {
+		return fmt.Errorf("Wrong public randomness")
+	}
+	return nil
+}
+
+// leaderIndex returns the index of the public key with the given fingerprint.
+func leaderIndex(publics []abstract.Point, fingerprint []byte) (uint32, error) {
+	for i, p := range publics {
+		pub, err := p.MarshalBinary()
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(pub, fingerprint) {
+			return uint32(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Leader is not in the roster")
+}
@@ -3,8 +3,10 @@ package randhound
 import (
 	"fmt"
 
+	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/network"
 	"github.com/dedis/crypto/abstract"
+	"github.com/dedis/crypto/poly"
 	"github.com/dedis/crypto/random"
 )
 
@@ -46,9 +48,14 @@ func (rh *RandHound) Random() ([]byte, error) {
 	if !rh.IsRoot() {
 		return nil, fmt.Errorf("Random function can only be called from the leader node")
 	}
+	return randomOutput(rh.Suite(), rh.Leader.states)
+}
+
+// randomOutput returns the sum of the secrets shared by the peers.
+func randomOutput(suite abstract.Suite, states map[uint32]*poly.State) ([]byte, error) {
 
-	output := rh.Suite().Scalar().Zero()
-	for _, state := range rh.Leader.states {
+	output := suite.Scalar().Zero()
+	for _, state := range states {
 		output.Add(output, state.PriShares.Secret())
 	}
 
@@ -62,24 +69,32 @@ func (rh *RandHound) Random() ([]byte, error) {
 }
 
 func (rh *RandHound) chooseTrustees(Rc, Rs []byte) (map[uint32]uint32, []abstract.Point) {
+	return chooseTrustees(rh.Suite(), rh.Roster().Publics(),
+		uint32(rh.Root().ServerIdentityIdx), rh.Group.K, Rc, Rs)
+}
+
+// chooseTrustees selects the k trustees of a peer out of the nodes with the
+// given public keys, the leader excluded. It returns the share index of the
+// trustees, by index of the nodes, and the public keys of the trustees.
+func chooseTrustees(suite abstract.Suite, publics []abstract.Point, leader uint32,
+	k uint32, Rc, Rs []byte) (map[uint32]uint32, []abstract.Point) {
 
 	// Seed PRNG for selection of trustees
 	var seed []byte
 	seed = append(seed, Rc...)
 	seed = append(seed, Rs...)
-	prng := rh.Suite().Cipher(seed)
+	prng := suite.Cipher(seed)
 
 	// Choose trustees uniquely
 	shareIdx := make(map[uint32]uint32)
-	trustees := make([]abstract.Point, rh.Group.K)
-	tns := rh.List()
+	trustees := make([]abstract.Point, k)
 	j := uint32(0)
-	for uint32(len(shareIdx)) < rh.Group.K {
-		i := uint32(random.Uint64(prng) % uint64(len(tns)))
-		// Add trustee only if not done so before; choosing yourself as an trustee is fine; ignore leader at index 0
-		if _, ok := shareIdx[i]; !ok && !tns[i].IsRoot() {
+	for uint32(len(shareIdx)) < k {
+		i := uint32(random.Uint64(prng) % uint64(len(publics)))
+		// Add trustee only if not done so before; choosing yourself as an trustee is fine; ignore leader
+		if _, ok := shareIdx[i]; !ok && i != leader {
 			shareIdx[i] = j // j is the share index
-			trustees[j] = tns[i].ServerIdentity.Public
+			trustees[j] = publics[i]
 			j++
 		}
 	}
@@ -94,5 +109,40 @@ func (rh *RandHound) index() uint32 {
 	return uint32(rh.Index())
 }
 
-func (rh *RandHound) generateTranscript() {} // TODO
-func (rh *RandHound) verifyTranscript()   {} // TODO
+// reply is a message of a peer that is signed.
+type reply interface {
+	Hash(suite abstract.Suite) ([]byte, error)
+}
+
+// sign returns the signature of the node on the hash of the reply.
+func (rh *RandHound) sign(r reply) (*crypto.SchnorrSig, error) {
+	h, err := r.Hash(rh.Suite())
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.SignSchnorr(rh.Suite(), rh.Private(), h)
+	if err != nil {
+		return nil, err
+	}
+	return &sig, nil
+}
+
+// verifyReply returns nil if sig is the signature of the peer with index src
+// on the reply.
+func verifyReply(suite abstract.Suite, publics []abstract.Point, src uint32,
+	r reply, sig *crypto.SchnorrSig) error {
+	if src >= uint32(len(publics)) {
+		return fmt.Errorf("Unknown peer %d", src)
+	}
+	if sig == nil {
+		return fmt.Errorf("Missing signature of peer %d", src)
+	}
+	h, err := r.Hash(suite)
+	if err != nil {
+		return err
+	}
+	if err := crypto.VerifySchnorr(suite, publics[src], h, *sig); err != nil {
+		return fmt.Errorf("Wrong signature of peer %d: %s", src, err)
+	}
+	return nil
+}
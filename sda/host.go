@@ -12,6 +12,7 @@ import (
 	"strconv"
 
 	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/monitor"
 	"github.com/dedis/cothority/network"
 	"github.com/dedis/crypto/abstract"
 	"github.com/dedis/crypto/config"
@@ -78,6 +79,18 @@ func NewHost(si *network.ServerIdentity, pkey abstract.Scalar) *Host {
 	return h
 }
 
+// SetLimits sets the sizes of the packets accepted by this host. It should
+// be called before the host starts listening.
+func (h *Host) SetLimits(l network.Limits) error {
+	return h.host.SetLimits(l)
+}
+
+// InjectFaults makes all connections of this host subject to the faults of
+// the injector. It has to be called before the host starts listening.
+func (h *Host) InjectFaults(f *network.FaultInjector) {
+	h.host = network.NewFaultyHost(h.host, f)
+}
+
 // listen starts listening for messages coming from any host that tries to
 // contact this host. If 'wait' is true, it will try to connect to itself before
 // returning.
@@ -298,6 +311,12 @@ func (h *Host) handleConn(c network.SecureConn) {
 				h.closeConnection(c)
 				return
 			}
+			if _, ok := err.(*network.PacketSizeError); ok {
+				log.Error(h.ServerIdentity.First(), "closing connection to",
+					address, ":", err)
+				h.closeConnection(c)
+				return
+			}
 			log.Error(h.ServerIdentity.Addresses, "Error with connection", address, "=>", err)
 		} else {
 			h.closingMut.Lock()
@@ -414,6 +433,36 @@ func (h *Host) GetStatus() Status {
 	return m
 }
 
+// Collect implements the monitor.Collector interface. It returns the bytes
+// sent and received, the number of open connections and the metrics of the
+// services.
+func (h *Host) Collect() []*monitor.Metric {
+	h.networkLock.RLock()
+	conns := len(h.connections)
+	h.networkLock.RUnlock()
+	ret := []*monitor.Metric{
+		{
+			Name:  "cothority_host_tx_bytes_total",
+			Type:  monitor.MetricCounter,
+			Help:  "Bytes sent by the host",
+			Value: float64(h.Tx()),
+		},
+		{
+			Name:  "cothority_host_rx_bytes_total",
+			Type:  monitor.MetricCounter,
+			Help:  "Bytes received by the host",
+			Value: float64(h.Rx()),
+		},
+		{
+			Name:  "cothority_host_connections",
+			Type:  monitor.MetricGauge,
+			Help:  "Number of open connections",
+			Value: float64(conns),
+		},
+	}
+	return append(ret, h.serviceManager.Collect()...)
+}
+
 // GetService returns the service with the given name.
 func (h *Host) GetService(name string) Service {
 	return h.serviceManager.Service(name)
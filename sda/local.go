@@ -26,6 +26,9 @@ type LocalTest struct {
 	Trees map[TreeID]*Tree
 	// All single nodes
 	Nodes []*TreeNodeInstance
+	// If non-nil, all hosts generated are subject to the faults of this
+	// injector
+	Faults *network.FaultInjector
 }
 
 // NewLocalTest creates a new Local handler that can be used to test protocols
@@ -73,7 +76,7 @@ func (l *LocalTest) CreateProtocol(name string, t *Tree) (ProtocolInstance, erro
 // hosts will be connected between each other. If 'processMsg' is true,
 // the ProcessMsg-method will be called.
 func (l *LocalTest) GenLocalHosts(n int, connect, processMsg bool) []*Host {
-	hosts := GenLocalHosts(n, connect, processMsg)
+	hosts := genLocalHosts(n, connect, processMsg, l.Faults)
 	for _, host := range hosts {
 		l.Hosts[host.ServerIdentity.ID] = host
 		l.Overlays[host.ServerIdentity.ID] = host.overlay
@@ -263,9 +266,18 @@ func NewLocalHost() *Host {
 // the other nodes if connect is true. It will take the port-number from
 // the global variable LocalHostPort.
 func GenLocalHosts(n int, connect bool, processMessages bool) []*Host {
+	return genLocalHosts(n, connect, processMessages, nil)
+}
+
+// genLocalHosts is GenLocalHosts with the hosts subject to the faults of
+// 'faults', if it is non-nil.
+func genLocalHosts(n int, connect, processMessages bool, faults *network.FaultInjector) []*Host {
 	hosts := make([]*Host, n)
 	for i := 0; i < n; i++ {
 		host := NewLocalHost()
+		if faults != nil {
+			host.InjectFaults(faults)
+		}
 		hosts[i] = host
 	}
 	root := hosts[0]
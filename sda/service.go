@@ -10,9 +10,11 @@ import (
 	"strings"
 
 	"reflect"
+	"sort"
 	"sync"
 
 	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/monitor"
 	"github.com/dedis/cothority/network"
 	"github.com/dedis/crypto/config"
 	"github.com/satori/go.uuid"
@@ -189,6 +191,16 @@ type serviceManager struct {
 	host *Host
 	// the dispatcher can take registration of Processors
 	Dispatcher
+	// statistics about the client requests, per service name
+	requests      map[string]*requestStats
+	requestsMutex sync.Mutex
+}
+
+// requestStats holds the number of requests a service got and the
+// distribution of the time it took to process them.
+type requestStats struct {
+	latency *monitor.Sketch
+	sum     float64
 }
 
 const configFolder = "config"
@@ -207,7 +219,13 @@ func newServiceManager(h *Host, o *Overlay) *serviceManager {
 	}
 	services := make(map[ServiceID]Service)
 	configs := make(map[ServiceID]string)
-	s := &serviceManager{services, configs, h, NewRoutineDispatcher()}
+	s := &serviceManager{
+		services:   services,
+		paths:      configs,
+		host:       h,
+		Dispatcher: NewRoutineDispatcher(),
+		requests:   make(map[string]*requestStats),
+	}
 	ids := ServiceFactory.registeredServicesID()
 	for _, id := range ids {
 		name := ServiceFactory.Name(id)
@@ -243,20 +261,72 @@ func (s *serviceManager) Process(data *network.Packet) {
 	case ClientRequestID:
 		r := data.Msg.(ClientRequest)
 		// check if the target service is indeed existing
-		s, ok := s.serviceByID(r.Service)
+		serv, ok := s.serviceByID(r.Service)
 		if !ok {
 			log.Error("Received a request for an unknown service", r.Service)
 			// XXX TODO should reply with some generic response =>
 			// 404 Service Unknown
 			return
 		}
-		go s.ProcessClientRequest(id, &r)
+		go func() {
+			start := time.Now()
+			serv.ProcessClientRequest(id, &r)
+			s.addRequest(ServiceFactory.Name(r.Service), time.Since(start))
+		}()
 	default:
 		// will launch a go routine for that message
 		s.Dispatch(data)
 	}
 }
 
+// addRequest stores the time it took the service to process a request.
+func (s *serviceManager) addRequest(name string, d time.Duration) {
+	s.requestsMutex.Lock()
+	defer s.requestsMutex.Unlock()
+	rs, ok := s.requests[name]
+	if !ok {
+		rs = &requestStats{latency: monitor.NewSketch(monitor.DefaultSketchAccuracy)}
+		s.requests[name] = rs
+	}
+	rs.latency.Add(d.Seconds())
+	rs.sum += d.Seconds()
+}
+
+// Collect implements the monitor.Collector interface and returns the
+// request counts and latencies of every service, followed by the metrics of
+// all services that implement monitor.Collector themselves.
+func (s *serviceManager) Collect() []*monitor.Metric {
+	var ret []*monitor.Metric
+	s.requestsMutex.Lock()
+	names := make([]string, 0, len(s.requests))
+	for n := range s.requests {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		rs := s.requests[n]
+		labels := map[string]string{"service": n}
+		ret = append(ret, &monitor.Metric{
+			Name:   "cothority_service_requests_total",
+			Type:   monitor.MetricCounter,
+			Help:   "Number of client requests processed by the service",
+			Labels: labels,
+			Value:  float64(rs.latency.Count()),
+		})
+		ret = append(ret, monitor.NewSummaryMetric(
+			"cothority_service_request_seconds",
+			"Time taken by the service to process a client request",
+			labels, rs.latency, rs.sum))
+	}
+	s.requestsMutex.Unlock()
+	for _, n := range s.AvailableServices() {
+		if c, ok := s.Service(n).(monitor.Collector); ok {
+			ret = append(ret, c.Collect()...)
+		}
+	}
+	return ret
+}
+
 // RegisterProcessor the processor to the service manager and tells the host to dispatch
 // this message to the service manager. The service manager will then dispatch
 // the message in a go routine. XXX This is needed because we need to have
@@ -446,6 +516,55 @@ func (c *Client) Send(dst *network.ServerIdentity, msg network.Body) (*network.P
 	}
 }
 
+// Stream opens a connection to 'dst' and sends the message 'msg', like Send,
+// but keeps the connection open afterwards. Every packet the service sends
+// on that connection is passed to 'fn', until 'fn' returns false or the
+// connection fails. A StatusRet holding an error ends the stream with that
+// error.
+func (c *Client) Stream(dst *network.ServerIdentity, msg network.Body, fn func(*network.Packet) bool) error {
+	kp := config.NewKeyPair(network.Suite)
+	host := network.NewSecureTCPHost(kp.Secret,
+		network.NewServerIdentity(kp.Public, ""))
+	defer host.Close()
+
+	log.Lvl4("Opening stream to", dst)
+	con, err := host.Open(dst)
+	if err != nil {
+		return err
+	}
+	m, err := network.NewNetworkPacket(msg)
+	if err != nil {
+		return err
+	}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	err = con.Send(context.TODO(), &ClientRequest{
+		Service: c.ServiceID,
+		Data:    b,
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		packet, err := con.Receive(context.TODO())
+		if err != nil {
+			return err
+		}
+		if _, ok := packet.Msg.(StatusRet); ok {
+			if err := ErrMsg(&packet, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if !fn(&packet) {
+			log.Lvl4("Closing stream to", dst)
+			return nil
+		}
+	}
+}
+
 // SendToAll sends a message to all ServerIdentities of the Roster and returns
 // all errors encountered concatenated together as a string.
 func (c *Client) SendToAll(dst *Roster, msg network.Body) ([]*network.Packet, error) {
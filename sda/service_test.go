@@ -167,6 +167,48 @@ func TestServiceProcessRequest(t *testing.T) {
 	}
 }
 
+func TestServiceRequestMetrics(t *testing.T) {
+	ds := &DummyService{
+		link: make(chan bool),
+	}
+	RegisterNewService("DummyService", func(c *Context, path string) Service {
+		ds.c = c
+		ds.path = path
+		return ds
+	})
+	host := NewLocalHost()
+	host.Listen()
+	host.StartProcessMessages()
+	defer host.Close()
+	re := &ClientRequest{
+		Service: ServiceFactory.ServiceID("DummyService"),
+		Data:    []byte("a"),
+	}
+	h2 := NewLocalHost()
+	defer h2.Close()
+	log.ErrFatal(h2.SendRaw(host.ServerIdentity, re))
+	waitOrFatalValue(ds.link, false, t)
+
+	// The request is counted once the service returns
+	var requests float64
+	for i := 0; i < 10 && requests == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		for _, m := range host.Collect() {
+			if m.Name == "cothority_service_requests_total" &&
+				m.Labels["service"] == "DummyService" {
+				requests = m.Value
+			}
+		}
+	}
+	assert.Equal(t, 1.0, requests)
+	names := make(map[string]bool)
+	for _, m := range host.Collect() {
+		names[m.Name] = true
+	}
+	assert.True(t, names["cothority_host_connections"])
+	assert.True(t, names["cothority_service_request_seconds"])
+}
+
 // Test if a request that makes the service create a new protocol works
 func TestServiceRequestNewProtocol(t *testing.T) {
 	ds := &DummyService{
@@ -63,6 +63,9 @@ type SimulationConfig struct {
 	Host *Host
 	// Additional configuration used to run
 	Config string
+	// If non-empty, a network.FaultScenario in toml-format that is applied
+	// to all hosts
+	Faults string
 }
 
 // SimulationConfigFile stores the state of the simulation's config.
@@ -72,6 +75,7 @@ type SimulationConfigFile struct {
 	Roster      *Roster
 	PrivateKeys map[string]abstract.Scalar
 	Config      string
+	Faults      string
 }
 
 // LoadSimulationConfig gets all configuration from dir + SimulationFileName and instantiates the
@@ -92,11 +96,21 @@ func LoadSimulationConfig(dir, ha string) ([]*SimulationConfig, error) {
 		Roster:      scf.Roster,
 		PrivateKeys: scf.PrivateKeys,
 		Config:      scf.Config,
+		Faults:      scf.Faults,
 	}
 	sc.Tree, err = scf.TreeMarshal.MakeTree(sc.Roster)
 	if err != nil {
 		return nil, err
 	}
+	// All hosts of this instance share the same timeline of faults
+	var faults *network.FaultInjector
+	if scf.Faults != "" {
+		fs, err := network.ParseFaultScenario(scf.Faults)
+		if err != nil {
+			return nil, err
+		}
+		faults = network.NewFaultInjector(fs)
+	}
 
 	var ret []*SimulationConfig
 	if ha != "" {
@@ -116,6 +130,9 @@ func LoadSimulationConfig(dir, ha string) ([]*SimulationConfig, error) {
 				if strings.Contains(a, ha) {
 					log.Lvl3("Found host", a, "to match", ha)
 					host := NewHost(e, scf.PrivateKeys[a])
+					if faults != nil {
+						host.InjectFaults(faults)
+					}
 					scNew := *sc
 					scNew.Host = host
 					scNew.Overlay = host.overlay
@@ -149,6 +166,7 @@ func (sc *SimulationConfig) Save(dir string) error {
 		Roster:      sc.Roster,
 		PrivateKeys: sc.PrivateKeys,
 		Config:      sc.Config,
+		Faults:      sc.Faults,
 	}
 	buf, err := network.MarshalRegisteredType(scf)
 	if err != nil {
@@ -1,8 +1,10 @@
 package sda
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 
 	"math/rand"
@@ -399,6 +401,35 @@ func (el *Roster) Publics() []abstract.Point {
 	return res
 }
 
+// HashTo writes the ID, the addresses and public keys of all members and the
+// aggregate key of the Roster to h, so that a signature on the hash covers
+// the whole Roster.
+func (el *Roster) HashTo(h io.Writer) error {
+	h.Write(uuid.UUID(el.ID).Bytes())
+	points := []abstract.Point{el.Aggregate}
+	for _, si := range el.List {
+		if err := binary.Write(h, binary.LittleEndian, int64(len(si.Addresses))); err != nil {
+			return err
+		}
+		for _, addr := range si.Addresses {
+			if err := binary.Write(h, binary.LittleEndian, int64(len(addr))); err != nil {
+				return err
+			}
+			h.Write([]byte(addr))
+		}
+		points = append(points, si.Public)
+	}
+	for _, p := range points {
+		if p == nil {
+			return errors.New("Roster without public key")
+		}
+		if _, err := p.MarshalTo(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GenerateBigNaryTree creates a tree where each node has N children.
 // It will make a tree with exactly 'nodes' elements, regardless of the
 // size of the Roster. If 'nodes' is bigger than the number of elements
@@ -1,6 +1,8 @@
 package sda
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"net"
 	"strconv"
 	"testing"
@@ -435,6 +437,25 @@ func TestRoster_Publics(t *testing.T) {
 	}
 }
 
+func TestRoster_HashTo(t *testing.T) {
+	_, el := genLocalTree(3, 0)
+	hash := func(el *Roster) []byte {
+		h := sha256.New()
+		log.ErrFatal(el.HashTo(h))
+		return h.Sum(nil)
+	}
+	h1 := hash(el)
+	assert.True(t, bytes.Equal(h1, hash(el)))
+	other := NewRoster(el.List)
+	assert.False(t, bytes.Equal(h1, hash(other)), "Same hash for another ID")
+	addr := el.List[0].Addresses[0]
+	el.List[0].Addresses[0] = prefix + "1"
+	assert.False(t, bytes.Equal(h1, hash(el)), "Same hash for another address")
+	el.List[0].Addresses[0] = addr
+	el.Aggregate = nil
+	assert.NotNil(t, el.HashTo(sha256.New()))
+}
+
 func TestTreeNode_AggregatePublic(t *testing.T) {
 	tree, el := genLocalTree(7, 0)
 	agg := el.Aggregate
@@ -1,6 +1,7 @@
 package debianupdate
 
 import (
+	"bytes"
 	"errors"
 	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/log"
@@ -72,6 +73,9 @@ func (c *Client) TimestampRequests(names []string) (*TimestampRets, error) {
 	return &tr, nil
 }
 
+// LatestRelease returns the latest release of the repository with the proofs
+// of all its packages. The proof returned by the service is checked against
+// the root of the release.
 func (c *Client) LatestRelease(repo string) (*LatestRelease, error) {
 
 	// First we gather the latest skipblock
@@ -86,26 +90,126 @@ func (c *Client) LatestRelease(repo string) (*LatestRelease, error) {
 	if err != nil {
 		return nil, err
 	}
+	release, ok := r.(*Release)
+	if !ok {
+		return nil, errors.New("Block doesn't hold a release")
+	}
 
-	// from the release we extract the packages names + hashes + proofs
-	release := r.(*Release)
-
-	proofs := release.Proofs
-	lengths := release.ProofsLength
-	packages := release.Repository.Packages
-
-	packageProofHash := map[string]PackageProof{}
+	names := make([]string, len(release.Repository.Packages))
+	for i, p := range release.Repository.Packages {
+		names[i] = p.Name
+	}
+	ppr, err := c.PackagesProof(repo, names)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(ppr.RootID, release.RootID) {
+		return nil, errors.New("Proof is not for the latest release")
+	}
+	if err := ppr.Verify(release.RootID); err != nil {
+		return nil, err
+	}
 
 	log.Lvl2("preparing the datas")
-	for i, p := range packages {
-		flatproof := crypto.Proof{}
-		for _, subproof := range proofs[:lengths[i]] {
-			flatproof = append(flatproof, subproof...)
-		}
-		packageProofHash[p.Name] = PackageProof{p.Hash, flatproof}
-		proofs = proofs[lengths[i]:]
+	packageProofHash := map[string]PackageProof{}
+	for _, p := range ppr.Packages {
+		packageProofHash[p.Name] = PackageProof{p.Hash, p.Version, ppr.Proof}
 	}
 
 	// We need to return the root signed
 	return &LatestRelease{release.RootID, packageProofHash, lbr.Update}, nil
 }
+
+// PackagesProof returns the requested packages of the latest release of the
+// repository with one proof of inclusion for all of them. The proof has to be
+// verified against a signed root with PackagesProofRet.Verify.
+func (c *Client) PackagesProof(repo string, names []string) (*PackagesProofRet, error) {
+	r, err := c.Send(c.Root, &PackagesProof{repo, names})
+	if e := sda.ErrMsg(r, err); e != nil {
+		return nil, e
+	}
+	ppr, ok := r.Msg.(PackagesProofRet)
+	if !ok {
+		return nil, errors.New("Wrong Message " + reflect.TypeOf(r.Msg).String())
+	}
+	return &ppr, nil
+}
+
+// Verify returns nil if the proof shows that the packages are in the release
+// with the given root.
+func (ppr *PackagesProofRet) Verify(root crypto.HashID) error {
+	if ppr.Proof == nil {
+		return errors.New("No proof given")
+	}
+	tree, err := ppr.Proof.Tree(HashFunc(), root)
+	if err != nil {
+		return err
+	}
+	for _, p := range ppr.Packages {
+		if err := verifyPackage(tree, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AbsenceProof returns a proof that the package is not in the latest release
+// of the repository. The proof has to be verified against the signed root of
+// the returned block with AbsenceProofRet.Verify.
+func (c *Client) AbsenceProof(repo string, name string) (*AbsenceProofRet, error) {
+	r, err := c.Send(c.Root, &AbsenceProof{repo, name})
+	if e := sda.ErrMsg(r, err); e != nil {
+		return nil, e
+	}
+	apr, ok := r.Msg.(AbsenceProofRet)
+	if !ok {
+		return nil, errors.New("Wrong Message " + reflect.TypeOf(r.Msg).String())
+	}
+	return &apr, nil
+}
+
+// Verify returns nil if the proof shows that the package with the given name
+// is not in the release with the given root.
+func (apr *AbsenceProofRet) Verify(name string, root crypto.HashID) error {
+	if apr.Proof == nil {
+		return errors.New("No proof given")
+	}
+	_, ok, err := apr.Proof.Get(HashFunc(), root, []byte(name))
+	if err != nil {
+		return err
+	}
+	if ok {
+		return errors.New("Package " + name + " is in the release")
+	}
+	return nil
+}
+
+// Verify returns nil if the proof shows that the package with the given name
+// is in the release with the given root.
+func (pp *PackageProof) Verify(name string, root crypto.HashID) error {
+	if pp.Proof == nil {
+		return errors.New("No proof for " + name)
+	}
+	tree, err := pp.Proof.Tree(HashFunc(), root)
+	if err != nil {
+		return err
+	}
+	return verifyPackage(tree, &Package{Name: name, Version: pp.Version,
+		Hash: pp.Hash})
+}
+
+// verifyPackage returns nil if the tree holds the StanzaHash of the package
+// for its name.
+func verifyPackage(tree *crypto.IncrementalTree, p *Package) error {
+	value, ok, err := tree.Get([]byte(p.Name))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("Package " + p.Name + " is not in the release")
+	}
+	if !bytes.Equal(value, p.StanzaHash()) {
+		return errors.New("Wrong version or hash for " + p.Name)
+	}
+	return nil
+}
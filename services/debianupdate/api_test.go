@@ -56,3 +56,88 @@ func TestClient_LatestUpdates(t *testing.T) {
 	require.Equal(t, 1, len(lbret.Updates))
 	require.Equal(t, 2, len(lbret.Updates[0]))
 }
+
+func TestClient_PackagesProof(t *testing.T) {
+	local := sda.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, s := local.MakeHELS(5, debianUpdateService)
+	service := s.(*DebianUpdate)
+
+	release := chain1.blocks[0].release
+	_, err := service.CreateRepository(nil,
+		&CreateRepository{roster, release, 2, 10})
+	log.ErrFatal(err)
+	repo := release.Repository.GetName()
+
+	client := NewClient(roster)
+	ppr, err := client.PackagesProof(repo, []string{"test3", "test1"})
+	log.ErrFatal(err)
+	require.Equal(t, 2, len(ppr.Packages))
+	require.Equal(t, "test3", ppr.Packages[0].Name)
+	require.Equal(t, "test1", ppr.Packages[1].Name)
+	require.Equal(t, release.RootID, ppr.RootID)
+	require.Nil(t, ppr.Verify(release.RootID))
+	require.NotNil(t, ppr.Verify(chain2.blocks[0].release.RootID))
+
+	ppr.Packages[0].Hash = "1111"
+	require.NotNil(t, ppr.Verify(release.RootID))
+
+	_, err = client.PackagesProof(repo, []string{"test1", "unknown"})
+	require.NotNil(t, err)
+	_, err = client.PackagesProof("unknown", []string{"test1"})
+	require.NotNil(t, err)
+}
+
+func TestClient_LatestRelease(t *testing.T) {
+	local := sda.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, s := local.MakeHELS(5, debianUpdateService)
+	service := s.(*DebianUpdate)
+
+	release := chain1.blocks[0].release
+	_, err := service.CreateRepository(nil,
+		&CreateRepository{roster, release, 2, 10})
+	log.ErrFatal(err)
+
+	client := NewClient(roster)
+	lr, err := client.LatestRelease(release.Repository.GetName())
+	log.ErrFatal(err)
+	require.Equal(t, release.RootID, lr.RootID)
+	require.Equal(t, len(release.Repository.Packages), len(lr.Packages))
+	for _, p := range release.Repository.Packages {
+		pp, ok := lr.Packages[p.Name]
+		require.True(t, ok)
+		require.Nil(t, pp.Verify(p.Name, lr.RootID))
+	}
+}
+
+func TestClient_AbsenceProof(t *testing.T) {
+	local := sda.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, s := local.MakeHELS(5, debianUpdateService)
+	service := s.(*DebianUpdate)
+
+	release := chain1.blocks[0].release
+	cpr, err := service.CreateRepository(nil,
+		&CreateRepository{roster, release, 2, 10})
+	log.ErrFatal(err)
+	sc := cpr.(*CreateRepositoryRet).RepositoryChain
+	repo := release.Repository.GetName()
+
+	client := NewClient(roster)
+	for _, name := range []string{"a", "test0", "test2a", "test5"} {
+		apr, err := client.AbsenceProof(repo, name)
+		log.ErrFatal(err)
+		require.Equal(t, sc.Data.Hash, apr.Block)
+		require.Equal(t, release.RootID, apr.RootID)
+		require.Nil(t, apr.Verify(name, release.RootID), name)
+		require.NotNil(t, apr.Verify(name, chain2.blocks[0].release.RootID))
+	}
+	apr, err := client.AbsenceProof(repo, "test2a")
+	log.ErrFatal(err)
+	require.NotNil(t, apr.Verify("test2", release.RootID))
+	require.NotNil(t, apr.Verify("test3", release.RootID))
+
+	_, err = client.AbsenceProof(repo, "test2")
+	require.NotNil(t, err, "test2 is in the repository")
+}
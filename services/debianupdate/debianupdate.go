@@ -5,6 +5,10 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
 	"sort"
 	"sync"
 	"time"
@@ -13,11 +17,12 @@ import (
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/monitor"
 	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/protocols/cosi"
 	"github.com/dedis/cothority/protocols/manage"
-	"github.com/dedis/cothority/protocols/swupdate"
 	"github.com/dedis/cothority/sda"
 	"github.com/dedis/cothority/services/skipchain"
 	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
 )
 
 // ServiceName is the name to refer to the CoSi service
@@ -31,7 +36,7 @@ func init() {
 	sda.RegisterNewService(ServiceName, NewDebianUpdate)
 	debianUpdateService = sda.ServiceFactory.ServiceID(ServiceName)
 	network.RegisterPacketType(&storage{})
-	skipchain.VerificationRegistration(verifierID, verifierFunc)
+	skipchain.BlockVerificationRegistration(verifierID, verifyBlock)
 }
 
 // DebianUpdate service
@@ -41,6 +46,8 @@ type DebianUpdate struct {
 	Storage        *storage
 	skipchain      *skipchain.Client
 	ReasonableTime time.Duration
+	// trees holds the tree of the latest release of every repository
+	trees map[string]*crypto.IncrementalTree
 	sync.Mutex
 }
 
@@ -66,11 +73,13 @@ func NewDebianUpdate(context *sda.Context, path string) sda.Service {
 			RepositoryChain:        map[string]*RepositoryChain{},
 		},
 		ReasonableTime: time.Hour,
+		trees:          map[string]*crypto.IncrementalTree{},
 	}
 
 	err := service.RegisterMessages(service.CreateRepository,
 		service.UpdateRepository, service.LatestBlocks,
-		service.LatestBlockFromName, service.LatestBlock)
+		service.LatestBlockFromName, service.LatestBlock,
+		service.PackagesProof, service.AbsenceProof)
 	/*service.TimeStampProof,
 	service.LatestBlocks,
 	service.TimestampProofs)*/
@@ -78,29 +87,82 @@ func NewDebianUpdate(context *sda.Context, path string) sda.Service {
 	if err != nil {
 		log.ErrFatal(err, "Couldn't register messages")
 	}
+	if err := service.tryLoad(); err != nil {
+		log.Error(err)
+	}
 
 	return service
 }
 
+// fileName returns the file of the Storage. The local tests run all nodes
+// with the same path, so the file is specific to the node.
+func (service *DebianUpdate) fileName() string {
+	return path.Join(service.path, "debianupdate-"+
+		uuid.UUID(service.ServerIdentity().ID).String()+".bin")
+}
+
+// save stores the Storage of the service. The lock of the service must be
+// held.
+func (service *DebianUpdate) save() {
+	log.Lvl3("Saving service")
+	b, err := network.MarshalRegisteredType(service.Storage)
+	if err != nil {
+		log.Error("Couldn't marshal service:", err)
+		return
+	}
+	err = ioutil.WriteFile(service.fileName(), b, 0660)
+	if err != nil {
+		log.Error("Couldn't save file:", err)
+	}
+}
+
+// Tries to load the Storage and updates if one is found, else it returns an
+// error.
+func (service *DebianUpdate) tryLoad() error {
+	b, err := ioutil.ReadFile(service.fileName())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error while reading %s: %s", service.fileName(), err)
+	}
+	if len(b) > 0 {
+		_, msg, err := network.UnmarshalRegistered(b)
+		if err != nil {
+			return fmt.Errorf("Couldn't unmarshal: %s", err)
+		}
+		log.Lvl3("Successfully loaded")
+		service.Storage = msg.(*storage)
+		if service.Storage.RepositoryChainGenesis == nil {
+			service.Storage.RepositoryChainGenesis = map[string]*RepositoryChain{}
+		}
+		if service.Storage.RepositoryChain == nil {
+			service.Storage.RepositoryChain = map[string]*RepositoryChain{}
+		}
+	}
+	return nil
+}
+
 func (service *DebianUpdate) CreateRepository(si *network.ServerIdentity,
 	cr *CreateRepository) (network.Body, error) {
 	repo := cr.Release.Repository
 	log.Lvlf3("%s Creating repository %s version %s", service,
 		repo.GetName(), repo.Version)
 
+	service.Lock()
 	repoChain := &RepositoryChain{
 		Release: cr.Release,
 		Root:    service.Storage.Root,
 	}
-	if service.Storage.Root == nil {
+	service.Unlock()
+	if repoChain.Root == nil {
 		log.Lvl3("Creating Root-skipchain")
-		var err error
-		service.Storage.Root, err = service.skipchain.CreateRoster(cr.Roster,
+		root, err := service.skipchain.CreateRoster(cr.Roster,
 			cr.Base, cr.Height, skipchain.VerifyNone, nil)
 		if err != nil {
 			return nil, err
 		}
-		repoChain.Root = service.Storage.Root
+		service.Lock()
+		service.Storage.Root = root
+		service.Unlock()
+		repoChain.Root = root
 	}
 	log.Lvl3("Creating Data-skipchain")
 	var err error
@@ -110,7 +172,10 @@ func (service *DebianUpdate) CreateRepository(si *network.ServerIdentity,
 		log.Lvl2("error while adding the data in the skipchain")
 		return nil, err
 	}
+	service.Lock()
 	service.Storage.RepositoryChainGenesis[repo.GetName()] = repoChain
+	service.save()
+	service.Unlock()
 	if err := service.startPropagate(repo.GetName(), repoChain); err != nil {
 		return nil, err
 	}
@@ -121,7 +186,9 @@ func (service *DebianUpdate) CreateRepository(si *network.ServerIdentity,
 
 func (service *DebianUpdate) startPropagate(repo string,
 	repoChain *RepositoryChain) error {
+	service.Lock()
 	roster := service.Storage.Root.Roster
+	service.Unlock()
 	log.Lvl2("Propagating repository", repo, "to", roster.List)
 	replies, err := manage.PropagateStartAndWait(service.Context, roster,
 		repoChain, 120000, service.PropagateSkipBlock)
@@ -140,13 +207,19 @@ func (service *DebianUpdate) PropagateSkipBlock(msg network.Body) {
 		log.Error("Couldn't convert to SkipBlock")
 		return
 	}
+	if repoChain.Release == nil || repoChain.Release.Repository == nil {
+		log.Error("Propagated block without release")
+		return
+	}
 	repo := repoChain.Release.Repository.GetName()
 	log.Lvl2("saving repositorychain for", repo)
-	// TODO: Verification
+	service.Lock()
+	defer service.Unlock()
 	if _, exists := service.Storage.RepositoryChainGenesis[repo]; !exists {
 		service.Storage.RepositoryChainGenesis[repo] = repoChain
 	}
 	service.Storage.RepositoryChain[repo] = repoChain
+	service.save()
 }
 
 // timestamp creates a merkle tree of all the latests skipblocks of each
@@ -155,45 +228,34 @@ func (service *DebianUpdate) PropagateSkipBlock(msg network.Body) {
 func (service *DebianUpdate) timestamp(time time.Time) {
 	//measure := monitor.NewTimeMeasure("debianupdate_timestamp")
 	// order all packets and marshal them
+	service.Lock()
 	ids := service.orderedLatestSkipblocksID()
+	service.Unlock()
 	// create merkle tree + proofs and the final message
 	root, proofs := crypto.ProofTree(HashFunc(), ids)
 	msg := MarshalPair(root, time.Unix())
 	// run protocol
-	signature := service.cosiSign(msg)
-	// TODO XXX Here in a non-academical world we should test if the
-	// signature contains enough participants.
-	service.updateTimestampInfo(root, proofs, time.Unix(), signature)
-	//measure.Record()
-}
-
-func (service *DebianUpdate) cosiSign(msg []byte) []byte {
-	sdaTree := service.Storage.Root.Roster.GenerateBinaryTree()
-
-	// TODO XXX Here we use the swupdate protocol (should we ?)
-	tni := service.NewTreeNodeInstance(sdaTree, sdaTree.Root, swupdate.ProtocolName)
-	pi, err := swupdate.NewCoSiUpdate(tni, service.cosiVerify)
+	sig, err := service.cosiSign(msg)
 	if err != nil {
-		panic("Couldn't make new protocol: " + err.Error())
+		log.Error("Couldn't sign the timestamp:", err)
+		return
 	}
-	service.RegisterProtocolInstance(pi)
+	service.updateTimestampInfo(root, proofs, time.Unix(), sig.Sig)
+	//measure.Record()
+}
 
+func (service *DebianUpdate) cosiSign(msg []byte) (*cosi.Signature, error) {
 	// measure the time the cothority takes to sign the root
 	measure := monitor.NewTimeMeasure("cothority_signing")
-
-	pi.SigningMessage(msg)
-	// Take the raw message (already expecting a hash for the timestamp service)
-	response := make(chan []byte)
-	pi.RegisterSignatureHook(func(sig []byte) {
-		response <- sig
-	})
-	go pi.Dispatch()
-	go pi.Start()
+	defer measure.Record()
+	ctx, cancel := context.WithTimeout(context.Background(), cosi.DefaultRoundTimeout)
+	defer cancel()
 	log.Lvl2("Waiting on cosi response ...")
-	res := <-response
-	measure.Record()
-	log.Lvl2("... DONE: Recieved cosi response")
-	return res
+	service.Lock()
+	roster := service.Storage.Root.Roster
+	service.Unlock()
+	return cosi.Sign(ctx, service.Context, &cosi.Config{Roster: roster}, msg,
+		service.cosiVerify)
 }
 
 func (service *DebianUpdate) cosiVerify(msg []byte) bool {
@@ -205,7 +267,9 @@ func (service *DebianUpdate) cosiVerify(msg []byte) bool {
 	}
 	// check merkle tree root
 	// order all packets and marshal them
+	service.Lock()
 	ids := service.orderedLatestSkipblocksID()
+	service.Unlock()
 
 	// create merkle tree + proofs and the final message
 
@@ -263,7 +327,8 @@ func UnmarshalPair(buff []byte) (crypto.HashID, int64) {
 }
 
 // orderedLatestSkipblocksID sorts the latests blocks of all skipchains and
-// return all ids in an array of HashID
+// return all ids in an array of HashID. The lock of the service has to be
+// held.
 func (service *DebianUpdate) orderedLatestSkipblocksID() []crypto.HashID {
 	keys := service.getOrderedRepositoryNames()
 
@@ -275,6 +340,38 @@ func (service *DebianUpdate) orderedLatestSkipblocksID() []crypto.HashID {
 	return ids
 }
 
+// Collect implements the monitor.Collector interface and returns the length
+// of the skipchain of each repository and the age of the latest timestamp.
+func (service *DebianUpdate) Collect() []*monitor.Metric {
+	service.Lock()
+	defer service.Unlock()
+	var ret []*monitor.Metric
+	for _, name := range service.getOrderedRepositoryNames() {
+		chain := service.Storage.RepositoryChain[name]
+		if chain == nil || chain.Data == nil {
+			continue
+		}
+		ret = append(ret, &monitor.Metric{
+			Name:   "debianupdate_skipchain_length",
+			Type:   monitor.MetricGauge,
+			Help:   "Number of blocks in the Data-skipchain of the repository",
+			Labels: map[string]string{"repository": name},
+			Value:  float64(chain.Data.Index + 1),
+		})
+	}
+	if t := service.Storage.Timestamp; t != nil {
+		ret = append(ret, &monitor.Metric{
+			Name:  "debianupdate_timestamp_age_seconds",
+			Type:  monitor.MetricGauge,
+			Help:  "Seconds since the latest timestamp has been signed",
+			Value: time.Since(time.Unix(t.Timestamp, 0)).Seconds(),
+		})
+	}
+	return ret
+}
+
+// getOrderedRepositoryNames returns the sorted names of the repositories. The
+// lock of the service has to be held.
 func (service *DebianUpdate) getOrderedRepositoryNames() []string {
 	keys := make([]string, 0)
 	for k := range service.Storage.RepositoryChain {
@@ -295,10 +392,18 @@ func (service *DebianUpdate) UpdateRepository(si *network.ServerIdentity,
 	}
 	release := ur.Release
 
-	actualRoot := service.Storage.RepositoryChain[release.Repository.GetName()].
-		Release.RootID
+	service.Lock()
+	actual := service.Storage.RepositoryChain[release.Repository.GetName()].
+		Release
+	service.Unlock()
 	// Check if the new block is different
-	if !bytes.Equal(actualRoot, release.RootID) {
+	if !bytes.Equal(actual.RootID, release.RootID) {
+		service.Lock()
+		tree, err := service.updateTree(actual, release)
+		service.Unlock()
+		if err != nil {
+			return nil, err
+		}
 
 		log.Lvl1("Adding new data to the Data-skipchain")
 		ret, err := service.skipchain.ProposeData(ur.RepositoryChain.Root,
@@ -307,6 +412,9 @@ func (service *DebianUpdate) UpdateRepository(si *network.ServerIdentity,
 			return nil, err
 		}
 		repoChain.Data = ret.Latest
+		service.Lock()
+		service.trees[release.Repository.GetName()] = tree
+		service.Unlock()
 
 		if err := service.startPropagate(release.Repository.GetName(),
 			repoChain); err != nil {
@@ -315,7 +423,9 @@ func (service *DebianUpdate) UpdateRepository(si *network.ServerIdentity,
 	} else {
 		log.Lvl1("The latest existing skipblock is the same," +
 			" only update the timestamp.")
+		service.Lock()
 		repoChain = service.Storage.RepositoryChain[release.Repository.GetName()]
+		service.Unlock()
 	}
 
 	service.timestamp(time.Now())
@@ -337,7 +447,7 @@ func (service *DebianUpdate) NewProtocol(tn *sda.TreeNodeInstance,
 		pi.(*manage.Propagate).RegisterOnData(service.PropagateSkipBlock)
 	default:
 		log.Lvl2("DebianUpdate Service received New Protocol COSI event")
-		pi, err = swupdate.NewCoSiUpdate(tn, service.cosiVerify)
+		pi, err = cosi.NewCoSi(tn, service.cosiVerify)
 		if err != nil {
 			return nil, err
 		}
@@ -345,57 +455,128 @@ func (service *DebianUpdate) NewProtocol(tn *sda.TreeNodeInstance,
 	return pi, err
 }
 
-func verifierFunc(msg, data []byte) bool {
-	_, sbBuf, err := network.UnmarshalRegistered(data)
-	sb, ok := sbBuf.(*skipchain.SkipBlock)
-	if err != nil || !ok {
-		log.Error(err, ok)
-		return false
-	}
-	_, relBuf, err := network.UnmarshalRegistered(sb.Data)
-	release, ok := relBuf.(*Release)
-	if err != nil || !ok {
-		log.Error(err, ok)
+// verifyBlock verifies the release of a new block of the Data-skipchain of
+// a repository against the release of the previous block.
+func verifyBlock(previous, newest *skipchain.SkipBlock) bool {
+	release, err := blockRelease(newest)
+	if err != nil {
+		log.Error(err)
 		return false
 	}
-	repo := release.Repository
-	if repo == nil {
+	if release.Repository == nil {
 		log.Lvl2("The repository contained in the release is nil")
 		return false
 	}
-	root := release.RootID
-	if len(root) == 0 {
+	if len(release.RootID) == 0 {
 		log.Lvl2("No root hash, has the Merkle-tree correctly been built ?")
 		return false
 	}
-
-	//ver := monitor.NewTimeMeasure("verification")
-
-	// build the merkle-tree for packages
-	hashes := make([]crypto.HashID, len(repo.Packages))
-	for i, p := range repo.Packages {
-		hashes[i] = crypto.HashID(p.Hash)
+	var prevRelease *Release
+	if previous != nil {
+		if prevRelease, err = blockRelease(previous); err != nil {
+			log.Error(err)
+			return false
+		}
 	}
 
 	// measure the time the cothority takes to verify the merkle tree
 	measure := monitor.NewTimeMeasure("cothority_verify_proofs")
+	defer measure.Record()
+	if err := verifyRelease(release, prevRelease); err != nil {
+		log.Lvl2(err)
+		return false
+	}
+	return true
+}
 
-	possibleRoot, _ := crypto.ProofTree(HashFunc(), hashes)
+// blockRelease returns the release held by the block.
+func blockRelease(sb *skipchain.SkipBlock) (*Release, error) {
+	_, msg, err := network.UnmarshalRegistered(sb.Data)
+	if err != nil {
+		return nil, err
+	}
+	release, ok := msg.(*Release)
+	if !ok {
+		return nil, errors.New("Block doesn't hold a release")
+	}
+	return release, nil
+}
 
-	if !bytes.Equal(possibleRoot, root) {
-		log.Lvl2("Wrong root hash")
-		return false
+// verifyRelease checks that the update of the release starts from the root
+// of the previous release and leads to the root of the release, which only
+// costs the hashes of the changed packages. The release of the first block has
+// no previous release, so its packages have to hash to its root.
+func verifyRelease(release, previous *Release) error {
+	if previous == nil {
+		tree, err := release.Repository.Tree()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(tree.Root(), release.RootID) {
+			return errors.New("Wrong root hash")
+		}
+		return nil
+	}
+	if release.Update == nil {
+		return errors.New("Missing update from the previous release")
+	}
+	if !bytes.Equal(release.Update.OldRoot, previous.RootID) {
+		return errors.New("Update is not from the previous release")
 	}
+	return release.Update.Verify(HashFunc(), release.RootID)
+}
 
-	measure.Record()
+// tree returns the tree of the release, which is kept for the latest release
+// of every repository. The lock of the service has to be held, as the trees
+// can't be used concurrently.
+func (service *DebianUpdate) tree(release *Release) (*crypto.IncrementalTree, error) {
+	name := release.Repository.GetName()
+	if t := service.trees[name]; t != nil && bytes.Equal(t.Root(), release.RootID) {
+		return t, nil
+	}
+	t, err := release.Repository.Tree()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(t.Root(), release.RootID) {
+		return nil, errors.New("Packages of " + name + " don't match the root")
+	}
+	service.trees[name] = t
+	return t, nil
+}
 
-	return true
+// updateTree applies the changes between the packages of the previous and the
+// new release to the tree of the previous release, and stores the proof of
+// the update in the new release. It returns the tree of the new release. The
+// lock of the service has to be held.
+func (service *DebianUpdate) updateTree(previous, release *Release) (*crypto.IncrementalTree, error) {
+	old, err := service.tree(previous)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := release.Repository.Changes(previous.Repository)
+	if err != nil {
+		return nil, err
+	}
+	tree, update, err := old.Apply(ops)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(tree.Root(), release.RootID) {
+		return nil, errors.New("Packages of " +
+			release.Repository.GetName() + " don't match the root")
+	}
+	release.Update = update
+	return tree, nil
 }
 
 func (service *DebianUpdate) RepositorySC(si *network.ServerIdentity,
 	rsc *RepositorySC) (network.Body, error) {
 
+	service.Lock()
 	repoChain, ok := service.Storage.RepositoryChain[rsc.repositoryName]
+	genesis := service.Storage.RepositoryChainGenesis[rsc.repositoryName]
+	service.Unlock()
 
 	if !ok {
 		return nil, errors.New("Does not exist.")
@@ -410,7 +591,7 @@ func (service *DebianUpdate) RepositorySC(si *network.ServerIdentity,
 
 	update := latestBlockRet.(*LatestBlockRet).Update
 	return &RepositorySCRet{
-		First: service.Storage.RepositoryChainGenesis[rsc.repositoryName].Data,
+		First: genesis.Data,
 		Last:  update[len(update)-1],
 	}, nil
 }
@@ -418,25 +599,29 @@ func (service *DebianUpdate) RepositorySC(si *network.ServerIdentity,
 func (service *DebianUpdate) LatestBlock(si *network.ServerIdentity,
 	lb *LatestBlock) (network.Body, error) {
 
-	if service.Storage.Timestamp == nil {
+	service.Lock()
+	ts, root := service.Storage.Timestamp, service.Storage.Root
+	service.Unlock()
+	if ts == nil {
 		return nil, errors.New("Timestamp-service missing!")
 	}
 
-	gucRet, err := service.skipchain.GetUpdateChain(service.Storage.Root,
-		lb.LastKnownSB)
+	gucRet, err := service.skipchain.GetUpdateChain(root, lb.LastKnownSB)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &LatestBlockRet{service.Storage.Timestamp, gucRet.Update}, nil
+	return &LatestBlockRet{ts, gucRet.Update}, nil
 }
 
 func (service *DebianUpdate) LatestBlockFromName(si *network.ServerIdentity,
 	lbr *LatestBlockRepo) (network.Body, error) {
 	repoName := lbr.RepoName
 
+	service.Lock()
 	chain := service.Storage.RepositoryChain[repoName]
+	service.Unlock()
 	if chain == nil {
 		return nil, errors.New("skipchain not found for " + repoName)
 	}
@@ -464,3 +649,64 @@ func (service *DebianUpdate) LatestBlocks(si *network.ServerIdentity,
 	}
 	return &LatestBlocksRetInternal{t, updates, lengths}, nil
 }
+
+// PackagesProof returns the requested packages of the latest release of the
+// repository together with one proof of inclusion for all of them.
+func (service *DebianUpdate) PackagesProof(si *network.ServerIdentity,
+	pp *PackagesProof) (network.Body, error) {
+	service.Lock()
+	defer service.Unlock()
+	chain := service.Storage.RepositoryChain[pp.RepoName]
+	if chain == nil || chain.Release == nil {
+		return nil, errors.New("skipchain not found for " + pp.RepoName)
+	}
+	tree, err := service.tree(chain.Release)
+	if err != nil {
+		return nil, err
+	}
+	repo := chain.Release.Repository
+	packages := make(map[string]*Package, len(repo.Packages))
+	for _, p := range repo.Packages {
+		packages[p.Name] = p
+	}
+	ret := &PackagesProofRet{RootID: chain.Release.RootID}
+	keys := make([][]byte, len(pp.Names))
+	for i, name := range pp.Names {
+		p, ok := packages[name]
+		if !ok {
+			return nil, errors.New("package " + name + " not in " + pp.RepoName)
+		}
+		ret.Packages = append(ret.Packages, p)
+		keys[i] = []byte(name)
+	}
+	ret.Proof = tree.Prove(keys...)
+	return ret, nil
+}
+
+// AbsenceProof returns a proof that the package is not in the latest release
+// of the repository, together with the id of the block holding that release.
+func (service *DebianUpdate) AbsenceProof(si *network.ServerIdentity,
+	ap *AbsenceProof) (network.Body, error) {
+	service.Lock()
+	defer service.Unlock()
+	chain := service.Storage.RepositoryChain[ap.RepoName]
+	if chain == nil || chain.Release == nil {
+		return nil, errors.New("skipchain not found for " + ap.RepoName)
+	}
+	tree, err := service.tree(chain.Release)
+	if err != nil {
+		return nil, err
+	}
+	_, ok, err := tree.Get([]byte(ap.Name))
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, errors.New("package " + ap.Name + " is in " + ap.RepoName)
+	}
+	return &AbsenceProofRet{
+		Block:  chain.Data.Hash,
+		RootID: chain.Release.RootID,
+		Proof:  tree.Prove([]byte(ap.Name)),
+	}, nil
+}
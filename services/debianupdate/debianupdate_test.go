@@ -8,7 +8,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/monitor"
 	"github.com/dedis/cothority/sda"
@@ -37,6 +36,7 @@ func TestMain(m *testing.M) {
 	}()
 	select {
 	case code := <-done:
+		os.RemoveAll("config")
 		monitor.EndAndCleanup()
 		log.AfterTest(nil)
 		os.Exit(code)
@@ -84,7 +84,7 @@ func TestDebianUpdate_CreateRepository(t *testing.T) {
 	createRepo, err := service.CreateRepository(nil,
 		&CreateRepository{
 			Roster:  roster,
-			Release: &Release{repo1, rootHash, release1.Proofs},
+			Release: &Release{Repository: repo1, RootID: rootHash},
 			Base:    2,
 			Height:  10,
 		})
@@ -130,6 +130,71 @@ func TestDebianUpdate_UpdateRepository(t *testing.T) {
 	assert.Equal(t, *chain1.blocks[1].repo, *repoChain.Release.Repository)
 }
 
+func TestDebianUpdate_UpdateTree(t *testing.T) {
+	local := sda.NewLocalTest()
+	defer local.CloseAll()
+
+	_, roster, s := local.MakeHELS(5, debianUpdateService)
+	service := s.(*DebianUpdate)
+
+	release1 := chain1.blocks[0].release
+	repo, err := service.CreateRepository(nil,
+		&CreateRepository{roster, release1, 2, 10})
+	log.ErrFatal(err)
+	repoChain := repo.(*CreateRepositoryRet).RepositoryChain
+
+	repo2 := *release1.Repository
+	repo2.Version = "1.4"
+	repo2.Packages = []*Package{
+		{"test1", "0.2", "0001"},
+		{"test2", "0.1", "0101"},
+		{"test4", "0.1", "1111"},
+		{"test5", "0.1", "0000"},
+	}
+	tree, err := repo2.Tree()
+	log.ErrFatal(err)
+
+	// The root has to match the packages
+	_, err = service.UpdateRepository(nil, &UpdateRepository{repoChain,
+		&Release{Repository: &repo2, RootID: release1.RootID[1:]}})
+	assert.NotNil(t, err)
+
+	release2 := &Release{Repository: &repo2, RootID: tree.Root()}
+	updateRepo, err := service.UpdateRepository(nil,
+		&UpdateRepository{repoChain, release2})
+	log.ErrFatal(err)
+	repoChain2 := updateRepo.(*UpdateRepositoryRet).RepositoryChain
+	update := repoChain2.Release.Update
+	require.NotNil(t, update)
+	assert.Equal(t, release1.RootID, update.OldRoot)
+	assert.Equal(t, 3, len(update.Ops))
+	assert.Nil(t, update.Verify(HashFunc(), tree.Root()))
+
+	// Every conode checks that the update follows the previous release and
+	// leads to the new root.
+	assert.Nil(t, verifyRelease(repoChain2.Release, release1))
+	assert.NotNil(t, verifyRelease(&Release{Repository: &repo2,
+		RootID: tree.Root()}, release1))
+	wrong := *update
+	wrong.Ops = update.Ops[1:]
+	assert.NotNil(t, verifyRelease(&Release{Repository: &repo2,
+		RootID: tree.Root(), Update: &wrong}, release1))
+	assert.NotNil(t, verifyRelease(repoChain2.Release,
+		chain2.blocks[0].release))
+
+	// The first release has no update, its packages have to match the root.
+	assert.Nil(t, verifyRelease(release1, nil))
+	assert.NotNil(t, verifyRelease(&Release{Repository: &Repository{},
+		RootID: release1.RootID}, nil))
+
+	// The latest release is persisted.
+	loaded := &DebianUpdate{ServiceProcessor: service.ServiceProcessor,
+		path: service.path}
+	log.ErrFatal(loaded.tryLoad())
+	assert.Equal(t, tree.Root(),
+		loaded.Storage.RepositoryChain[repo2.GetName()].Release.RootID)
+}
+
 func TestDebianUpdate_PropagateBlock(t *testing.T) {
 	local := sda.NewLocalTest()
 	defer local.CloseAll()
@@ -173,14 +238,11 @@ func initGlobals() {
 				"/main/binary-amd64/",
 		}
 
-		hashes := make([]crypto.HashID, len(repo.Packages))
-		for i, p := range repo.Packages {
-			hashes[i] = crypto.HashID(p.Hash)
-		}
-		root, proofs := crypto.ProofTree(HashFunc(), hashes)
+		tree, err := repo.Tree()
+		log.ErrFatal(err)
 		return &repositoryBlock{
 			repo:    repo,
-			release: &Release{repo, root, proofs},
+			release: &Release{Repository: repo, RootID: tree.Root()},
 		}
 	}
 
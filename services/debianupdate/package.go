@@ -1,8 +1,11 @@
 package debianupdate
 
 import (
+	"encoding/binary"
 	"errors"
 	"strings"
+
+	"github.com/dedis/cothority/crypto"
 )
 
 /*
@@ -68,3 +71,22 @@ func NewPackage(packageString string) (*Package, error) {
 
 	return p, nil
 }
+
+// TreeOp returns the operation that puts the package in the tree of a
+// release: the name of the package is the key and its StanzaHash the value.
+func (p *Package) TreeOp() *crypto.TreeOp {
+	return &crypto.TreeOp{Key: []byte(p.Name), Value: p.StanzaHash()}
+}
+
+// StanzaHash returns the hash of the fields of the package stanza that are
+// kept. Every field is preceded by its length.
+func (p *Package) StanzaHash() crypto.HashID {
+	h := HashFunc()()
+	for _, f := range []string{p.Version, p.Hash} {
+		l := make([]byte, 4)
+		binary.BigEndian.PutUint32(l, uint32(len(f)))
+		h.Write(l)
+		h.Write([]byte(f))
+	}
+	return h.Sum(nil)
+}
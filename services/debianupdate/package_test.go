@@ -34,3 +34,28 @@ func TestNewPackage(t *testing.T) {
 	log.ErrFatal(err)
 	require.NotNil(p)
 }
+
+func TestPackage_TreeOp(t *testing.T) {
+	require := require.New(t)
+
+	repo := chain1.blocks[0].repo
+	release := chain1.blocks[0].release
+	tree, err := repo.Tree()
+	log.ErrFatal(err)
+	for _, p := range repo.Packages {
+		pp := &PackageProof{p.Hash, p.Version, tree.Prove([]byte(p.Name))}
+		require.Nil(pp.Verify(p.Name, release.RootID))
+		// The proof is bound to the name and version of the package.
+		require.NotNil(pp.Verify(p.Name+"x", release.RootID))
+		pp.Version += "x"
+		require.NotNil(pp.Verify(p.Name, release.RootID))
+	}
+	pp := &PackageProof{repo.Packages[0].Hash, repo.Packages[0].Version,
+		tree.Prove([]byte(repo.Packages[1].Name))}
+	require.NotNil(pp.Verify(repo.Packages[0].Name, release.RootID))
+
+	// Moving fields between version and hash changes the leaf.
+	p1 := &Package{Name: "a", Version: "bc", Hash: "00"}
+	p2 := &Package{Name: "a", Version: "b", Hash: "c00"}
+	require.NotEqual(p1.TreeOp().Value, p2.TreeOp().Value)
+}
@@ -1,10 +1,12 @@
 package debianupdate
 
 import (
+	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/log"
 
 	"bufio"
 	"compress/gzip"
+	"errors"
 	"io/ioutil"
 	"os"
 	"sort"
@@ -105,3 +107,48 @@ func (r *Repository) AddPackage(packageString string) {
 func (r *Repository) GetName() string {
 	return r.Origin + "-" + r.Suite
 }
+
+// Tree returns the authenticated tree of the packages of the repository, whose
+// root is the RootID of a release. It returns an error if a package is present
+// twice.
+func (r *Repository) Tree() (*crypto.IncrementalTree, error) {
+	ops := make([]*crypto.TreeOp, len(r.Packages))
+	names := make(map[string]bool, len(r.Packages))
+	for i, p := range r.Packages {
+		if names[p.Name] {
+			return nil, errors.New("Package present twice: " + p.Name)
+		}
+		names[p.Name] = true
+		ops[i] = p.TreeOp()
+	}
+	tree, _, err := crypto.NewIncrementalTree(HashFunc()).Apply(ops)
+	return tree, err
+}
+
+// Changes returns the operations that change the tree of the old repository
+// into the tree of this one: the packages that are new or changed, and the
+// ones that have been removed. It returns an error if a package of this
+// repository is present twice.
+func (r *Repository) Changes(old *Repository) ([]*crypto.TreeOp, error) {
+	previous := make(map[string]*Package, len(old.Packages))
+	for _, p := range old.Packages {
+		previous[p.Name] = p
+	}
+	names := make(map[string]bool, len(r.Packages))
+	var ops []*crypto.TreeOp
+	for _, p := range r.Packages {
+		if names[p.Name] {
+			return nil, errors.New("Package present twice: " + p.Name)
+		}
+		names[p.Name] = true
+		if o, ok := previous[p.Name]; !ok || *o != *p {
+			ops = append(ops, p.TreeOp())
+		}
+	}
+	for _, p := range old.Packages {
+		if !names[p.Name] {
+			ops = append(ops, &crypto.TreeOp{Key: []byte(p.Name), Delete: true})
+		}
+	}
+	return ops, nil
+}
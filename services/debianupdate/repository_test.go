@@ -22,3 +22,40 @@ func TestNewRepository(t *testing.T) {
 	require.Equal(sourceUrl, repo.SourceUrl)*/
 
 }
+
+func TestRepository_Changes(t *testing.T) {
+	require := require.New(t)
+
+	old := &Repository{Packages: []*Package{
+		{"a", "0.1", "00"},
+		{"b", "0.1", "01"},
+		{"c", "0.1", "10"},
+	}}
+	repo := &Repository{Packages: []*Package{
+		{"a", "0.1", "00"},
+		{"c", "0.2", "11"},
+		{"d", "0.1", "00"},
+	}}
+	ops, err := repo.Changes(old)
+	log.ErrFatal(err)
+	require.Equal(3, len(ops))
+	require.Equal("c", string(ops[0].Key))
+	require.Equal("d", string(ops[1].Key))
+	require.Equal("b", string(ops[2].Key))
+	require.True(ops[2].Delete)
+
+	oldTree, err := old.Tree()
+	log.ErrFatal(err)
+	tree, err := repo.Tree()
+	log.ErrFatal(err)
+	newTree, update, err := oldTree.Apply(ops)
+	log.ErrFatal(err)
+	require.Equal(tree.Root(), newTree.Root())
+	require.Nil(update.Verify(HashFunc(), tree.Root()))
+
+	repo.Packages = append(repo.Packages, &Package{"a", "0.2", "00"})
+	_, err = repo.Changes(old)
+	require.NotNil(err)
+	_, err = repo.Tree()
+	require.NotNil(err)
+}
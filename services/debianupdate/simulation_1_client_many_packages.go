@@ -3,7 +3,6 @@ package debianupdate
 import (
 	"errors"
 	"github.com/BurntSushi/toml"
-	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/monitor"
 	"github.com/dedis/cothority/sda"
@@ -109,20 +108,11 @@ func (e *oneClientSimulation) Run(config *sda.SimulationConfig) error {
 		log.ErrFatal(err)
 		log.Lvl1("Repository created with", len(repo.Packages), "packages")
 
-		// Recover all the hashes from the repo
-		hashes := make([]crypto.HashID, len(repo.Packages))
-		for i, p := range repo.Packages {
-			hashes[i] = crypto.HashID(p.Hash)
-		}
-
-		// Compute the root and the proofs
-		root, proofs := crypto.ProofTree(HashFunc(), hashes)
-		lengths := []int64{}
-		for _, proof := range proofs {
-			lengths = append(lengths, int64(len(proof)))
-		}
-		// Store the repo, root and proofs in a release
-		release := &Release{repo, root, proofs, lengths}
+		// Compute the root of the tree of the packages
+		tree, err := repo.Tree()
+		log.ErrFatal(err)
+		// Store the repo and the root in a release
+		release := &Release{Repository: repo, RootID: tree.Root()}
 
 		// check if the skipchain has already been created for this repo
 		sc, knownRepo := repos[repo.GetName()]
@@ -193,14 +183,14 @@ func (e *oneClientSimulation) Run(config *sda.SimulationConfig) error {
 
 	log.Lvl1("Verifying at most", e.NumberOfInstalledPackages, "packages")
 	i := 1
+	var installed []string
 	for name, p := range lr.Packages {
-		hash := []byte(p.Hash)
-		proof := p.Proof
-		if proof.Check(HashFunc(), lr.RootID, hash) {
+		if err := p.Verify(name, lr.RootID); err == nil {
 			log.Lvl1("Package", name, "correctly verified")
 		} else {
-			log.ErrFatal(errors.New("The proof for " + name + " is not correct."))
+			log.ErrFatal(errors.New("The proof for " + name + " is not correct: " + err.Error()))
 		}
+		installed = append(installed, name)
 		i = i + 1
 		if i > e.NumberOfInstalledPackages {
 			break
@@ -208,6 +198,15 @@ func (e *oneClientSimulation) Run(config *sda.SimulationConfig) error {
 	}
 	round.Record()
 
+	// Same verification with one proof for all installed packages
+	bw_multi := monitor.NewCounterIOMeasure("client_bw_multiproof", updateClient)
+	ppr, err := updateClient.PackagesProof(e.Snapshots, installed)
+	log.ErrFatal(err)
+	bw_multi.Record()
+	verify_multi := monitor.NewTimeMeasure("verify_multiproof")
+	log.ErrFatal(ppr.Verify(lr.RootID))
+	verify_multi.Record()
+
 	// APT Emulation
 	key := swupdate.NewPGP()
 	signedFile := `Origin: Debian
@@ -2,7 +2,6 @@ package debianupdate
 
 import (
 	"github.com/BurntSushi/toml"
-	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/monitor"
 	"github.com/dedis/cothority/sda"
@@ -108,20 +107,11 @@ func (e *createSimulation) Run(config *sda.SimulationConfig) error {
 		log.ErrFatal(err)
 		log.Lvl1("Repository created with", len(repo.Packages), "packages")
 
-		// Recover all the hashes from the repo
-		hashes := make([]crypto.HashID, len(repo.Packages))
-		for i, p := range repo.Packages {
-			hashes[i] = crypto.HashID(p.Hash)
-		}
-
-		// Compute the root and the proofs
-		root, proofs := crypto.ProofTree(HashFunc(), hashes)
-		lengths := []int64{}
-		for _, proof := range proofs {
-			lengths = append(lengths, int64(len(proof)))
-		}
-		// Store the repo, root and proofs in a release
-		release := &Release{repo, root, proofs, lengths}
+		// Compute the root of the tree of the packages
+		tree, err := repo.Tree()
+		log.ErrFatal(err)
+		// Store the repo and the root in a release
+		release := &Release{Repository: repo, RootID: tree.Root()}
 
 		// check if the skipchain has already been created for this repo
 		sc, knownRepo := repos[repo.GetName()]
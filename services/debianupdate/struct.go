@@ -26,6 +26,10 @@ func init() {
 		LatestBlockRet{},
 		LatestRelease{},
 		PackageProof{},
+		PackagesProof{},
+		PackagesProofRet{},
+		AbsenceProof{},
+		AbsenceProofRet{},
 	} {
 		network.RegisterPacketType(msg)
 	}
@@ -35,10 +39,15 @@ type ProjectID uuid.UUID
 
 // Release is a Debian Repository and the developers' signatures
 type Release struct {
-	Repository   *Repository
-	RootID       crypto.HashID
-	Proofs       []crypto.Proof
-	ProofsLength []int64
+	Repository *Repository
+	// RootID is the root of the tree of the packages, as returned by
+	// Repository.Tree
+	RootID crypto.HashID
+	// Update proves the change from the root of the previous release to
+	// RootID. It is set by the service and lets the conodes check that the
+	// release follows the previous one without building the tree of all
+	// packages.
+	Update *crypto.TreeUpdate
 }
 
 type RepositoryChain struct {
@@ -149,8 +158,9 @@ type TimestampRets struct {
 }
 
 type PackageProof struct {
-	Hash  string
-	Proof crypto.Proof
+	Hash    string
+	Version string
+	Proof   *crypto.PartialTree
 }
 
 type LatestRelease struct {
@@ -158,3 +168,33 @@ type LatestRelease struct {
 	Packages map[string]PackageProof
 	Update   []*skipchain.SkipBlock
 }
+
+// PackagesProof asks for a proof of inclusion of the packages denoted by
+// Names in the latest release of the repository denoted by RepoName.
+type PackagesProof struct {
+	RepoName string
+	Names    []string
+}
+
+// PackagesProofRet holds the requested packages, in the order of the
+// request, and one proof for all of them.
+type PackagesProofRet struct {
+	RootID   crypto.HashID
+	Packages []*Package
+	Proof    *crypto.PartialTree
+}
+
+// AbsenceProof asks for a proof that the package denoted by Name is not in the
+// latest release of the repository denoted by RepoName.
+type AbsenceProof struct {
+	RepoName string
+	Name     string
+}
+
+// AbsenceProofRet proves that the package is not in the release of the
+// block Block, whose root is RootID.
+type AbsenceProofRet struct {
+	Block  skipchain.SkipBlockID
+	RootID crypto.HashID
+	Proof  *crypto.PartialTree
+}
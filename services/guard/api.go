@@ -3,6 +3,7 @@ package guard
 import (
 	"errors"
 
+	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/network"
 	"github.com/dedis/cothority/sda"
@@ -34,3 +35,72 @@ func (c *Client) SendToGuard(dst *network.ServerIdentity, UID []byte, epoch []by
 	}
 	return &sr, nil
 }
+
+// GetEpoch returns the actual epoch of the guard, which is used for new keys.
+func (c *Client) GetEpoch(dst *network.ServerIdentity) ([]byte, error) {
+	return c.sendEpoch(dst, &EpochRequest{})
+}
+
+// Rotate asks the guard to create a new epoch and returns it. private is the
+// private key of the guard's conode, held by its operator. The keys of the
+// users can be moved to the new epoch with the token returned by Token.
+func (c *Client) Rotate(dst *network.ServerIdentity, private abstract.Scalar) ([]byte, error) {
+	epoch, err := c.GetEpoch(dst)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.SignSchnorr(network.Suite, private, RotateHash(epoch))
+	if err != nil {
+		return nil, err
+	}
+	return c.sendEpoch(dst, &Rotate{Signature: &sig})
+}
+
+// Token returns the token that moves the responses of the UID from the epoch
+// from to the actual epoch of the guard. private is the private key of the
+// guard's conode.
+func (c *Client) Token(dst *network.ServerIdentity, private abstract.Scalar, uid, from []byte) (*TokenReply, error) {
+	to, err := c.GetEpoch(dst)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.SignSchnorr(network.Suite, private, TokenHash(uid, from, to))
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.Send(dst, &TokenRequest{UID: uid, From: from, To: to,
+		Signature: &sig})
+	if e := sda.ErrMsg(reply, err); e != nil {
+		return nil, e
+	}
+	tr, ok := reply.Msg.(TokenReply)
+	if !ok {
+		return nil, errors.New("Wrong return type")
+	}
+	return &tr, nil
+}
+
+// Retire asks the guard to delete the key of the epoch. It must only be
+// called once no user references the epoch anymore. private is the private
+// key of the guard's conode.
+func (c *Client) Retire(dst *network.ServerIdentity, private abstract.Scalar, epoch []byte) error {
+	sig, err := crypto.SignSchnorr(network.Suite, private, RetireHash(epoch))
+	if err != nil {
+		return err
+	}
+	_, err = c.sendEpoch(dst, &Retire{Epoch: epoch, Signature: &sig})
+	return err
+}
+
+// sendEpoch sends msg to dst and returns the epoch of the reply.
+func (c *Client) sendEpoch(dst *network.ServerIdentity, msg network.Body) ([]byte, error) {
+	reply, err := c.Send(dst, msg)
+	if e := sda.ErrMsg(reply, err); e != nil {
+		return nil, e
+	}
+	er, ok := reply.Msg.(EpochReply)
+	if !ok {
+		return nil, errors.New("Wrong return type")
+	}
+	return er.Epoch, nil
+}
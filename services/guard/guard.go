@@ -2,11 +2,21 @@ package guard
 
 import (
 	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/network"
 	"github.com/dedis/cothority/sda"
 	"github.com/dedis/crypto/abstract"
+	"github.com/satori/go.uuid"
 )
 
 // This file contains all the code to run a Guard service. The Guard receives takes a
@@ -16,19 +26,80 @@ import (
 // ServiceName is the name to refer to the Guard service.
 const ServiceName = "Guard"
 
+var guardService sda.ServiceID
+
 func init() {
 	sda.RegisterNewService(ServiceName, newGuardService)
+	guardService = sda.ServiceFactory.ServiceID(ServiceName)
 	network.RegisterPacketType(&Request{})
 	network.RegisterPacketType(&Response{})
+	network.RegisterPacketType(&EpochRequest{})
+	network.RegisterPacketType(&Rotate{})
+	network.RegisterPacketType(&EpochReply{})
+	network.RegisterPacketType(&TokenRequest{})
+	network.RegisterPacketType(&TokenReply{})
+	network.RegisterPacketType(&Retire{})
+	network.RegisterPacketType(&Storage{})
+	network.RegisterPacketType(&attemptStorage{})
 }
 
-//This is the area where Z is generated for a server, it creates z, which is a bytestring of length n for each guard.
+// MaxAttempts is the number of requests a UID can do during AttemptWindow.
+// Once this number is exceeded, all requests for that UID are refused during
+// LockoutDuration.
+var MaxAttempts = 10
+
+// AttemptWindow is the period over which the requests of a UID are counted.
+var AttemptWindow = time.Hour
+
+// LockoutDuration is how long a UID is locked out once it made too many
+// requests.
+var LockoutDuration = time.Hour
 
-// Guard is a structure that stores the guards secret key, z, to be used later in the process of hashing the clients requests.
+// AttemptsSaveInterval is how often the attempts are saved to disk. A
+// lockout is saved at once, so a restart only forgets the attempts of this
+// interval.
+var AttemptsSaveInterval = time.Minute
+
+// Guard is a structure that stores the guards secret keys, z, to be used later in the process of hashing the clients requests.
 type Guard struct {
 	*sda.ServiceProcessor
+	*Storage
 	path string
-	Z    []byte
+	// storageMutex protects the Storage.
+	storageMutex sync.Mutex
+	// attempts of the UIDs whose window or lockout didn't lapse yet
+	attempts map[string]*Attempts
+	// attemptsSaved is when the attempts have been saved the last time
+	attemptsSaved time.Time
+	// attemptsMutex protects attempts and attemptsSaved
+	attemptsMutex sync.Mutex
+}
+
+// Storage holds the keys of the Guard that are saved to disk.
+type Storage struct {
+	// Keys holds the secret key z of every epoch that has not been retired.
+	Keys map[string][]byte
+	// Epochs is the list of the epochs in Keys, the last one being the
+	// actual epoch.
+	Epochs []string
+	// Counter is the number of the last epoch created.
+	Counter int
+}
+
+// attemptStorage holds the attempts that are saved to disk. They are kept
+// apart from the Storage as they change with every request.
+type attemptStorage struct {
+	Attempts map[string]*Attempts
+}
+
+// Attempts counts the requests of one UID. All times are in nanoseconds
+// since the epoch.
+type Attempts struct {
+	// Count is the number of requests since WindowStart.
+	Count       int
+	WindowStart int64
+	// LockedUntil is the end of the lockout, if any.
+	LockedUntil int64
 }
 
 // Request is what the Guard service is expected to receive from clients.
@@ -43,36 +114,332 @@ type Response struct {
 	Msg abstract.Point
 }
 
-// Request treats external request to this service.
-func (st *Guard) Request(e *network.ServerIdentity, req *Request) (network.Body, error) {
-	//hashy computes the hash that should be sent back to the main server H(pwhash, x, UID, Epoch)
-	blankpoint := network.Suite.Point()
-	zbytes := network.Suite.Scalar()
-	zbytes.SetBytes(st.Z)
-	//need to change this impementation, the setbytes will not work
+// EpochRequest asks the Guard for its actual epoch.
+type EpochRequest struct{}
+
+// Rotate asks the Guard to create a new epoch with a new key. It has to be
+// signed by the operator of the Guard, see RotateHash.
+type Rotate struct {
+	Signature *crypto.SchnorrSig
+}
+
+// EpochReply holds the actual epoch of the Guard.
+type EpochReply struct {
+	Epoch []byte
+}
+
+// TokenRequest asks for the token that moves the responses of the UID from
+// the epoch From to the epoch To, which has to be the actual epoch. It has to
+// be signed by the operator of the Guard, see TokenHash.
+type TokenRequest struct {
+	UID       []byte
+	From      []byte
+	To        []byte
+	Signature *crypto.SchnorrSig
+}
+
+// TokenReply holds the token k_From / k_To of the UID, k being the key of the
+// UID in an epoch. A response R of the epoch To multiplied by the token gives
+// the response of the epoch From for the same message, so the keys of the
+// user can be moved to the epoch To without the password.
+type TokenReply struct {
+	UID   []byte
+	From  []byte
+	To    []byte
+	Token abstract.Scalar
+}
+
+// Retire asks the Guard to delete the key of an epoch that is not used
+// anymore. It has to be signed by the operator of the Guard, see RetireHash.
+type Retire struct {
+	Epoch     []byte
+	Signature *crypto.SchnorrSig
+}
+
+// RotateHash returns the hash the operator signs to rotate the key of a Guard
+// whose actual epoch is epoch.
+func RotateHash(epoch []byte) []byte {
+	return abstract.Sum(network.Suite, []byte("rotate"), epoch)
+}
+
+// TokenHash returns the hash the operator signs to get the token of the UID
+// from the epoch from to the epoch to.
+func TokenHash(uid, from, to []byte) []byte {
+	return abstract.Sum(network.Suite, []byte("token"), abstract.Sum(network.Suite, uid),
+		from, []byte{0}, to)
+}
 
-	sendy := blankpoint.Mul(req.Msg, zbytes)
+// RetireHash returns the hash the operator signs to retire the epoch.
+func RetireHash(epoch []byte) []byte {
+	return abstract.Sum(network.Suite, []byte("retire"), epoch)
+}
+
+// Request treats external request to this service. Every request counts as
+// an attempt for the UID and is refused if there were too many attempts. The
+// message is multiplied with the key of the UID in the requested epoch, so
+// that a response obtained under another UID is of no use for this UID.
+func (st *Guard) Request(e *network.ServerIdentity, req *Request) (network.Body, error) {
+	if err := st.countAttempt(req.UID); err != nil {
+		return nil, err
+	}
+	st.storageMutex.Lock()
+	z, ok := st.Keys[string(req.Epoch)]
+	st.storageMutex.Unlock()
+	if !ok {
+		return nil, errors.New("Unknown epoch " + string(req.Epoch))
+	}
+	sendy := network.Suite.Point().Mul(req.Msg, userKey(z, req.UID))
 	return &Response{sendy}, nil
 }
 
+// EpochRequest returns the actual epoch, which clients use for new keys.
+func (st *Guard) EpochRequest(e *network.ServerIdentity, req *EpochRequest) (network.Body, error) {
+	st.storageMutex.Lock()
+	defer st.storageMutex.Unlock()
+	return &EpochReply{[]byte(st.epoch())}, nil
+}
+
+// Rotate creates a new epoch with a new key and returns it. The keys of the
+// older epochs are kept until they are retired, so that the clients can
+// migrate their users to the new epoch with a token.
+func (st *Guard) Rotate(e *network.ServerIdentity, req *Rotate) (network.Body, error) {
+	st.storageMutex.Lock()
+	defer st.storageMutex.Unlock()
+	if err := crypto.VerifySignature(network.Suite, st.ServerIdentity().Public,
+		RotateHash([]byte(st.epoch())), req.Signature); err != nil {
+		return nil, err
+	}
+	st.newEpoch()
+	st.save()
+	log.Lvl2(st.ServerIdentity(), "rotated to epoch", st.epoch())
+	return &EpochReply{[]byte(st.epoch())}, nil
+}
+
+// TokenRequest returns the token to move the responses of an old epoch to the
+// actual epoch.
+func (st *Guard) TokenRequest(e *network.ServerIdentity, req *TokenRequest) (network.Body, error) {
+	st.storageMutex.Lock()
+	defer st.storageMutex.Unlock()
+	if string(req.To) != st.epoch() {
+		return nil, errors.New("Tokens are only given to the actual epoch " +
+			st.epoch())
+	}
+	if err := crypto.VerifySignature(network.Suite, st.ServerIdentity().Public,
+		TokenHash(req.UID, req.From, req.To), req.Signature); err != nil {
+		return nil, err
+	}
+	from, ok := st.Keys[string(req.From)]
+	if !ok {
+		return nil, errors.New("Unknown epoch " + string(req.From))
+	}
+	token := network.Suite.Scalar().Div(userKey(from, req.UID),
+		userKey(st.Keys[string(req.To)], req.UID))
+	return &TokenReply{UID: req.UID, From: req.From, To: req.To, Token: token}, nil
+}
+
+// Retire deletes the key of an old epoch. The operator has to make sure that
+// no user references the epoch anymore, the actual epoch can't be retired.
+func (st *Guard) Retire(e *network.ServerIdentity, req *Retire) (network.Body, error) {
+	st.storageMutex.Lock()
+	defer st.storageMutex.Unlock()
+	if err := crypto.VerifySignature(network.Suite, st.ServerIdentity().Public,
+		RetireHash(req.Epoch), req.Signature); err != nil {
+		return nil, err
+	}
+	epoch := string(req.Epoch)
+	if epoch == st.epoch() {
+		return nil, errors.New("The actual epoch can't be retired")
+	}
+	if _, ok := st.Keys[epoch]; !ok {
+		return nil, errors.New("Unknown epoch " + epoch)
+	}
+	log.Lvl2(st.ServerIdentity(), "deleting key of epoch", epoch)
+	delete(st.Keys, epoch)
+	for i, e := range st.Epochs {
+		if e == epoch {
+			st.Epochs = append(st.Epochs[:i], st.Epochs[i+1:]...)
+			break
+		}
+	}
+	st.save()
+	return &EpochReply{[]byte(st.epoch())}, nil
+}
+
+// userKey returns the key of the UID for the secret z of an epoch, which is
+// H(z || UID) mapped to a scalar.
+func userKey(z, uid []byte) abstract.Scalar {
+	cipher := network.Suite.Cipher(abstract.Sum(network.Suite, z, uid))
+	return network.Suite.Scalar().Pick(cipher)
+}
+
+// epoch returns the actual epoch. The storageMutex must be held.
+func (st *Guard) epoch() string {
+	return st.Epochs[len(st.Epochs)-1]
+}
+
+// newEpoch creates a new epoch with a random key. The storageMutex must be
+// held.
+func (st *Guard) newEpoch() {
+	const n = 88
+	z := make([]byte, n)
+	_, err := rand.Read(z)
+	log.ErrFatal(err)
+	st.Counter++
+	epoch := strconv.Itoa(st.Counter)
+	st.Keys[epoch] = z
+	st.Epochs = append(st.Epochs, epoch)
+}
+
+// countAttempt adds an attempt for the UID and returns an error if the UID
+// is locked out. The lapsed attempts are pruned first, so that the attempts
+// don't grow between two saves.
+func (st *Guard) countAttempt(uid []byte) error {
+	st.attemptsMutex.Lock()
+	defer st.attemptsMutex.Unlock()
+	now := time.Now()
+	st.pruneAttempts(now)
+	a, ok := st.attempts[string(uid)]
+	if !ok {
+		a = &Attempts{WindowStart: now.UnixNano()}
+		st.attempts[string(uid)] = a
+	}
+	if locked := time.Unix(0, a.LockedUntil); now.Before(locked) {
+		return fmt.Errorf("Too many attempts, locked until %s",
+			locked.Format(time.RFC3339))
+	}
+	if now.Sub(time.Unix(0, a.WindowStart)) > AttemptWindow {
+		a.Count = 0
+		a.WindowStart = now.UnixNano()
+	}
+	a.Count++
+	if a.Count > MaxAttempts {
+		log.Lvl2(st.ServerIdentity(), "locking out", string(uid))
+		a.LockedUntil = now.Add(LockoutDuration).UnixNano()
+		a.Count = 0
+		a.WindowStart = a.LockedUntil
+		st.saveAttempts(now)
+		return errors.New("Too many attempts, locked out")
+	}
+	if now.Sub(st.attemptsSaved) > AttemptsSaveInterval {
+		st.saveAttempts(now)
+	}
+	return nil
+}
+
+// pruneAttempts removes the attempts of the UIDs whose window and lockout
+// lapsed, as they don't count anymore. The attemptsMutex must be held.
+func (st *Guard) pruneAttempts(now time.Time) {
+	for uid, a := range st.attempts {
+		if now.Sub(time.Unix(0, a.WindowStart)) > AttemptWindow &&
+			now.After(time.Unix(0, a.LockedUntil)) {
+			delete(st.attempts, uid)
+		}
+	}
+}
+
+// fileName returns the file of the Storage. The local tests run all nodes
+// with the same path, so the file is specific to the node.
+func (st *Guard) fileName() string {
+	return path.Join(st.path, "guard-"+uuid.UUID(st.ServerIdentity().ID).String()+".bin")
+}
+
+// save stores the Storage of the Guard. The storageMutex must be held.
+func (st *Guard) save() {
+	log.Lvl3("Saving service")
+	b, err := network.MarshalRegisteredType(st.Storage)
+	if err != nil {
+		log.Error("Couldn't marshal service:", err)
+		return
+	}
+	err = ioutil.WriteFile(st.fileName(), b, 0660)
+	if err != nil {
+		log.Error("Couldn't save file:", err)
+	}
+}
+
+// attemptsFileName returns the file of the attempts.
+func (st *Guard) attemptsFileName() string {
+	return path.Join(st.path, "guard-attempts-"+uuid.UUID(st.ServerIdentity().ID).String()+".bin")
+}
+
+// saveAttempts stores the attempts. The attemptsMutex must be held.
+func (st *Guard) saveAttempts(now time.Time) {
+	st.attemptsSaved = now
+	b, err := network.MarshalRegisteredType(&attemptStorage{Attempts: st.attempts})
+	if err != nil {
+		log.Error("Couldn't marshal attempts:", err)
+		return
+	}
+	err = ioutil.WriteFile(st.attemptsFileName(), b, 0660)
+	if err != nil {
+		log.Error("Couldn't save file:", err)
+	}
+}
+
+// Tries to load the Storage and updates if one is found, else it returns an
+// error.
+func (st *Guard) tryLoad() error {
+	b, err := ioutil.ReadFile(st.fileName())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error while reading %s: %s", st.fileName(), err)
+	}
+	if len(b) > 0 {
+		_, msg, err := network.UnmarshalRegistered(b)
+		if err != nil {
+			return fmt.Errorf("Couldn't unmarshal: %s", err)
+		}
+		log.Lvl3("Successfully loaded")
+		st.Storage = msg.(*Storage)
+		if st.Keys == nil {
+			st.Keys = make(map[string][]byte)
+		}
+	}
+
+	st.attemptsMutex.Lock()
+	defer st.attemptsMutex.Unlock()
+	st.attempts = make(map[string]*Attempts)
+	b, err = ioutil.ReadFile(st.attemptsFileName())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error while reading %s: %s", st.attemptsFileName(), err)
+	}
+	if len(b) > 0 {
+		_, msg, err := network.UnmarshalRegistered(b)
+		if err != nil {
+			return fmt.Errorf("Couldn't unmarshal: %s", err)
+		}
+		if as := msg.(*attemptStorage); as.Attempts != nil {
+			st.attempts = as.Attempts
+		}
+		st.pruneAttempts(time.Now())
+	}
+	return nil
+}
+
 // newGuardService creates a new service that is built for Guard.
 func newGuardService(c *sda.Context, path string) sda.Service {
 	s := &Guard{
 		ServiceProcessor: sda.NewServiceProcessor(c),
-		path:             path,
+		Storage: &Storage{
+			Keys: make(map[string][]byte),
+		},
+		attempts: make(map[string]*Attempts),
+		path:     path,
 	}
-	err := s.RegisterMessage(s.Request)
-	if err != nil {
-		log.ErrFatal(err, "Couldn't register message:")
+	if err := s.tryLoad(); err != nil {
+		log.Error(err)
+	}
+	for _, f := range []interface{}{s.Request, s.EpochRequest, s.Rotate,
+		s.TokenRequest, s.Retire} {
+		if err := s.RegisterMessage(f); err != nil {
+			log.ErrFatal(err, "Couldn't register message:")
+		}
 	}
 
-	//This is the area where Z is generated for a server
-	const n = 88
-	lel := make([]byte, n)
-	_, err = rand.Read(lel)
-	log.ErrFatal(err)
-	s.Z = lel
-
+	// This is the area where the first Z is generated for a server
+	if len(s.Epochs) == 0 {
+		s.newEpoch()
+		s.save()
+	}
 	return s
 }
 
@@ -2,10 +2,12 @@ package guard
 
 import (
 	"testing"
+	"time"
 
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/network"
 	"github.com/dedis/cothority/sda"
+	"github.com/dedis/crypto/random"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,13 +26,176 @@ func TestServiceGuard(t *testing.T) {
 	client := NewClient()
 	log.Lvl1("Sending request to service...")
 	UID := []byte("USER")
-	Epoch := []byte("EPOCH")
-	msg := network.Suite.Point()
+	Epoch, err := client.GetEpoch(el.List[0])
+	log.ErrFatal(err)
+	msg := network.Suite.Point().Pick(nil, random.Stream)
 
-	Hzi, _ := client.SendToGuard(el.List[0], UID, Epoch, msg)
+	Hzi, err := client.SendToGuard(el.List[0], UID, Epoch, msg)
+	log.ErrFatal(err)
 	// We send the message twice to see that the key did not change for the
 	//same epoch.
-	Hz2, _ := client.SendToGuard(el.List[0], UID, Epoch, msg)
-	assert.Equal(t, Hzi, Hz2)
+	Hz2, err := client.SendToGuard(el.List[0], UID, Epoch, msg)
+	log.ErrFatal(err)
+	assert.True(t, Hzi.Msg.Equal(Hz2.Msg))
 
+	_, err = client.SendToGuard(el.List[0], UID, []byte("EPOCH"), msg)
+	assert.NotNil(t, err, "Unknown epoch should fail")
+
+}
+
+func TestServiceGuardRateLimit(t *testing.T) {
+	defer func(max int, lockout time.Duration) {
+		MaxAttempts = max
+		LockoutDuration = lockout
+	}(MaxAttempts, LockoutDuration)
+	MaxAttempts = 3
+	LockoutDuration = 500 * time.Millisecond
+
+	local := sda.NewLocalTest()
+	hosts, el, _ := local.GenTree(2, false, true, false)
+	defer local.CloseAll()
+
+	client := NewClient()
+	msg := network.Suite.Point().Pick(nil, random.Stream)
+	epoch, err := client.GetEpoch(el.List[0])
+	log.ErrFatal(err)
+	user, err := client.SendToGuard(el.List[0], []byte("USER"), epoch, msg)
+	log.ErrFatal(err)
+	for i := 1; i < MaxAttempts; i++ {
+		_, err := client.SendToGuard(el.List[0], []byte("USER"), epoch, msg)
+		log.ErrFatal(err)
+	}
+	_, err = client.SendToGuard(el.List[0], []byte("USER"), epoch, msg)
+	assert.NotNil(t, err)
+	_, err = client.SendToGuard(el.List[0], []byte("USER"), epoch, msg)
+	assert.NotNil(t, err, "Should still be locked out")
+	// Other users and other servers are not concerned, but the answer for
+	// another UID doesn't unlock the share of USER
+	other, err := client.SendToGuard(el.List[0], []byte("OTHER"), epoch, msg)
+	log.ErrFatal(err)
+	assert.False(t, user.Msg.Equal(other.Msg))
+	epoch1, err := client.GetEpoch(el.List[1])
+	log.ErrFatal(err)
+	_, err = client.SendToGuard(el.List[1], []byte("USER"), epoch1, msg)
+	log.ErrFatal(err)
+
+	// The lockout is persisted at once, the other attempts in batches
+	g := local.GetServices(hosts, guardService)[0].(*Guard)
+	loaded := &Guard{ServiceProcessor: g.ServiceProcessor, path: g.path}
+	log.ErrFatal(loaded.tryLoad())
+	assert.NotEqual(t, int64(0), loaded.attempts["USER"].LockedUntil)
+	_, ok := loaded.attempts["OTHER"]
+	assert.False(t, ok)
+
+	time.Sleep(LockoutDuration)
+	_, err = client.SendToGuard(el.List[0], []byte("USER"), epoch, msg)
+	log.ErrFatal(err)
+}
+
+func TestServiceGuardPruneAttempts(t *testing.T) {
+	local := sda.NewLocalTest()
+	hosts, el, _ := local.GenTree(1, false, true, false)
+	defer local.CloseAll()
+	g := local.GetServices(hosts, guardService)[0].(*Guard)
+
+	client := NewClient()
+	epoch, err := client.GetEpoch(el.List[0])
+	log.ErrFatal(err)
+	_, err = client.SendToGuard(el.List[0], []byte("USER"), epoch,
+		network.Suite.Point())
+	log.ErrFatal(err)
+
+	// Once the window lapsed, the attempts are removed
+	g.attemptsMutex.Lock()
+	assert.Equal(t, 1, len(g.attempts))
+	g.pruneAttempts(time.Now())
+	assert.Equal(t, 1, len(g.attempts))
+	g.pruneAttempts(time.Now().Add(AttemptWindow + time.Second))
+	assert.Equal(t, 0, len(g.attempts))
+
+	// Every request prunes the lapsed attempts of the other UIDs
+	g.attempts["OLD"] = &Attempts{Count: 1,
+		WindowStart: time.Now().Add(-AttemptWindow - time.Second).UnixNano()}
+	g.attemptsMutex.Unlock()
+	_, err = client.SendToGuard(el.List[0], []byte("USER"), epoch,
+		network.Suite.Point())
+	log.ErrFatal(err)
+	g.attemptsMutex.Lock()
+	defer g.attemptsMutex.Unlock()
+	_, ok := g.attempts["OLD"]
+	assert.False(t, ok)
+	assert.Equal(t, 1, len(g.attempts))
+}
+
+func TestServiceGuardRotate(t *testing.T) {
+	local := sda.NewLocalTest()
+	hosts, el, _ := local.GenTree(1, false, true, false)
+	defer local.CloseAll()
+	private := local.GetPrivate(hosts[0])
+
+	client := NewClient()
+	uid := []byte("USER")
+	msg := network.Suite.Point().Pick(nil, random.Stream)
+	epoch1, err := client.GetEpoch(el.List[0])
+	log.ErrFatal(err)
+	rep1, err := client.SendToGuard(el.List[0], uid, epoch1, msg)
+	log.ErrFatal(err)
+
+	// Only the operator can rotate
+	_, err = client.sendEpoch(el.List[0], &Rotate{})
+	assert.NotNil(t, err, "Rotation without signature")
+	other, _ := sda.PrivPub()
+	_, err = client.Rotate(el.List[0], other)
+	assert.NotNil(t, err, "Rotation with wrong key")
+	epoch2, err := client.Rotate(el.List[0], private)
+	log.ErrFatal(err)
+	assert.NotEqual(t, epoch1, epoch2)
+	actual, err := client.GetEpoch(el.List[0])
+	log.ErrFatal(err)
+	assert.Equal(t, epoch2, actual)
+
+	// The old epoch still works and gives the same result, the new one
+	// uses another key.
+	rep, err := client.SendToGuard(el.List[0], uid, epoch1, msg)
+	log.ErrFatal(err)
+	assert.True(t, rep1.Msg.Equal(rep.Msg))
+	rep2, err := client.SendToGuard(el.List[0], uid, epoch2, msg)
+	log.ErrFatal(err)
+	assert.False(t, rep1.Msg.Equal(rep2.Msg))
+
+	// The token moves the responses of the new epoch to the old one
+	_, err = client.Token(el.List[0], other, uid, epoch1)
+	assert.NotNil(t, err, "Token with wrong key")
+	token, err := client.Token(el.List[0], private, uid, epoch1)
+	log.ErrFatal(err)
+	assert.Equal(t, epoch2, token.To)
+	assert.True(t, rep1.Msg.Equal(network.Suite.Point().Mul(rep2.Msg, token.Token)))
+	// The token is specific to the UID
+	token, err = client.Token(el.List[0], private, []byte("OTHER"), epoch1)
+	log.ErrFatal(err)
+	assert.False(t, rep1.Msg.Equal(network.Suite.Point().Mul(rep2.Msg, token.Token)))
+
+	// The keys are persisted
+	g := local.GetServices(hosts, guardService)[0].(*Guard)
+	loaded := &Guard{ServiceProcessor: g.ServiceProcessor, path: g.path}
+	log.ErrFatal(loaded.tryLoad())
+	assert.Equal(t, g.Keys, loaded.Keys)
+
+	// Further rotations keep the old keys until they are retired
+	_, err = client.Rotate(el.List[0], private)
+	log.ErrFatal(err)
+	rep, err = client.SendToGuard(el.List[0], uid, epoch1, msg)
+	log.ErrFatal(err)
+	assert.True(t, rep1.Msg.Equal(rep.Msg))
+	assert.NotNil(t, client.Retire(el.List[0], other, epoch1))
+	log.ErrFatal(client.Retire(el.List[0], private, epoch1))
+	_, err = client.SendToGuard(el.List[0], uid, epoch1, msg)
+	assert.NotNil(t, err)
+	rep, err = client.SendToGuard(el.List[0], uid, epoch2, msg)
+	log.ErrFatal(err)
+	assert.True(t, rep2.Msg.Equal(rep.Msg))
+	actual, err = client.GetEpoch(el.List[0])
+	log.ErrFatal(err)
+	assert.NotNil(t, client.Retire(el.List[0], private, actual),
+		"Retiring the actual epoch")
 }
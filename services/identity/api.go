@@ -1,6 +1,7 @@
 package identity
 
 import (
+	"bytes"
 	"errors"
 	"io"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/network"
 	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/skipchain"
 	"github.com/dedis/crypto/abstract"
 	"github.com/dedis/crypto/config"
 )
@@ -38,6 +40,7 @@ func init() {
 		&ProposeUpdateReply{},
 		&ProposeVote{},
 		&ProposeVoteReply{},
+		&Subscribe{},
 		// Internal messages
 		&PropagateIdentity{},
 		&UpdateSkipBlock{},
@@ -60,9 +63,19 @@ type Identity struct {
 	ID ID
 	// Config is the actual, valid configuration of the identity-skipchain.
 	Config *Config
+	// Data is the latest known block of the data-skipchain. New blocks
+	// pushed to a subscription have to link to it.
+	Data *skipchain.SkipBlock
 	// Proposed is the new configuration that has not been validated by a
 	// threshold of devices.
 	Proposed *Config
+	// ProposedAccepted and ProposedRejected are the devices that already
+	// voted on the proposed configuration, as of the last ProposeUpdate.
+	ProposedAccepted []string
+	ProposedRejected []string
+	// NewPrivate is the private key of a proposed key-rotation. It replaces
+	// Private once the rotation has been accepted.
+	NewPrivate abstract.Scalar
 	// DeviceName must be unique in the identity-skipchain.
 	DeviceName string
 	// Cothority is the roster responsible for the identity-skipchain. It
@@ -159,6 +172,7 @@ func (i *Identity) CreateIdentity() error {
 	}
 	air := msg.Msg.(CreateIdentityReply)
 	i.ID = ID(air.Data.Hash)
+	i.Data = air.Data
 
 	return nil
 }
@@ -166,11 +180,50 @@ func (i *Identity) CreateIdentity() error {
 // ProposeSend sends the new proposition of this identity
 // ProposeVote
 func (i *Identity) ProposeSend(il *Config) error {
+	if i.Config != nil && il.removesDevice(i.Config) {
+		il.capThreshold()
+	}
 	_, err := i.Send(i.Cothority.RandomServerIdentity(), &ProposeSend{i.ID, il})
 	i.Proposed = il
+	i.ProposedAccepted = nil
+	i.ProposedRejected = nil
 	return err
 }
 
+// ProposeRemoveDevice proposes a new configuration without the given device.
+func (i *Identity) ProposeRemoveDevice(name string) error {
+	prop := i.GetProposed()
+	if err := prop.RemoveDevice(name); err != nil {
+		return err
+	}
+	return i.ProposeSend(prop)
+}
+
+// ProposeRotateKey proposes a new key for this device. The new private key
+// is only used once the proposition has been accepted.
+func (i *Identity) ProposeRotateKey() error {
+	kp := config.NewKeyPair(network.Suite)
+	prop := i.GetProposed()
+	if err := prop.RotateDevice(i.DeviceName, kp.Public); err != nil {
+		return err
+	}
+	if err := i.ProposeSend(prop); err != nil {
+		return err
+	}
+	i.NewPrivate = kp.Secret
+	return nil
+}
+
+// ProposeLink proposes to add the identity id as a voter with the given name.
+// All devices of the linked identity share one vote.
+func (i *Identity) ProposeLink(name string, id ID) error {
+	prop := i.GetProposed()
+	if err := prop.AddLink(name, id); err != nil {
+		return err
+	}
+	return i.ProposeSend(prop)
+}
+
 // ProposeUpdate verifies if there is a new configuration awaiting that
 // needs approval from clients
 func (i *Identity) ProposeUpdate() error {
@@ -182,19 +235,20 @@ func (i *Identity) ProposeUpdate() error {
 	}
 	cnc := msg.Msg.(ProposeUpdateReply)
 	i.Proposed = cnc.Propose
+	i.ProposedAccepted = cnc.Accepted
+	i.ProposedRejected = cnc.Rejected
 	return nil
 }
 
-// ProposeVote calls the 'accept'-vote on the current propose-configuration
+// ProposeVote sends a signed 'accept'- or 'reject'-vote on the current
+// propose-configuration. If the proposition gets discarded because of too
+// many rejections, Proposed is set to nil.
 func (i *Identity) ProposeVote(accept bool) error {
 	if i.Proposed == nil {
 		return errors.New("No proposed config")
 	}
 	log.Lvlf3("Voting %t on %s", accept, i.Proposed.Device)
-	if !accept {
-		return nil
-	}
-	hash, err := i.Proposed.Hash()
+	hash, err := i.Proposed.VoteHash(accept)
 	if err != nil {
 		return err
 	}
@@ -205,23 +259,61 @@ func (i *Identity) ProposeVote(accept bool) error {
 	msg, err := i.Send(i.Cothority.RandomServerIdentity(), &ProposeVote{
 		ID:        i.ID,
 		Signer:    i.DeviceName,
+		Accept:    accept,
 		Signature: &sig,
 	})
 	err = sda.ErrMsg(msg, err)
 	if err != nil {
 		return err
 	}
-	_, ok := msg.Msg.(ProposeVoteReply)
-	if ok {
+	reply, ok := msg.Msg.(ProposeVoteReply)
+	if ok && reply.Data == nil {
+		log.Lvl2("Proposition has been rejected")
+		i.Proposed = nil
+	} else if ok {
 		log.Lvl2("Threshold reached and signed")
 		i.Config = i.Proposed
 		i.Proposed = nil
+		i.updateKey()
 	} else {
 		log.Lvl2("Threshold not reached")
 	}
 	return nil
 }
 
+// ProposeVoteLinked votes on the propose-configuration of the identity id,
+// where this identity is linked under the name link. The vote of this device
+// only counts once a threshold of devices of this identity voted.
+func (i *Identity) ProposeVoteLinked(id ID, link string, accept bool) error {
+	msg, err := i.Send(i.Cothority.RandomServerIdentity(), &ProposeUpdate{
+		ID: id,
+	})
+	if err != nil {
+		return err
+	}
+	prop := msg.Msg.(ProposeUpdateReply).Propose
+	if prop == nil {
+		return errors.New("No proposed config")
+	}
+	log.Lvlf3("Voting %t as %s/%s on %s", accept, link, i.DeviceName, prop.Device)
+	hash, err := prop.VoteHash(accept)
+	if err != nil {
+		return err
+	}
+	sig, err := crypto.SignSchnorr(network.Suite, i.Private, hash)
+	if err != nil {
+		return err
+	}
+	msg, err = i.Send(i.Cothority.RandomServerIdentity(), &ProposeVote{
+		ID:        id,
+		Signer:    link,
+		Member:    i.DeviceName,
+		Accept:    accept,
+		Signature: &sig,
+	})
+	return sda.ErrMsg(msg, err)
+}
+
 // ConfigUpdate asks if there is any new config available that has already
 // been approved by others and updates the local configuration
 func (i *Identity) ConfigUpdate() error {
@@ -235,5 +327,95 @@ func (i *Identity) ConfigUpdate() error {
 	cu := msg.Msg.(ConfigUpdateReply)
 	// TODO - verify new config
 	i.Config = cu.Config
+	i.Data = cu.Data
+	i.updateKey()
 	return nil
 }
+
+// Subscribe asks a node of the cothority to send every new configuration of
+// this identity. For each new configuration that is signed by the cothority
+// and follows the known block, Config is updated and fn is called. It blocks
+// until fn returns false or the connection fails.
+func (i *Identity) Subscribe(fn func(*Config) bool) error {
+	if i.Cothority == nil || len(i.Cothority.List) == 0 {
+		return errors.New("Didn't find any list in the cothority")
+	}
+	return i.Stream(i.Cothority.RandomServerIdentity(), &Subscribe{[]ID{i.ID}},
+		func(p *network.Packet) bool {
+			usb, ok := p.Msg.(UpdateSkipBlock)
+			if !ok || !bytes.Equal(usb.ID, i.ID) {
+				log.Lvl2("Got unknown message", p.MsgType)
+				return true
+			}
+			if err := i.verifyUpdate(usb.Latest); err != nil {
+				log.Error("Refusing update:", err)
+				return true
+			}
+			_, msg, err := network.UnmarshalRegistered(usb.Latest.Data)
+			if err != nil {
+				log.Error(err)
+				return true
+			}
+			conf, ok := msg.(*Config)
+			if !ok {
+				log.Error("Got wrong data in skipblock")
+				return true
+			}
+			i.Config = conf
+			i.Data = usb.Latest
+			i.updateKey()
+			return fn(conf)
+		})
+}
+
+// verifyUpdate returns nil if sb is a block of the data-skipchain signed by
+// the roster of the known block that links back to it. As the updates are
+// sent concurrently, older blocks may arrive after newer ones and are
+// refused.
+func (i *Identity) verifyUpdate(sb *skipchain.SkipBlock) error {
+	if i.Data == nil {
+		return errors.New("No known block to verify the update")
+	}
+	if sb == nil {
+		return errors.New("Update without block")
+	}
+	if err := sb.VerifySignatures(); err != nil {
+		return err
+	}
+	if sb.Index <= i.Data.Index {
+		return errors.New("Update is not newer than the known block")
+	}
+	if sb.Roster == nil || len(sb.Roster.List) != len(i.Data.Roster.List) {
+		return errors.New("Update signed by another roster")
+	}
+	for n, si := range sb.Roster.List {
+		if !si.Public.Equal(i.Data.Roster.List[n].Public) {
+			return errors.New("Update signed by another roster")
+		}
+	}
+	for _, id := range sb.BackLinkIds {
+		if id.Equal(i.Data.Hash) {
+			return nil
+		}
+	}
+	return errors.New("Update doesn't link to the known block")
+}
+
+// updateKey replaces the private key with NewPrivate once the configuration
+// holds the rotated key.
+func (i *Identity) updateKey() {
+	if i.NewPrivate == nil || i.Config == nil {
+		return
+	}
+	dev, ok := i.Config.Device[i.DeviceName]
+	if !ok {
+		return
+	}
+	pub := network.Suite.Point().Mul(nil, i.NewPrivate)
+	if dev.Point.Equal(pub) {
+		log.Lvl2("Key rotation accepted for", i.DeviceName)
+		i.Private = i.NewPrivate
+		i.Public = pub
+		i.NewPrivate = nil
+	}
+}
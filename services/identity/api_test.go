@@ -5,10 +5,13 @@ import (
 
 	"io/ioutil"
 	"os"
+	"time"
 
+	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/network"
 	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/skipchain"
 	"github.com/dedis/crypto/config"
 	"github.com/stretchr/testify/assert"
 )
@@ -149,6 +152,294 @@ func TestIdentity_ProposeVote(t *testing.T) {
 	}
 }
 
+func TestIdentity_ProposeVoteReject(t *testing.T) {
+	l := sda.NewLocalTest()
+	_, el, _ := l.GenTree(3, true, true, true)
+	defer l.CloseAll()
+
+	c1 := NewIdentity(el, 2, "one")
+	c2 := NewIdentity(el, 2, "two")
+	c3 := NewIdentity(el, 2, "three")
+	log.ErrFatal(c1.CreateIdentity())
+	log.ErrFatal(c2.AttachToIdentity(c1.ID))
+	proposeUpVote(c1)
+	log.ErrFatal(c3.AttachToIdentity(c1.ID))
+	proposeUpVote(c1)
+	proposeUpVote(c2)
+	log.ErrFatal(c1.ConfigUpdate())
+	assert.Equal(t, 3, len(c1.Config.Device))
+
+	conf := c1.Config.Copy()
+	conf.Data["key"] = "value"
+	log.ErrFatal(c1.ProposeSend(conf))
+	proposeUpVote(c1)
+	log.ErrFatal(c2.ProposeUpdate())
+	assert.Equal(t, []string{"one"}, c2.ProposedAccepted)
+	assert.Equal(t, 0, len(c2.ProposedRejected))
+
+	// A rejection alone doesn't discard the proposition.
+	log.ErrFatal(c2.ProposeVote(false))
+	assert.NotNil(t, c2.Proposed)
+	log.ErrFatal(c3.ProposeUpdate())
+	assert.Equal(t, []string{"two"}, c3.ProposedRejected)
+
+	// The second rejection makes the threshold unreachable.
+	log.ErrFatal(c3.ProposeVote(false))
+	assert.Nil(t, c3.Proposed)
+	log.ErrFatal(c1.ProposeUpdate())
+	assert.Nil(t, c1.Proposed)
+	log.ErrFatal(c1.ConfigUpdate())
+	assert.Equal(t, "", c1.Config.Data["key"])
+}
+
+func TestIdentity_ProposeVoteWrongSignature(t *testing.T) {
+	l := sda.NewLocalTest()
+	hosts, el, _ := l.GenTree(3, true, true, true)
+	services := l.GetServices(hosts, identityService)
+	defer l.CloseAll()
+
+	c1 := NewIdentity(el, 2, "one")
+	log.ErrFatal(c1.CreateIdentity())
+	conf := c1.Config.Copy()
+	conf.Data["key"] = "value"
+	log.ErrFatal(c1.ProposeSend(conf))
+
+	// A signature on the reject-hash can't be used to accept.
+	hash, err := conf.VoteHash(false)
+	log.ErrFatal(err)
+	sig, err := crypto.SignSchnorr(network.Suite, c1.Private, hash)
+	log.ErrFatal(err)
+	_, err = services[0].(*Service).ProposeVote(nil, &ProposeVote{
+		ID:        c1.ID,
+		Signer:    "one",
+		Accept:    true,
+		Signature: &sig,
+	})
+	assert.NotNil(t, err)
+	_, err = services[0].(*Service).ProposeVote(nil, &ProposeVote{
+		ID:     c1.ID,
+		Signer: "one",
+		Accept: false,
+	})
+	assert.NotNil(t, err)
+}
+
+func TestIdentity_ProposalTimeout(t *testing.T) {
+	defer func(timeout time.Duration) {
+		ProposalTimeout = timeout
+	}(ProposalTimeout)
+	ProposalTimeout = 100 * time.Millisecond
+
+	l := sda.NewLocalTest()
+	_, el, _ := l.GenTree(3, true, true, true)
+	defer l.CloseAll()
+
+	c1 := NewIdentity(el, 2, "one")
+	log.ErrFatal(c1.CreateIdentity())
+	conf := c1.Config.Copy()
+	conf.Data["key"] = "value"
+	log.ErrFatal(c1.ProposeSend(conf))
+	log.ErrFatal(c1.ProposeUpdate())
+	assert.NotNil(t, c1.Proposed)
+
+	time.Sleep(2 * ProposalTimeout)
+	if c1.ProposeVote(true) == nil {
+		t.Fatal("Should not be able to vote on expired proposal")
+	}
+	log.ErrFatal(c1.ProposeUpdate())
+	assert.Nil(t, c1.Proposed)
+}
+
+func TestIdentity_ProposeRemoveDevice(t *testing.T) {
+	l := sda.NewLocalTest()
+	_, el, _ := l.GenTree(3, true, true, true)
+	defer l.CloseAll()
+
+	c1 := NewIdentity(el, 2, "one")
+	c2 := NewIdentity(el, 2, "two")
+	log.ErrFatal(c1.CreateIdentity())
+	log.ErrFatal(c2.AttachToIdentity(c1.ID))
+	proposeUpVote(c1)
+	log.ErrFatal(c1.ConfigUpdate())
+	assert.Equal(t, 2, len(c1.Config.Device))
+
+	log.ErrFatal(c1.ProposeRemoveDevice("two"))
+	log.ErrFatal(c1.ProposeVote(true))
+	proposeUpVote(c2)
+	log.ErrFatal(c1.ConfigUpdate())
+	assert.Equal(t, 1, len(c1.Config.Device))
+	assert.Equal(t, 1, c1.Config.Threshold)
+
+	// The removed device can't vote anymore.
+	conf := c1.Config.Copy()
+	conf.Data["key"] = "value"
+	log.ErrFatal(c1.ProposeSend(conf))
+	log.ErrFatal(c2.ProposeUpdate())
+	if c2.ProposeVote(true) == nil {
+		t.Fatal("Removed device should not be able to vote")
+	}
+	assert.NotNil(t, c1.ProposeRemoveDevice("one"))
+}
+
+func TestIdentity_ProposeRotateKey(t *testing.T) {
+	l := sda.NewLocalTest()
+	_, el, _ := l.GenTree(3, true, true, true)
+	defer l.CloseAll()
+
+	c1 := NewIdentity(el, 2, "one")
+	c2 := NewIdentity(el, 2, "two")
+	log.ErrFatal(c1.CreateIdentity())
+	log.ErrFatal(c2.AttachToIdentity(c1.ID))
+	proposeUpVote(c1)
+
+	oldPublic := c2.Public
+	log.ErrFatal(c2.ConfigUpdate())
+	log.ErrFatal(c2.ProposeUpdate())
+	log.ErrFatal(c2.ProposeRotateKey())
+	assert.NotNil(t, c2.NewPrivate)
+	proposeUpVote(c2)
+	assert.True(t, c2.Public.Equal(oldPublic), "Key rotated before the vote passed")
+	proposeUpVote(c1)
+	log.ErrFatal(c2.ConfigUpdate())
+	assert.Nil(t, c2.NewPrivate)
+	assert.False(t, c2.Public.Equal(oldPublic))
+	assert.True(t, c2.Config.Device["two"].Point.Equal(c2.Public))
+
+	// Only the new key is valid for votes.
+	conf := c2.Config.Copy()
+	conf.Data["key"] = "value"
+	log.ErrFatal(c2.ProposeSend(conf))
+	log.ErrFatal(c2.ProposeVote(true))
+}
+
+func TestIdentity_ProposeVoteLinked(t *testing.T) {
+	l := sda.NewLocalTest()
+	_, el, _ := l.GenTree(3, true, true, true)
+	defer l.CloseAll()
+
+	boss := NewIdentity(el, 2, "boss")
+	log.ErrFatal(boss.CreateIdentity())
+	c1 := NewIdentity(el, 2, "c1")
+	c2 := NewIdentity(el, 2, "c2")
+	log.ErrFatal(c1.CreateIdentity())
+	log.ErrFatal(c2.AttachToIdentity(c1.ID))
+	proposeUpVote(c1)
+	log.ErrFatal(c2.ConfigUpdate())
+
+	if boss.ProposeLink("self", boss.ID) == nil {
+		t.Fatal("Should not be able to link to itself")
+	}
+	log.ErrFatal(boss.ProposeUpdate())
+	log.ErrFatal(boss.ProposeLink("team", c1.ID))
+	log.ErrFatal(boss.ProposeVote(true))
+	log.ErrFatal(boss.ConfigUpdate())
+	assert.Equal(t, 1, len(boss.Config.Link))
+
+	conf := boss.Config.Copy()
+	conf.Data["key"] = "value"
+	log.ErrFatal(boss.ProposeSend(conf))
+	log.ErrFatal(boss.ProposeVote(true))
+	if c1.ProposeVoteLinked(boss.ID, "other", true) == nil {
+		t.Fatal("Should not vote for unknown link")
+	}
+	log.ErrFatal(c1.ProposeVoteLinked(boss.ID, "team", true))
+	if c1.ProposeVoteLinked(boss.ID, "team", true) == nil {
+		t.Fatal("Should not vote twice")
+	}
+	log.ErrFatal(boss.ConfigUpdate())
+	assert.Equal(t, "", boss.Config.Data["key"],
+		"One device of the linked identity is not enough")
+
+	log.ErrFatal(c2.ProposeVoteLinked(boss.ID, "team", true))
+	log.ErrFatal(boss.ConfigUpdate())
+	assert.Equal(t, "value", boss.Config.Data["key"])
+}
+
+func TestIdentity_ProposeVoteLinkedReject(t *testing.T) {
+	l := sda.NewLocalTest()
+	_, el, _ := l.GenTree(3, true, true, true)
+	defer l.CloseAll()
+
+	boss := NewIdentity(el, 2, "boss")
+	log.ErrFatal(boss.CreateIdentity())
+	c1 := NewIdentity(el, 1, "c1")
+	log.ErrFatal(c1.CreateIdentity())
+	log.ErrFatal(boss.ProposeLink("team", c1.ID))
+	log.ErrFatal(boss.ProposeVote(true))
+	log.ErrFatal(boss.ConfigUpdate())
+
+	conf := boss.Config.Copy()
+	conf.Data["key"] = "value"
+	log.ErrFatal(boss.ProposeSend(conf))
+	log.ErrFatal(c1.ProposeVoteLinked(boss.ID, "team", false))
+	log.ErrFatal(boss.ProposeUpdate())
+	assert.Nil(t, boss.Proposed)
+}
+
+func TestIdentity_Subscribe(t *testing.T) {
+	l := sda.NewLocalTest()
+	hosts, el, _ := l.GenTree(3, true, true, true)
+	services := l.GetServices(hosts, identityService)
+	defer l.CloseAll()
+
+	c1 := NewIdentity(el, 2, "one")
+	log.ErrFatal(c1.CreateIdentity())
+	follower, err := NewIdentityFromCothority(el, c1.ID)
+	log.ErrFatal(err)
+
+	configs := make(chan *Config, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- follower.Subscribe(func(c *Config) bool {
+			configs <- c
+			return false
+		})
+	}()
+	// Wait for the subscription to be registered.
+	for subscribed := false; !subscribed; {
+		for _, s := range services {
+			is := s.(*Service)
+			is.subscribersMutex.Lock()
+			subscribed = subscribed || len(is.subscribers[string(c1.ID)]) > 0
+			is.subscribersMutex.Unlock()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conf := c1.Config.Copy()
+	conf.Data["key"] = "value"
+	log.ErrFatal(c1.ProposeSend(conf))
+	log.ErrFatal(c1.ProposeVote(true))
+	select {
+	case c := <-configs:
+		assert.Equal(t, "value", c.Data["key"])
+		assert.Equal(t, "value", follower.Config.Data["key"])
+	case <-time.After(10 * time.Second):
+		t.Fatal("Didn't get the new config")
+	}
+	log.ErrFatal(<-done)
+
+	// Only signed blocks that follow the known block are accepted
+	latest := follower.Data
+	assert.Equal(t, 1, latest.Index)
+	assert.NotNil(t, follower.verifyUpdate(nil))
+	assert.NotNil(t, follower.verifyUpdate(latest), "Replayed block")
+	assert.NotNil(t, follower.verifyUpdate(c1.Data), "Older block")
+	forged := latest.Copy()
+	forged.Index++
+	forged.BackLinkIds = []skipchain.SkipBlockID{latest.Hash}
+	assert.NotNil(t, follower.verifyUpdate(forged), "Block with reused signature")
+
+	unknown := &Identity{
+		Client:    sda.NewClient(ServiceName),
+		Cothority: el,
+		ID:        ID{1, 2, 3},
+	}
+	if unknown.Subscribe(nil) == nil {
+		t.Fatal("Should not subscribe to unknown identity")
+	}
+}
+
 func TestIdentity_SaveToStream(t *testing.T) {
 	l := sda.NewLocalTest()
 	_, el, _ := l.GenTree(5, true, true, true)
@@ -12,6 +12,7 @@ collective signatures and be assured that the blockchain is valid.
 package identity
 
 import (
+	"bytes"
 	"errors"
 
 	"sync"
@@ -24,12 +25,17 @@ import (
 
 	"fmt"
 
+	"sort"
+
+	"time"
+
 	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/network"
 	"github.com/dedis/cothority/protocols/manage"
 	"github.com/dedis/cothority/sda"
 	"github.com/dedis/cothority/services/skipchain"
+	"github.com/dedis/crypto/abstract"
 )
 
 // ServiceName can be used to refer to the name of this service
@@ -37,6 +43,10 @@ const ServiceName = "Identity"
 
 var identityService sda.ServiceID
 
+// ProposalTimeout is how long a proposed configuration stays open for votes.
+// Once it is elapsed, the proposition is discarded.
+var ProposalTimeout = 24 * time.Hour
+
 func init() {
 	sda.RegisterNewService(ServiceName, newIdentityService)
 	identityService = sda.ServiceFactory.ServiceID(ServiceName)
@@ -51,6 +61,10 @@ type Service struct {
 	identitiesMutex sync.Mutex
 	skipchain       *skipchain.Client
 	path            string
+	// subscribers holds the clients to notify of new skipblocks, per
+	// identity.
+	subscribers      map[string][]*network.ServerIdentity
+	subscribersMutex sync.Mutex
 }
 
 // StorageMap holds the map to the storages so it can be marshaled.
@@ -63,9 +77,92 @@ type Storage struct {
 	sync.Mutex
 	Latest   *Config
 	Proposed *Config
-	Votes    map[string]*crypto.SchnorrSig
-	Root     *skipchain.SkipBlock
-	Data     *skipchain.SkipBlock
+	// Votes and Rejects hold the signatures of the devices accepting,
+	// respectively rejecting the proposed configuration. Votes of devices
+	// of linked identities are stored as 'link/device'.
+	Votes   map[string]*crypto.SchnorrSig
+	Rejects map[string]*crypto.SchnorrSig
+	// ProposedAt is the time of the proposition in nanoseconds since the
+	// epoch.
+	ProposedAt int64
+	Root       *skipchain.SkipBlock
+	Data       *skipchain.SkipBlock
+}
+
+// proposalExpired returns true if there is a proposed configuration that is
+// older than ProposalTimeout.
+func (sid *Storage) proposalExpired() bool {
+	return sid.Proposed != nil &&
+		time.Since(time.Unix(0, sid.ProposedAt)) > ProposalTimeout
+}
+
+// discardProposal removes the proposed configuration together with all
+// votes.
+func (sid *Storage) discardProposal() {
+	sid.Proposed = nil
+	sid.Votes = make(map[string]*crypto.SchnorrSig)
+	sid.Rejects = make(map[string]*crypto.SchnorrSig)
+}
+
+// countVotes returns how many voters of the latest configuration accepted and
+// rejected the proposition. links holds the latest configurations of the
+// linked identities. A linked identity accepts once a threshold of its
+// devices accepted, and rejects once its threshold can't be reached anymore.
+func (sid *Storage) countVotes(links map[string]*Config) (accepted, rejected int) {
+	for n := range sid.Latest.Device {
+		if _, ok := sid.Votes[n]; ok {
+			accepted++
+		} else if _, ok := sid.Rejects[n]; ok {
+			rejected++
+		}
+	}
+	for n := range sid.Latest.Link {
+		child, ok := links[n]
+		if !ok {
+			continue
+		}
+		var acc, rej int
+		for dev := range child.Device {
+			if _, ok := sid.Votes[voteKey(n, dev)]; ok {
+				acc++
+			} else if _, ok := sid.Rejects[voteKey(n, dev)]; ok {
+				rej++
+			}
+		}
+		// Only the devices of a linked identity can vote for it.
+		needed := child.Threshold
+		if needed > len(child.Device) {
+			needed = len(child.Device)
+		}
+		if acc >= needed {
+			accepted++
+		} else if len(child.Device)-rej < needed {
+			rejected++
+		}
+	}
+	return
+}
+
+// voteKey returns the key under which the vote of a signer is stored.
+func voteKey(signer, member string) string {
+	if member == "" {
+		return signer
+	}
+	return signer + "/" + member
+}
+
+// tally returns the sorted names of the devices that accepted and rejected
+// the proposed configuration.
+func (sid *Storage) tally() (accepted, rejected []string) {
+	for n := range sid.Votes {
+		accepted = append(accepted, n)
+	}
+	for n := range sid.Rejects {
+		rejected = append(rejected, n)
+	}
+	sort.Strings(accepted)
+	sort.Strings(rejected)
+	return
 }
 
 // NewProtocol is called by the Overlay when a new protocol request comes in.
@@ -137,17 +234,35 @@ func (s *Service) ConfigUpdate(si *network.ServerIdentity, cu *ConfigUpdate) (ne
 	log.Lvl3(s, "Sending config-update")
 	return &ConfigUpdateReply{
 		Config: sid.Latest,
+		Data:   sid.Data,
 	}, nil
 }
 
 // ProposeSend only stores the proposed configuration internally. Signatures
-// come later.
+// come later. If the proposition removes devices, its threshold is capped to
+// the number of remaining devices.
 func (s *Service) ProposeSend(si *network.ServerIdentity, p *ProposeSend) (network.Body, error) {
 	log.Lvl2(s, "Storing new proposal")
 	sid := s.getIdentityStorage(p.ID)
 	if sid == nil {
 		return nil, errors.New("Didn't find Identity")
 	}
+	if p.Config == nil || p.voters() == 0 {
+		return nil, errors.New("Proposition needs at least one device")
+	}
+	for n, id := range p.Link {
+		if bytes.Equal(id, p.ID) {
+			return nil, errors.New("Cannot link identity to itself")
+		}
+		if s.getIdentityStorage(id) == nil {
+			return nil, errors.New("Didn't find linked identity " + n)
+		}
+	}
+	sid.Lock()
+	if p.removesDevice(sid.Latest) {
+		p.capThreshold()
+	}
+	sid.Unlock()
 	roster := sid.Root.Roster
 	replies, err := manage.PropagateStartAndWait(s.Context, roster,
 		p, 120000, s.Propagate)
@@ -169,14 +284,24 @@ func (s *Service) ProposeUpdate(si *network.ServerIdentity, cnc *ProposeUpdate)
 	}
 	sid.Lock()
 	defer sid.Unlock()
+	if sid.proposalExpired() {
+		log.Lvl2(s, "Discarding expired proposal")
+		sid.discardProposal()
+	}
+	accepted, rejected := sid.tally()
 	return &ProposeUpdateReply{
-		Propose: sid.Proposed,
+		Propose:  sid.Proposed,
+		Accepted: accepted,
+		Rejected: rejected,
 	}, nil
 }
 
-// ProposeVote takes int account a vote for the proposed config. It also verifies
-// that the voter is in the latest config.
-// An empty signature signifies that the vote has been rejected.
+// ProposeVote takes into account a vote for the proposed config. It also
+// verifies that the voter is in the latest config and that the signature
+// matches the vote. The vote of a device of a linked identity is verified
+// against the latest config of that identity. If enough voters rejected the
+// proposition so that the threshold can't be reached anymore, the
+// proposition is discarded.
 func (s *Service) ProposeVote(si *network.ServerIdentity, v *ProposeVote) (network.Body, error) {
 	log.Lvl2(s, "Voting on proposal")
 	// First verify if the signature is legitimate
@@ -184,33 +309,59 @@ func (s *Service) ProposeVote(si *network.ServerIdentity, v *ProposeVote) (netwo
 	if sid == nil {
 		return nil, errors.New("Didn't find identity")
 	}
+	links := s.linkConfigs(sid)
 
 	// Putting this in a function because of the lock which needs to be held
 	// over all calls that might return an error.
 	err := func() error {
 		sid.Lock()
 		defer sid.Unlock()
-		log.Lvl3("Voting on", sid.Proposed.Device)
-		owner, ok := sid.Latest.Device[v.Signer]
-		if !ok {
-			return errors.New("Didn't find signer")
-		}
 		if sid.Proposed == nil {
 			return errors.New("No proposed block")
 		}
-		hash, err := sid.Proposed.Hash()
-		if err != nil {
-			return errors.New("Couldn't get hash")
+		if sid.proposalExpired() {
+			sid.discardProposal()
+			return errors.New("Proposed block expired")
+		}
+		log.Lvl3("Voting on", sid.Proposed.Device)
+		var pub abstract.Point
+		if v.Member == "" {
+			owner, ok := sid.Latest.Device[v.Signer]
+			if !ok {
+				return errors.New("Didn't find signer")
+			}
+			pub = owner.Point
+		} else {
+			if _, ok := sid.Latest.Link[v.Signer]; !ok {
+				return errors.New("Didn't find linked identity")
+			}
+			child, ok := links[v.Signer]
+			if !ok {
+				return errors.New("Couldn't resolve linked identity")
+			}
+			member, ok := child.Device[v.Member]
+			if !ok {
+				return errors.New("Didn't find signer in linked identity")
+			}
+			pub = member.Point
 		}
-		if _, exists := sid.Votes[v.Signer]; exists {
+		if v.Signature == nil {
+			return errors.New("Missing signature")
+		}
+		key := voteKey(v.Signer, v.Member)
+		_, accepted := sid.Votes[key]
+		_, rejected := sid.Rejects[key]
+		if accepted || rejected {
 			return errors.New("Already voted for that block")
 		}
-		log.Lvl3(v.Signer, "voted", v.Signature)
-		if v.Signature != nil {
-			err = crypto.VerifySchnorr(network.Suite, owner.Point, hash, *v.Signature)
-			if err != nil {
-				return errors.New("Wrong signature: " + err.Error())
-			}
+		hash, err := sid.Proposed.VoteHash(v.Accept)
+		if err != nil {
+			return errors.New("Couldn't get hash")
+		}
+		log.Lvl3(v.Signer, "voted", v.Accept, v.Signature)
+		err = crypto.VerifySchnorr(network.Suite, pub, hash, *v.Signature)
+		if err != nil {
+			return errors.New("Wrong signature: " + err.Error())
 		}
 		return nil
 	}()
@@ -223,8 +374,16 @@ func (s *Service) ProposeVote(si *network.ServerIdentity, v *ProposeVote) (netwo
 	if err != nil {
 		return nil, err
 	}
-	if len(sid.Votes) >= sid.Latest.Threshold ||
-		len(sid.Votes) == len(sid.Latest.Device) {
+	sid.Lock()
+	proposed := sid.Proposed
+	accepted, _ := sid.countVotes(links)
+	sid.Unlock()
+	if proposed == nil {
+		log.Lvl2("Proposal has been rejected")
+		s.save()
+		return &ProposeVoteReply{}, nil
+	}
+	if accepted >= sid.Latest.votesNeeded() {
 		// If we have enough signatures, make a new data-skipblock and
 		// propagate it
 		log.Lvl3("Having majority or all votes")
@@ -252,6 +411,27 @@ func (s *Service) ProposeVote(si *network.ServerIdentity, v *ProposeVote) (netwo
 	return nil, nil
 }
 
+// Subscribe registers the client to be notified of new skipblocks of the
+// given identities. The notifications are sent over the connection of the
+// request, until it is closed by the client.
+func (s *Service) Subscribe(si *network.ServerIdentity, sub *Subscribe) (network.Body, error) {
+	if len(sub.IDs) == 0 {
+		return nil, errors.New("No identity to subscribe to")
+	}
+	for _, id := range sub.IDs {
+		if s.getIdentityStorage(id) == nil {
+			return nil, errors.New("Didn't find identity")
+		}
+	}
+	s.subscribersMutex.Lock()
+	defer s.subscribersMutex.Unlock()
+	for _, id := range sub.IDs {
+		log.Lvlf3("%s subscribing %s to %x", s, si, []byte(id))
+		s.subscribers[string(id)] = append(s.subscribers[string(id)], si)
+	}
+	return nil, nil
+}
+
 /*
  * Internal messages
  */
@@ -285,16 +465,39 @@ func (s *Service) Propagate(msg network.Body) {
 			log.Error("Didn't find entity in", s)
 			return
 		}
+		var links map[string]*Config
+		if _, ok := msg.(*ProposeVote); ok {
+			links = s.linkConfigs(sid)
+		}
 		sid.Lock()
 		defer sid.Unlock()
 		switch msg.(type) {
 		case *ProposeSend:
 			p := msg.(*ProposeSend)
+			sid.discardProposal()
 			sid.Proposed = p.Config
-			sid.Votes = make(map[string]*crypto.SchnorrSig)
+			sid.ProposedAt = time.Now().UnixNano()
 		case *ProposeVote:
 			v := msg.(*ProposeVote)
-			sid.Votes[v.Signer] = v.Signature
+			if sid.Proposed == nil {
+				return
+			}
+			if sid.Votes == nil {
+				sid.Votes = make(map[string]*crypto.SchnorrSig)
+			}
+			if sid.Rejects == nil {
+				sid.Rejects = make(map[string]*crypto.SchnorrSig)
+			}
+			if v.Accept {
+				sid.Votes[voteKey(v.Signer, v.Member)] = v.Signature
+			} else {
+				sid.Rejects[voteKey(v.Signer, v.Member)] = v.Signature
+			}
+			_, rejected := sid.countVotes(links)
+			if sid.Latest.voters()-rejected < sid.Latest.votesNeeded() {
+				log.Lvl3("Too many rejections - discarding proposal")
+				sid.discardProposal()
+			}
 		case *UpdateSkipBlock:
 			skipblock := msg.(*UpdateSkipBlock).Latest
 			_, msgLatest, err := network.UnmarshalRegistered(skipblock.Data)
@@ -310,10 +513,43 @@ func (s *Service) Propagate(msg network.Body) {
 			sid.Data = skipblock
 			sid.Latest = al
 			sid.Proposed = nil
+			go s.notify(msg.(*UpdateSkipBlock))
 		}
 	}
 }
 
+// notify sends the new skipblock to all subscribers of the identity. The
+// subscribers that can't be reached anymore are removed.
+func (s *Service) notify(usb *UpdateSkipBlock) {
+	s.subscribersMutex.Lock()
+	subscribers := append([]*network.ServerIdentity{}, s.subscribers[string(usb.ID)]...)
+	s.subscribersMutex.Unlock()
+	failed := make(map[*network.ServerIdentity]bool)
+	for _, si := range subscribers {
+		if err := s.SendRaw(si, usb); err != nil {
+			log.Lvl2("Removing subscriber", si, ":", err)
+			failed[si] = true
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+	// The subscribers might have changed while sending
+	s.subscribersMutex.Lock()
+	defer s.subscribersMutex.Unlock()
+	var active []*network.ServerIdentity
+	for _, si := range s.subscribers[string(usb.ID)] {
+		if !failed[si] {
+			active = append(active, si)
+		}
+	}
+	if len(active) == 0 {
+		delete(s.subscribers, string(usb.ID))
+	} else {
+		s.subscribers[string(usb.ID)] = active
+	}
+}
+
 // getIdentityStorage returns the corresponding IdentityStorage or nil
 // if none was found
 func (s *Service) getIdentityStorage(id ID) *Storage {
@@ -326,6 +562,30 @@ func (s *Service) getIdentityStorage(id ID) *Storage {
 	return is
 }
 
+// linkConfigs returns the latest configurations of the identities linked in
+// sid. Unknown identities are left out. The locks are not nested, so that
+// identities linking each other don't deadlock.
+func (s *Service) linkConfigs(sid *Storage) map[string]*Config {
+	sid.Lock()
+	ids := make(map[string]ID, len(sid.Latest.Link))
+	for n, id := range sid.Latest.Link {
+		ids[n] = id
+	}
+	sid.Unlock()
+	links := make(map[string]*Config, len(ids))
+	for n, id := range ids {
+		child := s.getIdentityStorage(id)
+		if child == nil {
+			log.Lvl2("Didn't find linked identity", n)
+			continue
+		}
+		child.Lock()
+		links[n] = child.Latest
+		child.Unlock()
+	}
+	return links
+}
+
 // setIdentityStorage saves an IdentityStorage
 func (s *Service) setIdentityStorage(id ID, is *Storage) {
 	s.identitiesMutex.Lock()
@@ -377,12 +637,13 @@ func newIdentityService(c *sda.Context, path string) sda.Service {
 		StorageMap:       &StorageMap{make(map[string]*Storage)},
 		skipchain:        skipchain.NewClient(),
 		path:             path,
+		subscribers:      make(map[string][]*network.ServerIdentity),
 	}
 	if err := s.tryLoad(); err != nil {
 		log.Error(err)
 	}
 	for _, f := range []interface{}{s.ProposeSend, s.ProposeVote,
-		s.CreateIdentity, s.ProposeUpdate, s.ConfigUpdate} {
+		s.CreateIdentity, s.ProposeUpdate, s.ConfigUpdate, s.Subscribe} {
 		if err := s.RegisterMessage(f); err != nil {
 			log.Fatal("Registration error:", err)
 		}
@@ -2,6 +2,7 @@ package identity
 
 import (
 	"encoding/binary"
+	"errors"
 	"sort"
 
 	"fmt"
@@ -20,10 +21,13 @@ type ID skipchain.SkipBlockID
 
 // Config holds the information about all devices and the data stored in this
 // identity-blockchain. All Devices have voting-rights to the Config-structure.
+// Every linked identity in Link has one vote, which is given once a threshold
+// of its own devices voted.
 type Config struct {
 	Threshold int
 	Device    map[string]*Device
 	Data      map[string]string
+	Link      map[string]ID
 }
 
 // Device is represented by a public key.
@@ -89,15 +93,137 @@ func (c *Config) Hash() (crypto.HashID, error) {
 			return nil, err
 		}
 	}
+	var links []string
+	for s := range c.Link {
+		links = append(links, s)
+	}
+	sort.Strings(links)
+	for _, s := range links {
+		_, err = hash.Write([]byte(s))
+		if err != nil {
+			return nil, err
+		}
+		_, err = hash.Write(c.Link[s])
+		if err != nil {
+			return nil, err
+		}
+	}
 	return hash.Sum(nil), nil
 }
 
+// VoteHash returns the hash a device signs to accept or reject the
+// configuration. Accepting and rejecting sign different hashes, so that a
+// signature can't be used for the other vote.
+func (c *Config) VoteHash(accept bool) (crypto.HashID, error) {
+	hash, err := c.Hash()
+	if err != nil {
+		return nil, err
+	}
+	vote := "reject"
+	if accept {
+		vote = "accept"
+	}
+	h := network.Suite.Hash()
+	if _, err = h.Write(hash); err != nil {
+		return nil, err
+	}
+	if _, err = h.Write([]byte(vote)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// RemoveDevice deletes the device or the linked identity from the
+// configuration. If the threshold is bigger than the number of remaining
+// voters, it is capped.
+func (c *Config) RemoveDevice(name string) error {
+	_, isDevice := c.Device[name]
+	_, isLink := c.Link[name]
+	if !isDevice && !isLink {
+		return errors.New("Didn't find device " + name)
+	}
+	if c.voters() == 1 {
+		return errors.New("Cannot remove the last device")
+	}
+	delete(c.Device, name)
+	delete(c.Link, name)
+	c.capThreshold()
+	return nil
+}
+
+// AddLink adds the identity id as a voter under the given name. All devices
+// of the linked identity share one vote.
+func (c *Config) AddLink(name string, id ID) error {
+	_, isDevice := c.Device[name]
+	_, isLink := c.Link[name]
+	if isDevice || isLink {
+		return errors.New("Name " + name + " is already taken")
+	}
+	if c.Link == nil {
+		c.Link = make(map[string]ID)
+	}
+	c.Link[name] = id
+	return nil
+}
+
+// RotateDevice replaces the public key of an existing device.
+func (c *Config) RotateDevice(name string, pub abstract.Point) error {
+	dev, ok := c.Device[name]
+	if !ok {
+		return errors.New("Didn't find device " + name)
+	}
+	if dev.Point.Equal(pub) {
+		return errors.New("New key is the same as the old key")
+	}
+	c.Device[name] = &Device{pub}
+	return nil
+}
+
+// voters returns the number of devices and linked identities.
+func (c *Config) voters() int {
+	return len(c.Device) + len(c.Link)
+}
+
+// votesNeeded returns how many voters need to accept a proposition.
+func (c *Config) votesNeeded() int {
+	if c.Threshold < c.voters() {
+		return c.Threshold
+	}
+	return c.voters()
+}
+
+// capThreshold makes sure the threshold is not bigger than the number of
+// voters.
+func (c *Config) capThreshold() {
+	if c.Threshold > c.voters() {
+		c.Threshold = c.voters()
+	}
+}
+
+// removesDevice returns true if a device or a link of latest is missing in c.
+func (c *Config) removesDevice(latest *Config) bool {
+	for n := range latest.Device {
+		if _, ok := c.Device[n]; !ok {
+			return true
+		}
+	}
+	for n := range latest.Link {
+		if _, ok := c.Link[n]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
 // String returns a nicely formatted output of the AccountList
 func (c *Config) String() string {
 	var owners []string
 	for n := range c.Device {
 		owners = append(owners, fmt.Sprintf("Owner: %s", n))
 	}
+	for n, id := range c.Link {
+		owners = append(owners, fmt.Sprintf("Link: %s/%x", n, []byte(id)))
+	}
 	var data []string
 	for k, v := range c.Data {
 		data = append(data, fmt.Sprintf("Data: %s/%s", k, v))
@@ -197,9 +323,11 @@ type ConfigUpdate struct {
 	ID ID
 }
 
-// ConfigUpdateReply returns the updated configuration.
+// ConfigUpdateReply returns the updated configuration and the block of the
+// data-skipchain holding it.
 type ConfigUpdateReply struct {
 	Config *Config
+	Data   *skipchain.SkipBlock
 }
 
 // ProposeSend sends a new proposition to be stored in all identities. It
@@ -214,25 +342,41 @@ type ProposeUpdate struct {
 	ID ID
 }
 
-// ProposeUpdateReply returns the updated propose-configuration.
+// ProposeUpdateReply returns the updated propose-configuration together
+// with the devices that already accepted or rejected it.
 type ProposeUpdateReply struct {
-	Propose *Config
+	Propose  *Config
+	Accepted []string
+	Rejected []string
 }
 
-// ProposeVote sends the signature for a specific IdentityList. It replies nil
-// if the threshold hasn't been reached, or the new SkipBlock
+// ProposeVote sends the signature for a specific IdentityList. Accept tells
+// whether the signer accepts or rejects the proposition, the signature is on
+// the corresponding Config.VoteHash. If Signer is a linked identity, Member
+// is the device of the linked identity that signed.
+// It replies nil if the threshold hasn't been reached, or a ProposeVoteReply.
 type ProposeVote struct {
 	ID        ID
 	Signer    string
+	Member    string
+	Accept    bool
 	Signature *crypto.SchnorrSig
 }
 
 // ProposeVoteReply returns the signed new skipblock if the threshold of
-// votes have arrived.
+// votes have arrived, or an empty Data if the proposition has been discarded
+// because too many devices rejected it.
 type ProposeVoteReply struct {
 	Data *skipchain.SkipBlock
 }
 
+// Subscribe asks the service to send an UpdateSkipBlock for every new
+// skipblock of the identities in IDs. The messages are sent over the
+// connection of the request, which the client has to keep open.
+type Subscribe struct {
+	IDs []ID
+}
+
 // Messages to be sent from one identity to another
 
 // PropagateIdentity sends a new identity to other identityServices
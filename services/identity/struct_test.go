@@ -3,6 +3,8 @@ package identity
 import (
 	"testing"
 
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/crypto/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -29,6 +31,54 @@ func TestKvGetIntKeys(t *testing.T) {
 	assert.Equal(t, "gh", s2)
 }
 
+func TestConfig_RemoveDevice(t *testing.T) {
+	kp1 := config.NewKeyPair(network.Suite)
+	kp2 := config.NewKeyPair(network.Suite)
+	cfg := NewConfig(2, kp1.Public, "one")
+	cfg.Device["two"] = &Device{kp2.Public}
+
+	assert.NotNil(t, cfg.RemoveDevice("three"))
+	assert.Nil(t, cfg.RemoveDevice("two"))
+	assert.Equal(t, 1, len(cfg.Device))
+	assert.Equal(t, 1, cfg.Threshold)
+	assert.NotNil(t, cfg.RemoveDevice("one"))
+}
+
+func TestConfig_RotateDevice(t *testing.T) {
+	kp1 := config.NewKeyPair(network.Suite)
+	kp2 := config.NewKeyPair(network.Suite)
+	cfg := NewConfig(2, kp1.Public, "one")
+	h1, err := cfg.Hash()
+	assert.Nil(t, err)
+
+	assert.NotNil(t, cfg.RotateDevice("two", kp2.Public))
+	assert.NotNil(t, cfg.RotateDevice("one", kp1.Public))
+	assert.Nil(t, cfg.RotateDevice("one", kp2.Public))
+	assert.True(t, cfg.Device["one"].Point.Equal(kp2.Public))
+	h2, err := cfg.Hash()
+	assert.Nil(t, err)
+	assert.NotEqual(t, h1, h2)
+}
+
+func TestConfig_AddLink(t *testing.T) {
+	kp1 := config.NewKeyPair(network.Suite)
+	cfg := NewConfig(2, kp1.Public, "one")
+	h1, err := cfg.Hash()
+	assert.Nil(t, err)
+
+	assert.NotNil(t, cfg.AddLink("one", ID{1}))
+	assert.Nil(t, cfg.AddLink("team", ID{1}))
+	assert.NotNil(t, cfg.AddLink("team", ID{2}))
+	assert.Equal(t, 2, cfg.votesNeeded())
+	h2, err := cfg.Hash()
+	assert.Nil(t, err)
+	assert.NotEqual(t, h1, h2)
+
+	assert.Nil(t, cfg.RemoveDevice("team"))
+	assert.Equal(t, 0, len(cfg.Link))
+	assert.Equal(t, 1, cfg.Threshold)
+}
+
 func setupConfig() *Config {
 	return &Config{
 		Data: map[string]string{
@@ -76,8 +76,14 @@ func (c *API) GetSurveyResults(surveyID libmedco.SurveyID) (*[][]int64, *[][]int
 		grp := make([][]int64, len(encResults.Results))
 		aggr := make([][]int64, len(encResults.Results))
 		for i, res := range encResults.Results {
-			grp[i] = libmedco.DecryptIntVector(c.private, &res.GroupingAttributes)
-			aggr[i] = libmedco.DecryptIntVector(c.private, &res.AggregatingAttributes)
+			grp[i], err = libmedco.DecryptIntVector(c.private, &res.GroupingAttributes)
+			if err != nil {
+				return nil, nil, err
+			}
+			aggr[i], err = libmedco.DecryptIntVector(c.private, &res.AggregatingAttributes)
+			if err != nil {
+				return nil, nil, err
+			}
 		}
 		return &grp, &aggr, nil
 	}
@@ -8,16 +8,9 @@ import (
 	"github.com/dedis/crypto/random"
 )
 
-// MaxHomomorphicInt is upper bound for integers used in messages, a failed decryption will return this value.
-const MaxHomomorphicInt int64 = 300
-
 // BytesToPointEncodingSeed default seed used in bytes encoding.
 const BytesToPointEncodingSeed string = "seed"
 
-// PointToInt creates a map between EC points and integers.
-var PointToInt = make(map[string]int64, MaxHomomorphicInt)
-var currentGreatestM abstract.Point
-var currentGreatestInt int64
 var suite = network.Suite
 
 // CipherText is an ElGamal encrypted point.
@@ -127,43 +120,23 @@ func DecryptPoint(prikey abstract.Scalar, c CipherText) abstract.Point {
 	return M
 }
 
-// DecryptInt decrypts an integer from an ElGamal cipher text where integer are encoded in the exponent.
-func DecryptInt(prikey abstract.Scalar, cipher CipherText) int64 {
+// DecryptInt decrypts an integer from an ElGamal cipher text where integer are encoded in the exponent. It returns
+// an error if the integer is out of the range set by SetMaxHomomorphicInt.
+func DecryptInt(prikey abstract.Scalar, cipher CipherText) (int64, error) {
 	M := DecryptPoint(prikey, cipher)
-	return discreteLog(M)
+	return defaultDecoder().Decode(M)
 }
 
 // DecryptIntVector decrypts a cipherVector.
-func DecryptIntVector(prikey abstract.Scalar, cipherVector *CipherVector) []int64 {
+func DecryptIntVector(prikey abstract.Scalar, cipherVector *CipherVector) ([]int64, error) {
 	result := make([]int64, len(*cipherVector))
 	for i, c := range *cipherVector {
-		result[i] = DecryptInt(prikey, c)
-	}
-	return result
-}
-
-// Brute-Forces the discrete log for integer decoding.
-func discreteLog(P abstract.Point) int64 {
-	B := suite.Point().Base()
-	var Bi abstract.Point
-	var m int64
-	var ok bool
-
-	if m, ok = PointToInt[P.String()]; ok {
-		return m
-	}
-
-	if currentGreatestInt == 0 {
-		currentGreatestM = suite.Point().Null()
-	}
-
-	for Bi, m = currentGreatestM, currentGreatestInt; !Bi.Equal(P) && m < MaxHomomorphicInt; Bi, m = Bi.Add(Bi, B), m+1 {
-		PointToInt[Bi.String()] = m
+		var err error
+		if result[i], err = DecryptInt(prikey, c); err != nil {
+			return nil, err
+		}
 	}
-	currentGreatestM = Bi
-	PointToInt[Bi.String()] = m
-	currentGreatestInt = m
-	return m
+	return result, nil
 }
 
 // Switching
@@ -38,7 +38,8 @@ func TestNullCipherText(t *testing.T) {
 	secKey, pubKey := GenKey()
 
 	nullEnc := EncryptInt(pubKey, 0)
-	nullDec := DecryptInt(secKey, *nullEnc)
+	nullDec, err := DecryptInt(secKey, *nullEnc)
+	assert.Nil(t, err)
 
 	if 0 != nullDec {
 		t.Fatal("Decryption of encryption of 0 should be 0, got", nullDec)
@@ -46,7 +47,8 @@ func TestNullCipherText(t *testing.T) {
 
 	var twoTimesNullEnc = CipherText{suite.Point().Null(), suite.Point().Null()}
 	twoTimesNullEnc.Add(*nullEnc, *nullEnc)
-	twoTimesNullDec := DecryptInt(secKey, twoTimesNullEnc)
+	twoTimesNullDec, err := DecryptInt(secKey, twoTimesNullEnc)
+	assert.Nil(t, err)
 
 	if 0 != nullDec {
 		t.Fatal("Decryption of encryption of 0+0 should be 0, got", twoTimesNullDec)
@@ -60,7 +62,8 @@ func TestNullCipherVector(t *testing.T) {
 
 	nullVectEnc := *NullCipherVector(10, pubKey)
 
-	nullVectDec := DecryptIntVector(secKey, &nullVectEnc)
+	nullVectDec, err := DecryptIntVector(secKey, &nullVectEnc)
+	assert.Nil(t, err)
 
 	target := []int64{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
 	if !reflect.DeepEqual(nullVectDec, target) {
@@ -69,7 +72,8 @@ func TestNullCipherVector(t *testing.T) {
 
 	twoTimesNullEnc := NewCipherVector(10)
 	twoTimesNullEnc.Add(nullVectEnc, nullVectEnc)
-	twoTimesNullDec := DecryptIntVector(secKey, twoTimesNullEnc)
+	twoTimesNullDec, err := DecryptIntVector(secKey, twoTimesNullEnc)
+	assert.Nil(t, err)
 
 	if !reflect.DeepEqual(twoTimesNullDec, target) {
 		t.Fatal("Null vector + Null vector should be ", target, "got", twoTimesNullDec)
@@ -86,7 +90,8 @@ func TestHomomorphicOpp(t *testing.T) {
 
 	cv3 := NewCipherVector(5).Add(*cv1, *cv2)
 
-	p := DecryptIntVector(secKey, cv3)
+	p, err := DecryptIntVector(secKey, cv3)
+	assert.Nil(t, err)
 
 	assert.Equal(t, target, p)
 }
@@ -138,7 +143,8 @@ func TestCryptoKeySwitching(t *testing.T) {
 		kscv.KeySwitching(&kscv, &origEphem, newPublic, privates[n])
 	}
 
-	res := DecryptIntVector(newPrivate, &kscv)
+	res, err := DecryptIntVector(newPrivate, &kscv)
+	assert.Nil(t, err)
 	assert.True(t, reflect.DeepEqual(res, target))
 
 }
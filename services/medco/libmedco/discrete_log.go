@@ -0,0 +1,96 @@
+package libmedco
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/dedis/crypto/abstract"
+)
+
+// MaxHomomorphicInt is the default bound of the integers that can be decrypted: values in
+// [-MaxHomomorphicInt, MaxHomomorphicInt] are found, others return an error.
+const MaxHomomorphicInt int64 = 1 << 30
+
+// IntDecoder decodes points of the form iB back to the integer i, using baby-step giant-step. Its table of baby
+// steps is computed on first use and only read afterwards, so an IntDecoder can be used concurrently.
+type IntDecoder struct {
+	max   int64
+	steps int64
+
+	once  sync.Once
+	table map[string]int64
+	giant abstract.Point
+}
+
+// NewIntDecoder returns a decoder for the integers in [-max, max]. It needs memory for about sqrt(max) points
+// and decodes i in about |i|/sqrt(max) point additions.
+func NewIntDecoder(max int64) *IntDecoder {
+	if max < 0 {
+		max = 0
+	}
+	return &IntDecoder{
+		max:   max,
+		steps: int64(math.Ceil(math.Sqrt(float64(max) + 1))),
+	}
+}
+
+// Max returns the bound of the integers the decoder finds.
+func (d *IntDecoder) Max() int64 {
+	return d.max
+}
+
+// init computes the table of the baby steps jB for j in [0, steps) and the giant step -steps*B.
+func (d *IntDecoder) init() {
+	B := suite.Point().Base()
+	d.table = make(map[string]int64, d.steps)
+	jB := suite.Point().Null()
+	for j := int64(0); j < d.steps; j++ {
+		d.table[jB.String()] = j
+		jB.Add(jB, B)
+	}
+	// jB is now steps*B.
+	d.giant = suite.Point().Neg(jB)
+}
+
+// Decode returns the integer i with P = iB, or an error if there is no such i in [-max, max].
+func (d *IntDecoder) Decode(P abstract.Point) (int64, error) {
+	d.once.Do(d.init)
+
+	// Look for i and -i at the same time, so that the cost only depends
+	// on |i|.
+	pos := suite.Point().Add(P, suite.Point().Null())
+	neg := suite.Point().Neg(P)
+	for i := int64(0); i*d.steps <= d.max; i++ {
+		if j, ok := d.table[pos.String()]; ok {
+			if m := i*d.steps + j; m <= d.max {
+				return m, nil
+			}
+		}
+		if j, ok := d.table[neg.String()]; ok {
+			if m := i*d.steps + j; m <= d.max {
+				return -m, nil
+			}
+		}
+		pos.Add(pos, d.giant)
+		neg.Add(neg, d.giant)
+	}
+	return 0, fmt.Errorf("Decrypted value out of range [-%d, %d]", d.max, d.max)
+}
+
+var decoder = NewIntDecoder(MaxHomomorphicInt)
+var decoderMutex sync.Mutex
+
+// SetMaxHomomorphicInt changes the bound of the integers that DecryptInt and DecryptIntVector can decrypt.
+func SetMaxHomomorphicInt(max int64) {
+	decoderMutex.Lock()
+	defer decoderMutex.Unlock()
+	decoder = NewIntDecoder(max)
+}
+
+// defaultDecoder returns the decoder used by DecryptInt.
+func defaultDecoder() *IntDecoder {
+	decoderMutex.Lock()
+	defer decoderMutex.Unlock()
+	return decoder
+}
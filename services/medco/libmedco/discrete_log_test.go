@@ -0,0 +1,70 @@
+package libmedco_test
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/dedis/cothority/services/medco/libmedco"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIntDecoder tests decoding of positive, negative and out of range integers.
+func TestIntDecoder(t *testing.T) {
+	d := NewIntDecoder(1000)
+	assert.Equal(t, int64(1000), d.Max())
+	for _, i := range []int64{0, 1, -1, 31, 32, -32, 999, 1000, -1000} {
+		P := suite.Point().Mul(suite.Point().Base(), suite.Scalar().SetInt64(i))
+		res, err := d.Decode(P)
+		assert.Nil(t, err)
+		assert.Equal(t, i, res)
+	}
+	for _, i := range []int64{1001, -1001, 5000} {
+		P := suite.Point().Mul(suite.Point().Base(), suite.Scalar().SetInt64(i))
+		_, err := d.Decode(P)
+		assert.NotNil(t, err, "Should not decode", i)
+	}
+}
+
+// TestDecryptIntLarge tests decryption of counts in the millions and of negative differences.
+func TestDecryptIntLarge(t *testing.T) {
+	secKey, pubKey := GenKey()
+
+	cv1 := EncryptIntVector(pubKey, []int64{3000000, 5, 0})
+	cv2 := EncryptIntVector(pubKey, []int64{1, 12000000, 7})
+	sum, err := DecryptIntVector(secKey, NewCipherVector(3).Add(*cv1, *cv2))
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{3000001, 12000005, 7}, sum)
+
+	diff, err := DecryptIntVector(secKey, NewCipherVector(3).Sub(*cv1, *cv2))
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{2999999, -11999995, -7}, diff)
+}
+
+// TestDecryptIntRange tests the error returned for values out of the configured range.
+func TestDecryptIntRange(t *testing.T) {
+	defer SetMaxHomomorphicInt(MaxHomomorphicInt)
+	SetMaxHomomorphicInt(100)
+
+	secKey, pubKey := GenKey()
+	_, err := DecryptInt(secKey, *EncryptInt(pubKey, 101))
+	assert.NotNil(t, err)
+	res, err := DecryptInt(secKey, *EncryptInt(pubKey, -100))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-100), res)
+}
+
+// TestDecryptIntConcurrent tests concurrent decryptions.
+func TestDecryptIntConcurrent(t *testing.T) {
+	secKey, pubKey := GenKey()
+	var wg sync.WaitGroup
+	for i := int64(0); i < 10; i++ {
+		wg.Add(1)
+		go func(i int64) {
+			defer wg.Done()
+			res, err := DecryptInt(secKey, *EncryptInt(pubKey, i*1000))
+			assert.Nil(t, err)
+			assert.Equal(t, i*1000, res)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -36,7 +36,9 @@ type SurveyStore struct {
 	GroupedDeterministicGroupingAttributes map[TempID]GroupingAttributes
 	GroupedAggregatingAttributes           map[TempID]CipherVector
 
-	lastID uint64
+	// LastID is the last TempID given out, it is kept so that the IDs stay unique when the store is saved and
+	// loaded again.
+	LastID uint64
 }
 
 // NewSurveyStore is the store constructor.
@@ -114,8 +116,8 @@ func (s *SurveyStore) PollLocallyAggregatedResponses() (map[GroupingKey]Grouping
 }
 
 func (s *SurveyStore) nextID() TempID {
-	s.lastID++
-	return TempID(s.lastID)
+	s.LastID++
+	return TempID(s.LastID)
 }
 
 func addInMapping(s map[GroupingKey]CipherVector, key GroupingKey, added CipherVector) {
@@ -1,7 +1,17 @@
 package medco
 
 import (
-	"github.com/btcsuite/goleveldb/leveldb/errors"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/network"
 	"github.com/dedis/cothority/protocols/medco"
@@ -15,6 +25,9 @@ import (
 // ServiceName is the registered name for the medco service.
 const ServiceName = "MedCo"
 
+// SurveyExpiration is how long a survey is kept once it has been closed.
+var SurveyExpiration = 24 * time.Hour
+
 func init() {
 	sda.RegisterNewService(ServiceName, NewService)
 	network.RegisterPacketType(&libmedco.ClientResponse{})
@@ -22,6 +35,7 @@ func init() {
 	network.RegisterPacketType(&SurveyCreationQuery{})
 	network.RegisterPacketType(&SurveyResultResponse{})
 	network.RegisterPacketType(&ServiceResponse{})
+	network.RegisterPacketType(&SurveyState{})
 }
 
 // SurveyCreationQuery is used to trigger the creation of a survey.
@@ -53,19 +67,35 @@ type SurveyResultResponse struct {
 	Results []libmedco.SurveyResult
 }
 
+// SurveyState is a survey as it is kept by a node.
+type SurveyState struct {
+	Survey libmedco.Survey
+	// ClosedAt is the unix time at which the survey stopped accepting data, 0 while it is open.
+	ClosedAt int64
+}
+
+// SurveyMap holds the surveys of the service.
+type SurveyMap struct {
+	Surveys map[libmedco.SurveyID]*SurveyState
+}
+
 // Service defines a service in medco case with a survey.
 type Service struct {
 	*sda.ServiceProcessor
-	homePath string
-
-	survey libmedco.Survey // For now, server only handles one survey.
+	*SurveyMap
+	surveysMutex sync.Mutex
+	path         string
 }
 
 // NewService constructor which registers the needed messages.
 func NewService(c *sda.Context, path string) sda.Service {
 	newMedCoInstance := &Service{
 		ServiceProcessor: sda.NewServiceProcessor(c),
-		homePath:         path,
+		SurveyMap:        &SurveyMap{make(map[libmedco.SurveyID]*SurveyState)},
+		path:             path,
+	}
+	if err := newMedCoInstance.tryLoad(); err != nil {
+		log.Error(err)
 	}
 	newMedCoInstance.RegisterMessage(newMedCoInstance.HandleSurveyResponseData)
 	newMedCoInstance.RegisterMessage(newMedCoInstance.HandleSurveyResultsQuery)
@@ -82,19 +112,38 @@ func (mcs *Service) HandleSurveyCreationQuery(si *network.ServerIdentity, recq *
 		newID := libmedco.SurveyID(uuid.NewV4().String())
 		recq.SurveyID = &newID
 
-		mcs.SendISMOthers(&recq.Roster, recq)
+		// The other nodes have to know about the survey before the
+		// clients send their responses, so wait for their replies.
+		client := sda.NewClient(ServiceName)
+		for _, e := range recq.Roster.List {
+			if e.ID.Equal(mcs.ServerIdentity().ID) {
+				continue
+			}
+			if _, err := client.Send(e, recq); err != nil {
+				return nil, err
+			}
+		}
 
 		log.Lvl1(mcs.ServerIdentity(), "initiated the survey", newID)
 	}
 
-	mcs.survey = libmedco.Survey{
-		SurveyStore:       libmedco.NewSurveyStore(),
-		ID:                *recq.SurveyID,
-		Roster:            recq.Roster,
-		SurveyPHKey:       network.Suite.Scalar().Pick(random.Stream),
-		ClientPublic:      nil,
-		SurveyDescription: recq.SurveyDescription,
+	mcs.surveysMutex.Lock()
+	defer mcs.surveysMutex.Unlock()
+	if _, exists := mcs.Surveys[*recq.SurveyID]; exists {
+		return nil, errors.New("Survey " + string(*recq.SurveyID) + " already exists")
+	}
+	mcs.Surveys[*recq.SurveyID] = &SurveyState{
+		Survey: libmedco.Survey{
+			SurveyStore:       libmedco.NewSurveyStore(),
+			ID:                *recq.SurveyID,
+			Roster:            recq.Roster,
+			SurveyPHKey:       network.Suite.Scalar().Pick(random.Stream),
+			ClientPublic:      network.Suite.Point().Null(),
+			SurveyDescription: recq.SurveyDescription,
+		},
 	}
+	mcs.expireSurveys()
+	mcs.saveSurvey(*recq.SurveyID)
 	log.Lvl1(mcs.ServerIdentity(), "created the survey", *recq.SurveyID)
 
 	return &ServiceResponse{*recq.SurveyID}, nil
@@ -103,113 +152,399 @@ func (mcs *Service) HandleSurveyCreationQuery(si *network.ServerIdentity, recq *
 // HandleSurveyResponseData handles a survey answers submission by a subject.
 func (mcs *Service) HandleSurveyResponseData(si *network.ServerIdentity, resp *SurveyResponseQuery) (network.Body, error) {
 	log.Lvl1(mcs.ServerIdentity(), "recieved response data for survey ", resp.SurveyID)
-	if mcs.survey.ID == resp.SurveyID {
-		mcs.survey.InsertClientResponse(resp.ClientResponse)
-		return &ServiceResponse{"1"}, nil
+	mcs.surveysMutex.Lock()
+	defer mcs.surveysMutex.Unlock()
+	survey, err := mcs.openSurvey(resp.SurveyID)
+	if err != nil {
+		return nil, err
 	}
-	log.Lvl1(mcs.ServerIdentity(), "does not know about this survey!")
+	survey.Survey.InsertClientResponse(resp.ClientResponse)
+	mcs.appendResponse(resp.SurveyID, &resp.ClientResponse)
 	return &ServiceResponse{resp.SurveyID}, nil
 }
 
-// HandleSurveyResultsQuery handles the survey result query by the surveyor.
+// HandleSurveyResultsQuery handles the survey result query by the surveyor. It closes the survey, so no more data
+// is accepted for it.
 func (mcs *Service) HandleSurveyResultsQuery(si *network.ServerIdentity, resq *SurveyResultsQuery) (network.Body, error) {
-
 	log.Lvl1(mcs.ServerIdentity(), "recieved a survey result query from", si)
-	mcs.survey.ClientPublic = resq.ClientPublic
-	pi, _ := mcs.startProtocol(medco.MedcoServiceProtocolName, resq.SurveyID)
+	mcs.surveysMutex.Lock()
+	survey, err := mcs.openSurvey(resq.SurveyID)
+	if err == nil {
+		survey.Survey.ClientPublic = resq.ClientPublic
+		survey.ClosedAt = time.Now().Unix()
+		mcs.saveSurvey(resq.SurveyID)
+	}
+	mcs.surveysMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
 
+	pi, err := mcs.startProtocol(medco.MedcoServiceProtocolName, resq.SurveyID)
+	if err != nil {
+		return nil, err
+	}
 	<-pi.(*medco.PipelineProtocol).FeedbackChannel
 	log.Lvl1(mcs.ServerIdentity(), "completed the query processing...")
-	return &SurveyResultResponse{mcs.survey.PollDeliverableResults()}, nil
+
+	mcs.surveysMutex.Lock()
+	defer mcs.surveysMutex.Unlock()
+	results := survey.Survey.PollDeliverableResults()
+	mcs.saveSurvey(resq.SurveyID)
+	return &SurveyResultResponse{results}, nil
 }
 
-// NewProtocol handles the creation of the right protocol parameters.
+// NewProtocol handles the creation of the right protocol parameters. The survey specific parameters are set once the
+// protocol instance knows its survey.
 func (mcs *Service) NewProtocol(tn *sda.TreeNodeInstance, conf *sda.GenericConfig) (sda.ProtocolInstance, error) {
-
 	var pi sda.ProtocolInstance
 	var err error
 	switch tn.ProtocolName() {
 	case medco.MedcoServiceProtocolName:
 		pi, err = medco.NewPipelineProcotol(tn)
-		medcoServ := pi.(*medco.PipelineProtocol)
-		medcoServ.MedcoServiceInstance = mcs
-		medcoServ.TargetSurvey = &mcs.survey
+		if err == nil {
+			pi.(*medco.PipelineProtocol).MedcoServiceInstance = mcs
+		}
 	case medco.DeterministicSwitchingProtocolName:
 		pi, err = medco.NewDeterministSwitchingProtocol(tn)
-		detSwitch := pi.(*medco.DeterministicSwitchingProtocol)
-		detSwitch.SurveyPHKey = &mcs.survey.SurveyPHKey
-		if tn.IsRoot() {
-			groupingAttr := mcs.survey.PollProbabilisticGroupingAttributes()
-			detSwitch.TargetOfSwitch = &groupingAttr
+		if err == nil {
+			pi.(*medco.DeterministicSwitchingProtocol).OnSurvey = mcs.surveyCallback(pi)
 		}
 	case medco.PrivateAggregateProtocolName:
 		pi, err = medco.NewPrivateAggregate(tn)
-		groups, groupedData := mcs.survey.PollLocallyAggregatedResponses()
-		pi.(*medco.PrivateAggregateProtocol).GroupedData = &groupedData
-		pi.(*medco.PrivateAggregateProtocol).Groups = &groups
+		if err == nil {
+			pi.(*medco.PrivateAggregateProtocol).OnSurvey = mcs.surveyCallback(pi)
+		}
 	case medco.ProbabilisticSwitchingProtocolName:
 		pi, err = medco.NewProbabilisticSwitchingProtocol(tn)
-		probSwitch := pi.(*medco.ProbabilisticSwitchingProtocol)
-		probSwitch.SurveyPHKey = &mcs.survey.SurveyPHKey
-		if tn.IsRoot() {
-			groups := mcs.survey.PollCothorityAggregatedGroupsID()
-			probSwitch.TargetOfSwitch = libmedco.GroupingAttributesToDeterministicCipherVector(&groups)
-			probSwitch.TargetPublicKey = &mcs.survey.ClientPublic
+		if err == nil {
+			pi.(*medco.ProbabilisticSwitchingProtocol).OnSurvey = mcs.surveyCallback(pi)
 		}
 	case medco.KeySwitchingProtocolName:
 		pi, err = medco.NewKeySwitchingProtocol(tn)
-		keySwitch := pi.(*medco.KeySwitchingProtocol)
-		if tn.IsRoot() {
-			coaggr := mcs.survey.PollCothorityAggregatedGroupsAttr()
-			keySwitch.TargetOfSwitch = &coaggr
-			keySwitch.TargetPublicKey = &mcs.survey.ClientPublic
-		}
 	default:
 		return nil, errors.New("Service attempts to start an unknown protocol: " + tn.ProtocolName() + ".")
 	}
 	return pi, err
 }
 
+// surveyCallback returns the callback setting up pi once its survey is known.
+func (mcs *Service) surveyCallback(pi sda.ProtocolInstance) medco.SurveyCallback {
+	return func(id libmedco.SurveyID) error {
+		return mcs.setupProtocol(pi, id)
+	}
+}
+
+// setupProtocol sets the parameters of pi that depend on the survey.
+func (mcs *Service) setupProtocol(pi sda.ProtocolInstance, id libmedco.SurveyID) error {
+	mcs.surveysMutex.Lock()
+	defer mcs.surveysMutex.Unlock()
+	state, err := mcs.getSurvey(id)
+	if err != nil {
+		return err
+	}
+	survey := &state.Survey
+
+	switch p := pi.(type) {
+	case *medco.PipelineProtocol:
+		p.TargetSurvey = survey
+	case *medco.DeterministicSwitchingProtocol:
+		p.SurveyID = id
+		p.SurveyPHKey = &survey.SurveyPHKey
+		if p.IsRoot() {
+			groupingAttr := survey.PollProbabilisticGroupingAttributes()
+			p.TargetOfSwitch = &groupingAttr
+		}
+	case *medco.PrivateAggregateProtocol:
+		p.SurveyID = id
+		groups, groupedData := survey.PollLocallyAggregatedResponses()
+		p.GroupedData = &groupedData
+		p.Groups = &groups
+	case *medco.ProbabilisticSwitchingProtocol:
+		p.SurveyID = id
+		p.SurveyPHKey = &survey.SurveyPHKey
+		if p.IsRoot() {
+			groups := survey.PollCothorityAggregatedGroupsID()
+			p.TargetOfSwitch = libmedco.GroupingAttributesToDeterministicCipherVector(&groups)
+			p.TargetPublicKey = &survey.ClientPublic
+		}
+	case *medco.KeySwitchingProtocol:
+		if p.IsRoot() {
+			coaggr := survey.PollCothorityAggregatedGroupsAttr()
+			p.TargetOfSwitch = &coaggr
+			p.TargetPublicKey = &survey.ClientPublic
+		}
+	}
+	return nil
+}
+
 func (mcs *Service) startProtocol(name string, targetSurvey libmedco.SurveyID) (sda.ProtocolInstance, error) {
-	tree := mcs.survey.Roster.GenerateNaryTreeWithRoot(2, mcs.ServerIdentity())
+	mcs.surveysMutex.Lock()
+	state, err := mcs.getSurvey(targetSurvey)
+	var roster sda.Roster
+	if err == nil {
+		roster = state.Survey.Roster
+	}
+	mcs.surveysMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	tree := roster.GenerateNaryTreeWithRoot(2, mcs.ServerIdentity())
 	tni := mcs.NewTreeNodeInstance(tree, tree.Root, name)
 	pi, err := mcs.NewProtocol(tni, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := mcs.setupProtocol(pi, targetSurvey); err != nil {
+		return nil, err
+	}
 	mcs.RegisterProtocolInstance(pi)
 	go pi.Dispatch()
 	go pi.Start()
-	return pi, err
+	return pi, nil
+}
+
+// getSurvey returns the survey with the given ID. The expired surveys are removed first. The surveysMutex must be
+// held.
+func (mcs *Service) getSurvey(id libmedco.SurveyID) (*SurveyState, error) {
+	mcs.expireSurveys()
+	survey, ok := mcs.Surveys[id]
+	if !ok {
+		return nil, errors.New("Unknown survey " + string(id))
+	}
+	return survey, nil
+}
+
+// openSurvey returns the survey with the given ID if it still accepts data. The surveysMutex must be held.
+func (mcs *Service) openSurvey(id libmedco.SurveyID) (*SurveyState, error) {
+	survey, err := mcs.getSurvey(id)
+	if err != nil {
+		return nil, err
+	}
+	if survey.ClosedAt != 0 {
+		return nil, errors.New("Survey " + string(id) + " is closed")
+	}
+	return survey, nil
+}
+
+// closeSurvey stops the survey from accepting data.
+func (mcs *Service) closeSurvey(id libmedco.SurveyID) error {
+	mcs.surveysMutex.Lock()
+	defer mcs.surveysMutex.Unlock()
+	survey, err := mcs.getSurvey(id)
+	if err != nil {
+		return err
+	}
+	if survey.ClosedAt == 0 {
+		survey.ClosedAt = time.Now().Unix()
+		mcs.saveSurvey(id)
+	}
+	return nil
+}
+
+// expireSurveys removes the surveys closed for longer than SurveyExpiration. The surveysMutex must be held.
+func (mcs *Service) expireSurveys() {
+	limit := time.Now().Add(-SurveyExpiration).Unix()
+	for id, survey := range mcs.Surveys {
+		if survey.ClosedAt != 0 && survey.ClosedAt < limit {
+			log.Lvl2(mcs.ServerIdentity(), "removes expired survey", id)
+			delete(mcs.Surveys, id)
+			mcs.removeSurvey(id)
+		}
+	}
+}
+
+// filePrefix returns the beginning of the files of the surveys. The local tests run all nodes with the same path,
+// so the files are specific to the node.
+func (mcs *Service) filePrefix() string {
+	return path.Join(mcs.path, "medco-"+uuid.UUID(mcs.ServerIdentity().ID).String()+"-")
+}
+
+// surveyFileName returns the file the survey is saved in. The ID is given by the client, so it is hex-encoded to
+// stay in the path of the service.
+func (mcs *Service) surveyFileName(id libmedco.SurveyID) string {
+	return mcs.filePrefix() + hex.EncodeToString([]byte(id)) + ".bin"
+}
+
+// responsesFileName returns the file the responses to an open survey are appended to.
+func (mcs *Service) responsesFileName(id libmedco.SurveyID) string {
+	return mcs.filePrefix() + hex.EncodeToString([]byte(id)) + ".responses"
+}
+
+// saveSurvey writes the survey to disk. Once the survey is closed, its responses are part of it and their file is
+// removed. The surveysMutex must be held.
+func (mcs *Service) saveSurvey(id libmedco.SurveyID) {
+	log.Lvl3("Saving survey", id)
+	survey, ok := mcs.Surveys[id]
+	if !ok {
+		return
+	}
+	b, err := network.MarshalRegisteredType(survey)
+	if err != nil {
+		log.Error("Couldn't marshal survey:", err)
+		return
+	}
+	if err := ioutil.WriteFile(mcs.surveyFileName(id), b, 0660); err != nil {
+		log.Error("Couldn't save file:", err)
+		return
+	}
+	if survey.ClosedAt != 0 {
+		if err := os.Remove(mcs.responsesFileName(id)); err != nil && !os.IsNotExist(err) {
+			log.Error("Couldn't remove file:", err)
+		}
+	}
+}
+
+// appendResponse appends the response to the file of the responses of the open survey, so that the survey is not
+// written again for every response. Every response is preceded by its length. The surveysMutex must be held.
+func (mcs *Service) appendResponse(id libmedco.SurveyID, resp *libmedco.ClientResponse) {
+	b, err := network.MarshalRegisteredType(resp)
+	if err != nil {
+		log.Error("Couldn't marshal response:", err)
+		return
+	}
+	record := make([]byte, 4+len(b))
+	binary.LittleEndian.PutUint32(record, uint32(len(b)))
+	copy(record[4:], b)
+	f, err := os.OpenFile(mcs.responsesFileName(id), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		log.Error("Couldn't open file:", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(record); err != nil {
+		log.Error("Couldn't save response:", err)
+	}
+}
+
+// removeSurvey removes the files of the survey.
+func (mcs *Service) removeSurvey(id libmedco.SurveyID) {
+	for _, f := range []string{mcs.surveyFileName(id), mcs.responsesFileName(id)} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			log.Error("Couldn't remove file:", err)
+		}
+	}
+}
+
+// loadResponses reads the responses appended by appendResponse. A response that has only been written partially is
+// ignored.
+func (mcs *Service) loadResponses(id libmedco.SurveyID) ([]*libmedco.ClientResponse, error) {
+	b, err := ioutil.ReadFile(mcs.responsesFileName(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var responses []*libmedco.ClientResponse
+	for offset := 0; offset+4 <= len(b); {
+		length := int(binary.LittleEndian.Uint32(b[offset:]))
+		if offset+4+length > len(b) {
+			log.Warn("Ignoring partially written response of survey", id)
+			break
+		}
+		_, msg, err := network.UnmarshalRegistered(b[offset+4 : offset+4+length])
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't unmarshal response: %s", err)
+		}
+		responses = append(responses, msg.(*libmedco.ClientResponse))
+		offset += 4 + length
+	}
+	return responses, nil
+}
+
+// Tries to load the surveys and updates if files are found, else it returns an error. The responses of the open
+// surveys are inserted again.
+func (mcs *Service) tryLoad() error {
+	files, err := filepath.Glob(mcs.filePrefix() + "*.bin")
+	if err != nil {
+		return err
+	}
+	mcs.SurveyMap = &SurveyMap{make(map[libmedco.SurveyID]*SurveyState)}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("Error while reading %s: %s", file, err)
+		}
+		_, msg, err := network.UnmarshalRegistered(b)
+		if err != nil {
+			return fmt.Errorf("Couldn't unmarshal: %s", err)
+		}
+		survey := msg.(*SurveyState)
+		id := survey.Survey.ID
+		if survey.ClosedAt == 0 {
+			responses, err := mcs.loadResponses(id)
+			if err != nil {
+				return fmt.Errorf("Couldn't load responses of %s: %s", id, err)
+			}
+			survey.Survey.SurveyStore = libmedco.NewSurveyStore()
+			for _, resp := range responses {
+				survey.Survey.InsertClientResponse(*resp)
+			}
+		}
+		mcs.Surveys[id] = survey
+	}
+	log.Lvl3("Successfully loaded")
+	mcs.expireSurveys()
+	return nil
 }
 
 // Pipeline steps forward operations
 
-// DeterministicSwitchingPhase performs the private grouping on the currently collected data.
+// DeterministicSwitchingPhase performs the private grouping on the currently collected data. The survey is closed
+// first, so the data is complete.
 func (mcs *Service) DeterministicSwitchingPhase(targetSurvey libmedco.SurveyID) error {
-
+	if err := mcs.closeSurvey(targetSurvey); err != nil {
+		return err
+	}
 	pi, err := mcs.startProtocol(medco.DeterministicSwitchingProtocolName, targetSurvey)
 	if err != nil {
 		return err
 	}
 	deterministicSwitchedResult := <-pi.(*medco.DeterministicSwitchingProtocol).FeedbackChannel
-	mcs.survey.PushDeterministicGroupingAttributes(*libmedco.DeterministicCipherVectorToGroupingAttributes(&deterministicSwitchedResult))
-	return err
+
+	mcs.surveysMutex.Lock()
+	defer mcs.surveysMutex.Unlock()
+	survey, err := mcs.getSurvey(targetSurvey)
+	if err != nil {
+		return err
+	}
+	survey.Survey.PushDeterministicGroupingAttributes(*libmedco.DeterministicCipherVectorToGroupingAttributes(&deterministicSwitchedResult))
+	mcs.saveSurvey(targetSurvey)
+	return nil
 }
 
 // AggregationPhase performs the per-group aggregation on the currently grouped data.
 func (mcs *Service) AggregationPhase(targetSurvey libmedco.SurveyID) error {
-
 	pi, err := mcs.startProtocol(medco.PrivateAggregateProtocolName, targetSurvey)
 	if err != nil {
 		return err
 	}
 	cothorityAggregatedData := <-pi.(*medco.PrivateAggregateProtocol).FeedbackChannel
 
-	mcs.survey.PushCothorityAggregatedGroups(cothorityAggregatedData.Groups, cothorityAggregatedData.GroupedData)
-
-	return err
+	mcs.surveysMutex.Lock()
+	defer mcs.surveysMutex.Unlock()
+	survey, err := mcs.getSurvey(targetSurvey)
+	if err != nil {
+		return err
+	}
+	survey.Survey.PushCothorityAggregatedGroups(cothorityAggregatedData.Groups, cothorityAggregatedData.GroupedData)
+	mcs.saveSurvey(targetSurvey)
+	return nil
 }
 
 // KeySwitchingPhase performs the switch to data querier key on the currently aggregated data.
 func (mcs *Service) KeySwitchingPhase(targetSurvey libmedco.SurveyID) error {
+	mcs.surveysMutex.Lock()
+	survey, err := mcs.getSurvey(targetSurvey)
+	var grouping bool
+	if err == nil {
+		grouping = survey.Survey.SurveyDescription.GroupingAttributesCount > 0
+	}
+	mcs.surveysMutex.Unlock()
+	if err != nil {
+		return err
+	}
 
 	pi, err := mcs.startProtocol(medco.KeySwitchingProtocolName, targetSurvey)
 	if err != nil {
@@ -219,7 +554,7 @@ func (mcs *Service) KeySwitchingPhase(targetSurvey libmedco.SurveyID) error {
 
 	//TODO: extract this subphase because it is optional
 	keySwitchedAggregatedGroups := make(map[libmedco.TempID]libmedco.CipherVector)
-	if mcs.survey.SurveyDescription.GroupingAttributesCount > 0 {
+	if grouping {
 		pi, err = mcs.startProtocol(medco.ProbabilisticSwitchingProtocolName, targetSurvey)
 		if err != nil {
 			return err
@@ -227,7 +562,9 @@ func (mcs *Service) KeySwitchingPhase(targetSurvey libmedco.SurveyID) error {
 		keySwitchedAggregatedGroups = <-pi.(*medco.ProbabilisticSwitchingProtocol).FeedbackChannel
 	}
 
-	mcs.survey.PushQuerierKeyEncryptedData(keySwitchedAggregatedGroups, keySwitchedAggregatedAttributes)
-
-	return err
+	mcs.surveysMutex.Lock()
+	defer mcs.surveysMutex.Unlock()
+	survey.Survey.PushQuerierKeyEncryptedData(keySwitchedAggregatedGroups, keySwitchedAggregatedAttributes)
+	mcs.saveSurvey(targetSurvey)
+	return nil
 }
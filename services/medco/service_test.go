@@ -22,8 +22,6 @@ func TestMain(m *testing.M) {
 
 // TestService tests medco complete service execution.
 func TestService(t *testing.T) {
-	t.Skip("Issue with deadlock occuring in the medco protocol: https://github.com/dedis/cothority/issues/479")
-
 	local := sda.NewLocalTest()
 	// generate 5 hosts, they don't connect, they process messages, and they
 	// don't register the tree or entitylist
@@ -93,3 +91,44 @@ func TestService(t *testing.T) {
 		}
 	}
 }
+
+// TestServiceSurveys tests that surveys are kept apart and closed once their results are queried.
+func TestServiceSurveys(t *testing.T) {
+	local := sda.NewLocalTest()
+	_, el, _ := local.GenTree(3, true, true, true)
+	defer local.CloseAll()
+
+	client := medco.NewMedcoClient(el.List[0])
+	surveyDesc := SurveyDescription{0, 2}
+	first, err := client.CreateSurvey(el, surveyDesc)
+	log.ErrFatal(err)
+	second, err := client.CreateSurvey(el, surveyDesc)
+	log.ErrFatal(err)
+
+	for i := 0; i < 3; i++ {
+		dataHolder := medco.NewMedcoClient(el.List[i])
+		log.ErrFatal(dataHolder.SendSurveyResultsData(*first, nil, []int64{1, 0}, el.Aggregate))
+		log.ErrFatal(dataHolder.SendSurveyResultsData(*second, nil, []int64{0, 2}, el.Aggregate))
+	}
+	if err := medco.NewMedcoClient(el.List[1]).SendSurveyResultsData("unknown", nil, []int64{1, 1}, el.Aggregate); err == nil {
+		t.Fatal("Data for an unknown survey should be rejected")
+	}
+
+	_, aggr, err := client.GetSurveyResults(*first)
+	log.ErrFatal(err)
+	if len(*aggr) != 1 || !reflect.DeepEqual((*aggr)[0], []int64{3, 0}) {
+		t.Fatal("Wrong results for the first survey:", *aggr)
+	}
+	if err := medco.NewMedcoClient(el.List[2]).SendSurveyResultsData(*first, nil, []int64{1, 1}, el.Aggregate); err == nil {
+		t.Fatal("Data for a closed survey should be rejected")
+	}
+	if _, _, err := client.GetSurveyResults(*first); err == nil {
+		t.Fatal("Results of a closed survey should not be computed again")
+	}
+
+	_, aggr, err = client.GetSurveyResults(*second)
+	log.ErrFatal(err)
+	if len(*aggr) != 1 || !reflect.DeepEqual((*aggr)[0], []int64{0, 6}) {
+		t.Fatal("Wrong results for the second survey:", *aggr)
+	}
+}
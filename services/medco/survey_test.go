@@ -0,0 +1,87 @@
+package medco
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/cothority/services/medco/libmedco"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_SaveLoad(t *testing.T) {
+	local := sda.NewLocalTest()
+	defer local.CloseAll()
+	_, el, s := local.MakeHELS(3, sda.ServiceFactory.ServiceID(ServiceName))
+	service := s.(*Service)
+	defer os.RemoveAll(service.path)
+
+	id := libmedco.SurveyID("save-load")
+	_, err := service.HandleSurveyCreationQuery(nil,
+		&SurveyCreationQuery{&id, *el, libmedco.SurveyDescription{0, 2}})
+	log.ErrFatal(err)
+	_, err = service.HandleSurveyResponseData(nil, &SurveyResponseQuery{id,
+		libmedco.ClientResponse{AggregatingAttributes: *libmedco.EncryptIntVector(el.Aggregate, []int64{1, 2})}})
+	log.ErrFatal(err)
+
+	loaded := &Service{ServiceProcessor: service.ServiceProcessor, path: service.path}
+	log.ErrFatal(loaded.tryLoad())
+	survey, ok := loaded.Surveys[id]
+	if !ok {
+		t.Fatal("Survey has not been loaded")
+	}
+	assert.True(t, survey.Survey.SurveyPHKey.Equal(service.Surveys[id].Survey.SurveyPHKey))
+	assert.Equal(t, 1, len(survey.Survey.LocGroupingAggregating))
+	assert.Equal(t, int64(0), survey.ClosedAt)
+
+	// Once closed, the responses are part of the saved survey
+	_, err = os.Stat(service.responsesFileName(id))
+	log.ErrFatal(err)
+	log.ErrFatal(service.closeSurvey(id))
+	_, err = os.Stat(service.responsesFileName(id))
+	assert.True(t, os.IsNotExist(err))
+	log.ErrFatal(loaded.tryLoad())
+	survey = loaded.Surveys[id]
+	assert.NotEqual(t, int64(0), survey.ClosedAt)
+	assert.Equal(t, 1, len(survey.Survey.LocGroupingAggregating))
+}
+
+func TestService_ExpireSurveys(t *testing.T) {
+	local := sda.NewLocalTest()
+	defer local.CloseAll()
+	_, el, s := local.MakeHELS(3, sda.ServiceFactory.ServiceID(ServiceName))
+	service := s.(*Service)
+	defer os.RemoveAll(service.path)
+
+	old := libmedco.SurveyID("old")
+	_, err := service.HandleSurveyCreationQuery(nil,
+		&SurveyCreationQuery{&old, *el, libmedco.SurveyDescription{0, 1}})
+	log.ErrFatal(err)
+	_, err = service.HandleSurveyCreationQuery(nil,
+		&SurveyCreationQuery{&old, *el, libmedco.SurveyDescription{0, 1}})
+	assert.NotNil(t, err, "Shouldn't create the same survey twice")
+
+	response := libmedco.ClientResponse{AggregatingAttributes: *libmedco.EncryptIntVector(el.Aggregate, []int64{1})}
+	recent := libmedco.SurveyID("recent")
+	_, err = service.HandleSurveyCreationQuery(nil,
+		&SurveyCreationQuery{&recent, *el, libmedco.SurveyDescription{0, 1}})
+	log.ErrFatal(err)
+	log.ErrFatal(service.closeSurvey(recent))
+	_, err = service.HandleSurveyResponseData(nil, &SurveyResponseQuery{recent, response})
+	assert.NotNil(t, err, "Closed survey shouldn't accept data")
+
+	// Every access removes the expired surveys, and their files.
+	service.Surveys[old].ClosedAt = time.Now().Add(-2 * SurveyExpiration).Unix()
+	_, err = service.HandleSurveyResponseData(nil, &SurveyResponseQuery{recent, response})
+	assert.NotNil(t, err)
+	_, ok := service.Surveys[old]
+	assert.False(t, ok)
+	_, ok = service.Surveys[recent]
+	assert.True(t, ok)
+	_, err = os.Stat(service.surveyFileName(old))
+	assert.True(t, os.IsNotExist(err))
+	_, err = service.HandleSurveyResponseData(nil, &SurveyResponseQuery{old, response})
+	assert.NotNil(t, err, "Unknown survey shouldn't accept data")
+}
@@ -0,0 +1,134 @@
+package randhound
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/dedis/cothority/crypto"
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/protocols/randhound"
+	"github.com/dedis/cothority/sda"
+	"github.com/dedis/crypto/abstract"
+)
+
+// Client is a structure to communicate with the RandHound service
+type Client struct {
+	*sda.Client
+}
+
+// NewClient instantiates a new RandHound client
+func NewClient() *Client {
+	return &Client{Client: sda.NewClient(ServiceName)}
+}
+
+// Setup configures the service on the first node of the roster with the
+// private key of that node. setup.Version has to be one more than the actual
+// version, which is 0 before the first setup.
+func (c *Client) Setup(setup *SetupRequest, private abstract.Scalar) (*SetupResponse, error) {
+	if setup.Roster == nil || len(setup.Roster.List) == 0 {
+		return nil, errors.New("No roster given")
+	}
+	hash, err := setup.Hash()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.SignSchnorr(network.Suite, private, hash)
+	if err != nil {
+		return nil, err
+	}
+	setup.Signature = &sig
+	reply, err := c.Send(setup.Roster.List[0], setup)
+	if e := sda.ErrMsg(reply, err); e != nil {
+		return nil, e
+	}
+	sr, ok := reply.Msg.(SetupResponse)
+	if !ok {
+		return nil, errors.New("This is odd: couldn't cast reply.")
+	}
+	return &sr, nil
+}
+
+// Stop asks the root node to stop the service, with the private key of the
+// root. version has to be one more than the actual version.
+func (c *Client) Stop(root *network.ServerIdentity, version int,
+	private abstract.Scalar) (*StopResponse, error) {
+	stop := &StopRequest{Version: version}
+	hash, err := stop.Hash()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.SignSchnorr(network.Suite, private, hash)
+	if err != nil {
+		return nil, err
+	}
+	stop.Signature = &sig
+	reply, err := c.Send(root, stop)
+	if e := sda.ErrMsg(reply, err); e != nil {
+		return nil, e
+	}
+	sr, ok := reply.Msg.(StopResponse)
+	if !ok {
+		return nil, errors.New("This is odd: couldn't cast reply.")
+	}
+	return &sr, nil
+}
+
+// Random asks the root node to run the round with the given index, which has
+// to be the index of the next round, with the private key of the root. The
+// transcript of the round can be requested with the index.
+func (c *Client) Random(root *network.ServerIdentity, index int,
+	private abstract.Scalar) (*RandomResponse, error) {
+	req := &RandomRequest{Index: index}
+	hash, err := req.Hash()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.SignSchnorr(network.Suite, private, hash)
+	if err != nil {
+		return nil, err
+	}
+	req.Signature = &sig
+	reply, err := c.Send(root, req)
+	if e := sda.ErrMsg(reply, err); e != nil {
+		return nil, e
+	}
+	rr, ok := reply.Msg.(RandomResponse)
+	if !ok {
+		return nil, errors.New("This is odd: couldn't cast reply.")
+	}
+	return &rr, nil
+}
+
+// Transcript returns the transcript of the round with the given index, or of
+// the latest round if index is negative.
+func (c *Client) Transcript(root *network.ServerIdentity, index int) (*TranscriptResponse, error) {
+	reply, err := c.Send(root, &TranscriptRequest{Index: index})
+	if e := sda.ErrMsg(reply, err); e != nil {
+		return nil, e
+	}
+	tr, ok := reply.Msg.(TranscriptResponse)
+	if !ok {
+		return nil, errors.New("This is odd: couldn't cast reply.")
+	}
+	if tr.Transcript == nil {
+		return nil, errors.New("No transcript in reply")
+	}
+	return &tr, nil
+}
+
+// Verify checks that random is the output of a valid RandHound run of the
+// nodes of the roster for the round with the given index of a service
+// configured with purpose, as proven by the transcript. It doesn't need to
+// contact the service.
+func Verify(roster *sda.Roster, purpose string, index int, random []byte,
+	t *randhound.Transcript) error {
+	if !bytes.Equal(random, t.Random) {
+		return errors.New("Randomness is not the output of the transcript")
+	}
+	if t.Session == nil || t.Session.Purpose != RoundPurpose(purpose, index) {
+		return errors.New("Transcript is not the one of round " +
+			strconv.Itoa(index) + " of " + purpose)
+	}
+	return randhound.VerifyTranscript(network.Suite, roster, t)
+}
@@ -0,0 +1,575 @@
+// Package randhound runs RandHound rounds on a roster to produce public
+// randomness, either on demand or on a schedule. The service is controlled by
+// the operator of the conode: the setup, the stop and every round on demand
+// have to be signed with the private key of the conode. The transcripts of the
+// last MaxRounds rounds are stored and can be requested by clients, who verify
+// them offline against the roster with Verify.
+package randhound
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dedis/cothority/crypto"
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/protocols/randhound"
+	"github.com/dedis/cothority/sda"
+	"github.com/satori/go.uuid"
+)
+
+// ServiceName can be used to refer to the name of the RandHound service
+const ServiceName = "RandHound"
+
+// protocolName is the name of the RandHound protocol run by the service
+const protocolName = "RandHound"
+
+// DefaultRoundTimeout is how long the service waits for a round to finish.
+const DefaultRoundTimeout = time.Minute
+
+// MaxRounds is the number of transcripts kept by the service, the older ones
+// are deleted.
+const MaxRounds = 1000
+
+var randhoundSID sda.ServiceID
+
+func init() {
+	sda.RegisterNewService(ServiceName, newRandHoundService)
+	randhoundSID = sda.ServiceFactory.ServiceID(ServiceName)
+	network.RegisterPacketType(&SetupRequest{})
+	network.RegisterPacketType(&SetupResponse{})
+	network.RegisterPacketType(&StopRequest{})
+	network.RegisterPacketType(&StopResponse{})
+	network.RegisterPacketType(&RandomRequest{})
+	network.RegisterPacketType(&RandomResponse{})
+	network.RegisterPacketType(&TranscriptRequest{})
+	network.RegisterPacketType(&TranscriptResponse{})
+	network.RegisterPacketType(&storage{})
+	network.RegisterPacketType(&round{})
+}
+
+// Service runs the RandHound rounds and stores their transcripts.
+type Service struct {
+	*sda.ServiceProcessor
+	// config path for service:
+	path string
+
+	roster   *sda.Roster
+	trustees int
+	purpose  string
+	interval time.Duration
+	version  int
+	stopped  bool
+	// configLock protects the configuration
+	configLock sync.Mutex
+	// roundLock makes sure only one round runs at a time
+	roundLock sync.Mutex
+	// stop is closed to interrupt the scheduled rounds
+	stop chan bool
+	// loopDone is closed once the scheduled rounds are interrupted
+	loopDone chan bool
+	// rounds are the transcripts of the last MaxRounds rounds
+	rounds map[int]*randhound.Transcript
+	// next is the index of the next round
+	next int
+	// roundsLock protects rounds and next
+	roundsLock sync.Mutex
+}
+
+// storage is the configuration saved to disk. The transcripts are saved in
+// a file per round.
+type storage struct {
+	Roster   *sda.Roster
+	Trustees int
+	Purpose  string
+	Interval time.Duration
+	Version  int
+	Stopped  bool
+}
+
+// round is the transcript of a round saved to disk.
+type round struct {
+	Transcript *randhound.Transcript
+}
+
+// config holds the parameters of the rounds.
+type config struct {
+	roster   *sda.Roster
+	trustees int
+	purpose  string
+}
+
+// SetupRequest configures the service. The first node of the roster has to
+// be the conode receiving the request, it is the leader of all rounds. A
+// running configuration is replaced.
+type SetupRequest struct {
+	Roster *sda.Roster
+	// Trustees is the number of nodes every peer shares its secret with, all
+	// other peers if zero
+	Trustees int
+	// Purpose of the randomness, included with the index of the round in the
+	// session of every round
+	Purpose string
+	// Interval between two scheduled rounds, no rounds are scheduled if zero
+	Interval time.Duration
+	// Version has to be one more than the actual version.
+	Version int
+	// Signature by the conode on Hash
+	Signature *crypto.SchnorrSig
+}
+
+// SetupResponse returns the version of the configuration.
+type SetupResponse struct {
+	Version int
+}
+
+// StopRequest asks the service to stop the scheduled rounds and to refuse
+// the rounds on demand.
+type StopRequest struct {
+	// Version has to be one more than the actual version.
+	Version int
+	// Signature by the conode on Hash
+	Signature *crypto.SchnorrSig
+}
+
+// StopResponse returns the version of the stopped configuration.
+type StopResponse struct {
+	Version int
+}
+
+// RandomRequest asks the service to run a round.
+type RandomRequest struct {
+	// Index has to be the index of the next round, so that a request can't
+	// be replayed.
+	Index int
+	// Signature by the conode on Hash
+	Signature *crypto.SchnorrSig
+}
+
+// RandomResponse holds the randomness of a round and the index of its
+// transcript.
+type RandomResponse struct {
+	Index  int
+	Random []byte
+}
+
+// TranscriptRequest asks for the transcript of the round with the given
+// index. A negative index asks for the latest round.
+type TranscriptRequest struct {
+	Index int
+}
+
+// TranscriptResponse holds the transcript of a round.
+type TranscriptResponse struct {
+	Index      int
+	Transcript *randhound.Transcript
+}
+
+// Hash returns the hash of the request that the conode signs to configure
+// the service. It covers all members of the roster.
+func (setup *SetupRequest) Hash() ([]byte, error) {
+	h := sha256.New()
+	h.Write([]byte("setup"))
+	if setup.Roster != nil {
+		if err := setup.Roster.HashTo(h); err != nil {
+			return nil, err
+		}
+	}
+	for _, i := range []int64{int64(setup.Trustees), int64(setup.Interval),
+		int64(setup.Version)} {
+		if err := binary.Write(h, binary.LittleEndian, i); err != nil {
+			return nil, err
+		}
+	}
+	h.Write([]byte(setup.Purpose))
+	return h.Sum(nil), nil
+}
+
+// Hash returns the hash of the request that the conode signs to stop the
+// service.
+func (stop *StopRequest) Hash() ([]byte, error) {
+	h := sha256.New()
+	h.Write([]byte("stop"))
+	if err := binary.Write(h, binary.LittleEndian, int64(stop.Version)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Hash returns the hash of the request that the conode signs to run a round.
+func (req *RandomRequest) Hash() ([]byte, error) {
+	h := sha256.New()
+	h.Write([]byte("random"))
+	if err := binary.Write(h, binary.LittleEndian, int64(req.Index)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// RoundPurpose returns the purpose of the session of the round with the
+// given index.
+func RoundPurpose(purpose string, index int) string {
+	return purpose + " #" + strconv.Itoa(index)
+}
+
+// NewProtocol is called on all nodes of a round except the leader.
+func (s *Service) NewProtocol(tn *sda.TreeNodeInstance, conf *sda.GenericConfig) (sda.ProtocolInstance, error) {
+	log.Lvl3("RandHound Service received New Protocol event")
+	return randhound.NewRandHound(tn)
+}
+
+// Setup handles `SetupRequest`s signed by the conode by storing the
+// configuration and starting the scheduled rounds.
+func (s *Service) Setup(si *network.ServerIdentity, setup *SetupRequest) (network.Body, error) {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	if setup.Version != s.version+1 {
+		return nil, errors.New("Wrong version: need " + fmt.Sprint(s.version+1))
+	}
+	hash, err := setup.Hash()
+	if err != nil {
+		return nil, err
+	}
+	if err := crypto.VerifySignature(network.Suite, s.ServerIdentity().Public,
+		hash, setup.Signature); err != nil {
+		return nil, err
+	}
+	if setup.Roster == nil || len(setup.Roster.List) < 2 {
+		return nil, errors.New("Need a roster with at least 2 nodes")
+	}
+	if !setup.Roster.List[0].ID.Equal(s.ServerIdentity().ID) {
+		return nil, errors.New("This conode has to be the root of the roster")
+	}
+	peers := len(setup.Roster.List) - 1
+	if setup.Trustees == 0 {
+		setup.Trustees = peers
+	}
+	if setup.Trustees < 0 || setup.Trustees > peers {
+		return nil, fmt.Errorf("Number of trustees has to be between 1 and %d", peers)
+	}
+	if setup.Interval < 0 {
+		return nil, errors.New("Interval can't be negative")
+	}
+
+	s.stopLoop()
+	s.roster = setup.Roster
+	s.trustees = setup.Trustees
+	s.purpose = setup.Purpose
+	s.interval = setup.Interval
+	s.version = setup.Version
+	s.stopped = false
+	s.save()
+	s.startLoop()
+	return &SetupResponse{Version: s.version}, nil
+}
+
+// Stop handles `StopRequest`s signed by the conode by interrupting the
+// scheduled rounds. The rounds on demand are refused until the next setup.
+func (s *Service) Stop(si *network.ServerIdentity, stop *StopRequest) (network.Body, error) {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	if stop.Version != s.version+1 {
+		return nil, errors.New("Wrong version: need " + fmt.Sprint(s.version+1))
+	}
+	hash, err := stop.Hash()
+	if err != nil {
+		return nil, err
+	}
+	if err := crypto.VerifySignature(network.Suite, s.ServerIdentity().Public,
+		hash, stop.Signature); err != nil {
+		return nil, err
+	}
+	s.stopLoop()
+	s.version = stop.Version
+	s.stopped = true
+	s.save()
+	log.Lvl2("Stopped RandHound service")
+	return &StopResponse{Version: s.version}, nil
+}
+
+// Random handles `RandomRequest`s signed by the conode by running a round.
+func (s *Service) Random(si *network.ServerIdentity, req *RandomRequest) (network.Body, error) {
+	if req.Index < 0 {
+		return nil, errors.New("Index can't be negative")
+	}
+	hash, err := req.Hash()
+	if err != nil {
+		return nil, err
+	}
+	s.configLock.Lock()
+	if err := crypto.VerifySignature(network.Suite, s.ServerIdentity().Public,
+		hash, req.Signature); err != nil {
+		s.configLock.Unlock()
+		return nil, err
+	}
+	if s.roster == nil || s.stopped {
+		s.configLock.Unlock()
+		return nil, errors.New("RandHound service is not running")
+	}
+	conf := s.config()
+	s.configLock.Unlock()
+
+	t, err := s.run(conf, req.Index)
+	if err != nil {
+		return nil, err
+	}
+	return &RandomResponse{Index: req.Index, Random: t.Random}, nil
+}
+
+// Transcript handles `TranscriptRequest`s by returning the stored transcript.
+func (s *Service) Transcript(si *network.ServerIdentity, req *TranscriptRequest) (network.Body, error) {
+	s.roundsLock.Lock()
+	defer s.roundsLock.Unlock()
+	index := req.Index
+	if index < 0 {
+		index = s.next - 1
+	}
+	t, ok := s.rounds[index]
+	if !ok {
+		return nil, errors.New("Unknown round: " + fmt.Sprint(s.next) +
+			" rounds finished, the last " + fmt.Sprint(MaxRounds) + " are stored")
+	}
+	return &TranscriptResponse{Index: index, Transcript: t}, nil
+}
+
+// config returns the parameters of the rounds. The configLock must be held.
+func (s *Service) config() *config {
+	return &config{roster: s.roster, trustees: s.trustees, purpose: s.purpose}
+}
+
+// run runs the round with the given index, or the next round if index is
+// negative, and stores its transcript.
+func (s *Service) run(conf *config, index int) (*randhound.Transcript, error) {
+	s.roundLock.Lock()
+	defer s.roundLock.Unlock()
+	s.roundsLock.Lock()
+	next := s.next
+	s.roundsLock.Unlock()
+	if index < 0 {
+		index = next
+	}
+	if index != next {
+		return nil, errors.New("Wrong index: need " + fmt.Sprint(next))
+	}
+
+	tree := conf.roster.GenerateBinaryTree()
+	pi, err := s.CreateProtocolService(protocolName, tree)
+	if err != nil {
+		return nil, errors.New("Couldn't make new protocol: " + err.Error())
+	}
+	rh := pi.(*randhound.RandHound)
+	defer rh.Done()
+	if err := rh.Setup(uint32(len(conf.roster.List)), uint32(conf.trustees),
+		RoundPurpose(conf.purpose, index)); err != nil {
+		return nil, err
+	}
+	if err := rh.Start(); err != nil {
+		return nil, err
+	}
+	select {
+	case <-rh.Leader.Done:
+	case <-time.After(DefaultRoundTimeout):
+		return nil, errors.New("RandHound round timed out")
+	}
+	t, err := rh.Transcript()
+	if err != nil {
+		return nil, err
+	}
+
+	s.saveRound(index, t)
+	s.roundsLock.Lock()
+	s.rounds[index] = t
+	s.next = index + 1
+	s.prune()
+	s.roundsLock.Unlock()
+	log.Lvl2("Finished RandHound round", index)
+	return t, nil
+}
+
+// prune deletes the transcripts older than the last MaxRounds rounds. The
+// roundsLock must be held.
+func (s *Service) prune() {
+	for index := range s.rounds {
+		if index >= s.next-MaxRounds {
+			continue
+		}
+		delete(s.rounds, index)
+		if err := os.Remove(s.roundFileName(index)); err != nil &&
+			!os.IsNotExist(err) {
+			log.Error("Couldn't remove transcript:", err)
+		}
+	}
+}
+
+// startLoop starts the scheduled rounds if an interval is configured. The
+// configLock must be held.
+func (s *Service) startLoop() {
+	if s.interval <= 0 || s.stopped || s.roster == nil || s.stop != nil {
+		return
+	}
+	s.stop = make(chan bool)
+	s.loopDone = make(chan bool)
+	go s.runLoop(s.config(), s.interval, s.stop, s.loopDone)
+}
+
+// stopLoop interrupts the scheduled rounds and waits for the running round
+// to finish. The configLock must be held.
+func (s *Service) stopLoop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.loopDone
+	s.stop = nil
+	s.loopDone = nil
+}
+
+// runLoop runs a round every interval until stop is closed.
+func (s *Service) runLoop(conf *config, interval time.Duration, stop, loopDone chan bool) {
+	defer close(loopDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.run(conf, -1); err != nil {
+				log.Error("Couldn't run scheduled round:", err)
+			}
+		case <-stop:
+			log.Lvl2("Scheduled rounds interrupted")
+			return
+		}
+	}
+}
+
+// fileName returns the file where the configuration is saved. The services
+// of all conodes of a local test share the same path.
+func (s *Service) fileName() string {
+	return path.Join(s.path, "randhound-"+uuid.UUID(s.ServerIdentity().ID).String()+".bin")
+}
+
+// roundFileName returns the file where the transcript of the round with the
+// given index is saved.
+func (s *Service) roundFileName(index int) string {
+	return strings.TrimSuffix(s.fileName(), ".bin") + "-" + strconv.Itoa(index) + ".bin"
+}
+
+// save stores the configuration of the service. The configLock must be held.
+func (s *Service) save() {
+	log.Lvl3("Saving service")
+	b, err := network.MarshalRegisteredType(&storage{
+		Roster:   s.roster,
+		Trustees: s.trustees,
+		Purpose:  s.purpose,
+		Interval: s.interval,
+		Version:  s.version,
+		Stopped:  s.stopped,
+	})
+	if err != nil {
+		log.Error("Couldn't marshal service:", err)
+		return
+	}
+	err = ioutil.WriteFile(s.fileName(), b, 0660)
+	if err != nil {
+		log.Error("Couldn't save file:", err)
+	}
+}
+
+// saveRound stores the transcript of a round in its own file, so that the
+// other transcripts don't have to be written again.
+func (s *Service) saveRound(index int, t *randhound.Transcript) {
+	b, err := network.MarshalRegisteredType(&round{Transcript: t})
+	if err != nil {
+		log.Error("Couldn't marshal transcript:", err)
+		return
+	}
+	err = ioutil.WriteFile(s.roundFileName(index), b, 0660)
+	if err != nil {
+		log.Error("Couldn't save file:", err)
+	}
+}
+
+// Tries to load the configuration and the transcripts and updates if they
+// are found, else it returns an error.
+func (s *Service) tryLoad() error {
+	b, err := ioutil.ReadFile(s.fileName())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error while reading %s: %s", s.fileName(), err)
+	}
+	if len(b) > 0 {
+		_, msg, err := network.UnmarshalRegistered(b)
+		if err != nil {
+			return fmt.Errorf("Couldn't unmarshal: %s", err)
+		}
+		log.Lvl3("Successfully loaded")
+		st := msg.(*storage)
+		s.roster = st.Roster
+		s.trustees = st.Trustees
+		s.purpose = st.Purpose
+		s.interval = st.Interval
+		s.version = st.Version
+		s.stopped = st.Stopped
+	}
+
+	prefix := strings.TrimSuffix(s.fileName(), ".bin") + "-"
+	files, err := filepath.Glob(prefix + "*.bin")
+	if err != nil {
+		return err
+	}
+	s.roundsLock.Lock()
+	defer s.roundsLock.Unlock()
+	s.rounds = make(map[int]*randhound.Transcript)
+	s.next = 0
+	for _, f := range files {
+		index, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(f, prefix), ".bin"))
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("Error while reading %s: %s", f, err)
+		}
+		_, msg, err := network.UnmarshalRegistered(b)
+		if err != nil {
+			return fmt.Errorf("Couldn't unmarshal %s: %s", f, err)
+		}
+		s.rounds[index] = msg.(*round).Transcript
+		if index >= s.next {
+			s.next = index + 1
+		}
+	}
+	s.prune()
+	return nil
+}
+
+func newRandHoundService(c *sda.Context, path string) sda.Service {
+	s := &Service{
+		ServiceProcessor: sda.NewServiceProcessor(c),
+		path:             path,
+		rounds:           make(map[int]*randhound.Transcript),
+	}
+	for _, f := range []interface{}{s.Setup, s.Stop, s.Random, s.Transcript} {
+		if err := s.RegisterMessage(f); err != nil {
+			log.ErrFatal(err, "Couldn't register message:")
+		}
+	}
+
+	// resume the scheduled rounds of a saved configuration
+	if err := s.tryLoad(); err != nil {
+		log.Error(err)
+	}
+	s.configLock.Lock()
+	s.startLoop()
+	s.configLock.Unlock()
+	return s
+}
@@ -0,0 +1,152 @@
+package randhound
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/sda"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	os.RemoveAll("config")
+	log.MainTest(m)
+}
+
+func TestService_Setup(t *testing.T) {
+	defer log.AfterTest(t)
+	local := sda.NewLocalTest()
+	hosts, roster, _ := local.GenTree(4, false, true, false)
+	defer local.CloseAll()
+	c := NewClient()
+	root := roster.List[0]
+	private := local.GetPrivate(hosts[0])
+
+	_, err := c.Random(root, 0, private)
+	assert.NotNil(t, err, "Round without setup")
+	_, err = c.Setup(&SetupRequest{Roster: roster, Trustees: 4,
+		Purpose: "test", Version: 1}, private)
+	assert.NotNil(t, err, "Too many trustees")
+	_, err = c.Setup(&SetupRequest{Roster: roster, Purpose: "test",
+		Version: 1}, local.GetPrivate(hosts[1]))
+	assert.NotNil(t, err, "Setup not signed by the root")
+	_, err = c.Setup(&SetupRequest{Roster: roster, Purpose: "test"}, private)
+	assert.NotNil(t, err, "Setup with wrong version")
+	sr, err := c.Setup(&SetupRequest{Roster: roster, Purpose: "test",
+		Version: 1}, private)
+	log.ErrFatal(err)
+	assert.Equal(t, 1, sr.Version)
+
+	// Only the root can reconfigure and stop the service
+	_, err = c.Setup(&SetupRequest{Roster: roster, Purpose: "other",
+		Version: 2}, local.GetPrivate(hosts[1]))
+	assert.NotNil(t, err)
+	_, err = c.Setup(&SetupRequest{Roster: roster, Purpose: "other",
+		Version: 2}, private)
+	log.ErrFatal(err)
+	_, err = c.Stop(root, 3, local.GetPrivate(hosts[1]))
+	assert.NotNil(t, err)
+	_, err = c.Stop(root, 3, private)
+	log.ErrFatal(err)
+	_, err = c.Random(root, 0, private)
+	assert.NotNil(t, err, "Round on stopped service")
+
+	s := local.GetServices(hosts, randhoundSID)[0].(*Service)
+	loaded := &Service{ServiceProcessor: s.ServiceProcessor, path: s.path}
+	log.ErrFatal(loaded.tryLoad())
+	assert.Equal(t, "other", loaded.purpose)
+	assert.Equal(t, 3, loaded.version)
+	assert.True(t, loaded.stopped)
+}
+
+func TestService_Random(t *testing.T) {
+	defer log.AfterTest(t)
+	local := sda.NewLocalTest()
+	hosts, roster, _ := local.GenTree(5, false, true, false)
+	defer local.CloseAll()
+	c := NewClient()
+	root := roster.List[0]
+	private := local.GetPrivate(hosts[0])
+	_, err := c.Setup(&SetupRequest{Roster: roster, Trustees: 3,
+		Purpose: "test", Version: 1}, private)
+	log.ErrFatal(err)
+
+	_, err = c.Random(root, 0, local.GetPrivate(hosts[1]))
+	assert.NotNil(t, err, "Round not signed by the root")
+	rr, err := c.Random(root, 0, private)
+	log.ErrFatal(err)
+	assert.Equal(t, 0, rr.Index)
+	_, err = c.Random(root, 0, private)
+	assert.NotNil(t, err, "Replayed round")
+	tr, err := c.Transcript(root, rr.Index)
+	log.ErrFatal(err)
+	log.ErrFatal(Verify(roster, "test", 0, rr.Random, tr.Transcript))
+	assert.NotNil(t, Verify(roster, "test", 1, rr.Random, tr.Transcript))
+	assert.NotNil(t, Verify(roster, "other", 0, rr.Random, tr.Transcript))
+
+	rr2, err := c.Random(root, 1, private)
+	log.ErrFatal(err)
+	assert.Equal(t, 1, rr2.Index)
+	assert.NotEqual(t, rr.Random, rr2.Random)
+	assert.NotNil(t, Verify(roster, "test", 1, rr2.Random, tr.Transcript))
+	tr, err = c.Transcript(root, -1)
+	log.ErrFatal(err)
+	assert.Equal(t, 1, tr.Index)
+	log.ErrFatal(Verify(roster, "test", 1, rr2.Random, tr.Transcript))
+	_, err = c.Transcript(root, 2)
+	assert.NotNil(t, err)
+
+	// The transcripts are saved
+	s := local.GetServices(hosts, randhoundSID)[0].(*Service)
+	loaded := &Service{ServiceProcessor: s.ServiceProcessor, path: s.path}
+	log.ErrFatal(loaded.tryLoad())
+	assert.Equal(t, 3, loaded.trustees)
+	assert.Equal(t, 2, len(loaded.rounds))
+	assert.Equal(t, 2, loaded.next)
+	log.ErrFatal(Verify(roster, "test", 1, rr2.Random, loaded.rounds[1]))
+
+	// Only the last MaxRounds transcripts are kept
+	s.roundsLock.Lock()
+	s.next = MaxRounds + 1
+	s.prune()
+	s.roundsLock.Unlock()
+	_, err = c.Transcript(root, 0)
+	assert.NotNil(t, err)
+	_, err = c.Transcript(root, 1)
+	log.ErrFatal(err)
+	log.ErrFatal(loaded.tryLoad())
+	assert.Equal(t, 1, len(loaded.rounds))
+	assert.Equal(t, 2, loaded.next)
+}
+
+func TestService_Schedule(t *testing.T) {
+	defer log.AfterTest(t)
+	local := sda.NewLocalTest()
+	hosts, roster, _ := local.GenTree(3, false, true, false)
+	defer local.CloseAll()
+	c := NewClient()
+	root := roster.List[0]
+	private := local.GetPrivate(hosts[0])
+	_, err := c.Setup(&SetupRequest{Roster: roster, Purpose: "scheduled",
+		Interval: 100 * time.Millisecond, Version: 1}, private)
+	log.ErrFatal(err)
+
+	s := local.GetServices(hosts, randhoundSID)[0].(*Service)
+	for i := 0; i < 100; i++ {
+		s.roundsLock.Lock()
+		n := s.next
+		s.roundsLock.Unlock()
+		if n >= 2 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	_, err = c.Stop(root, 2, private)
+	log.ErrFatal(err)
+
+	tr, err := c.Transcript(root, 1)
+	log.ErrFatal(err)
+	log.ErrFatal(Verify(roster, "scheduled", 1, tr.Transcript.Random, tr.Transcript))
+}
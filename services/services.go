@@ -7,7 +7,10 @@ import (
 	_ "github.com/dedis/cothority/services/debianupdate"
 	_ "github.com/dedis/cothority/services/guard"
 	_ "github.com/dedis/cothority/services/identity"
+	_ "github.com/dedis/cothority/services/medco"
+	_ "github.com/dedis/cothority/services/randhound"
 	_ "github.com/dedis/cothority/services/skipchain"
 	_ "github.com/dedis/cothority/services/status"
 	_ "github.com/dedis/cothority/services/swupdate"
+	_ "github.com/dedis/cothority/services/timestamp"
 )
@@ -51,6 +51,25 @@ func VerificationRegistration(v VerifierID, f bftcosi.VerificationFunction) erro
 	return nil
 }
 
+// BlockVerificationFunction verifies a new SkipBlock together with the
+// previous block of its skipchain, which is nil for a genesis block.
+type BlockVerificationFunction func(previous, newest *SkipBlock) bool
+
+var blockVerifiers map[VerifierID]BlockVerificationFunction
+
+// BlockVerificationRegistration stores a verification that needs the
+// previous block of the skipchain. It is called instead of a verification
+// stored with VerificationRegistration for the same VerifierID.
+func BlockVerificationRegistration(v VerifierID, f BlockVerificationFunction) error {
+	verifiersMutex.Lock()
+	if len(blockVerifiers) == 0 {
+		blockVerifiers = map[VerifierID]BlockVerificationFunction{}
+	}
+	blockVerifiers[v] = f
+	verifiersMutex.Unlock()
+	return nil
+}
+
 var (
 	// VerifyNone does only basic syntax checking
 	VerifyNone = VerifierID(uuid.Nil)
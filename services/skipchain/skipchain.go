@@ -40,6 +40,9 @@ func init() {
 
 var skipchainSID sda.ServiceID
 
+// signatureTimeout is how long we wait for the BFT-signature of a new block
+var signatureTimeout = time.Minute * 30
+
 // Service handles adding new SkipBlocks
 type Service struct {
 	*sda.ServiceProcessor
@@ -335,7 +338,7 @@ func (s *Service) startBFTSignature(block *SkipBlock) error {
 		if err := block.BlockSig.Verify(network.Suite, el.Publics()); err != nil {
 			return errors.New("Couldn't verify signature")
 		}
-	case <-time.After(time.Minute * 30):
+	case <-time.After(signatureTimeout):
 		return errors.New("Timed out while waiting for signature")
 	}
 	return nil
@@ -454,7 +457,22 @@ func (s *Service) bftVerify(msg []byte, data []byte) bool {
 		// launch the reproducible build
 		buildT.Record()
 	default:
+		verifiersMutex.Lock()
+		bf, bok := blockVerifiers[sb.VerifierID]
 		f, ok := verifiers[sb.VerifierID]
+		verifiersMutex.Unlock()
+		if bok {
+			log.Lvlf3("Found user block verification %x", sb.VerifierID)
+			var previous *SkipBlock
+			if sb.Index > 0 {
+				previous, ok = s.getSkipBlockByID(sb.BackLinkIds[0])
+				if !ok {
+					log.Lvl2("Previous skipblock doesn't exist")
+					return false
+				}
+			}
+			return bf(previous, sb)
+		}
 		if ok {
 			log.Lvlf3("Found user verification %x", sb.VerifierID)
 			return f(msg, data)
@@ -9,8 +9,10 @@ import (
 
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
 	"github.com/dedis/cothority/sda"
 	"github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
@@ -365,6 +367,44 @@ func TestService_SignBlock(t *testing.T) {
 	log.ErrFatal(sbSecond.VerifySignatures())
 }
 
+func TestService_SignBlockFaults(t *testing.T) {
+	// Testing how the signing of SkipBlocks copes with a slow network and
+	// a failing node
+	defer func(timeout time.Duration) {
+		signatureTimeout = timeout
+	}(signatureTimeout)
+	signatureTimeout = 5 * time.Second
+	local := sda.NewLocalTest()
+	defer local.CloseAll()
+	local.Faults = network.NewFaultInjector(&network.FaultScenario{
+		Default: network.LinkFault{
+			Delay:  network.Duration{Duration: 20 * time.Millisecond},
+			Jitter: network.Duration{Duration: 10 * time.Millisecond},
+		},
+	})
+	hosts, el, service := makeHELS(local, 4)
+
+	log.Lvl1("Signing with a slow network")
+	sb := makeGenesisRoster(service, el)
+	log.ErrFatal(sb.VerifySignatures())
+
+	log.Lvl1("Signing with a failed node")
+	local.Faults.Isolate(hosts[3].ServerIdentity.Addresses...)
+	next := NewSkipBlock()
+	next.Roster = sb.Roster
+	_, err := service.ProposeSkipBlock(nil, &ProposeSkipBlock{sb.Hash, next})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, service.lenSkipBlocks())
+
+	log.Lvl1("Signing once the node is back")
+	local.Faults.Heal()
+	next = NewSkipBlock()
+	next.Roster = sb.Roster
+	psbr, err := service.ProposeSkipBlock(nil, &ProposeSkipBlock{sb.Hash, next})
+	log.ErrFatal(err)
+	log.ErrFatal(psbr.(*ProposedSkipBlockReply).Latest.VerifySignatures())
+}
+
 func TestService_ProtocolVerification(t *testing.T) {
 	// Testing whether we sign correctly the SkipBlocks
 	local := sda.NewLocalTest()
@@ -417,6 +457,32 @@ func TestService_RegisterVerification(t *testing.T) {
 	assert.Equal(t, 3, len(ver))
 }
 
+func TestService_RegisterBlockVerification(t *testing.T) {
+	local := sda.NewLocalTest()
+	defer local.CloseAll()
+	_, el, s1 := makeHELS(local, 3)
+	VerifyTest := VerifierID(uuid.NewV5(uuid.NamespaceURL, "Test2"))
+	ver := make(chan *SkipBlock, 6)
+	verifier := func(previous, newest *SkipBlock) bool {
+		ver <- previous
+		return true
+	}
+	log.ErrFatal(BlockVerificationRegistration(VerifyTest, verifier))
+	sb := makeGenesisRosterArgs(s1, el, nil, VerifyTest, 1, 1)
+	assert.Equal(t, 3, len(ver))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, <-ver)
+	}
+	next := NewSkipBlock()
+	next.Roster = el
+	_, err := s1.ProposeSkipBlock(nil, &ProposeSkipBlock{sb.Hash, next})
+	log.ErrFatal(err)
+	assert.Equal(t, 3, len(ver))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, sb.Hash, (<-ver).Hash)
+	}
+}
+
 // makes a genesis Roster-block
 func makeGenesisRosterArgs(s *Service, el *sda.Roster, parent SkipBlockID,
 	vid VerifierID, base, height int) *SkipBlock {
@@ -122,7 +122,18 @@ func NewSkipBlock() *SkipBlock {
 
 // VerifySignatures returns whether all signatures are correctly signed
 // by the aggregate public key of the roster. It needs the aggregate key.
+// The signed message has to be the hash of the block, so that the signature
+// of one block can't be attached to another.
 func (sb *SkipBlock) VerifySignatures() error {
+	if sb.SkipBlockFix == nil || sb.Roster == nil || sb.BlockSig == nil {
+		return errors.New("Block without content or signature")
+	}
+	if !sb.Hash.Equal(sb.calculateHash()) {
+		return errors.New("Hash doesn't match the block")
+	}
+	if !bytes.Equal(sb.BlockSig.Msg, sb.Hash) {
+		return errors.New("Signature is not on the hash of the block")
+	}
 	if err := sb.BlockSig.Verify(network.Suite, sb.Roster.Publics()); err != nil {
 		log.Error(err.Error() + log.Stack())
 		return err
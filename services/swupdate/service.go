@@ -25,11 +25,12 @@ import (
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/monitor"
 	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/protocols/cosi"
 	"github.com/dedis/cothority/protocols/manage"
-	"github.com/dedis/cothority/protocols/swupdate"
 	"github.com/dedis/cothority/sda"
 	"github.com/dedis/cothority/services/skipchain"
 	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
 )
 
 // This file contains all the code to run a CoSi service. It is used to reply to
@@ -237,7 +238,7 @@ func (cs *Service) NewProtocol(tn *sda.TreeNodeInstance, conf *sda.GenericConfig
 		pi.(*manage.Propagate).RegisterOnData(cs.PropagateSkipBlock)
 	default:
 		log.Lvl2("SWUpdate Service received New Protocol COSI event")
-		pi, err = swupdate.NewCoSiUpdate(tn, cs.cosiVerify)
+		pi, err = cosi.NewCoSi(tn, cs.cosiVerify)
 		if err != nil {
 			return nil, err
 		}
@@ -459,36 +460,21 @@ func (s *Service) timestamp(time time.Time) {
 	root, proofs := crypto.ProofTree(HashFunc(), ids)
 	msg := MarshalPair(root, time.Unix())
 	// run protocol
-	signature := s.cosiSign(msg)
-	// TODO XXX Here in a non-academical world we should test if the
-	// signature contains enough participants.
-	s.updateTimestampInfo(root, proofs, time.Unix(), signature)
+	sig, err := s.cosiSign(msg)
+	if err != nil {
+		log.Error("Couldn't sign the timestamp:", err)
+		return
+	}
+	s.updateTimestampInfo(root, proofs, time.Unix(), sig.Sig)
 	measure.Record()
 }
 
-func (s *Service) cosiSign(msg []byte) []byte {
-	sdaTree := s.Storage.Root.Roster.GenerateBinaryTree()
-
-	tni := s.NewTreeNodeInstance(sdaTree, sdaTree.Root, swupdate.ProtocolName)
-	pi, err := swupdate.NewCoSiUpdate(tni, s.cosiVerify)
-	if err != nil {
-		panic("Couldn't make new protocol: " + err.Error())
-	}
-	s.RegisterProtocolInstance(pi)
-
-	pi.SigningMessage(msg)
-	// Take the raw message (already expecting a hash for the timestamp
-	// service)
-	response := make(chan []byte)
-	pi.RegisterSignatureHook(func(sig []byte) {
-		response <- sig
-	})
-	go pi.Dispatch()
-	go pi.Start()
+func (s *Service) cosiSign(msg []byte) (*cosi.Signature, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cosi.DefaultRoundTimeout)
+	defer cancel()
 	log.Lvl2("Waiting on cosi response ...")
-	res := <-response
-	log.Lvl2("... DONE: Recieved cosi response")
-	return res
+	return cosi.Sign(ctx, s.Context, &cosi.Config{Roster: s.Storage.Root.Roster},
+		msg, s.cosiVerify)
 }
 
 // cosiVerify takes the message from the cosi protocol and split it into
@@ -16,7 +16,7 @@ import (
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/monitor"
 	"github.com/dedis/cothority/network"
-	"github.com/dedis/cothority/protocols/swupdate"
+	"github.com/dedis/cothority/protocols/cosi"
 	"github.com/dedis/cothority/sda"
 	"github.com/dedis/cothority/services/skipchain"
 	"github.com/dedis/crypto/abstract"
@@ -183,7 +183,7 @@ func verifyProof(proof crypto.Proof, root, leaf crypto.HashID, ts int64, publics
 	}
 	// verify timestamp signature
 	msg := MarshalPair(root, ts)
-	return swupdate.VerifySignature(network.Suite, publics, msg, sig)
+	return cosi.VerifySignature(network.Suite, publics, msg, sig)
 }
 
 // Same as TestService_TimestampProof but checking all chains instead of just
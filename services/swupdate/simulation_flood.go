@@ -13,7 +13,7 @@ import (
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/monitor"
 	"github.com/dedis/cothority/network"
-	"github.com/dedis/cothority/protocols/swupdate"
+	"github.com/dedis/cothority/protocols/cosi"
 	"github.com/dedis/cothority/sda"
 	"github.com/dedis/cothority/services/skipchain"
 	"github.com/dedis/cothority/services/timestamp"
@@ -163,7 +163,7 @@ func runClientRequests(config *sda.SimulationConfig, blockID skipchain.SkipBlock
 
 	// verify signature
 	msg := MarshalPair(lbret.Timestamp.Root, lbret.Timestamp.SignatureResponse.Timestamp)
-	err = swupdate.VerifySignature(network.Suite, config.Roster.Publics(), msg, lbret.Timestamp.SignatureResponse.Signature)
+	err = cosi.VerifySignature(network.Suite, config.Roster.Publics(), msg, lbret.Timestamp.SignatureResponse.Signature)
 	if err != nil {
 		log.Warn("Signature timestamp invalid")
 	} else {
@@ -10,7 +10,7 @@ import (
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/monitor"
 	"github.com/dedis/cothority/network"
-	"github.com/dedis/cothority/protocols/swupdate"
+	"github.com/dedis/cothority/protocols/cosi"
 	"github.com/dedis/cothority/sda"
 	"github.com/dedis/cothority/services/skipchain"
 	"github.com/dedis/cothority/services/timestamp"
@@ -204,7 +204,7 @@ func verification(c *Client, timeClient *timestamp.Client, latest map[string]ski
 	// verify signature
 	if lbr.Timestamp != nil {
 		msg := MarshalPair(lbr.Timestamp.Root, lbr.Timestamp.SignatureResponse.Timestamp)
-		err = swupdate.VerifySignature(network.Suite, publics, msg, lbr.Timestamp.SignatureResponse.Signature)
+		err = cosi.VerifySignature(network.Suite, publics, msg, lbr.Timestamp.SignatureResponse.Signature)
 		if err != nil {
 			log.Warn("Signature timestamp invalid")
 		} else {
@@ -1,13 +1,18 @@
 package timestamp
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"errors"
 
 	"time"
 
+	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/network"
+	"github.com/dedis/cothority/protocols/cosi"
 	"github.com/dedis/cothority/sda"
+	"github.com/dedis/crypto/abstract"
 )
 
 // Client is a structure to communicate with the CoSi
@@ -38,18 +43,182 @@ func (c *Client) SignMsg(root *network.ServerIdentity, msg []byte) (*SignatureRe
 	return &sr, nil
 }
 
+// Verify checks that the response holds a collective signature of at least
+// cosi.DefaultThreshold nodes of the roster on the root and the timestamp, and
+// that msg is included in the root. It doesn't need to contact the
+// timestamper.
+func Verify(roster *sda.Roster, msg []byte, resp *SignatureResponse) error {
+	if err := verifySignature(roster, resp); err != nil {
+		return err
+	}
+	return verifyInclusion(msg, resp)
+}
+
+// VerifyAggregate checks the response like Verify when only the aggregate key
+// of the roster is known. As the nodes that didn't sign can't be removed from
+// the aggregate key, the signature only verifies if all nodes signed.
+func VerifyAggregate(agg abstract.Point, msg []byte, resp *SignatureResponse) error {
+	lenSig := network.Suite.PointLen() + network.Suite.ScalarLen()
+	if len(resp.Signature) <= lenSig {
+		return errors.New("Wrong length of signature")
+	}
+	// verify with a roster of one node holding the aggregate key
+	sig := append(append([]byte{}, resp.Signature[:lenSig]...), 1)
+	if err := cosi.VerifySignature(network.Suite, []abstract.Point{agg},
+		resp.SignedMsg(), sig); err != nil {
+		return err
+	}
+	return verifyInclusion(msg, resp)
+}
+
+// VerifyOrder returns nil if the response first was signed in an epoch before
+// the one of the response second. epochs is the chain from the epoch of first
+// to the epoch of second, as returned by Client.Epochs. As every epoch
+// includes the ID of the previous one, only the signature of second is
+// checked.
+func VerifyOrder(roster *sda.Roster, first, second *SignatureResponse,
+	epochs []*Epoch) error {
+	if err := verifySignature(roster, second); err != nil {
+		return err
+	}
+	return verifyChain(first, second, epochs)
+}
+
+// verifyChain returns nil if epochs link the epoch of first to the later
+// epoch of second.
+func verifyChain(first, second *SignatureResponse, epochs []*Epoch) error {
+	if len(epochs) < 2 {
+		return errors.New("Need at least two epochs")
+	}
+	if !bytes.Equal(epochs[0].ID(), first.ID()) {
+		return errors.New("First epoch doesn't match the first response")
+	}
+	for i := 1; i < len(epochs); i++ {
+		if !bytes.Equal(epochs[i].Previous, epochs[i-1].ID()) {
+			return errors.New("Epochs are not linked")
+		}
+	}
+	if !bytes.Equal(epochs[len(epochs)-1].ID(), second.ID()) {
+		return errors.New("Last epoch doesn't match the second response")
+	}
+	return nil
+}
+
+// verifySignature returns nil if the response holds a collective signature
+// of at least cosi.DefaultThreshold nodes of the roster on its epoch.
+func verifySignature(roster *sda.Roster, resp *SignatureResponse) error {
+	publics := roster.Publics()
+	return cosi.VerifySignatureThreshold(network.Suite, publics,
+		resp.SignedMsg(), resp.Signature, cosi.DefaultThreshold(len(publics)))
+}
+
+// verifyInclusion returns nil if the proof of the response shows that msg is
+// a leaf of the signed root. The proof binds the index of the leaf and the
+// size of the tree, so the root or an inner node can't be given as msg.
+func verifyInclusion(msg []byte, resp *SignatureResponse) error {
+	if resp.Proof == nil {
+		return errors.New("Missing inclusion proof")
+	}
+	if err := resp.Proof.Verify(sha256.New, resp.Root, msg); err != nil {
+		return errors.New("Message is not included in the signed root: " +
+			err.Error())
+	}
+	return nil
+}
+
+// Epochs returns the signed epochs from from to to, both included, of the
+// timestamper running on root. More than MaxEpochsRange epochs are requested
+// in several parts.
+func (c *Client) Epochs(root *network.ServerIdentity, from, to int) ([]*Epoch, error) {
+	var epochs []*Epoch
+	for start := from; start <= to; start += MaxEpochsRange {
+		end := start + MaxEpochsRange - 1
+		if end > to {
+			end = to
+		}
+		reply, err := c.Send(root, &EpochsRequest{From: start, To: end})
+		if e := sda.ErrMsg(reply, err); e != nil {
+			return nil, e
+		}
+		er, ok := reply.Msg.(EpochsResponse)
+		if !ok {
+			return nil, errors.New("This is odd: couldn't cast reply.")
+		}
+		epochs = append(epochs, er.Epochs...)
+	}
+	if len(epochs) == 0 {
+		return nil, errors.New("Wrong range of epochs")
+	}
+	return epochs, nil
+}
+
 // SetupStamper initializes the root node with the desired configuration
 // parameters. The root node will start the main loop upon receiving this
 // request.
 // XXX This is a quick hack which simplifies the simulations.
 func (c *Client) SetupStamper(roster *sda.Roster, epochDuration time.Duration,
 	maxIterations int) (*SetupRosterResponse, error) {
+	return c.SetupOwnedStamper(roster, epochDuration, maxIterations, nil)
+}
+
+// SetupOwnedStamper initializes the root node like SetupStamper. The owner is
+// allowed to stop and reconfigure the timestamper later.
+func (c *Client) SetupOwnedStamper(roster *sda.Roster, epochDuration time.Duration,
+	maxIterations int, owner abstract.Point) (*SetupRosterResponse, error) {
 	serviceReq := &SetupRosterRequest{
 		Roster:        roster,
 		EpochDuration: epochDuration,
 		MaxIterations: maxIterations,
+		Owner:         owner,
+	}
+	return c.setup(roster.List[0], serviceReq)
+}
+
+// Reconfigure sends the new configuration signed by the owner to the root
+// node the timestamper is running on. setup.Version has to be one more than
+// the actual version.
+func (c *Client) Reconfigure(root *network.ServerIdentity, setup *SetupRosterRequest,
+	private abstract.Scalar) (*SetupRosterResponse, error) {
+	hash, err := setup.Hash()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.SignSchnorr(network.Suite, private, hash)
+	if err != nil {
+		return nil, err
+	}
+	setup.Signature = &sig
+	return c.setup(root, setup)
+}
+
+// Stop asks the root node to stop the timestamper of the roster with the
+// given ID, with the signature of the owner. version has to be one more than
+// the actual version.
+func (c *Client) Stop(root *network.ServerIdentity, id sda.RosterID, version int,
+	private abstract.Scalar) (*StopResponse, error) {
+	stop := &StopRequest{ID: id, Version: version}
+	hash, err := stop.Hash()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.SignSchnorr(network.Suite, private, hash)
+	if err != nil {
+		return nil, err
+	}
+	stop.Signature = &sig
+	reply, err := c.Send(root, stop)
+	if e := sda.ErrMsg(reply, err); e != nil {
+		return nil, e
 	}
-	root := roster.List[0]
+	sr, ok := reply.Msg.(StopResponse)
+	if !ok {
+		return nil, errors.New("This is odd: couldn't cast reply.")
+	}
+	return &sr, nil
+}
+
+// setup sends the SetupRosterRequest to root.
+func (c *Client) setup(root *network.ServerIdentity, serviceReq *SetupRosterRequest) (*SetupRosterResponse, error) {
 	log.Lvl4("Sending message to:", root)
 	reply, err := c.Send(root, serviceReq)
 	if e := sda.ErrMsg(reply, err); e != nil {
@@ -2,11 +2,20 @@
 // During one epoch it collects statements from
 // clients, waits EpochDuration time and responds with a signature of the
 // requested data.
+// Every signed epoch includes the ID of the previous one, so the epochs form a
+// hash chain that proves the order of the responses.
+// The configuration is saved to disk and the service resumes after a restart.
+// If the configuration has an owner, it can stop and reconfigure the service.
 package timestamp
 
 import (
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
 	"sync"
 	"time"
 
@@ -15,8 +24,11 @@ import (
 	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/network"
-	"github.com/dedis/cothority/protocols/swupdate"
+	"github.com/dedis/cothority/protocols/cosi"
 	"github.com/dedis/cothority/sda"
+	"github.com/dedis/crypto/abstract"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
 )
 
 // ServiceName can be used to refer to the name of the timestamp service
@@ -24,15 +36,6 @@ const ServiceName = "Timestamp"
 
 var timestampSID sda.ServiceID
 
-var dummyVerfier = func(rootAndTimestamp []byte) bool {
-	l := len(rootAndTimestamp)
-	_, err := bytesToTimestamp(rootAndTimestamp[l-10 : l])
-	if err != nil {
-		log.Error("Got some invalid timestamp.")
-	}
-	return true
-}
-
 func init() {
 	sda.RegisterNewService(ServiceName, newTimestampService)
 	timestampSID = sda.ServiceFactory.ServiceID(ServiceName)
@@ -40,8 +43,23 @@ func init() {
 	network.RegisterPacketType(&SignatureResponse{})
 	network.RegisterPacketType(&SetupRosterRequest{})
 	network.RegisterPacketType(&SetupRosterResponse{})
+	network.RegisterPacketType(&StopRequest{})
+	network.RegisterPacketType(&StopResponse{})
+	network.RegisterPacketType(&EpochsRequest{})
+	network.RegisterPacketType(&EpochsResponse{})
+	network.RegisterPacketType(&storage{})
+	network.RegisterPacketType(&Epoch{})
+	network.RegisterPacketType(&signedStorage{})
 }
 
+// MaxEpochsRange is the biggest number of epochs returned for one
+// EpochsRequest.
+const MaxEpochsRange = 1000
+
+// TimestampWindow is how far the timestamp of an epoch may be from the clock
+// of a conode asked to sign it.
+var TimestampWindow = time.Minute
+
 // Service handles client requests. It implements
 type Service struct {
 	*sda.ServiceProcessor
@@ -61,6 +79,56 @@ type Service struct {
 	roster   *sda.Roster
 	// easy to change from one signer (cosi) to another (mock/BFTcosi):
 	signMsg func(m []byte) []byte
+
+	// owner is the public key allowed to stop and reconfigure the service
+	owner abstract.Point
+	// version is increased with every stop and reconfiguration
+	version int
+	// stopped is true if the owner stopped the service
+	stopped bool
+	// configLock protects the configuration and the main loop
+	configLock sync.Mutex
+	// stop is closed to interrupt the main loop
+	stop chan bool
+	// loopDone is closed when the main loop returns
+	loopDone chan bool
+	// epochs is the chain of the signed epochs
+	epochs []*Epoch
+	// epochsLock protects epochs
+	epochsLock sync.Mutex
+	// signed holds the last epoch this conode agreed to sign for the
+	// timestamper of every root
+	signed map[string]*signedEpoch
+	// signedLock protects signed
+	signedLock sync.Mutex
+}
+
+// signedEpoch is the last epoch a conode agreed to sign for one timestamper.
+type signedEpoch struct {
+	// ID of the epoch
+	ID crypto.HashID
+	// Previous of the epoch. An epoch replacing this one after a failed
+	// round links to it.
+	Previous  crypto.HashID
+	Timestamp int64
+}
+
+// signedStorage holds the last signed epoch of every timestamper, indexed by
+// the ID of its root. It is saved to disk so that a restart doesn't let the
+// root start another chain.
+type signedStorage struct {
+	Signed map[string]*signedEpoch
+}
+
+// storage is the configuration of the service that is saved to disk. The
+// epochs are appended to a file of their own.
+type storage struct {
+	Roster        *sda.Roster
+	EpochDuration time.Duration
+	MaxIterations int
+	Owner         abstract.Point
+	Version       int
+	Stopped       bool
 }
 
 // NewProtocol is called on all nodes of a Tree (except the root, since it is
@@ -68,7 +136,10 @@ type Service struct {
 // generate the PI on all others node.
 func (s *Service) NewProtocol(tn *sda.TreeNodeInstance, conf *sda.GenericConfig) (sda.ProtocolInstance, error) {
 	log.Lvl2("Timestamp Service received New Protocol event")
-	pi, err := swupdate.NewCoSiUpdate(tn, dummyVerfier)
+	root := uuid.UUID(tn.Root().ServerIdentity.ID).String()
+	pi, err := cosi.NewCoSi(tn, func(msg []byte) bool {
+		return s.verifyEpoch(root, msg)
+	})
 	return pi, err
 }
 
@@ -84,15 +155,82 @@ type SignatureRequest struct {
 // SetupRosterRequest can be send by a client to initialize the service.
 // It defines the roster that will be used, the epoch duration and (optionally)
 // the number of iterations the service will run.
+// If an Owner is given, the service can later be reconfigured by sending
+// a new SetupRosterRequest with the next Version, signed by the Owner.
 type SetupRosterRequest struct {
 	Roster        *sda.Roster
 	EpochDuration time.Duration
 	MaxIterations int
+	// Owner is the public key allowed to stop and reconfigure the service. A
+	// reconfiguration without Owner keeps the actual one.
+	Owner abstract.Point
+	// Version has to be one more than the actual version for a
+	// reconfiguration.
+	Version int
+	// Signature by the actual owner on Hash, only for a reconfiguration.
+	Signature *crypto.SchnorrSig
 }
 
 // SetupRosterResponse returns the ID of the roster if the init. was successful.
 type SetupRosterResponse struct {
 	ID *sda.RosterID
+	// Version of the configuration
+	Version int
+}
+
+// StopRequest asks the service to stop signing. It has to be signed by the
+// owner and pending requests are refused.
+type StopRequest struct {
+	// ID of the roster of the timestamper to stop.
+	ID sda.RosterID
+	// Version has to be one more than the actual version.
+	Version int
+	// Signature by the owner on Hash
+	Signature *crypto.SchnorrSig
+}
+
+// StopResponse returns the version of the stopped configuration.
+type StopResponse struct {
+	Version int
+}
+
+// Hash returns the hash of the request that the owner signs to reconfigure
+// the service.
+func (setup *SetupRosterRequest) Hash() ([]byte, error) {
+	h := sha256.New()
+	h.Write([]byte("setup"))
+	if setup.Roster != nil {
+		if err := setup.Roster.HashTo(h); err != nil {
+			return nil, err
+		}
+	}
+	for _, i := range []int64{int64(setup.EpochDuration),
+		int64(setup.MaxIterations), int64(setup.Version)} {
+		if err := binary.Write(h, binary.LittleEndian, i); err != nil {
+			return nil, err
+		}
+	}
+	if setup.Owner != nil {
+		b, err := setup.Owner.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		h.Write(b)
+	}
+	return h.Sum(nil), nil
+}
+
+// Hash returns the hash of the request that the owner signs to stop the
+// service. It includes the roster ID, so that the request can't be replayed
+// against another timestamper of the same owner.
+func (stop *StopRequest) Hash() ([]byte, error) {
+	h := sha256.New()
+	h.Write([]byte("stop"))
+	h.Write(uuid.UUID(stop.ID).Bytes())
+	if err := binary.Write(h, binary.LittleEndian, int64(stop.Version)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
 }
 
 // SignatureResponse is what the Cosi service will reply to clients.
@@ -101,14 +239,68 @@ type SignatureResponse struct {
 	Timestamp int64
 	// The tree root that was signed:
 	Root crypto.HashID
-	// Proof is an Inclusion proof for the data the client requested:
-	Proof crypto.Proof
-	// Collective signature on Timestamp||hash(treeroot):
+	// Proof is an Inclusion proof for the data the client requested, in the
+	// tree built by crypto.MerkleTree:
+	Proof *crypto.MerkleProof
+	// Collective signature on Previous||hash(treeroot)||Timestamp:
 	Signature []byte
+	// Epoch is the index of the signed epoch in the chain
+	Epoch int
+	// Previous is the ID of the previous signed epoch, nil for the first
+	Previous crypto.HashID
 
 	// TODO should we return the roster used to sign this message?
 }
 
+// SignedMsg returns the message signed for the epoch of the response.
+func (sr *SignatureResponse) SignedMsg() []byte {
+	return RecreateLinkedMsg(sr.Previous, sr.Root, sr.Timestamp)
+}
+
+// ID returns the ID of the epoch of the response.
+func (sr *SignatureResponse) ID() crypto.HashID {
+	return epochID(sr.Previous, sr.Root, sr.Timestamp)
+}
+
+// Epoch is one signed epoch in the chain of the timestamper.
+type Epoch struct {
+	// Index of the epoch in the chain
+	Index     int
+	Timestamp int64
+	Root      crypto.HashID
+	// Previous is the ID of the previous epoch, nil for the first
+	Previous  crypto.HashID
+	Signature []byte
+}
+
+// SignedMsg returns the message signed for the epoch.
+func (e *Epoch) SignedMsg() []byte {
+	return RecreateLinkedMsg(e.Previous, e.Root, e.Timestamp)
+}
+
+// ID returns the hash of the signed message of the epoch, which is included
+// in the next epoch.
+func (e *Epoch) ID() crypto.HashID {
+	return epochID(e.Previous, e.Root, e.Timestamp)
+}
+
+// epochID returns the hash of the message signed for an epoch.
+func epochID(previous, root crypto.HashID, timestamp int64) crypto.HashID {
+	h := sha256.Sum256(RecreateLinkedMsg(previous, root, timestamp))
+	return h[:]
+}
+
+// EpochsRequest asks for the signed epochs from From to To, both included.
+type EpochsRequest struct {
+	From int
+	To   int
+}
+
+// EpochsResponse holds the requested epochs.
+type EpochsResponse struct {
+	Epochs []*Epoch
+}
+
 // SignatureRequest treats external request to this service.
 func (s *Service) SignatureRequest(si *network.ServerIdentity, req *SignatureRequest) (network.Body, error) {
 
@@ -116,7 +308,9 @@ func (s *Service) SignatureRequest(si *network.ServerIdentity, req *SignatureReq
 	// 1) If has the length of hashed nonce, add it to the local buffer of
 	//    of the service:
 	respC := make(chan *SignatureResponse)
-	s.requests.Add(req.Message, respC)
+	if err := s.requests.Add(req.Message, respC); err != nil {
+		return nil, err
+	}
 	// 2) At epoch time: create the merkle tree
 	// see runLoop
 	// 3) run *one* cosi round on treeroot||timestamp
@@ -126,93 +320,474 @@ func (s *Service) SignatureRequest(si *network.ServerIdentity, req *SignatureReq
 
 	// wait on my signature:
 	log.Lvl2("Waiting on epoch end.")
-	resp := <-respC
+	resp, ok := <-respC
+	if !ok {
+		return nil, errors.New("Timestamp service stopped before the end of the epoch")
+	}
+	if resp == nil {
+		return nil, errors.New("Couldn't collectively sign the epoch")
+	}
 	return resp, nil
 }
 
-// SetupCoSiRoster handles `SetupRosterRequest`s requests
+// SetupCoSiRoster handles `SetupRosterRequest`s requests. The first request
+// initializes the service, the following ones have to be signed by the owner.
 // XXX later we'll give it an ID instead of the actual roster?
 func (s *Service) SetupCoSiRoster(si *network.ServerIdentity, setup *SetupRosterRequest) (network.Body, error) {
-	if s.roster == nil && s.EpochDuration == 0 {
-		s.roster = setup.Roster
-		s.EpochDuration = setup.EpochDuration
-		s.maxIterations = setup.MaxIterations
-		go s.runLoop()
-		log.Lvl1("Started main loop with epoch duration:", s.EpochDuration)
-	} else {
-		log.Warnf("Timestamper already initialized and received init. request!"+
-			" Running with epoch duration %v (max. %v iterations) and with roster %v",
-			s.EpochDuration, s.maxIterations)
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	if s.roster != nil {
+		if s.owner == nil {
+			log.Warnf("Timestamper already initialized and received init. request!"+
+				" Running with epoch duration %v (max. %v iterations) and with roster %v",
+				s.EpochDuration, s.maxIterations, s.roster)
+			return &SetupRosterResponse{ID: &s.roster.ID, Version: s.version}, nil
+		}
+		if setup.Version != s.version+1 {
+			return nil, errors.New("Wrong version: need " +
+				fmt.Sprint(s.version+1))
+		}
+		hash, err := setup.Hash()
+		if err != nil {
+			return nil, err
+		}
+		if err := crypto.VerifySignature(network.Suite, s.owner,
+			hash, setup.Signature); err != nil {
+			return nil, err
+		}
+	}
+	if setup.Roster == nil || len(setup.Roster.List) == 0 {
+		return nil, errors.New("No roster given")
+	}
+	if !setup.Roster.List[0].ID.Equal(s.ServerIdentity().ID) {
+		return nil, errors.New("This conode has to be the root of the roster")
+	}
+	if setup.EpochDuration <= 0 {
+		return nil, errors.New("Epoch duration has to be positive")
 	}
-	return &SetupRosterResponse{ID: &s.roster.ID}, nil
 
+	s.stopLoop()
+	s.roster = setup.Roster
+	s.EpochDuration = setup.EpochDuration
+	s.maxIterations = setup.MaxIterations
+	if setup.Owner != nil {
+		s.owner = setup.Owner
+	}
+	s.version = setup.Version
+	s.stopped = false
+	s.save()
+	s.startLoop()
+	log.Lvl1("Started main loop with epoch duration:", s.EpochDuration)
+	return &SetupRosterResponse{ID: &s.roster.ID, Version: s.version}, nil
 }
 
-func (s *Service) cosiSign(msg []byte) []byte {
-	sdaTree := s.roster.GenerateBinaryTree()
+// Stop handles `StopRequest`s by stopping the main loop if the request is
+// signed by the owner.
+func (s *Service) Stop(si *network.ServerIdentity, stop *StopRequest) (network.Body, error) {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	if s.owner == nil {
+		return nil, errors.New("Timestamp service has no owner")
+	}
+	if s.roster == nil {
+		return nil, errors.New("Timestamp service has no roster")
+	}
+	if !uuid.Equal(uuid.UUID(stop.ID), uuid.UUID(s.roster.ID)) {
+		return nil, errors.New("Wrong roster: need " + s.roster.ID.String())
+	}
+	if stop.Version != s.version+1 {
+		return nil, errors.New("Wrong version: need " + fmt.Sprint(s.version+1))
+	}
+	hash, err := stop.Hash()
+	if err != nil {
+		return nil, err
+	}
+	if err := crypto.VerifySignature(network.Suite, s.owner,
+		hash, stop.Signature); err != nil {
+		return nil, err
+	}
+	s.stopLoop()
+	s.version = stop.Version
+	s.stopped = true
+	s.save()
+	log.Lvl1("Stopped main loop")
+	return &StopResponse{Version: s.version}, nil
+}
+
+// Epochs handles `EpochsRequest`s by returning the requested part of the chain
+// of epochs.
+func (s *Service) Epochs(si *network.ServerIdentity, req *EpochsRequest) (network.Body, error) {
+	s.epochsLock.Lock()
+	defer s.epochsLock.Unlock()
+	if req.From < 0 || req.From > req.To || req.To >= len(s.epochs) {
+		return nil, errors.New("Wrong range of epochs: " +
+			fmt.Sprint(len(s.epochs)) + " signed epochs")
+	}
+	if req.To-req.From >= MaxEpochsRange {
+		return nil, errors.New("Too many epochs requested, the maximum is " +
+			fmt.Sprint(MaxEpochsRange))
+	}
+	epochs := make([]*Epoch, req.To-req.From+1)
+	copy(epochs, s.epochs[req.From:req.To+1])
+	return &EpochsResponse{Epochs: epochs}, nil
+}
 
-	tni := s.NewTreeNodeInstance(sdaTree, sdaTree.Root, swupdate.ProtocolName)
-	pi, err := swupdate.NewCoSiUpdate(tni, dummyVerfier)
+// verifyEpoch returns true if msg is the signed message of an epoch of the
+// timestamper of root that links to the last epoch this conode signed for it
+// and whose timestamp is close to the clock of this conode. An epoch may also
+// replace the last one, in case its round failed. The replaced epoch can't be
+// extended anymore, so the chain doesn't fork. A conode that never signed for
+// the timestamper accepts any previous epoch.
+func (s *Service) verifyEpoch(root string, msg []byte) bool {
+	s.signedLock.Lock()
+	defer s.signedLock.Unlock()
+	if s.signed == nil {
+		s.signed = make(map[string]*signedEpoch)
+	}
+	last := s.signed[root]
+	var candidates []crypto.HashID
+	if last != nil {
+		candidates = []crypto.HashID{last.ID, last.Previous}
+	}
+	for _, previous := range candidates {
+		if e := parseEpoch(previous, msg); e != nil {
+			return s.acceptEpoch(root, last, e)
+		}
+	}
+	if last == nil && len(msg)/2 >= sha256.Size+binary.MaxVarintLen64 {
+		// The first epoch signed for the timestamper: the previous epoch
+		// is at the start of the second half of the message.
+		previous := msg[len(msg)/2 : len(msg)-sha256.Size-binary.MaxVarintLen64]
+		if e := parseEpoch(previous, msg); e != nil {
+			return s.acceptEpoch(root, last, e)
+		}
+	}
+	log.Lvl2("Epoch doesn't link to the last signed epoch")
+	return false
+}
+
+// acceptEpoch checks the timestamp of the epoch and stores it as the last
+// epoch signed for root. The signedLock must be held.
+func (s *Service) acceptEpoch(root string, last *signedEpoch, e *Epoch) bool {
+	ts := time.Unix(e.Timestamp, 0)
+	if d := time.Since(ts); d > TimestampWindow || d < -TimestampWindow {
+		log.Lvl2("Timestamp of epoch is too far from the clock:", ts)
+		return false
+	}
+	if last != nil && e.Timestamp < last.Timestamp {
+		log.Lvl2("Timestamp of epoch is before the last signed epoch")
+		return false
+	}
+	s.signed[root] = &signedEpoch{ID: e.ID(), Previous: e.Previous,
+		Timestamp: e.Timestamp}
+	if s.path != "" {
+		s.saveSigned()
+	}
+	return true
+}
+
+// parseEpoch returns the epoch whose signed message is msg if it links to
+// previous, else nil.
+func parseEpoch(previous crypto.HashID, msg []byte) *Epoch {
+	// msg is made of len(body) zeros followed by body, which is
+	// previous||root||timestamp.
+	n := len(previous) + sha256.Size + binary.MaxVarintLen64
+	if len(msg) != 2*n {
+		return nil
+	}
+	body := msg[n:]
+	if !bytes.Equal(body[:len(previous)], previous) {
+		return nil
+	}
+	root := body[len(previous) : len(previous)+sha256.Size]
+	ts, err := bytesToTimestamp(body[n-binary.MaxVarintLen64:])
 	if err != nil {
-		panic("Couldn't make new protocol: " + err.Error())
+		return nil
+	}
+	if len(previous) == 0 {
+		previous = nil
 	}
-	s.RegisterProtocolInstance(pi)
+	e := &Epoch{Timestamp: ts, Root: root, Previous: previous}
+	if !bytes.Equal(e.SignedMsg(), msg) {
+		return nil
+	}
+	return e
+}
 
-	pi.SigningMessage(msg)
-	// Take the raw message (already expecting a hash for the timestamp
-	// service)
-	response := make(chan []byte)
-	pi.RegisterSignatureHook(func(sig []byte) {
-		response <- sig
-	})
-	go pi.Dispatch()
-	go pi.Start()
-	res := <-response
-	log.Lvl2("Recieved cosi response")
-	return res
+func (s *Service) cosiSign(msg []byte) []byte {
+	ctx, cancel := context.WithTimeout(context.Background(), cosi.DefaultRoundTimeout)
+	defer cancel()
+	// The root always signs, the hook is only called on the other nodes.
+	root := uuid.UUID(s.ServerIdentity().ID).String()
+	sig, err := cosi.Sign(ctx, s.Context, &cosi.Config{Roster: s.roster},
+		msg, func(msg []byte) bool {
+			return s.verifyEpoch(root, msg)
+		})
+	if err != nil {
+		log.Error("Couldn't collectively sign:", err)
+		return nil
+	}
+	return sig.Sig
+}
+
+// startLoop opens the request pool and starts the main loop. The configLock
+// must be held.
+func (s *Service) startLoop() {
+	s.stop = make(chan bool)
+	s.loopDone = make(chan bool)
+	s.requests.open()
+	go s.runLoop()
+}
 
+// stopLoop interrupts the main loop and waits for it to return. The
+// configLock must be held.
+func (s *Service) stopLoop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.loopDone
+	s.stop = nil
+	s.loopDone = nil
 }
 
-// main loop
+// main loop, it returns once stop is closed or maxIterations epochs passed
+// and refuses the pending requests.
 func (s *Service) runLoop() {
-	c := time.Tick(s.EpochDuration)
+	stop, loopDone := s.stop, s.loopDone
+	defer func() {
+		s.requests.close()
+		if loopDone != nil {
+			close(loopDone)
+		}
+	}()
+	ticker := time.NewTicker(s.EpochDuration)
+	defer ticker.Stop()
 	counter := 0
 	log.Lvl4("Starting main loop:")
-	for now := range c /*TODO interrupt the main loop must be possible*/ {
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-stop:
+			log.Lvl2("Main loop interrupted")
+			return
+		}
 
 		counter++
 		if counter > s.maxIterations && s.maxIterations > 0 {
 			log.Info("Max epoch reached... Quitting main loop.")
-			break
+			return
 		}
 		// only sign something if there was some data/requests:
-		data, channels := s.requests.GetData()
-		s.requests.reset()
+		data, channels := s.requests.take()
 		numRequests := len(data)
 		if numRequests > 0 {
 			log.Lvl2("Signin tree root with timestampt:", now, "got", numRequests, "requests")
 
-			// create merkle tree and message to be signed:
-			root, proofs := crypto.ProofTree(sha256.New, data)
-			msg := RecreateSignedMsg(root, now.Unix())
+			// create merkle tree and message to be signed, linked to
+			// the previous epoch:
+			root, proofs := crypto.MerkleTree(sha256.New, data)
+			epoch := &Epoch{Timestamp: now.Unix(), Root: root}
+			s.epochsLock.Lock()
+			epoch.Index = len(s.epochs)
+			if epoch.Index > 0 {
+				epoch.Previous = s.epochs[epoch.Index-1].ID()
+			}
+			s.epochsLock.Unlock()
 
-			signature := s.signMsg(msg)
+			epoch.Signature = s.signMsg(epoch.SignedMsg())
+			if epoch.Signature == nil {
+				// Tell everyone waiting that the signature failed
+				for _, respC := range channels {
+					respC <- nil
+				}
+				continue
+			}
 			log.Lvlf2("%s: Signed a message.\n", time.Now().Format("Mon Jan 2 15:04:05 -0700 MST 2006"))
+			s.epochsLock.Lock()
+			s.epochs = append(s.epochs, epoch)
+			s.epochsLock.Unlock()
+			// the tests of the main loop run without sda
+			if s.path != "" {
+				s.saveEpoch(epoch)
+			}
 			// Give (individual) response to anyone waiting:
 			for i, respC := range channels {
 				respC <- &SignatureResponse{
-					Timestamp: now.Unix(),
+					Timestamp: epoch.Timestamp,
 					Proof:     proofs[i],
 					Root:      root,
-					// Collective signature on Timestamp||hash(treeroot)
-					Signature: signature,
+					// Collective signature on Previous||hash(treeroot)||Timestamp
+					Signature: epoch.Signature,
+					Epoch:     epoch.Index,
+					Previous:  epoch.Previous,
 				}
 			}
 		} else {
 			log.Lvl3("No requests at epoch:", time.Now().Format("Mon Jan 2 15:04:05 -0700 MST 2006"))
 		}
 	}
+}
+
+// fileName returns the file where the configuration is saved. The services
+// of all conodes of a local test share the same path.
+func (s *Service) fileName() string {
+	return path.Join(s.path, "timestamp-"+uuid.UUID(s.ServerIdentity().ID).String()+".bin")
+}
+
+// epochsFileName returns the file the signed epochs are appended to.
+func (s *Service) epochsFileName() string {
+	return path.Join(s.path, "timestamp-epochs-"+uuid.UUID(s.ServerIdentity().ID).String()+".bin")
+}
+
+// signedFileName returns the file of the last epochs signed for every
+// timestamper.
+func (s *Service) signedFileName() string {
+	return path.Join(s.path, "timestamp-signed-"+uuid.UUID(s.ServerIdentity().ID).String()+".bin")
+}
+
+// saveSigned stores the last epochs signed for every timestamper. The
+// signedLock must be held.
+func (s *Service) saveSigned() {
+	b, err := network.MarshalRegisteredType(&signedStorage{Signed: s.signed})
+	if err != nil {
+		log.Error("Couldn't marshal signed epochs:", err)
+		return
+	}
+	err = ioutil.WriteFile(s.signedFileName(), b, 0660)
+	if err != nil {
+		log.Error("Couldn't save file:", err)
+	}
+}
+
+// save stores the configuration of the service. The configLock must be held.
+func (s *Service) save() {
+	log.Lvl3("Saving service")
+	b, err := network.MarshalRegisteredType(&storage{
+		Roster:        s.roster,
+		EpochDuration: s.EpochDuration,
+		MaxIterations: s.maxIterations,
+		Owner:         s.owner,
+		Version:       s.version,
+		Stopped:       s.stopped,
+	})
+	if err != nil {
+		log.Error("Couldn't marshal service:", err)
+		return
+	}
+	err = ioutil.WriteFile(s.fileName(), b, 0660)
+	if err != nil {
+		log.Error("Couldn't save file:", err)
+	}
+}
+
+// saveEpoch appends the epoch to the file of the epochs, so that the epochs
+// signed before are not written again. Every epoch is preceded by its
+// length.
+func (s *Service) saveEpoch(e *Epoch) {
+	b, err := network.MarshalRegisteredType(e)
+	if err != nil {
+		log.Error("Couldn't marshal epoch:", err)
+		return
+	}
+	record := make([]byte, 4+len(b))
+	binary.LittleEndian.PutUint32(record, uint32(len(b)))
+	copy(record[4:], b)
+	f, err := os.OpenFile(s.epochsFileName(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		log.Error("Couldn't open file:", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(record); err != nil {
+		log.Error("Couldn't save epoch:", err)
+	}
+}
+
+// loadEpochs reads the epochs appended by saveEpoch. An epoch that has only
+// been written partially is removed from the file.
+func (s *Service) loadEpochs() ([]*Epoch, error) {
+	b, err := ioutil.ReadFile(s.epochsFileName())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Error while reading %s: %s", s.epochsFileName(), err)
+	}
+	var epochs []*Epoch
+	offset := 0
+	for offset+4 <= len(b) {
+		length := int(binary.LittleEndian.Uint32(b[offset:]))
+		if offset+4+length > len(b) {
+			break
+		}
+		_, msg, err := network.UnmarshalRegistered(b[offset+4 : offset+4+length])
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't unmarshal epoch %d: %s", len(epochs), err)
+		}
+		e := msg.(*Epoch)
+		if e.Index != len(epochs) {
+			return nil, fmt.Errorf("Epoch %d has index %d", len(epochs), e.Index)
+		}
+		epochs = append(epochs, e)
+		offset += 4 + length
+	}
+	if offset < len(b) {
+		log.Warn("Removing partially written epoch")
+		if err := os.Truncate(s.epochsFileName(), int64(offset)); err != nil {
+			return nil, err
+		}
+	}
+	return epochs, nil
+}
 
+// Tries to load the configuration and updates if one is found, else it
+// returns an error.
+func (s *Service) tryLoad() error {
+	b, err := ioutil.ReadFile(s.fileName())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error while reading %s: %s", s.fileName(), err)
+	}
+	if len(b) > 0 {
+		_, msg, err := network.UnmarshalRegistered(b)
+		if err != nil {
+			return fmt.Errorf("Couldn't unmarshal: %s", err)
+		}
+		log.Lvl3("Successfully loaded")
+		st := msg.(*storage)
+		s.roster = st.Roster
+		s.EpochDuration = st.EpochDuration
+		s.maxIterations = st.MaxIterations
+		s.owner = st.Owner
+		s.version = st.Version
+		s.stopped = st.Stopped
+	}
+	epochs, err := s.loadEpochs()
+	if err != nil {
+		return err
+	}
+	s.epochsLock.Lock()
+	s.epochs = epochs
+	s.epochsLock.Unlock()
+
+	s.signedLock.Lock()
+	defer s.signedLock.Unlock()
+	s.signed = make(map[string]*signedEpoch)
+	b, err = ioutil.ReadFile(s.signedFileName())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error while reading %s: %s", s.signedFileName(), err)
+	}
+	if len(b) > 0 {
+		_, msg, err := network.UnmarshalRegistered(b)
+		if err != nil {
+			return fmt.Errorf("Couldn't unmarshal: %s", err)
+		}
+		if ss := msg.(*signedStorage); ss.Signed != nil {
+			s.signed = ss.Signed
+		}
+	}
+	return nil
 }
 
 func timestampToBytes(t int64) []byte {
@@ -234,25 +809,26 @@ func newTimestampService(c *sda.Context, path string) sda.Service {
 	s := &Service{
 		ServiceProcessor: sda.NewServiceProcessor(c),
 		path:             path,
-		requests:         requestPool{},
+		// requests are refused until the main loop runs
+		requests: requestPool{closed: true},
 		// EpochDuration must be initialized by sending a setup req.
 	}
 	s.signMsg = s.cosiSign
-	err := s.RegisterMessage(s.SignatureRequest)
-	if err != nil {
-		log.ErrFatal(err, "Couldn't register message:")
-	}
-	err = s.RegisterMessage(s.SetupCoSiRoster)
-	if err != nil {
-		log.ErrFatal(err, "Couldn't register message:")
+	for _, f := range []interface{}{s.SignatureRequest, s.SetupCoSiRoster,
+		s.Stop, s.Epochs} {
+		if err := s.RegisterMessage(f); err != nil {
+			log.ErrFatal(err, "Couldn't register message:")
+		}
 	}
 
-	// start main loop:
-	// XXX will be triggered by init. message instead, makes the simulation
-	// easier but has the big downside, that the client who sends the initial
-	//
-	//go s.runLoop()
-
+	// resume the main loop of a saved configuration
+	if err := s.tryLoad(); err != nil {
+		log.Error(err)
+	}
+	if s.roster != nil && !s.stopped {
+		log.Lvl1("Resuming main loop with epoch duration:", s.EpochDuration)
+		s.startLoop()
+	}
 	return s
 }
 
@@ -265,22 +841,29 @@ type requestPool struct {
 	sync.Mutex
 	requestData      []crypto.HashID
 	responseChannels []chan *SignatureResponse
+	// closed is true if the pool doesn't accept requests
+	closed bool
 }
 
 func (rb *requestPool) reset() {
 	rb.Lock()
 	defer rb.Unlock()
 	rb.requestData = nil
-	// XXX do we need to close each channel separately?
 	rb.responseChannels = nil
 }
 
-func (rb *requestPool) Add(data []byte, responseChan chan *SignatureResponse) {
+// Add stores a request and the channel to send its response on. It returns
+// an error if the pool is closed.
+func (rb *requestPool) Add(data []byte, responseChan chan *SignatureResponse) error {
 	rb.Lock()
 	defer rb.Unlock()
+	if rb.closed {
+		return errors.New("Timestamp service is not running")
+	}
 	rb.requestData = append(rb.requestData, data)
 	log.Lvl5("Added request", len(rb.requestData), string(data))
 	rb.responseChannels = append(rb.responseChannels, responseChan)
+	return nil
 }
 
 func (rb *requestPool) GetData() ([]crypto.HashID, []chan *SignatureResponse) {
@@ -289,6 +872,35 @@ func (rb *requestPool) GetData() ([]crypto.HashID, []chan *SignatureResponse) {
 	return rb.requestData, rb.responseChannels
 }
 
+// take returns the requests of the pool and resets it.
+func (rb *requestPool) take() ([]crypto.HashID, []chan *SignatureResponse) {
+	rb.Lock()
+	defer rb.Unlock()
+	data, channels := rb.requestData, rb.responseChannels
+	rb.requestData = nil
+	rb.responseChannels = nil
+	return data, channels
+}
+
+// open lets the pool accept requests.
+func (rb *requestPool) open() {
+	rb.Lock()
+	defer rb.Unlock()
+	rb.closed = false
+}
+
+// close refuses new requests and closes the channels of the pending ones.
+func (rb *requestPool) close() {
+	rb.Lock()
+	defer rb.Unlock()
+	rb.closed = true
+	for _, c := range rb.responseChannels {
+		close(c)
+	}
+	rb.requestData = nil
+	rb.responseChannels = nil
+}
+
 // RecreateSignedMsg is a helper that can be used by the client to recreate the
 // message signed by the timestamp service (which is treeroot||timestamp)
 func RecreateSignedMsg(treeroot []byte, timestamp int64) []byte {
@@ -298,3 +910,10 @@ func RecreateSignedMsg(treeroot []byte, timestamp int64) []byte {
 	m = append(m, timeB...)
 	return m
 }
+
+// RecreateLinkedMsg recreates the message signed for an epoch, which is
+// previous||treeroot||timestamp, previous being the ID of the previous epoch.
+func RecreateLinkedMsg(previous, treeroot []byte, timestamp int64) []byte {
+	return RecreateSignedMsg(append(append([]byte{}, previous...),
+		treeroot...), timestamp)
+}
@@ -2,6 +2,7 @@ package timestamp
 
 import (
 	"crypto/sha256"
+	"os"
 	"strconv"
 	"testing"
 	"time"
@@ -9,9 +10,10 @@ import (
 	"github.com/dedis/cothority/crypto"
 	"github.com/dedis/cothority/log"
 	"github.com/dedis/cothority/network"
-	"github.com/dedis/cothority/protocols/swupdate"
+	"github.com/dedis/cothority/protocols/cosi"
 	"github.com/dedis/cothority/sda"
 	"github.com/dedis/crypto/abstract"
+	"github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/ed25519"
 )
@@ -69,12 +71,237 @@ func TestRunLoop(t *testing.T) {
 		assert.True(t, ed25519.Verify(pk, msg, resp.Signature),
 			"Wrong signature")
 		// Verify the inclusion proof:
-		assert.True(t, resp.Proof.Check(sha256.New, resp.Root, leaf),
+		assert.Nil(t, resp.Proof.Verify(sha256.New, resp.Root, leaf),
 			"Wrong inclusion proof for "+string(i))
 	}
 	log.Print("Done one round.")
 }
 
+func TestService_Epochs(t *testing.T) {
+	s := &Service{
+		requests:      requestPool{closed: true},
+		EpochDuration: time.Millisecond * 3,
+		signMsg:       mockSign,
+	}
+	s.startLoop()
+	var responses []*SignatureResponse
+	for i := 0; i < 3; i++ {
+		respC := make(chan *SignatureResponse)
+		log.ErrFatal(s.requests.Add([]byte("data"+strconv.Itoa(i)), respC))
+		resp := <-respC
+		assert.Equal(t, i, resp.Epoch)
+		assert.True(t, ed25519.Verify(pk, resp.SignedMsg(), resp.Signature))
+		responses = append(responses, resp)
+	}
+	s.stopLoop()
+	assert.Nil(t, responses[0].Previous)
+	assert.Equal(t, responses[0].ID(), responses[1].Previous)
+	assert.Equal(t, responses[1].ID(), responses[2].Previous)
+
+	reply, err := s.Epochs(nil, &EpochsRequest{From: 0, To: 2})
+	log.ErrFatal(err)
+	epochs := reply.(*EpochsResponse).Epochs
+	assert.Equal(t, 3, len(epochs))
+	assert.Nil(t, verifyChain(responses[0], responses[2], epochs))
+	assert.Nil(t, verifyChain(responses[1], responses[2], epochs[1:]))
+	assert.NotNil(t, verifyChain(responses[2], responses[0], epochs))
+	assert.NotNil(t, verifyChain(responses[0], responses[2],
+		[]*Epoch{epochs[0], epochs[2]}))
+	assert.NotNil(t, verifyChain(responses[0], responses[0], epochs[:1]))
+
+	_, err = s.Epochs(nil, &EpochsRequest{From: 1, To: 3})
+	assert.NotNil(t, err)
+	_, err = s.Epochs(nil, &EpochsRequest{From: 2, To: 1})
+	assert.NotNil(t, err)
+
+	// The number of epochs per request is limited
+	s.epochsLock.Lock()
+	for len(s.epochs) <= MaxEpochsRange {
+		s.epochs = append(s.epochs, &Epoch{Index: len(s.epochs)})
+	}
+	s.epochsLock.Unlock()
+	_, err = s.Epochs(nil, &EpochsRequest{From: 0, To: MaxEpochsRange})
+	assert.NotNil(t, err)
+	_, err = s.Epochs(nil, &EpochsRequest{From: 1, To: MaxEpochsRange})
+	log.ErrFatal(err)
+}
+
+func TestService_SaveEpochs(t *testing.T) {
+	local := sda.NewLocalTest()
+	hosts, _, _ := local.GenTree(1, false, false, false)
+	defer local.CloseAll()
+	s := local.GetServices(hosts, timestampSID)[0].(*Service)
+
+	for i := 0; i < 3; i++ {
+		s.saveEpoch(&Epoch{Index: i, Timestamp: int64(i)})
+	}
+	epochs, err := s.loadEpochs()
+	log.ErrFatal(err)
+	assert.Equal(t, 3, len(epochs))
+	assert.Equal(t, int64(2), epochs[2].Timestamp)
+
+	// A partially written epoch is removed
+	f, err := os.OpenFile(s.epochsFileName(), os.O_APPEND|os.O_WRONLY, 0660)
+	log.ErrFatal(err)
+	_, err = f.Write([]byte{100, 0, 0, 0, 1})
+	log.ErrFatal(err)
+	log.ErrFatal(f.Close())
+	epochs, err = s.loadEpochs()
+	log.ErrFatal(err)
+	assert.Equal(t, 3, len(epochs))
+	s.saveEpoch(&Epoch{Index: 3})
+	epochs, err = s.loadEpochs()
+	log.ErrFatal(err)
+	assert.Equal(t, 4, len(epochs))
+}
+
+func TestService_StopLoop(t *testing.T) {
+	s := &Service{
+		requests:      requestPool{closed: true},
+		EpochDuration: time.Hour,
+		signMsg:       mockSign,
+	}
+	assert.NotNil(t, s.requests.Add([]byte("data"), make(chan *SignatureResponse)))
+	s.startLoop()
+	respC := make(chan *SignatureResponse)
+	log.ErrFatal(s.requests.Add([]byte("data"), respC))
+
+	// Stopping refuses the pending and the new requests
+	s.stopLoop()
+	_, ok := <-respC
+	assert.False(t, ok)
+	assert.NotNil(t, s.requests.Add([]byte("data"), make(chan *SignatureResponse)))
+	s.stopLoop()
+}
+
+func TestService_Reconfigure(t *testing.T) {
+	defer log.AfterTest(t)
+	local := sda.NewLocalTest()
+	hosts, roster, _ := local.GenTree(3, false, true, false)
+	defer local.CloseAll()
+	root := roster.List[0]
+	c := NewClient()
+	priv, pub := sda.PrivPub()
+	_, err := c.SetupOwnedStamper(roster, time.Hour, 0, pub)
+	log.ErrFatal(err)
+
+	// Only the owner can reconfigure, with the next version
+	setup := &SetupRosterRequest{Roster: roster, EpochDuration: time.Minute,
+		Version: 1}
+	other, _ := sda.PrivPub()
+	_, err = c.Reconfigure(root, setup, other)
+	assert.NotNil(t, err)
+	setup.Version = 2
+	_, err = c.Reconfigure(root, setup, priv)
+	assert.NotNil(t, err)
+	setup.Version = 1
+	sr, err := c.Reconfigure(root, setup, priv)
+	log.ErrFatal(err)
+	assert.Equal(t, 1, sr.Version)
+
+	s := local.GetServices(hosts, timestampSID)[0].(*Service)
+	loaded := &Service{ServiceProcessor: s.ServiceProcessor, path: s.path}
+	log.ErrFatal(loaded.tryLoad())
+	assert.Equal(t, time.Minute, loaded.EpochDuration)
+	assert.Equal(t, 1, loaded.version)
+	assert.True(t, pub.Equal(loaded.owner))
+	assert.False(t, loaded.stopped)
+
+	// Once stopped, the requests are refused
+	_, err = c.Stop(root, roster.ID, 1, priv)
+	assert.NotNil(t, err)
+	_, err = c.Stop(root, roster.ID, 2, other)
+	assert.NotNil(t, err)
+	_, err = c.Stop(root, sda.RosterID(uuid.NewV4()), 2, priv)
+	assert.NotNil(t, err, "Stop for another timestamper")
+	stop := &StopRequest{ID: sda.RosterID(uuid.NewV4()), Version: 2}
+	hash, err := stop.Hash()
+	log.ErrFatal(err)
+	sig, err := crypto.SignSchnorr(network.Suite, priv, hash)
+	log.ErrFatal(err)
+	stop.ID = roster.ID
+	stop.Signature = &sig
+	_, err = s.Stop(nil, stop)
+	assert.NotNil(t, err, "Replayed stop of another timestamper")
+	_, err = c.Stop(root, roster.ID, 2, priv)
+	log.ErrFatal(err)
+	_, err = c.SignMsg(root, []byte("data"))
+	assert.NotNil(t, err)
+	log.ErrFatal(loaded.tryLoad())
+	assert.True(t, loaded.stopped)
+}
+
+func TestService_VerifyEpoch(t *testing.T) {
+	s := &Service{}
+	root := "root"
+	now := time.Now().Unix()
+	e0 := &Epoch{Timestamp: now, Root: rootHash("0")}
+	e1 := &Epoch{Timestamp: now, Root: rootHash("1"), Previous: e0.ID()}
+	e2 := &Epoch{Timestamp: now, Root: rootHash("2"), Previous: e1.ID()}
+
+	assert.False(t, s.verifyEpoch(root, nil))
+	assert.False(t, s.verifyEpoch(root, []byte("short")))
+	assert.True(t, s.verifyEpoch(root, e0.SignedMsg()))
+	assert.False(t, s.verifyEpoch(root, e2.SignedMsg()), "Missing link")
+	assert.True(t, s.verifyEpoch(root, e1.SignedMsg()))
+	assert.True(t, s.verifyEpoch(root, e2.SignedMsg()))
+
+	// The last epoch can be replaced after a failed round, but then it
+	// can't be extended anymore.
+	e2b := &Epoch{Timestamp: now, Root: rootHash("2b"), Previous: e1.ID()}
+	assert.True(t, s.verifyEpoch(root, e2b.SignedMsg()))
+	e3 := &Epoch{Timestamp: now, Root: rootHash("3"), Previous: e2.ID()}
+	assert.False(t, s.verifyEpoch(root, e3.SignedMsg()), "Fork")
+	assert.False(t, s.verifyEpoch(root, e1.SignedMsg()), "Replay")
+
+	// The timestamp has to be close to the clock
+	e3 = &Epoch{Timestamp: now + 3600, Root: rootHash("3"), Previous: e2b.ID()}
+	assert.False(t, s.verifyEpoch(root, e3.SignedMsg()))
+	e3.Timestamp = now - 3600
+	assert.False(t, s.verifyEpoch(root, e3.SignedMsg()))
+	e3.Timestamp = now
+	assert.True(t, s.verifyEpoch(root, e3.SignedMsg()))
+
+	// Other timestampers have their own chain
+	assert.True(t, s.verifyEpoch("other", e1.SignedMsg()))
+}
+
+func rootHash(s string) crypto.HashID {
+	h := sha256.Sum256([]byte(s))
+	return h[:]
+}
+
+func TestSetupRosterRequest_Hash(t *testing.T) {
+	local := sda.NewLocalTest()
+	_, roster, _ := local.GenTree(3, false, false, false)
+	defer local.CloseAll()
+	setup := &SetupRosterRequest{Roster: roster, EpochDuration: time.Minute}
+	h, err := setup.Hash()
+	log.ErrFatal(err)
+
+	// A roster with the same ID but other members has another hash
+	_, pub := sda.PrivPub()
+	list := make([]*network.ServerIdentity, len(roster.List))
+	copy(list, roster.List)
+	list[1] = network.NewServerIdentity(pub, roster.List[1].Addresses...)
+	setup.Roster = &sda.Roster{ID: roster.ID, List: list,
+		Aggregate: roster.Aggregate}
+	h2, err := setup.Hash()
+	log.ErrFatal(err)
+	assert.NotEqual(t, h, h2)
+
+	list[1] = network.NewServerIdentity(roster.List[1].Public,
+		roster.List[2].Addresses...)
+	h2, err = setup.Hash()
+	log.ErrFatal(err)
+	assert.NotEqual(t, h, h2)
+
+	list[1] = roster.List[1]
+	h2, err = setup.Hash()
+	log.ErrFatal(err)
+	assert.Equal(t, h, h2)
+}
+
 // run the whole framework (including network etc)
 func TestTimestampRunLoopSDA(t *testing.T) {
 	if testing.Short() {
@@ -140,13 +367,13 @@ func TestTimestampRunLoopSDA(t *testing.T) {
 	for _, e := range localRoster.List {
 		publics = append(publics, e.Public)
 	}
-	assert.NoError(t, swupdate.VerifySignature(network.Suite, publics,
+	assert.NoError(t, cosi.VerifySignature(network.Suite, publics,
 		signedMsg1, resp1.Signature))
-	assert.NoError(t, swupdate.VerifySignature(network.Suite, publics,
+	assert.NoError(t, cosi.VerifySignature(network.Suite, publics,
 		signedMsg2, resp2.Signature))
 
 	// check if proofs are what we expect:
-	root, proofs := crypto.ProofTree(sha256.New, []crypto.HashID{origMsg1, origMsg2})
+	root, proofs := crypto.MerkleTree(sha256.New, []crypto.HashID{origMsg1, origMsg2})
 	assert.Equal(t, proofs[0], resp1.Proof)
 	assert.Equal(t, proofs[1], resp2.Proof)
 	assert.Equal(t, root, resp1.Root)
@@ -154,8 +381,38 @@ func TestTimestampRunLoopSDA(t *testing.T) {
 
 	// verify inclusion proofs (fix above problem first):
 	log.Print("Received from channel:", resp1.Proof)
-	assert.True(t, resp1.Proof.Check(sha256.New, resp1.Root, origMsg1),
+	assert.Nil(t, resp1.Proof.Verify(sha256.New, resp1.Root, origMsg1),
 		"Wrong inclusion proof for msg1")
-	assert.True(t, resp2.Proof.Check(sha256.New, resp2.Root, origMsg2),
+	assert.Nil(t, resp2.Proof.Verify(sha256.New, resp2.Root, origMsg2),
 		"Wrong inclusion proof for msg2")
+
+	// verify the responses like a client
+	agg := network.Suite.Point().Null()
+	for _, p := range publics {
+		agg.Add(agg, p)
+	}
+	assert.Nil(t, Verify(localRoster, origMsg1, resp1))
+	assert.Nil(t, VerifyAggregate(agg, origMsg2, resp2))
+	assert.NotNil(t, Verify(localRoster, origMsg2, resp1))
+	assert.NotNil(t, VerifyAggregate(agg, origMsg1, resp2))
+	assert.NotNil(t, VerifyAggregate(publics[0], origMsg1, resp1))
+	wrong := *resp1
+	wrong.Timestamp++
+	assert.NotNil(t, Verify(localRoster, origMsg1, &wrong))
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	leaves := []crypto.HashID{[]byte("msg1"), []byte("msg2"), []byte("msg3")}
+	root, proofs := crypto.MerkleTree(sha256.New, leaves)
+	resp := &SignatureResponse{Root: root, Proof: proofs[1]}
+	assert.Nil(t, verifyInclusion(leaves[1], resp))
+	assert.NotNil(t, verifyInclusion(leaves[0], resp))
+
+	// The root can't be passed off as a message with an empty proof
+	resp.Proof = &crypto.MerkleProof{}
+	assert.NotNil(t, verifyInclusion(root, resp))
+	resp.Proof = &crypto.MerkleProof{Version: crypto.MerkleVersion, Size: 1}
+	assert.NotNil(t, verifyInclusion(root, resp))
+	resp.Proof = nil
+	assert.NotNil(t, verifyInclusion(root, resp))
 }
@@ -1,7 +1,9 @@
 package platform
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"runtime"
@@ -10,6 +12,7 @@ import (
 	"time"
 
 	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/network"
 	"github.com/dedis/cothority/sda"
 	// Import protocols so every protocols is registered to the sda
 	"strings"
@@ -150,6 +153,16 @@ func (d *Localhost) Deploy(rc RunConfig) error {
 		return err
 	}
 	d.sc.Config = string(rc.Toml())
+	if faults := rc.Get("faults"); faults != "" {
+		buf, err := ioutil.ReadFile(faults)
+		if err != nil {
+			return err
+		}
+		if _, err := network.ParseFaultScenario(string(buf)); err != nil {
+			return errors.New("Couldn't parse " + faults + ": " + err.Error())
+		}
+		d.sc.Faults = string(buf)
+	}
 	if err := d.sc.Save(d.runDir); err != nil {
 		return err
 	}
@@ -103,12 +103,12 @@ func RunTests(name string, runconfigs []platform.RunConfig) {
 	if nobuild == false {
 		if race {
 			if err := deployP.Build(build, "-race"); err != nil {
-				log.Error("Couln't finish build without errors:",
+				log.Error("Couldn't finish build without errors:",
 					err)
 			}
 		} else {
 			if err := deployP.Build(build); err != nil {
-				log.Error("Couln't finish build without errors:",
+				log.Error("Couldn't finish build without errors:",
 					err)
 			}
 		}
@@ -131,13 +131,21 @@ func RunTests(name string, runconfigs []platform.RunConfig) {
 	}
 	defer func() {
 		if err := f.Close(); err != nil {
-			log.Error("Couln't close", f.Name())
+			log.Error("Couldn't close", f.Name())
 		}
 	}()
 	err = f.Sync()
 	if err != nil {
 		log.Fatal("error syncing test file:", err)
 	}
+	exports := openExports(name, runconfigs[0], args)
+	defer func() {
+		for _, e := range exports {
+			if err := e.Close(); err != nil {
+				log.Error("Couldn't close", e.Name())
+			}
+		}
+	}()
 
 	start, stop := getStartStop(len(runconfigs))
 	for i, t := range runconfigs {
@@ -180,9 +188,52 @@ func RunTests(name string, runconfigs []platform.RunConfig) {
 		if err != nil {
 			log.Fatal("error syncing data to test file:", err)
 		}
+		for format, e := range exports {
+			if err := writeExport(format, e, s, i == 0); err != nil {
+				log.Fatal("error writing", format, "export:", err)
+			}
+		}
 	}
 }
 
+// openExports opens one file per export format given in the runconfig. The
+// files are opened with the same flags as the main test file.
+func openExports(name string, rc platform.RunConfig, args int) map[string]*os.File {
+	formats, err := monitor.ExportFormats(rc.Get(monitor.ExportKey))
+	if err != nil {
+		log.Fatal("error reading export formats:", err)
+	}
+	exports := make(map[string]*os.File)
+	for _, format := range formats {
+		f, err := os.OpenFile(exportFile(name, format), args, 0660)
+		if err != nil {
+			log.Fatal("error opening export file:", err)
+		}
+		exports[format] = f
+	}
+	return exports
+}
+
+// writeExport writes the stats to the export file in the given format.
+func writeExport(format string, f *os.File, s *monitor.Stats, header bool) error {
+	switch format {
+	case monitor.ExportJSON:
+		if err := s.WriteJSON(f); err != nil {
+			return err
+		}
+	case monitor.ExportCSV:
+		if header {
+			if err := s.WriteCSVHeader(f); err != nil {
+				return err
+			}
+		}
+		if err := s.WriteCSV(f); err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
+
 // RunTest a single test - takes a test-file as a string that will be copied
 // to the deterlab-server
 func RunTest(rc platform.RunConfig) (*monitor.Stats, error) {
@@ -301,6 +352,17 @@ func testFile(name string) string {
 	return "test_data/" + name + ".csv"
 }
 
+// exportFile returns the name of the file for the given export format. The
+// CSV export gets a suffix so it doesn't overwrite the test file.
+func exportFile(name, format string) string {
+	switch format {
+	case monitor.ExportJSON:
+		return "test_data/" + name + ".jsonl"
+	default:
+		return "test_data/" + name + "_" + format + ".csv"
+	}
+}
+
 // returns a tuple of start and stop configurations to run
 func getStartStop(rcs int) (int, int) {
 	ssStr := strings.Split(simRange, ":")